@@ -0,0 +1,277 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package bisect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// The states of a bisection.
+const (
+	Running = "running"
+	Done    = "done"
+	Failed  = "failed"
+)
+
+// ErrNotFound is returned when no bisection matches the requested id or run.
+var ErrNotFound = errors.New("bisection not found")
+
+var bisectBucket = []byte("bisections")
+
+// Bisection is the state of the binary search of the first commit regressing a query.
+// The good and bad commits are measured first, then each measured midpoint halves the range:
+// a commit whose median is above the middle of the good and bad medians is bad.
+type Bisection struct {
+	ID   string `json:"id"`
+	Org  string `json:"org"`
+	Repo string `json:"repo"`
+	// Issue is the issue or pull request the bisection was requested on, its results are posted there.
+	Issue  int    `json:"issue"`
+	Author string `json:"author,omitempty"`
+	Query  string `json:"query"`
+	// Good and Bad are the refs as requested.
+	Good string `json:"good"`
+	Bad  string `json:"bad"`
+	// Commits are the candidates, the good commit first and the bad commit last.
+	Commits []string `json:"commits"`
+	// Lo and Hi are the indexes of the closest known good and bad commits.
+	Lo int `json:"lo"`
+	Hi int `json:"hi"`
+	// Medians holds the measured median of the query, in seconds, per commit.
+	Medians map[string]float64 `json:"medians,omitempty"`
+	// Pending maps the uuid of the dispatched runs to their commit.
+	Pending map[string]string `json:"pending,omitempty"`
+	Status  string            `json:"status"`
+	// Result is the first bad commit once done, Reason explains a failure.
+	Result    string    `json:"result,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// New returns a running bisection of commits, ordered from the good to the bad commit.
+func New(id, org, repo string, issue int, query, good, bad string, commits []string) (*Bisection, error) {
+	if len(commits) < 2 {
+		return nil, fmt.Errorf("nothing to bisect between %s and %s", good, bad)
+	}
+	return &Bisection{
+		ID:      id,
+		Org:     org,
+		Repo:    repo,
+		Issue:   issue,
+		Query:   query,
+		Good:    good,
+		Bad:     bad,
+		Commits: commits,
+		Hi:      len(commits) - 1,
+		Medians: map[string]float64{},
+		Pending: map[string]string{},
+		Status:  Running,
+	}, nil
+}
+
+func (b *Bisection) pending(sha string) bool {
+	for _, p := range b.Pending {
+		if p == sha {
+			return true
+		}
+	}
+	return false
+}
+
+// Next returns the commits to measure next, none while the runs measuring them are pending
+// or when the bisection is over.
+func (b *Bisection) Next() []string {
+	if b.Status != Running {
+		return nil
+	}
+	var next []string
+	for _, sha := range []string{b.Commits[0], b.Commits[len(b.Commits)-1]} {
+		if _, ok := b.Medians[sha]; !ok && !b.pending(sha) {
+			next = append(next, sha)
+		}
+	}
+	if len(next) > 0 || len(b.Pending) > 0 || b.Hi-b.Lo <= 1 {
+		return next
+	}
+	return []string{b.Commits[(b.Lo+b.Hi)/2]}
+}
+
+// Dispatched records the run measuring a commit.
+func (b *Bisection) Dispatched(uuid, sha string) {
+	b.Pending[uuid] = sha
+}
+
+// Observe records the median measured by a pending run and narrows the range.
+// It returns false if the run is not pending, e.g. its status was reported again.
+func (b *Bisection) Observe(uuid string, median float64) bool {
+	sha, ok := b.Pending[uuid]
+	if !ok || b.Status != Running {
+		return false
+	}
+	delete(b.Pending, uuid)
+	b.Medians[sha] = median
+	b.advance()
+	return true
+}
+
+// Fail stops the bisection.
+func (b *Bisection) Fail(reason string) {
+	b.Status, b.Reason = Failed, reason
+	b.Pending = map[string]string{}
+}
+
+func (b *Bisection) advance() {
+	good, okGood := b.Medians[b.Commits[0]]
+	bad, okBad := b.Medians[b.Commits[len(b.Commits)-1]]
+	if !okGood || !okBad {
+		return
+	}
+	if bad <= good {
+		b.Fail(fmt.Sprintf("%s is not slower than %s on %s: %.3fs, %.3fs", b.Bad, b.Good, b.Query, bad, good))
+		return
+	}
+	threshold := (good + bad) / 2
+	for b.Hi-b.Lo > 1 {
+		m, ok := b.Medians[b.Commits[(b.Lo+b.Hi)/2]]
+		if !ok {
+			return
+		}
+		if m > threshold {
+			b.Hi = (b.Lo + b.Hi) / 2
+		} else {
+			b.Lo = (b.Lo + b.Hi) / 2
+		}
+	}
+	b.Status, b.Result = Done, b.Commits[b.Hi]
+}
+
+// Steps returns an estimate of the number of runs left.
+func (b *Bisection) Steps() int {
+	steps := 0
+	for _, sha := range []string{b.Commits[0], b.Commits[len(b.Commits)-1]} {
+		if _, ok := b.Medians[sha]; !ok {
+			steps++
+		}
+	}
+	for n := b.Hi - b.Lo; n > 1; n = (n + 1) / 2 {
+		steps++
+	}
+	return steps
+}
+
+// Store keeps the bisections in a bbolt bucket, usually in the run registry database,
+// so a bisection goes on when its runs report after a restart.
+type Store struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+// NewStore returns a store keeping its bucket in db.
+func NewStore(db *bolt.DB) (*Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bisectBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db, now: time.Now}, nil
+}
+
+func put(tx *bolt.Tx, b *Bisection) error {
+	v, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bisectBucket).Put([]byte(b.ID), v)
+}
+
+func get(tx *bolt.Tx, id string) (*Bisection, error) {
+	v := tx.Bucket(bisectBucket).Get([]byte(id))
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	var b Bisection
+	if err := json.Unmarshal(v, &b); err != nil {
+		return nil, err
+	}
+	if b.Medians == nil {
+		b.Medians = map[string]float64{}
+	}
+	if b.Pending == nil {
+		b.Pending = map[string]string{}
+	}
+	return &b, nil
+}
+
+// Create records a new bisection.
+func (s *Store) Create(b *Bisection) error {
+	if b.ID == "" {
+		return fmt.Errorf("missing bisection id")
+	}
+	b.CreatedAt = s.now()
+	b.UpdatedAt = b.CreatedAt
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return put(tx, b)
+	})
+}
+
+// Get returns the bisection with the given id or ErrNotFound.
+func (s *Store) Get(id string) (*Bisection, error) {
+	var b *Bisection
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		b, err = get(tx, id)
+		return err
+	})
+	return b, err
+}
+
+// Update applies fn on the bisection in a single transaction so concurrent status updates don't race,
+// and returns the updated bisection. Nothing is saved if fn fails.
+func (s *Store) Update(id string, fn func(*Bisection) error) (*Bisection, error) {
+	var b *Bisection
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		b, err = get(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+		b.UpdatedAt = s.now()
+		return put(tx, b)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// List returns every bisection, most recent first.
+func (s *Store) List() ([]Bisection, error) {
+	var res []Bisection
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bisectBucket).ForEach(func(_, v []byte) error {
+			var b Bisection
+			if err := json.Unmarshal(v, &b); err != nil {
+				return err
+			}
+			res = append(res, b)
+			return nil
+		})
+	})
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].CreatedAt.After(res[j].CreatedAt)
+	})
+	return res, err
+}
@@ -0,0 +1,117 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package bisect
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// measure runs the bisection to its end, the commits from firstBad on take twice as long.
+func measure(t *testing.T, b *Bisection, firstBad int) int {
+	runs := 0
+	for next := b.Next(); len(next) > 0; next = b.Next() {
+		for _, sha := range next {
+			runs++
+			uuid := fmt.Sprintf("run-%d", runs)
+			b.Dispatched(uuid, sha)
+			median := 1.0
+			for i, c := range b.Commits {
+				if c == sha && i >= firstBad {
+					median = 2.0
+				}
+			}
+			assert.True(t, b.Observe(uuid, median))
+			assert.False(t, b.Observe(uuid, median), "a run is observed once")
+		}
+	}
+	return runs
+}
+
+func commits(n int) []string {
+	var res []string
+	for i := 0; i < n; i++ {
+		res = append(res, fmt.Sprintf("c%d", i))
+	}
+	return res
+}
+
+func TestBisection(t *testing.T) {
+	tests := []struct {
+		name     string
+		commits  int
+		firstBad int
+		runs     int
+	}{
+		{name: "adjacent", commits: 2, firstBad: 1, runs: 2},
+		{name: "first", commits: 9, firstBad: 1, runs: 5},
+		{name: "middle", commits: 9, firstBad: 5, runs: 5},
+		{name: "last", commits: 9, firstBad: 8, runs: 5},
+		{name: "odd range", commits: 100, firstBad: 37, runs: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := New("1", "datafuselabs", "databend", 233, "Q1", "v0.4.0", "main", commits(tt.commits))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.runs, measure(t, b, tt.firstBad))
+			assert.Equal(t, Done, b.Status)
+			assert.Equal(t, fmt.Sprintf("c%d", tt.firstBad), b.Result)
+		})
+	}
+}
+
+func TestBisection_failures(t *testing.T) {
+	_, err := New("1", "datafuselabs", "databend", 233, "Q1", "a", "a", []string{"a"})
+	assert.Error(t, err)
+
+	b, err := New("1", "datafuselabs", "databend", 233, "Q1", "v0.4.0", "main", commits(5))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"c0", "c4"}, b.Next())
+	assert.Equal(t, 4, b.Steps())
+	b.Dispatched("good", "c0")
+	b.Dispatched("bad", "c4")
+	assert.Empty(t, b.Next(), "the pending commits are not measured twice")
+	b.Observe("good", 2)
+	b.Observe("bad", 1)
+	assert.Equal(t, Failed, b.Status)
+	assert.Contains(t, b.Reason, "main is not slower than v0.4.0")
+	assert.Empty(t, b.Next())
+}
+
+func TestStore(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "bisect.db"), 0600, nil)
+	assert.NoError(t, err)
+	defer db.Close()
+	s, err := NewStore(db)
+	assert.NoError(t, err)
+
+	b, err := New("1", "datafuselabs", "databend", 233, "Q1", "v0.4.0", "main", commits(3))
+	assert.NoError(t, err)
+	assert.NoError(t, s.Create(b))
+	_, err = s.Update("1", func(b *Bisection) error {
+		b.Dispatched("run-1", "c0")
+		return nil
+	})
+	assert.NoError(t, err)
+	_, err = s.Update("1", func(b *Bisection) error {
+		return fmt.Errorf("failed")
+	})
+	assert.Error(t, err)
+
+	got, err := s.Get("1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"run-1": "c0"}, got.Pending)
+	_, err = s.Get("2")
+	assert.Equal(t, ErrNotFound, err)
+	_, err = s.Update("2", func(*Bisection) error { return nil })
+	assert.Equal(t, ErrNotFound, err)
+
+	list, err := s.List()
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+}
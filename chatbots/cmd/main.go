@@ -2,9 +2,20 @@ package main
 
 import (
 	"context"
+	"datafuselabs/test-infra/chatbots/config"
 	"datafuselabs/test-infra/chatbots/hook"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/repoconfig"
+	"datafuselabs/test-infra/chatbots/retention"
+	"datafuselabs/test-infra/chatbots/bisect"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/policy"
+	"datafuselabs/test-infra/chatbots/scheduler"
+	"datafuselabs/test-infra/chatbots/trends"
 	"datafuselabs/test-infra/chatbots/utils"
+	"github.com/google/go-github/v35/github"
 	"github.com/google/uuid"
+	"golang.org/x/oauth2"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/leaderelection"
@@ -27,30 +38,53 @@ const (
 )
 
 var (
-	GithubToken          string
-	WebhookToken         string
-	Address              string
-	Region               string
-	Bucket               string
-	Endpoint             string
-	TemplateDir          string
-	StaticDir            string
+	ConfigFile           string
 	EnableLeaderElection bool
 )
 
 func init() {
-	flag.StringVar(&GithubToken, "github-token", "", "chatbot github token")
-	flag.StringVar(&WebhookToken, "webhook-token", "", "webhook token for chatbot server")
-	flag.StringVar(&Address, "address", "", "address that chatbot server binds to")
-	flag.StringVar(&Region, "region", "", "S3 Storage Region")
-	flag.StringVar(&Bucket, "bucket", "", "S3 Storage bucket")
-	flag.StringVar(&Endpoint, "endpoint", "", "S3 storage endpoint")
-	flag.StringVar(&TemplateDir, "template-dir", "", "dashboard template dir")
-	flag.StringVar(&StaticDir, "static-dir", "", "dashboard static file dir")
+	flag.StringVar(&ConfigFile, "config", "", "yaml configuration file of the chatbot, reloaded when it changes, the secrets may be given by $GITHUB_TOKEN, $WEBHOOK_TOKEN, $RUN_TOKEN_SECRET and $ADMIN_TOKEN")
 	flag.BoolVar(&EnableLeaderElection, "enable-leader-election", false, "configure leader election for k8s HA")
 
 }
 
+// loadConfig reads and validates the configuration file, the defaults and the environment without a file.
+func loadConfig(path string) (*config.Config, error) {
+	var c *config.Config
+	var err error
+	if path != "" {
+		c, err = config.Load(path)
+	} else {
+		c, err = config.Parse(nil, os.LookupEnv)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Validate(plugins.CommandNames(), plugins.PluginNames()); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// settings returns the settings of the server reloaded with the configuration.
+func settings(c *config.Config) hook.Settings {
+	disabled := map[string]bool{}
+	for _, p := range c.Plugins.Disabled {
+		disabled[p] = true
+	}
+	permissions := c.Permissions
+	return hook.Settings{
+		Policy:          &permissions,
+		DisabledPlugins: disabled,
+		PerfWatchBatch:  c.Plugins.PerfWatchBatch,
+	}
+}
+
+// schedulerOptions returns the options of the run-perf scheduler.
+func schedulerOptions(c *config.Config) scheduler.Options {
+	return scheduler.Options{Limit: c.RateLimits.PerfRunners, Timeout: c.RateLimits.PerfRunTimeout, Duration: c.RateLimits.PerfRunDuration}
+}
+
 func buildConfig(kubeconfig string) (*rest.Config, error) {
 	if kubeconfig != "" {
 		cfg, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
@@ -80,23 +114,98 @@ func clientSetup(kubeconfig string) (clientset *client.Clientset, err error) {
 
 func main() {
 	flag.Parse()
-	if !strings.HasPrefix(Endpoint, "http://") && !strings.HasPrefix(Endpoint, "https://") {
-		Endpoint = "https://" + Endpoint
+	conf, err := loadConfig(ConfigFile)
+	if err != nil {
+		log.Error().Msgf("invalid configuration %s, %s", ConfigFile, err.Error())
+		return
+	}
+	endpoint := conf.Storage.Endpoint
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		endpoint = "https://" + endpoint
+	}
+	runRegistry, err := registry.NewBoltRegistry(conf.Server.RegistryPath)
+	if err != nil {
+		log.Error().Msgf("unable to open run registry %s, %s", conf.Server.RegistryPath, err.Error())
+		return
+	}
+	defer runRegistry.Close()
+	signer, err := registry.NewSigner(conf.Github.RunTokenSecret, conf.Github.RunTokenTTL)
+	if err != nil {
+		log.Error().Msgf("unable to sign run tokens, %s", err.Error())
+		return
+	}
+	storage, err := utils.NewStorage(conf.Storage.Options())
+	if err != nil {
+		log.Error().Msgf("unable to open %s storage, %s", conf.Storage.Backend, err.Error())
+		return
 	}
 	cfg := hook.NewConfig(
-		&utils.FileStorage{
-			BasePath: "./tmp",
-		},
+		storage,
+		runRegistry,
+		signer,
 		context.Background(),
 		log.Logger,
-		GithubToken,
-		WebhookToken,
-		Address,
-		Region, Bucket, Endpoint,
-		TemplateDir,
-		StaticDir,
+		conf.Github.Token,
+		conf.Github.WebhookToken,
+		conf.Server.Address,
+		conf.Storage.Region, conf.Storage.Bucket, endpoint,
+		conf.Server.TemplateDir,
+		conf.Server.StaticDir,
 	)
+	cfg.Trends, err = trends.NewStore(runRegistry.DB())
+	if err != nil {
+		log.Error().Msgf("unable to open trends store, %s", err.Error())
+		return
+	}
+	cfg.Bisections, err = bisect.NewStore(runRegistry.DB())
+	if err != nil {
+		log.Error().Msgf("unable to open bisection store, %s", err.Error())
+		return
+	}
+	cfg.Scheduler, err = scheduler.New(runRegistry.DB(), schedulerOptions(conf))
+	if err != nil {
+		log.Error().Msgf("unable to open run queue, %s", err.Error())
+		return
+	}
+	cfg.PolicyStore, err = policy.NewStore(runRegistry.DB())
+	if err != nil {
+		log.Error().Msgf("unable to open approvals and audit log, %s", err.Error())
+		return
+	}
+	cfg.RepoConfigs = repoconfig.NewCache()
+	cfg.Limiter = plugins.NewRateLimiter(conf.RateLimits.CommandsPerHour, time.Hour)
+	cfg.StorageType = conf.Storage.JobType()
+	cfg.DashboardURL = conf.Server.DashboardURL
+	cfg.AdminToken = conf.Github.AdminToken
+	cfg.ArtifactMaxFileSize = conf.Server.ArtifactMaxFileSize
+	cfg.ArtifactMaxRunSize = conf.Server.ArtifactMaxRunSize
+	cfg.Settings = settings(conf)
+	if conf.Retention.Interval > 0 {
+		gh := github.NewClient(oauth2.NewClient(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: conf.Github.Token})))
+		cfg.Janitor = retention.New(storage, runRegistry, retention.GithubPullRequests{Client: gh}, conf.Retention.Policy())
+		cfg.RetentionInterval = conf.Retention.Interval
+	}
 	server := hook.NewServer(cfg)
+	if ConfigFile != "" {
+		current := conf
+		watcher, err := config.NewWatcher(ConfigFile, config.DefaultWatchInterval, loadConfig, func(c *config.Config) {
+			if sections := c.RestartRequired(current); len(sections) > 0 {
+				log.Warn().Msgf("configuration of %s changed, restart to apply it", strings.Join(sections, ", "))
+			}
+			server.Reload(settings(c))
+			cfg.Scheduler.SetOptions(schedulerOptions(c))
+			cfg.Limiter.SetLimit(c.RateLimits.CommandsPerHour, time.Hour)
+			if cfg.Janitor != nil {
+				cfg.Janitor.SetPolicy(c.Retention.Policy())
+			}
+			current = c
+		})
+		if err != nil {
+			log.Error().Msgf("unable to watch configuration %s, %s", ConfigFile, err.Error())
+			return
+		}
+		go watcher.Run(context.Background())
+	}
 	// run local
 	if !EnableLeaderElection {
 		server.Start()
@@ -0,0 +1,326 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"datafuselabs/test-infra/chatbots/hook"
+	"datafuselabs/test-infra/chatbots/policy"
+	"datafuselabs/test-infra/chatbots/retention"
+	"datafuselabs/test-infra/chatbots/scheduler"
+	"datafuselabs/test-infra/chatbots/utils"
+)
+
+// The environment variables overriding the secrets of the configuration file.
+const (
+	EnvGithubToken    = "GITHUB_TOKEN"
+	EnvWebhookToken   = "WEBHOOK_TOKEN"
+	EnvRunTokenSecret = "RUN_TOKEN_SECRET"
+	EnvAdminToken     = "ADMIN_TOKEN"
+	EnvStorageID      = "STORAGE_SECRET_ID"
+	EnvStorageKey     = "STORAGE_SECRET_KEY"
+)
+
+// Defaults of the configuration, see also the defaults of the hook and the scheduler.
+const (
+	DefaultStoragePath  = "./tmp"
+	DefaultRegistryPath = "./registry.db"
+	DefaultRunTokenTTL  = 24 * time.Hour
+)
+
+// backends are the supported storage backends.
+var backends = func() map[string]bool {
+	m := map[string]bool{}
+	for _, b := range utils.Backends {
+		m[b] = true
+	}
+	return m
+}()
+
+// Server configures the http server of the chatbot.
+type Server struct {
+	// Address is the address the server binds to, e.g. :7070.
+	Address     string `yaml:"address"`
+	TemplateDir string `yaml:"templateDir"`
+	StaticDir   string `yaml:"staticDir"`
+	// DashboardURL is the public url of the dashboard linked from github, e.g. https://perf.databend.rs.
+	DashboardURL string `yaml:"dashboardURL"`
+	// RegistryPath is the run registry database file.
+	RegistryPath string `yaml:"registryPath"`
+	// ArtifactMaxFileSize and ArtifactMaxRunSize are the upload quotas in bytes.
+	ArtifactMaxFileSize int64 `yaml:"artifactMaxFileSize"`
+	ArtifactMaxRunSize  int64 `yaml:"artifactMaxRunSize"`
+}
+
+// Storage selects where the chatbot keeps the artifacts, and the bucket given to the perf workflows.
+type Storage struct {
+	// Backend is the storage of the artifacts, file keeps them under Path,
+	// cos and s3 in the bucket the workflows upload to.
+	Backend string `yaml:"backend"`
+	Path    string `yaml:"path"`
+	// Region, Bucket and Endpoint are the bucket the workflows upload to.
+	Region   string `yaml:"region"`
+	Bucket   string `yaml:"bucket"`
+	Endpoint string `yaml:"endpoint"`
+	// SecretID and SecretKey are the credentials of the bucket, overridden by $STORAGE_SECRET_ID and $STORAGE_SECRET_KEY.
+	SecretID  string `yaml:"secretID"`
+	SecretKey string `yaml:"secretKey"`
+	// CredentialsFile and Profile are the shared credentials of the s3 backend when no secret is given
+	// and none is in $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY, ~/.aws/credentials and default by default.
+	CredentialsFile string `yaml:"credentialsFile"`
+	Profile         string `yaml:"profile"`
+	// PathStyle addresses an s3 bucket as a path of the endpoint, as MinIO expects.
+	PathStyle bool `yaml:"pathStyle"`
+	// ServerSideEncryption encrypts the objects of the s3 backend, AES256 or aws:kms with the KMSKeyID key.
+	ServerSideEncryption string `yaml:"serverSideEncryption"`
+	KMSKeyID             string `yaml:"kmsKeyID"`
+}
+
+// JobType returns the type of the bucket given to the perf workflows.
+func (s Storage) JobType() string {
+	if s.Backend == utils.S3Backend {
+		return "S3"
+	}
+	return "COS"
+}
+
+// Options returns the options of the storage backend.
+func (s Storage) Options() utils.StorageOptions {
+	return utils.StorageOptions{
+		Backend:   s.Backend,
+		Path:      s.Path,
+		Region:    s.Region,
+		Bucket:    s.Bucket,
+		Endpoint:  s.Endpoint,
+		SecretID:  s.SecretID,
+		SecretKey: s.SecretKey,
+		PathStyle: s.PathStyle,
+
+		CredentialsFile:      s.CredentialsFile,
+		Profile:              s.Profile,
+		ServerSideEncryption: s.ServerSideEncryption,
+		KMSKeyID:             s.KMSKeyID,
+	}
+}
+
+// Github configures the github authentication, the secrets are best given by the environment.
+type Github struct {
+	// Token is the token of the bot account, overridden by $GITHUB_TOKEN.
+	Token string `yaml:"token"`
+	// WebhookToken is the secret of the webhook, overridden by $WEBHOOK_TOKEN.
+	WebhookToken string `yaml:"webhookToken"`
+	// RunTokenSecret signs the per-run callback tokens, overridden by $RUN_TOKEN_SECRET.
+	RunTokenSecret string `yaml:"runTokenSecret"`
+	// RunTokenTTL is the validity of the per-run callback tokens.
+	RunTokenTTL time.Duration `yaml:"runTokenTTL"`
+	// AdminToken is the bearer token of the admin api, e.g. /api/audit, overridden by $ADMIN_TOKEN.
+	AdminToken string `yaml:"adminToken"`
+}
+
+// Plugins configures the plugins on every repository.
+type Plugins struct {
+	// Disabled are the plugins which never run, the repositories may disable more of them.
+	Disabled []string `yaml:"disabled"`
+	// PerfWatchBatch is the number of default branch commits measured by a single run-perf run,
+	// 0 disables the runs on push.
+	PerfWatchBatch int `yaml:"perfWatchBatch"`
+}
+
+// RateLimits bound the runs and the commands.
+type RateLimits struct {
+	// PerfRunners is the number of run-perf runs dispatched at once, the capacity of the perf-runner pool.
+	PerfRunners int `yaml:"perfRunners"`
+	// PerfRunTimeout frees the runner of a run which never completed.
+	PerfRunTimeout time.Duration `yaml:"perfRunTimeout"`
+	// PerfRunDuration is the expected duration of a run, used to estimate the start of the queued runs.
+	PerfRunDuration time.Duration `yaml:"perfRunDuration"`
+	// CommandsPerHour is the number of commands a user may run in an hour, 0 for no limit.
+	CommandsPerHour int `yaml:"commandsPerHour"`
+}
+
+// Retention configures the janitor deleting the runs from the storage, every limit is off by default.
+type Retention struct {
+	// Interval is the period of the sweeps of the storage, 0 disables the janitor.
+	Interval time.Duration `yaml:"interval"`
+	// KeepRuns is the number of most recent runs kept per pull request.
+	KeepRuns int `yaml:"keepRuns"`
+	// ClosedPRAge is how long the runs of a closed pull request are kept.
+	ClosedPRAge time.Duration `yaml:"closedPRAge"`
+	// MaxTotalSize is the size of the storage in bytes, the oldest runs are deleted beyond it.
+	MaxTotalSize int64 `yaml:"maxTotalSize"`
+}
+
+// Policy returns the policy of the janitor.
+func (r Retention) Policy() retention.Policy {
+	return retention.Policy{KeepRuns: r.KeepRuns, ClosedPRAge: r.ClosedPRAge, MaxTotalSize: r.MaxTotalSize}
+}
+
+// Config is the configuration file of the chatbot, e.g.
+//
+//	server:
+//	  address: :7070
+//	  dashboardURL: https://perf.databend.rs
+//	storage:
+//	  backend: file
+//	  path: /var/lib/chatbot
+//	  bucket: databend-perf
+//	github:
+//	  runTokenTTL: 12h
+//	plugins:
+//	  disabled: [build-docker]
+//	permissions:
+//	  commands:
+//	    run-perf:
+//	      teams: [perf]
+//	rateLimits:
+//	  perfRunners: 2
+//	  commandsPerHour: 20
+//	retention:
+//	  interval: 1h
+//	  keepRuns: 5
+//	  closedPRAge: 720h
+//
+// The plugins, the permissions, the rate limits and the retention limits are reloaded when the file changes,
+// the other sections and the retention interval need a restart.
+type Config struct {
+	Server  Server  `yaml:"server"`
+	Storage Storage `yaml:"storage"`
+	Github  Github  `yaml:"github"`
+	Plugins Plugins `yaml:"plugins"`
+	// Permissions map the commands to who may run them, the commands keep their declared permission by default.
+	Permissions policy.Policy `yaml:"permissions"`
+	RateLimits  RateLimits    `yaml:"rateLimits"`
+	Retention   Retention     `yaml:"retention"`
+}
+
+// Default returns the configuration used for the settings the file omits.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			RegistryPath:        DefaultRegistryPath,
+			ArtifactMaxFileSize: hook.DefaultArtifactMaxFileSize,
+			ArtifactMaxRunSize:  hook.DefaultArtifactMaxRunSize,
+		},
+		Storage:    Storage{Backend: "file", Path: DefaultStoragePath},
+		Github:     Github{RunTokenTTL: DefaultRunTokenTTL},
+		Plugins:    Plugins{PerfWatchBatch: 1},
+		RateLimits: RateLimits{PerfRunners: scheduler.DefaultLimit, PerfRunTimeout: scheduler.DefaultTimeout, PerfRunDuration: scheduler.DefaultDuration},
+	}
+}
+
+// Load reads the configuration file at path, see Parse.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data, os.LookupEnv)
+}
+
+// Parse decodes a yaml configuration over the defaults, then applies the environment overrides read with lookup.
+// Unknown fields are rejected so a typo doesn't silently keep a default.
+func Parse(data []byte, lookup func(string) (string, bool)) (*Config, error) {
+	c := Default()
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid configuration, %v", err)
+	}
+	for env, field := range map[string]*string{
+		EnvGithubToken:    &c.Github.Token,
+		EnvWebhookToken:   &c.Github.WebhookToken,
+		EnvRunTokenSecret: &c.Github.RunTokenSecret,
+		EnvAdminToken:     &c.Github.AdminToken,
+		EnvStorageID:      &c.Storage.SecretID,
+		EnvStorageKey:     &c.Storage.SecretKey,
+	} {
+		if v, ok := lookup(env); ok && v != "" {
+			*field = v
+		}
+	}
+	return c, nil
+}
+
+// Validate checks the configuration given the registered commands and plugins.
+func (c *Config) Validate(commands, plugins []string) error {
+	switch {
+	case !backends[c.Storage.Backend]:
+		return fmt.Errorf("storage.backend: unknown backend %q", c.Storage.Backend)
+	case c.Storage.Backend == "file" && c.Storage.Path == "":
+		return fmt.Errorf("storage.path is required by the file backend")
+	case c.Storage.Backend == utils.COSBackend && (c.Storage.Bucket == "" || c.Storage.Region == "" && c.Storage.Endpoint == ""):
+		return fmt.Errorf("storage.bucket and storage.region or storage.endpoint are required by the cos backend")
+	case c.Storage.Backend == utils.S3Backend && (c.Storage.Bucket == "" || c.Storage.Region == ""):
+		return fmt.Errorf("storage.bucket and storage.region are required by the s3 backend")
+	case c.Storage.ServerSideEncryption != "" && c.Storage.ServerSideEncryption != utils.SSES3 && c.Storage.ServerSideEncryption != utils.SSEKMS:
+		return fmt.Errorf("storage.serverSideEncryption: unknown encryption %q, %s or %s", c.Storage.ServerSideEncryption, utils.SSES3, utils.SSEKMS)
+	case c.Storage.KMSKeyID != "" && c.Storage.ServerSideEncryption != utils.SSEKMS:
+		return fmt.Errorf("storage.kmsKeyID requires the %s storage.serverSideEncryption", utils.SSEKMS)
+	case c.Server.RegistryPath == "":
+		return fmt.Errorf("server.registryPath is required")
+	case c.Server.ArtifactMaxFileSize <= 0 || c.Server.ArtifactMaxRunSize <= 0:
+		return fmt.Errorf("server: the artifact quotas must be positive")
+	case c.Github.Token == "":
+		return fmt.Errorf("github.token is required, or $%s", EnvGithubToken)
+	case c.Github.WebhookToken == "":
+		return fmt.Errorf("github.webhookToken is required, or $%s", EnvWebhookToken)
+	case c.Github.RunTokenSecret == "":
+		return fmt.Errorf("github.runTokenSecret is required, or $%s", EnvRunTokenSecret)
+	case c.Github.AdminToken == "":
+		return fmt.Errorf("github.adminToken is required, or $%s", EnvAdminToken)
+	case c.Github.RunTokenSecret == c.Github.WebhookToken || c.Github.AdminToken == c.Github.WebhookToken || c.Github.AdminToken == c.Github.RunTokenSecret:
+		return fmt.Errorf("github: the webhook token, the run token secret and the admin token must differ")
+	case c.Github.RunTokenTTL <= 0:
+		return fmt.Errorf("github.runTokenTTL must be positive")
+	case c.Plugins.PerfWatchBatch < 0:
+		return fmt.Errorf("plugins.perfWatchBatch must not be negative")
+	case c.RateLimits.PerfRunners <= 0:
+		return fmt.Errorf("rateLimits.perfRunners must be positive")
+	case c.RateLimits.PerfRunTimeout <= 0 || c.RateLimits.PerfRunDuration <= 0:
+		return fmt.Errorf("rateLimits: perfRunTimeout and perfRunDuration must be positive")
+	case c.RateLimits.CommandsPerHour < 0:
+		return fmt.Errorf("rateLimits.commandsPerHour must not be negative")
+	case c.Retention.Interval < 0 || c.Retention.KeepRuns < 0 || c.Retention.ClosedPRAge < 0 || c.Retention.MaxTotalSize < 0:
+		return fmt.Errorf("retention: the limits and the interval must not be negative")
+	}
+	known := map[string]bool{}
+	for _, p := range plugins {
+		known[p] = true
+	}
+	for _, p := range c.Plugins.Disabled {
+		if !known[p] {
+			return fmt.Errorf("plugins.disabled: unknown plugin %s", p)
+		}
+	}
+	if err := c.Permissions.Validate(commands); err != nil {
+		return fmt.Errorf("permissions: %v", err)
+	}
+	return nil
+}
+
+// RestartRequired returns the sections changed from old to c which are only applied on restart.
+func (c *Config) RestartRequired(old *Config) []string {
+	var sections []string
+	if c.Server != old.Server {
+		sections = append(sections, "server")
+	}
+	if c.Storage != old.Storage {
+		sections = append(sections, "storage")
+	}
+	if c.Github != old.Github {
+		sections = append(sections, "github")
+	}
+	if c.Retention.Interval != old.Retention.Interval {
+		sections = append(sections, "retention.interval")
+	}
+	return sections
+}
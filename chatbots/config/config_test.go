@@ -0,0 +1,199 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"datafuselabs/test-infra/chatbots/retention"
+	"datafuselabs/test-infra/chatbots/scheduler"
+	"datafuselabs/test-infra/chatbots/utils"
+)
+
+const testConfig = `
+server:
+  address: :7070
+  dashboardURL: https://perf.databend.rs
+storage:
+  path: /var/lib/chatbot
+  bucket: databend-perf
+github:
+  token: file-token
+  webhookToken: webhook
+  runTokenSecret: run-secret
+  adminToken: admin
+plugins:
+  disabled: [build-docker]
+  perfWatchBatch: 0
+permissions:
+  commands:
+    run-perf:
+      teams: [perf]
+rateLimits:
+  perfRunners: 2
+  perfRunTimeout: 2h
+  commandsPerHour: 20
+retention:
+  interval: 1h
+  keepRuns: 5
+  maxTotalSize: 107374182400
+`
+
+var (
+	testCommands = []string{"run-perf", "build-docker"}
+	testPlugins  = []string{"run-perf", "build-docker", "perf-watch"}
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(testConfig), env(map[string]string{EnvGithubToken: "env-token", EnvWebhookToken: ""}))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Validate(testCommands, testPlugins))
+
+	assert.Equal(t, ":7070", c.Server.Address)
+	assert.Equal(t, DefaultRegistryPath, c.Server.RegistryPath, "default")
+	assert.Equal(t, Storage{Backend: "file", Path: "/var/lib/chatbot", Bucket: "databend-perf"}, c.Storage)
+	assert.Equal(t, Github{Token: "env-token", WebhookToken: "webhook", RunTokenSecret: "run-secret", RunTokenTTL: DefaultRunTokenTTL, AdminToken: "admin"}, c.Github,
+		"the environment overrides the file, an empty variable doesn't")
+	assert.Equal(t, Plugins{Disabled: []string{"build-docker"}, PerfWatchBatch: 0}, c.Plugins)
+	assert.Equal(t, []string{"perf"}, c.Permissions.Commands["run-perf"].Teams)
+	assert.Equal(t, RateLimits{PerfRunners: 2, PerfRunTimeout: 2 * time.Hour, PerfRunDuration: scheduler.DefaultDuration, CommandsPerHour: 20}, c.RateLimits)
+	assert.Equal(t, Retention{Interval: time.Hour, KeepRuns: 5, MaxTotalSize: 100 << 30}, c.Retention)
+	assert.Equal(t, retention.Policy{KeepRuns: 5, MaxTotalSize: 100 << 30}, c.Retention.Policy())
+
+	c, err = Parse(nil, env(map[string]string{EnvGithubToken: "t", EnvWebhookToken: "w", EnvRunTokenSecret: "s", EnvAdminToken: "a"}))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Validate(testCommands, testPlugins), "the defaults and the environment are enough")
+	assert.Equal(t, "s", c.Github.RunTokenSecret)
+	assert.Equal(t, "a", c.Github.AdminToken)
+
+	c, err = Parse([]byte("storage:\n  backend: s3\n  region: us-east-1\n  bucket: perf\n  endpoint: http://minio:9000\n  pathStyle: true\n  secretKey: file-key\n"),
+		env(map[string]string{EnvGithubToken: "t", EnvWebhookToken: "w", EnvRunTokenSecret: "s", EnvAdminToken: "a", EnvStorageID: "id", EnvStorageKey: "key"}))
+	assert.NoError(t, err)
+	assert.NoError(t, c.Validate(testCommands, testPlugins))
+	assert.Equal(t, utils.StorageOptions{Backend: "s3", Path: DefaultStoragePath, Region: "us-east-1", Bucket: "perf", Endpoint: "http://minio:9000",
+		SecretID: "id", SecretKey: "key", PathStyle: true}, c.Storage.Options())
+	assert.Equal(t, "S3", c.Storage.JobType())
+
+	_, err = Parse([]byte("server:\n  adress: :7070\n"), env(nil))
+	assert.EqualError(t, err, "invalid configuration, yaml: unmarshal errors:\n  line 2: field adress not found in type config.Server")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      string
+		env         map[string]string
+		expectError string
+	}{
+		{name: "backend", config: "storage:\n  backend: ftp\n", expectError: `storage.backend: unknown backend "ftp"`},
+		{name: "path", config: "storage:\n  path: \"\"\n", expectError: "storage.path is required by the file backend"},
+		{name: "cos", config: "storage:\n  backend: cos\n  bucket: perf-1250000000\n", expectError: "storage.bucket and storage.region or storage.endpoint are required by the cos backend"},
+		{name: "s3", config: "storage:\n  backend: s3\n  region: us-east-2\n", expectError: "storage.bucket and storage.region are required by the s3 backend"},
+		{name: "sse", config: "storage:\n  serverSideEncryption: aes\n", expectError: `storage.serverSideEncryption: unknown encryption "aes", AES256 or aws:kms`},
+		{name: "kms", config: "storage:\n  serverSideEncryption: AES256\n  kmsKeyID: perf\n", expectError: "storage.kmsKeyID requires the aws:kms storage.serverSideEncryption"},
+		{name: "quota", config: "server:\n  artifactMaxRunSize: 0\n", expectError: "server: the artifact quotas must be positive"},
+		{name: "token", env: map[string]string{EnvWebhookToken: "w"}, expectError: "github.token is required, or $GITHUB_TOKEN"},
+		{name: "run token secret", env: map[string]string{EnvGithubToken: "t", EnvWebhookToken: "w", EnvAdminToken: "a"}, expectError: "github.runTokenSecret is required, or $RUN_TOKEN_SECRET"},
+		{name: "admin token", env: map[string]string{EnvGithubToken: "t", EnvWebhookToken: "w", EnvRunTokenSecret: "s"}, expectError: "github.adminToken is required, or $ADMIN_TOKEN"},
+		{name: "shared secret", env: map[string]string{EnvGithubToken: "t", EnvWebhookToken: "w", EnvRunTokenSecret: "w", EnvAdminToken: "a"},
+			expectError: "github: the webhook token, the run token secret and the admin token must differ"},
+		{name: "ttl", config: "github:\n  runTokenTTL: -1h\n", expectError: "github.runTokenTTL must be positive"},
+		{name: "batch", config: "plugins:\n  perfWatchBatch: -1\n", expectError: "plugins.perfWatchBatch must not be negative"},
+		{name: "plugin", config: "plugins:\n  disabled: [run-perf, perf-wach]\n", expectError: "plugins.disabled: unknown plugin perf-wach"},
+		{name: "runners", config: "rateLimits:\n  perfRunners: 0\n", expectError: "rateLimits.perfRunners must be positive"},
+		{name: "commands", config: "rateLimits:\n  commandsPerHour: -1\n", expectError: "rateLimits.commandsPerHour must not be negative"},
+		{name: "retention", config: "retention:\n  closedPRAge: -24h\n", expectError: "retention: the limits and the interval must not be negative"},
+		{name: "permissions", config: "permissions:\n  commands:\n    run-perf:\n      associations: [ADMIN]\n", expectError: "permissions: policy: command run-perf: unknown author association ADMIN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vars := tt.env
+			if vars == nil {
+				vars = map[string]string{EnvGithubToken: "t", EnvWebhookToken: "w", EnvRunTokenSecret: "s", EnvAdminToken: "a"}
+			}
+			c, err := Parse([]byte(tt.config), env(vars))
+			assert.NoError(t, err)
+			assert.EqualError(t, c.Validate(testCommands, testPlugins), tt.expectError)
+		})
+	}
+}
+
+// withCommandsPerHour returns the test configuration with another limit of commands.
+func withCommandsPerHour(n string) string {
+	return strings.Replace(testConfig, "commandsPerHour: 20", "commandsPerHour: "+n, 1)
+}
+
+func TestRestartRequired(t *testing.T) {
+	old, err := Parse([]byte(testConfig), env(nil))
+	assert.NoError(t, err)
+	c, err := Parse([]byte(withCommandsPerHour("5")), env(nil))
+	assert.NoError(t, err)
+	assert.Empty(t, c.RestartRequired(old))
+	c.Retention.KeepRuns = 2
+	assert.Empty(t, c.RestartRequired(old), "the retention limits are reloaded")
+	c.Server.Address = ":8080"
+	c.Github.Token = "new"
+	c.Retention.Interval = time.Minute
+	assert.Equal(t, []string{"server", "github", "retention.interval"}, c.RestartRequired(old))
+}
+
+func TestWatcher(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string, mod time.Time) {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+		assert.NoError(t, os.Chtimes(path, mod, mod))
+	}
+	start := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	write(testConfig, start)
+
+	var applied []int
+	load := func(path string) (*Config, error) {
+		c, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		return c, c.Validate(testCommands, testPlugins)
+	}
+	w, err := NewWatcher(path, time.Hour, load, func(c *Config) {
+		applied = append(applied, c.RateLimits.CommandsPerHour)
+	})
+	assert.NoError(t, err)
+
+	reloaded, err := w.Check()
+	assert.NoError(t, err)
+	assert.False(t, reloaded, "unchanged")
+
+	write(withCommandsPerHour("5"), start.Add(time.Minute))
+	reloaded, err = w.Check()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+
+	// an invalid file is reported once and the current configuration kept
+	write(withCommandsPerHour("-5"), start.Add(2*time.Minute))
+	_, err = w.Check()
+	assert.EqualError(t, err, "rateLimits.commandsPerHour must not be negative")
+	reloaded, err = w.Check()
+	assert.NoError(t, err)
+	assert.False(t, reloaded)
+
+	write(withCommandsPerHour("7"), start.Add(3*time.Minute))
+	reloaded, err = w.Check()
+	assert.NoError(t, err)
+	assert.True(t, reloaded)
+	assert.Equal(t, []int{5, 7}, applied)
+}
@@ -0,0 +1,79 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultWatchInterval is the period at which the configuration file is checked for changes.
+const DefaultWatchInterval = 10 * time.Second
+
+// Watcher reloads the configuration file when it changes. The file is polled rather than watched
+// with inotify so the updates of a mounted kubernetes ConfigMap, which swaps symlinks, are seen.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	// load reads and validates the file.
+	load func(path string) (*Config, error)
+	// apply is given each valid new configuration.
+	apply func(*Config)
+
+	modTime time.Time
+	size    int64
+}
+
+// NewWatcher returns a watcher of the file at path, which is current as loaded.
+// apply is given the new configuration when the file changes and load accepts it.
+func NewWatcher(path string, interval time.Duration, load func(path string) (*Config, error), apply func(*Config)) (*Watcher, error) {
+	w := &Watcher{path: path, interval: interval, load: load, apply: apply}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	w.modTime, w.size = fi.ModTime(), fi.Size()
+	return w, nil
+}
+
+// Check reloads the file when it changed since the last check, and returns whether it did.
+// An invalid file is reported and skipped, the current configuration is kept until the file changes again.
+func (w *Watcher) Check() (bool, error) {
+	fi, err := os.Stat(w.path)
+	if err != nil {
+		return false, err
+	}
+	if fi.ModTime().Equal(w.modTime) && fi.Size() == w.size {
+		return false, nil
+	}
+	w.modTime, w.size = fi.ModTime(), fi.Size()
+	c, err := w.load(w.path)
+	if err != nil {
+		return false, err
+	}
+	w.apply(c)
+	return true, nil
+}
+
+// Run checks the file every interval until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := w.Check()
+			if err != nil {
+				log.Error().Msgf("cannot reload configuration %s, keeping the current one, %s", w.path, err.Error())
+			} else if reloaded {
+				log.Info().Msgf("reloaded configuration %s", w.path)
+			}
+		}
+	}
+}
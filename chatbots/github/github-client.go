@@ -8,7 +8,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/google/go-github/v35/github"
 	"golang.org/x/oauth2"
@@ -71,12 +73,93 @@ func NewGithubClientByPush(ctx context.Context, e *github.PushEvent, token strin
 	}, nil
 }
 
+// NewGithubClientByPullRequest returns a client for the pull request of a pull_request event, at its head commit.
+func NewGithubClientByPullRequest(ctx context.Context, e *github.PullRequestEvent, token string) (*GithubClient, error) {
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+
+	}
+	if token == "" {
+		return nil, fmt.Errorf("env var missing")
+	}
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	tc := oauth2.NewClient(ctx, ts)
+	return &GithubClient{
+		Clt:     github.NewClient(tc),
+		Owner:   e.GetRepo().GetOwner().GetLogin(),
+		Repo:    e.GetRepo().GetName(),
+		Pr:      e.GetNumber(),
+		Author:  e.GetSender().GetLogin(),
+		LastSHA: e.GetPullRequest().GetHead().GetSHA(),
+		State:   e.GetPullRequest().GetState(),
+		Ctx:     ctx,
+	}, nil
+}
+
+// NewGithubClientByRun returns a client for the pull request and commit of a run reported by a workflow.
+func NewGithubClientByRun(ctx context.Context, owner, repo string, pr int, sha, token string) (*GithubClient, error) {
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+
+	}
+	if token == "" {
+		return nil, fmt.Errorf("env var missing")
+	}
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	tc := oauth2.NewClient(ctx, ts)
+	return &GithubClient{
+		Clt:     github.NewClient(tc),
+		Owner:   owner,
+		Repo:    repo,
+		Pr:      pr,
+		LastSHA: sha,
+		Ctx:     ctx,
+	}, nil
+}
+
 func (c GithubClient) PostComment(commentBody string) error {
 	issueComment := &github.IssueComment{Body: github.String(commentBody)}
 	_, _, err := c.Clt.Issues.CreateComment(c.Ctx, c.Owner, c.Repo, c.Pr, issueComment)
 	return err
 }
 
+// Login returns the login of the account the client is authenticated as.
+func (c GithubClient) Login() (string, error) {
+	user, _, err := c.Clt.Users.Get(c.Ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return user.GetLogin(), nil
+}
+
+// UpsertComment edits the pull request comment of the bot containing marker, or posts a new one.
+// It keeps a single comment per marker however many times it is called, the comments of
+// the other users are never edited even when they quote the marker.
+func (c GithubClient) UpsertComment(marker, commentBody string) error {
+	login, err := c.Login()
+	if err != nil {
+		return err
+	}
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := c.Clt.Issues.ListComments(c.Ctx, c.Owner, c.Repo, c.Pr, opts)
+		if err != nil {
+			return err
+		}
+		for _, comment := range comments {
+			if comment.GetUser().GetLogin() == login && strings.Contains(comment.GetBody(), marker) {
+				_, _, err := c.Clt.Issues.EditComment(c.Ctx, c.Owner, c.Repo, comment.GetID(), &github.IssueComment{Body: github.String(commentBody)})
+				return err
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return c.PostComment(commentBody)
+}
+
 func (c GithubClient) GetIssueState() string {
 	return c.State
 }
@@ -134,7 +217,14 @@ func (c *GithubClient) ListAssociatedPR(sha string) []*github.PullRequest {
 	return l
 }
 
-func (c GithubClient) CreateRepositoryDispatch(eventType string, clientPayload map[string]string) error {
+// MaxClientPayloadKeys is the number of top-level properties github accepts in the client payload of a repository dispatch.
+const MaxClientPayloadKeys = 10
+
+// CreateRepositoryDispatch starts the workflows of eventType, the TOKEN of the client payload is not logged.
+func (c GithubClient) CreateRepositoryDispatch(eventType string, clientPayload map[string]interface{}) error {
+	if len(clientPayload) > MaxClientPayloadKeys {
+		return fmt.Errorf("client payload has %d properties, github accepts at most %d", len(clientPayload), MaxClientPayloadKeys)
+	}
 	allArgs, err := json.Marshal(clientPayload)
 	if err != nil {
 		return fmt.Errorf("%v: could not encode client payload", err)
@@ -146,17 +236,70 @@ func (c GithubClient) CreateRepositoryDispatch(eventType string, clientPayload m
 		ClientPayload: &cp,
 	}
 
-	log.Printf("creating repository_dispatch with payload: %v", string(allArgs))
+	logged := make(map[string]interface{}, len(clientPayload))
+	for k, v := range clientPayload {
+		if k == "TOKEN" {
+			v = "<redacted>"
+		}
+		logged[k] = v
+	}
+	log.Printf("creating repository_dispatch with payload: %v", logged)
 	_, _, err = c.Clt.Repositories.Dispatch(c.Ctx, c.Owner, c.Repo, rd)
 	return err
 }
 
 func (c GithubClient) UpdateStatus(statusName, state, targetUrl string) error {
+	return c.UpdateStatusDescription(statusName, state, targetUrl, "")
+}
+
+// UpdateStatusDescription sets the commit status of LastSHA with a description shown next to it.
+func (c GithubClient) UpdateStatusDescription(statusName, state, targetUrl, description string) error {
 	status := github.RepoStatus{State: &state, Context: &statusName, TargetURL: &targetUrl}
+	if description != "" {
+		status.Description = &description
+	}
 	_, _, err := c.Clt.Repositories.CreateStatus(c.Ctx, c.Owner, c.Repo, c.LastSHA, &status)
 	return err
 }
 
+// CancelWorkflowRun cancels a workflow run of the repository.
+func (c GithubClient) CancelWorkflowRun(runID int64) error {
+	_, err := c.Clt.Actions.CancelWorkflowRunByID(c.Ctx, c.Owner, c.Repo, runID)
+	return err
+}
+
+// FindWorkflowRuns returns the ids of the queued and in progress workflow runs started by a repository dispatch
+// with a job named after id, the workflows name their jobs after the UUID of the run.
+func (c GithubClient) FindWorkflowRuns(id string) ([]int64, error) {
+	var ids []int64
+	// a run listed as queued may be in progress by the next request
+	found := map[int64]bool{}
+	for _, status := range []string{"queued", "in_progress"} {
+		opts := &github.ListWorkflowRunsOptions{Event: "repository_dispatch", Status: status, ListOptions: github.ListOptions{PerPage: 100}}
+		runs, _, err := c.Clt.Actions.ListRepositoryWorkflowRuns(c.Ctx, c.Owner, c.Repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, run := range runs.WorkflowRuns {
+			if found[run.GetID()] {
+				continue
+			}
+			jobs, _, err := c.Clt.Actions.ListWorkflowJobs(c.Ctx, c.Owner, c.Repo, run.GetID(), &github.ListWorkflowJobsOptions{})
+			if err != nil {
+				return nil, err
+			}
+			for _, job := range jobs.Jobs {
+				if strings.Contains(job.GetName(), id) {
+					found[run.GetID()] = true
+					ids = append(ids, run.GetID())
+					break
+				}
+			}
+		}
+	}
+	return ids, nil
+}
+
 func (c GithubClient) GetLatestTag() (string, error) {
 	listops := &github.ListOptions{Page: 1, PerPage: 250}
 	tags, _, err := c.Clt.Repositories.ListTags(context.Background(), c.Owner, c.Repo, listops)
@@ -170,3 +313,99 @@ func (c GithubClient) GetLatestTag() (string, error) {
 	}
 	return c.LastTag, nil
 }
+
+// IsTeamMember returns whether user is an active member of the team slug of org.
+// The token needs the read:org scope.
+func (c GithubClient) IsTeamMember(org, slug, user string) (bool, error) {
+	m, resp, err := c.Clt.Teams.GetTeamMembershipBySlug(c.Ctx, org, slug, user)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return m.GetState() == "active", nil
+}
+
+// DefaultBranchSHA returns the head commit of the default branch of the repository.
+func (c GithubClient) DefaultBranchSHA() (string, error) {
+	sha, _, err := c.Clt.Repositories.GetCommitSHA1(c.Ctx, c.Owner, c.Repo, "HEAD", "")
+	return sha, err
+}
+
+// GetFile returns the content of the file of the repository at ref, and false when it does not exist.
+func (c GithubClient) GetFile(path, ref string) ([]byte, bool, error) {
+	file, _, resp, err := c.Clt.Repositories.GetContents(c.Ctx, c.Owner, c.Repo, path, &github.RepositoryContentGetOptions{Ref: ref})
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if file == nil {
+		return nil, false, fmt.Errorf("%s is a directory", path)
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, false, err
+	}
+	return []byte(content), true, nil
+}
+
+// FindOpenIssue returns the open issue carrying label whose body contains marker, or nil.
+func (c GithubClient) FindOpenIssue(label, marker string) (*github.Issue, error) {
+	opts := &github.IssueListByRepoOptions{State: "open", Labels: []string{label}, ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		issues, resp, err := c.Clt.Issues.ListByRepo(c.Ctx, c.Owner, c.Repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range issues {
+			if !issue.IsPullRequest() && strings.Contains(issue.GetBody(), marker) {
+				return issue, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (c GithubClient) CreateIssue(title, body string, labels []string) (*github.Issue, error) {
+	issue, _, err := c.Clt.Issues.Create(c.Ctx, c.Owner, c.Repo, &github.IssueRequest{Title: &title, Body: &body, Labels: &labels})
+	return issue, err
+}
+
+func (c GithubClient) CommentIssue(number int, body string) error {
+	_, _, err := c.Clt.Issues.CreateComment(c.Ctx, c.Owner, c.Repo, number, &github.IssueComment{Body: github.String(body)})
+	return err
+}
+
+// ListIssueComments returns the comments of an issue, oldest first.
+func (c GithubClient) ListIssueComments(number int) ([]*github.IssueComment, error) {
+	var all []*github.IssueComment
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := c.Clt.Issues.ListComments(c.Ctx, c.Owner, c.Repo, number, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, comments...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+func (c GithubClient) CloseIssue(number int) error {
+	_, _, err := c.Clt.Issues.Edit(c.Ctx, c.Owner, c.Repo, number, &github.IssueRequest{State: github.String("closed")})
+	return err
+}
+
+// CompareCommits returns the commits reachable from head but not from base.
+func (c GithubClient) CompareCommits(base, head string) (*github.CommitsComparison, error) {
+	cmp, _, err := c.Clt.Repositories.CompareCommits(c.Ctx, c.Owner, c.Repo, base, head)
+	return cmp, err
+}
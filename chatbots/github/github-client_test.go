@@ -0,0 +1,63 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpsertComment(t *testing.T) {
+	tests := []struct {
+		name         string
+		comments     string
+		expectEdited string
+		expectPosted bool
+	}{
+		{name: "no comment", comments: `[]`, expectPosted: true},
+		{name: "edit the bot comment", comments: `[{"id":1,"body":"hello","user":{"login":"bot"}},{"id":2,"body":"<!-- report --> old","user":{"login":"bot"}}]`, expectEdited: "/repos/datafuselabs/databend/issues/comments/2"},
+		{name: "quoted by a user", comments: `[{"id":3,"body":"> <!-- report --> old","user":{"login":"alice"}}]`, expectPosted: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var edited string
+			var posted bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/user":
+					fmt.Fprint(w, `{"login":"bot"}`)
+				case r.Method == http.MethodGet && r.URL.Path == "/repos/datafuselabs/databend/issues/233/comments":
+					fmt.Fprint(w, tt.comments)
+				case r.Method == http.MethodPost && r.URL.Path == "/repos/datafuselabs/databend/issues/233/comments":
+					posted = true
+					fmt.Fprint(w, `{"id":4}`)
+				case r.Method == http.MethodPatch:
+					var comment github.IssueComment
+					assert.NoError(t, json.NewDecoder(r.Body).Decode(&comment))
+					assert.Equal(t, "<!-- report --> new", comment.GetBody())
+					edited = r.URL.Path
+					fmt.Fprint(w, `{"id":2}`)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer srv.Close()
+			clt := github.NewClient(srv.Client())
+			clt.BaseURL, _ = url.Parse(srv.URL + "/")
+			c := GithubClient{Clt: clt, Owner: "datafuselabs", Repo: "databend", Pr: 233, Ctx: context.Background()}
+
+			assert.NoError(t, c.UpsertComment("<!-- report -->", "<!-- report --> new"))
+			assert.Equal(t, tt.expectEdited, edited)
+			assert.Equal(t, tt.expectPosted, posted)
+		})
+	}
+}
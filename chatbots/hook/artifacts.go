@@ -0,0 +1,213 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package hook
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"datafuselabs/test-infra/chatbots/registry"
+)
+
+const (
+	// DefaultArtifactMaxFileSize is the default size limit of a single artifact.
+	DefaultArtifactMaxFileSize int64 = 64 << 20
+	// DefaultArtifactMaxRunSize is the default size limit of all the artifacts of a run.
+	DefaultArtifactMaxRunSize int64 = 512 << 20
+)
+
+var (
+	errQuotaExceeded       = errors.New("artifact quota exceeded")
+	errInvalidArtifactPath = errors.New("invalid artifact path")
+)
+
+// splitArtifactPath parses /artifacts/{owner}/{repo}/{scope}/{sha}/{uuid}/{path...}, scope is the pull request number or branch:{name},
+// the returned artifact path is empty when the url names the run itself.
+func splitArtifactPath(p string) (registry.Key, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(p, artifactsEndpoint), "/", 6)
+	if len(parts) < 5 {
+		return registry.Key{}, "", errPageNotFound
+	}
+	key := registry.NewKey(parts[0], parts[1], parts[2], parts[3], parts[4])
+	if err := key.Validate(); err != nil {
+		return registry.Key{}, "", err
+	}
+	if len(parts) == 5 || parts[5] == "" {
+		return key, "", nil
+	}
+	name := parts[5]
+	if strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return registry.Key{}, "", errInvalidArtifactPath
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return registry.Key{}, "", errInvalidArtifactPath
+		}
+	}
+	return key, name, nil
+}
+
+// artifactContentType returns the declared content type, or the one of the file extension.
+func artifactContentType(name, declared string) string {
+	if declared != "" && declared != "application/octet-stream" {
+		return declared
+	}
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// quotaReader counts and hashes what is read and fails once more than limit bytes are read.
+type quotaReader struct {
+	r     io.Reader
+	limit int64
+	read  int64
+	hash  hash.Hash
+}
+
+func (q *quotaReader) Read(p []byte) (int, error) {
+	n, err := q.r.Read(p)
+	q.read += int64(n)
+	q.hash.Write(p[:n])
+	if q.read > q.limit {
+		return n, errQuotaExceeded
+	}
+	return n, err
+}
+
+// storeArtifact streams body into the storage backend and records the artifact on the run.
+// size is the announced size of body, -1 if unknown.
+// Quotas are checked against the recorded artifacts, concurrent uploads of a run may overshoot them.
+func (s *Server) storeArtifact(ctx context.Context, key registry.Key, name, contentType string, size int64, body io.Reader) (*registry.Artifact, error) {
+	run, err := s.Config.Registry.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	limit := s.Config.ArtifactMaxFileSize
+	if remaining := s.Config.ArtifactMaxRunSize - run.ArtifactsSize(name); remaining < limit {
+		limit = remaining
+	}
+	if size > limit {
+		return nil, errQuotaExceeded
+	}
+	q := &quotaReader{r: body, limit: limit, hash: sha256.New()}
+	err = s.Config.StorageEndpoint.Put(ctx, key.Artifact(name), q)
+	if q.read > limit {
+		return nil, errQuotaExceeded
+	}
+	if err != nil {
+		return nil, err
+	}
+	artifact := registry.Artifact{
+		Path:        name,
+		Size:        q.read,
+		ContentType: artifactContentType(name, contentType),
+		SHA256:      hex.EncodeToString(q.hash.Sum(nil)),
+	}
+	run, err = s.Config.Registry.Record(registry.Run{Key: key, Artifacts: []registry.Artifact{artifact}})
+	if err != nil {
+		return nil, err
+	}
+	artifact, _ = run.Artifact(name)
+	s.Config.Logger.Info().Msgf("stored artifact %s of run %s, %d bytes", name, key.String(), artifact.Size)
+	return &artifact, nil
+}
+
+// artifactStatus maps the errors of the artifact handlers to a response code.
+func artifactStatus(err error) int {
+	switch {
+	case errors.Is(err, errQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, errInvalidArtifactPath):
+		return http.StatusBadRequest
+	case errors.Is(err, registry.ErrNotFound), errors.Is(err, errPageNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// runToken returns the callback token of an artifact request, sent as a bearer token.
+func runToken(req *http.Request) string {
+	return strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+}
+
+// artifacts serves PUT and GET on /artifacts/{owner}/{repo}/{pr}/{sha}/{uuid}/{path...}.
+// PUT streams the body as the artifact at path and requires the run callback token.
+// GET on a path downloads the artifact, GET on the run lists its artifacts.
+func (s *Server) artifacts(w http.ResponseWriter, req *http.Request) {
+	key, name, err := splitArtifactPath(req.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), artifactStatus(err))
+		return
+	}
+	switch {
+	case req.Method == http.MethodPut && name != "":
+		if err := s.verifyRun(key, runToken(req)); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		artifact, err := s.storeArtifact(req.Context(), key, name, req.Header.Get("Content-Type"), req.ContentLength, req.Body)
+		if err != nil {
+			s.Config.Logger.Error().Msgf("unable to store artifact %s of run %s, %s", name, key.String(), err.Error())
+			http.Error(w, err.Error(), artifactStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(artifact)
+	case req.Method == http.MethodGet && name == "":
+		run, err := s.Config.Registry.Get(key)
+		if err != nil {
+			http.Error(w, err.Error(), artifactStatus(err))
+			return
+		}
+		artifacts := run.Artifacts
+		if artifacts == nil {
+			artifacts = []registry.Artifact{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(artifacts)
+	case req.Method == http.MethodGet:
+		s.downloadArtifact(w, req, key, name)
+	default:
+		http.Error(w, fmt.Sprintf("method %s not allowed", req.Method), http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) downloadArtifact(w http.ResponseWriter, req *http.Request, key registry.Key, name string) {
+	run, err := s.Config.Registry.Get(key)
+	if err != nil {
+		http.Error(w, err.Error(), artifactStatus(err))
+		return
+	}
+	artifact, ok := run.Artifact(name)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	r, err := s.Config.StorageEndpoint.Get(req.Context(), key.Artifact(name))
+	if err != nil {
+		s.Config.Logger.Error().Msgf("unable to retrieve artifact %s of run %s, %s", name, key.String(), err.Error())
+		http.NotFound(w, req)
+		return
+	}
+	defer r.Close()
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Content-Length", fmt.Sprint(artifact.Size))
+	w.Header().Set("X-Checksum-Sha256", artifact.SHA256)
+	if _, err := io.Copy(w, r); err != nil {
+		s.Config.Logger.Error().Msgf("unable to send artifact %s of run %s, %s", name, key.String(), err.Error())
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package hook
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"datafuselabs/test-infra/chatbots/registry"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitArtifactPath(t *testing.T) {
+	key := registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "1"}
+	tests := []struct {
+		name     string
+		path     string
+		wantKey  registry.Key
+		wantName string
+		err      bool
+	}{
+		{name: "run", path: "/artifacts/datafuselabs/databend/233/foo/1", wantKey: key},
+		{name: "run trailing slash", path: "/artifacts/datafuselabs/databend/233/foo/1/", wantKey: key},
+		{name: "file", path: "/artifacts/datafuselabs/databend/233/foo/1/result.json", wantKey: key, wantName: "result.json"},
+		{name: "nested", path: "/artifacts/datafuselabs/databend/233/foo/1/flamegraphs/q1.svg", wantKey: key, wantName: "flamegraphs/q1.svg"},
+		{name: "missing uuid", path: "/artifacts/datafuselabs/databend/233/foo", err: true},
+		{name: "escape", path: "/artifacts/datafuselabs/databend/233/foo/1/../2/result.json", err: true},
+		{name: "empty segment", path: "/artifacts/datafuselabs/databend/233/foo/1/a//b", err: true},
+		{name: "invalid key", path: "/artifacts/datafuselabs/databend/233/../1/result.json", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, name, err := splitArtifactPath(tt.path)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantKey, key)
+			assert.Equal(t, tt.wantName, name)
+		})
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	s := newFakeServer(t)
+	s.Config.ArtifactMaxFileSize = 10
+	s.Config.ArtifactMaxRunSize = 16
+	key := registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "1"}
+	_, err := s.Config.Registry.Record(registry.Run{Key: key, DispatchName: "run-perf", Status: "queued"})
+	assert.NoError(t, err)
+	token := s.Config.Signer.Mint(key)
+	base := artifactsEndpoint + key.String() + "/"
+
+	do := func(method, path, token, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		s.artifacts(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		token       string
+		contentType string
+		body        string
+		code        int
+	}{
+		{name: "put json", method: http.MethodPut, path: base + "result.json", token: token, body: `{"q1":1}`, code: http.StatusCreated},
+		{name: "put nested", method: http.MethodPut, path: base + "flamegraphs/q1.svg", token: token, contentType: "image/svg+xml", body: "<svg/>", code: http.StatusCreated},
+		{name: "missing token", method: http.MethodPut, path: base + "other.txt", body: "x", code: http.StatusUnauthorized},
+		{name: "file quota", method: http.MethodPut, path: base + "big.log", token: token, body: "0123456789a", code: http.StatusRequestEntityTooLarge},
+		{name: "run quota", method: http.MethodPut, path: base + "more.log", token: token, body: "0123", code: http.StatusRequestEntityTooLarge},
+		{name: "replace within run quota", method: http.MethodPut, path: base + "result.json", token: token, body: `{"q1":222}`, code: http.StatusCreated},
+		{name: "invalid path", method: http.MethodPut, path: base + "../x", token: token, body: "x", code: http.StatusBadRequest},
+		{name: "unknown run", method: http.MethodGet, path: artifactsEndpoint + "datafuselabs/databend/233/foo/2/", code: http.StatusNotFound},
+		{name: "unknown artifact", method: http.MethodGet, path: base + "big.log", code: http.StatusNotFound},
+		{name: "delete", method: http.MethodDelete, path: base + "result.json", token: token, code: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := do(tt.method, tt.path, tt.token, tt.contentType, tt.body)
+			assert.Equal(t, tt.code, rec.Code, rec.Body.String())
+		})
+	}
+
+	rec := do(http.MethodGet, base, "", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var artifacts []registry.Artifact
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&artifacts))
+	assert.Len(t, artifacts, 2)
+	assert.Equal(t, "result.json", artifacts[0].Path)
+	assert.Equal(t, int64(10), artifacts[0].Size)
+	assert.Equal(t, "application/json", artifacts[0].ContentType)
+	assert.Equal(t, "image/svg+xml", artifacts[1].ContentType)
+
+	rec = do(http.MethodGet, base+"flamegraphs/q1.svg", "", "", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "<svg/>", rec.Body.String())
+	assert.Equal(t, "image/svg+xml", rec.Header().Get("Content-Type"))
+	sum := sha256.Sum256([]byte("<svg/>"))
+	assert.Equal(t, hex.EncodeToString(sum[:]), rec.Header().Get("X-Checksum-Sha256"))
+}
+
+// failingReader returns the content of r, then err instead of io.EOF.
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+func TestArtifacts_failedReplace(t *testing.T) {
+	s := newFakeServer(t)
+	s.Config.ArtifactMaxFileSize = 10
+	s.Config.ArtifactMaxRunSize = 16
+	key := registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "1"}
+	_, err := s.Config.Registry.Record(registry.Run{Key: key, DispatchName: "run-perf", Status: "queued"})
+	assert.NoError(t, err)
+	path := artifactsEndpoint + key.String() + "/result.json"
+
+	put := func(body io.Reader) int {
+		req := httptest.NewRequest(http.MethodPut, path, body)
+		// the size is unknown, the quota is checked while streaming
+		req.ContentLength = -1
+		req.Header.Set("Authorization", "Bearer "+s.Config.Signer.Mint(key))
+		rec := httptest.NewRecorder()
+		s.artifacts(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusCreated, put(strings.NewReader(`{"q1":1}`)))
+
+	tests := []struct {
+		name string
+		body io.Reader
+		code int
+	}{
+		{name: "over quota", body: strings.NewReader(`{"q1":123456789}`), code: http.StatusRequestEntityTooLarge},
+		{name: "broken upload", body: &failingReader{r: strings.NewReader(`{"q1"`), err: errors.New("connection reset")}, code: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.code, put(tt.body))
+
+			rec := httptest.NewRecorder()
+			s.artifacts(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, `{"q1":1}`, rec.Body.String(), "the stored artifact is kept")
+			run, err := s.Config.Registry.Get(key)
+			assert.NoError(t, err)
+			artifact, ok := run.Artifact("result.json")
+			assert.True(t, ok)
+			assert.Equal(t, int64(len(`{"q1":1}`)), artifact.Size)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package hook
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"datafuselabs/test-infra/chatbots/policy"
+)
+
+// defaultAuditLimit is the number of audit entries returned by default.
+const defaultAuditLimit = 100
+
+// adminAuthorized checks the bearer token of an admin api request.
+func (s *Server) adminAuthorized(r *http.Request) bool {
+	token := runToken(r)
+	return s.Config.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.Config.AdminToken)) == 1
+}
+
+// apiAudit serves /api/audit?limit=100, the last permission decisions, most recent first.
+// The log names the users and their denied commands, it requires the admin token.
+func (s *Server) apiAudit(w http.ResponseWriter, r *http.Request) {
+	if !s.adminAuthorized(r) {
+		http.Error(w, "admin token required", http.StatusUnauthorized)
+		return
+	}
+	if s.Config.PolicyStore == nil {
+		http.Error(w, "the audit log is not configured", http.StatusNotFound)
+		return
+	}
+	limit, err := intParam(r, "limit", defaultAuditLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entries, err := s.Config.PolicyStore.AuditLog(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []policy.Entry{}
+	}
+	writeJSON(w, entries)
+}
@@ -0,0 +1,51 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package hook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"datafuselabs/test-infra/chatbots/policy"
+	"datafuselabs/test-infra/chatbots/registry"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIAudit(t *testing.T) {
+	s := newFakeServer(t)
+	s.Config.AdminToken = "admin"
+	audit := func(target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		s.apiAudit(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusNotFound, audit(apiAuditEndpoint, "admin").Code)
+
+	var err error
+	s.Config.PolicyStore, err = policy.NewStore(s.Config.Registry.(*registry.BoltRegistry).DB())
+	assert.NoError(t, err)
+	assert.Equal(t, "[]\n", audit(apiAuditEndpoint, "admin").Body.String())
+
+	for _, user := range []string{"alice", "bob"} {
+		assert.NoError(t, s.Config.PolicyStore.Audit(policy.Entry{User: user, Command: "run-perf", Decision: policy.Denied}))
+	}
+	var entries []policy.Entry
+	assert.NoError(t, json.NewDecoder(audit(apiAuditEndpoint+"?limit=1", "admin").Body).Decode(&entries))
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "bob", entries[0].User)
+
+	assert.Equal(t, http.StatusBadRequest, audit(apiAuditEndpoint+"?limit=x", "admin").Code)
+	assert.Equal(t, http.StatusUnauthorized, audit(apiAuditEndpoint, "").Code)
+	assert.Equal(t, http.StatusUnauthorized, audit(apiAuditEndpoint, "webhook").Code)
+
+	s.Config.AdminToken = ""
+	assert.Equal(t, http.StatusUnauthorized, audit(apiAuditEndpoint, "").Code, "no admin token, no admin api")
+}
@@ -0,0 +1,254 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package hook
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/scheduler"
+	"datafuselabs/test-infra/chatbots/utils"
+)
+
+const (
+	// dashboardWindow is how far back the dashboard looks for runs.
+	dashboardWindow = 30 * 24 * time.Hour
+	// dashboardLimit caps the number of runs listed on the index page.
+	dashboardLimit = 200
+	// signedURLTTL is the validity of the presigned urls the run files are redirected to.
+	signedURLTTL = 15 * time.Minute
+)
+
+// benchmarkFiles are the run files uploaded by the workflows that the dashboard serves.
+var benchmarkFiles = map[string]string{
+	"compare.html": "text/html; charset=utf-8",
+	"current.log":  "text/plain; charset=utf-8",
+	"ref.log":      "text/plain; charset=utf-8",
+}
+
+var errPageNotFound = errors.New("page not found")
+
+// httpStatus maps the errors of the dashboard handlers to a response code.
+func httpStatus(err error) int {
+	if errors.Is(err, errPageNotFound) || errors.Is(err, registry.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// RunFilter holds the dashboard filters, empty fields match every run.
+type RunFilter struct {
+	PR     string
+	Author string
+	Status string
+}
+
+func runFilterFromRequest(r *http.Request) RunFilter {
+	q := r.URL.Query()
+	return RunFilter{
+		PR:     strings.TrimSpace(q.Get("pr")),
+		Author: strings.TrimSpace(q.Get("author")),
+		Status: strings.TrimSpace(q.Get("status")),
+	}
+}
+
+func (f RunFilter) match(run registry.Run) bool {
+	return (f.PR == "" || f.PR == run.PR) &&
+		(f.Author == "" || strings.EqualFold(f.Author, run.Author)) &&
+		(f.Status == "" || f.Status == run.Status || f.Status == run.Conclusion)
+}
+
+// RunView is a recorded run as displayed by the dashboard.
+type RunView struct {
+	registry.Run
+	Duration string
+}
+
+// BenchmarkURL returns the dashboard page of the run commit.
+func (v RunView) BenchmarkURL() string {
+	return strings.Join([]string{"/benchmark", v.Org, v.Repo, v.Scope(), v.SHA}, "/")
+}
+
+// FileURL returns the dashboard url serving one of the uploaded files of the run.
+func (v RunView) FileURL(name string) string {
+	return strings.Join([]string{v.BenchmarkURL(), v.UUID, name}, "/")
+}
+
+func newRunView(run registry.Run) RunView {
+	v := RunView{Run: run}
+	if d := run.Duration(); d > 0 {
+		v.Duration = d.Round(time.Second).String()
+	}
+	return v
+}
+
+// Dashboard is the parameter of the index template.
+type Dashboard struct {
+	Filter   RunFilter
+	Statuses []string
+	Items    []RunView
+}
+
+// BenchmarkPage is the parameter of the benchmark template.
+type BenchmarkPage struct {
+	Org    string
+	Repo   string
+	PR     string
+	Commit string
+	// Latest is the most recent run of the commit, its compare.html is embedded in the page.
+	Latest RunView
+	Items  []RunView
+}
+
+func (s *Server) dashboard(r *http.Request) (interface{}, error) {
+	if r.URL.Path != indexEndpoint {
+		return nil, errPageNotFound
+	}
+	filter := runFilterFromRequest(r)
+	now := time.Now()
+	runs, err := s.Config.Registry.ListByTime(now.Add(-dashboardWindow), now.Add(time.Minute))
+	if err != nil {
+		return nil, err
+	}
+	d := Dashboard{
+		Filter:   filter,
+		Statuses: []string{"queued", "in_progress", "completed", "success", "failure", "cancelled"},
+		Items:    []RunView{},
+	}
+	for _, run := range runs {
+		if !filter.match(run) {
+			continue
+		}
+		d.Items = append(d.Items, newRunView(run))
+		if len(d.Items) == dashboardLimit {
+			break
+		}
+	}
+	return d, nil
+}
+
+// splitBenchmarkPath parses /benchmark/{org}/{repo}/{scope}/{commit} and /benchmark/{org}/{repo}/{scope}/{commit}/{uuid}/{file},
+// scope is the pull request number or branch:{name}. The uuid of the key is empty for the commit page.
+func splitBenchmarkPath(p string) (key registry.Key, file string, err error) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(p, benchmarkResultEndpoint), "/"), "/")
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			return registry.Key{}, "", errPageNotFound
+		}
+	}
+	switch len(parts) {
+	case 4:
+		return registry.NewKey(parts[0], parts[1], parts[2], parts[3], ""), "", nil
+	case 6:
+		return registry.NewKey(parts[0], parts[1], parts[2], parts[3], parts[4]), parts[5], nil
+	}
+	return registry.Key{}, "", errPageNotFound
+}
+
+func (s *Server) benchmarkPage(r *http.Request) (interface{}, error) {
+	key, _, err := splitBenchmarkPath(r.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := s.Config.Registry.ListByCommit(key.Org, key.Repo, key.Scope(), key.SHA)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, registry.ErrNotFound
+	}
+	page := BenchmarkPage{Org: key.Org, Repo: key.Repo, PR: key.Scope(), Commit: key.SHA}
+	for _, run := range runs {
+		page.Items = append(page.Items, newRunView(run))
+	}
+	page.Latest = page.Items[0]
+	return page, nil
+}
+
+// benchmarkFile serves a file uploaded by a run from the storage backend,
+// or redirects to a presigned url of the bucket.
+func (s *Server) benchmarkFile(w http.ResponseWriter, r *http.Request, key registry.Key, file string) {
+	contentType, ok := benchmarkFiles[file]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	run, err := s.Config.Registry.Get(key)
+	if errors.Is(err, registry.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	u, err := s.Config.StorageEndpoint.SignedURL(r.Context(), run.Key.Artifact(file), signedURLTTL)
+	if err == nil {
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	if !errors.Is(err, utils.ErrSignedURLNotSupported) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, err := s.Config.StorageEndpoint.Get(r.Context(), run.Key.Artifact(file))
+	if err != nil {
+		s.Config.Logger.Error().Msgf("unable to retrieve %s of run %s, %s", file, run.Key.String(), err.Error())
+		http.NotFound(w, r)
+		return
+	}
+	defer data.Close()
+	w.Header().Set("Content-Type", contentType)
+	_, _ = io.Copy(w, data)
+}
+
+// benchmark routes the pages and the files under benchmarkResultEndpoint.
+func (s *Server) benchmark(w http.ResponseWriter, r *http.Request) {
+	key, file, err := splitBenchmarkPath(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if file != "" {
+		s.benchmarkFile(w, r, key, file)
+		return
+	}
+	s.handleTemplate("benchmark.html", s.benchmarkPage)(w, r)
+}
+
+// QueueItem is a run of the scheduler queue as listed on the queue page.
+type QueueItem struct {
+	scheduler.Entry
+	// Wait is the estimated time before a queued run starts, or the time a running run started.
+	Wait string
+}
+
+// QueuePage is the parameter of the queue template.
+type QueuePage struct {
+	Limit int
+	Items []QueueItem
+}
+
+func (s *Server) queuePage(r *http.Request) (interface{}, error) {
+	if s.Config.Scheduler == nil {
+		return nil, errPageNotFound
+	}
+	entries, err := s.Config.Scheduler.Queue()
+	if err != nil {
+		return nil, err
+	}
+	page := QueuePage{Limit: s.Config.Scheduler.Limit()}
+	for _, e := range entries {
+		item := QueueItem{Entry: e, Wait: e.ETA.Round(time.Minute).String()}
+		if e.State == scheduler.Running {
+			item.Wait = e.StartedAt.Format(time.RFC3339)
+		}
+		page.Items = append(page.Items, item)
+	}
+	return page, nil
+}
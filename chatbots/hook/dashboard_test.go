@@ -0,0 +1,204 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package hook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/scheduler"
+	"datafuselabs/test-infra/chatbots/trends"
+	"datafuselabs/test-infra/chatbots/utils"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeServer(t *testing.T) *Server {
+	r, err := registry.NewBoltRegistry(filepath.Join(t.TempDir(), "registry.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	storage := &utils.FileStorage{BasePath: t.TempDir()}
+	signer, err := registry.NewSigner("secret", time.Hour)
+	assert.NoError(t, err)
+	cfg := NewConfig(storage, r, signer, context.Background(), zerolog.Nop(), "", "", "", "", "", "", "../cmd/templates", "")
+	cfg.Trends, err = trends.NewStore(r.DB())
+	assert.NoError(t, err)
+	return &Server{Config: cfg}
+}
+
+func TestSplitBenchmarkPath(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		want []string
+		err  bool
+	}{
+		{name: "page", path: "/benchmark/datafuselabs/databend/233/foo", want: []string{"datafuselabs", "databend", "233", "foo", "", ""}},
+		{name: "page trailing slash", path: "/benchmark/datafuselabs/databend/233/foo/", want: []string{"datafuselabs", "databend", "233", "foo", "", ""}},
+		{name: "file", path: "/benchmark/datafuselabs/databend/233/foo/1/compare.html", want: []string{"datafuselabs", "databend", "233", "foo", "1", "compare.html"}},
+		{name: "missing commit", path: "/benchmark/datafuselabs/databend/233", err: true},
+		{name: "missing repository", path: "/benchmark/databend/233/foo", err: true},
+		{name: "escape", path: "/benchmark/datafuselabs/databend/233/../1/compare.html", err: true},
+		{name: "too long", path: "/benchmark/datafuselabs/databend/233/foo/1/compare.html/x", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, file, err := splitBenchmarkPath(tt.path)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, []string{key.Org, key.Repo, key.PR, key.SHA, key.UUID, file})
+		})
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	s := newFakeServer(t)
+	for _, meta := range []StatusMeta{
+		{Organization: "datafuselabs", Repository: "databend", PRNumber: "233", CommitSHA: "foo", UUID: "1", DispatchName: "run-perf", Author: "alice", Status: "completed", Conclusion: "success"},
+		{Organization: "datafuselabs", Repository: "databend", PRNumber: "233", CommitSHA: "bar", UUID: "2", DispatchName: "run-perf", Author: "alice", Status: "in_progress"},
+		{Organization: "datafuselabs", Repository: "databend", PRNumber: "12", CommitSHA: "baz", UUID: "3", DispatchName: "build-docker", Author: "bob", Status: "queued"},
+	} {
+		_, err := s.HandleStatus(meta)
+		assert.NoError(t, err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{name: "all", query: "", want: []string{"3", "2", "1"}},
+		{name: "pr", query: "?pr=233", want: []string{"2", "1"}},
+		{name: "author", query: "?author=Bob", want: []string{"3"}},
+		{name: "conclusion", query: "?status=success", want: []string{"1"}},
+		{name: "combined", query: "?pr=233&status=queued", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			d, err := s.dashboard(req)
+			assert.NoError(t, err)
+			var uuids []string
+			for _, item := range d.(Dashboard).Items {
+				uuids = append(uuids, item.UUID)
+			}
+			assert.Equal(t, tt.want, uuids)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	s.handleTemplate("index.html", s.dashboard)(rec, httptest.NewRequest(http.MethodGet, "/?pr=233", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `href="/benchmark/datafuselabs/databend/233/foo"`)
+
+	rec = httptest.NewRecorder()
+	s.handleTemplate("index.html", s.dashboard)(rec, httptest.NewRequest(http.MethodGet, "/unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestBenchmark(t *testing.T) {
+	s := newFakeServer(t)
+	meta := StatusMeta{Organization: "datafuselabs", Repository: "databend", PRNumber: "233", CommitSHA: "foo", UUID: "1", DispatchName: "run-perf", Status: "completed", Conclusion: "success"}
+	_, err := s.HandleStatus(meta)
+	assert.NoError(t, err)
+	err = s.Config.StorageEndpoint.Put(context.Background(), registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "1"}.Artifact("compare.html"), strings.NewReader("<p>compare</p>"))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		path     string
+		code     int
+		contains string
+	}{
+		{name: "page", path: "/benchmark/datafuselabs/databend/233/foo", code: http.StatusOK, contains: `src="/benchmark/datafuselabs/databend/233/foo/1/compare.html"`},
+		{name: "compare", path: "/benchmark/datafuselabs/databend/233/foo/1/compare.html", code: http.StatusOK, contains: "<p>compare</p>"},
+		{name: "missing log", path: "/benchmark/datafuselabs/databend/233/foo/1/current.log", code: http.StatusNotFound},
+		{name: "unknown file", path: "/benchmark/datafuselabs/databend/233/foo/1/registry.db", code: http.StatusNotFound},
+		{name: "unknown run", path: "/benchmark/datafuselabs/databend/233/foo/2/compare.html", code: http.StatusNotFound},
+		{name: "unknown commit", path: "/benchmark/datafuselabs/databend/233/bar", code: http.StatusNotFound},
+		{name: "other repository", path: "/benchmark/datafuselabs/fuse-store/233/foo", code: http.StatusNotFound},
+		{name: "other repository file", path: "/benchmark/datafuselabs/fuse-store/233/foo/1/compare.html", code: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.benchmark(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.code, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.contains)
+		})
+	}
+}
+
+// signingStorage is a storage serving its files from presigned urls.
+type signingStorage struct {
+	utils.FileStorage
+}
+
+func (s *signingStorage) SignedURL(ctx context.Context, key utils.ArtifactKey, ttl time.Duration) (string, error) {
+	return "https://perf.s3.amazonaws.com/" + key.Path() + "?X-Amz-Expires=" + fmt.Sprint(ttl.Seconds()), nil
+}
+
+func TestBenchmark_signedURL(t *testing.T) {
+	s := newFakeServer(t)
+	s.Config.StorageEndpoint = &signingStorage{}
+	meta := StatusMeta{Organization: "datafuselabs", Repository: "databend", PRNumber: "233", CommitSHA: "foo", UUID: "1", DispatchName: "run-perf", Status: "completed", Conclusion: "success"}
+	_, err := s.HandleStatus(meta)
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	s.benchmark(rec, httptest.NewRequest(http.MethodGet, "/benchmark/datafuselabs/databend/233/foo/1/current.log", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "https://perf.s3.amazonaws.com/datafuselabs/databend/233/foo/1/current.log?X-Amz-Expires=900", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	s.benchmark(rec, httptest.NewRequest(http.MethodGet, "/benchmark/datafuselabs/databend/233/foo/2/current.log", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "unknown run")
+}
+
+func TestQueue(t *testing.T) {
+	s := newFakeServer(t)
+	rec := httptest.NewRecorder()
+	s.handleTemplate("queue.html", s.queuePage)(rec, httptest.NewRequest(http.MethodGet, "/queue", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code, "no queue without scheduler")
+
+	var err error
+	s.Config.Scheduler, err = scheduler.New(s.Config.Registry.(*registry.BoltRegistry).DB(), scheduler.Options{Limit: 1})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var dispatched []string
+	s.Config.Scheduler.Start(ctx, func(j scheduler.Job) error {
+		dispatched = append(dispatched, j.Run.UUID)
+		return nil
+	}, nil, time.Hour)
+	for _, uuid := range []string{"1", "2"} {
+		meta := StatusMeta{Organization: "datafuselabs", Repository: "databend", PRNumber: uuid, CommitSHA: "foo", UUID: uuid, DispatchName: "run-perf", Status: "queued"}
+		_, err := s.Config.Scheduler.Enqueue(scheduler.NewJob("run_perf", meta.Run(), nil))
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"1"}, dispatched)
+
+	rec = httptest.NewRecorder()
+	s.handleTemplate("queue.html", s.queuePage)(rec, httptest.NewRequest(http.MethodGet, "/queue", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "2 runs, 1 running at once.")
+	assert.Contains(t, rec.Body.String(), `<td>queued</td>`)
+
+	// the completion of the running run gives its runner to the queued one
+	meta := StatusMeta{Organization: "datafuselabs", Repository: "databend", PRNumber: "1", CommitSHA: "foo", UUID: "1", DispatchName: "run-perf", Status: "completed", Conclusion: "success"}
+	_, err = s.HandleStatus(meta)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, dispatched)
+}
@@ -2,16 +2,33 @@ package hook
 
 import (
 	"context"
+	"datafuselabs/test-infra/chatbots/bisect"
 	"datafuselabs/test-infra/chatbots/plugins"
+	_ "datafuselabs/test-infra/chatbots/plugins/bisectperf"
 	_ "datafuselabs/test-infra/chatbots/plugins/builddocker"
+	_ "datafuselabs/test-infra/chatbots/plugins/cancelperf"
+	_ "datafuselabs/test-infra/chatbots/plugins/help"
 	_ "datafuselabs/test-infra/chatbots/plugins/labelrunperf"
+	_ "datafuselabs/test-infra/chatbots/plugins/oktoperf"
+	_ "datafuselabs/test-infra/chatbots/plugins/perfqueue"
+	_ "datafuselabs/test-infra/chatbots/plugins/perfreport"
+	_ "datafuselabs/test-infra/chatbots/plugins/perftrends"
+	_ "datafuselabs/test-infra/chatbots/plugins/perfwatch"
 	_ "datafuselabs/test-infra/chatbots/plugins/runperf"
+	"datafuselabs/test-infra/chatbots/policy"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/repoconfig"
+	"datafuselabs/test-infra/chatbots/retention"
+	"datafuselabs/test-infra/chatbots/scheduler"
+	"datafuselabs/test-infra/chatbots/trends"
 	"datafuselabs/test-infra/chatbots/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"strconv"
 	"sync"
+	"time"
 
 	githubcli "datafuselabs/test-infra/chatbots/github"
 
@@ -26,31 +43,88 @@ const (
 	statusEndpoint          string = "/status"
 	uploadEndpoint          string = "/upload"
 	indexEndpoint           string = "/"
-	benchmarkResultEndpoint string = "/benchmark/{pr:.*}/{commit:.*}"
+	artifactsEndpoint       string = "/artifacts/"
+	benchmarkResultEndpoint string = "/benchmark/"
+	trendsEndpoint          string = "/trends"
+	queueEndpoint           string = "/queue"
+	apiTrendsEndpoint       string = "/api/trends"
+	apiBaselineEndpoint     string = "/api/baseline"
+	compareBaselineEndpoint string = "/api/compare-baseline/"
+	apiAuditEndpoint        string = "/api/audit"
 )
 
 type Config struct {
 	StorageEndpoint utils.StorageInterface
+	Registry        registry.Registry
+	Signer          *registry.Signer
+	Trends          *trends.Store
+	Bisections      *bisect.Store
+	Scheduler       *scheduler.Scheduler
+	PolicyStore     *policy.Store
+	RepoConfigs     *repoconfig.Cache
+	Limiter         *plugins.RateLimiter
 	ctx             context.Context
 	Logger          zerolog.Logger
 	GithubToken     string
 	WebhookToken    string //
-	Address         string // binded address
-	Region          string
-	Bucket          string
-	Endpoint        string
-	TemplateDir     string
-	StaticDir       string
+	// AdminToken is the bearer token of the admin api.
+	AdminToken string
+	Address    string // binded address
+	Region     string
+	Bucket     string
+	Endpoint   string
+	// StorageType is the type of the bucket given to the perf workflows, COS or S3.
+	StorageType string
+	TemplateDir string
+	StaticDir   string
+	// DashboardURL is the public url of the dashboard, used in the links posted on github.
+	DashboardURL string
+	// ArtifactMaxFileSize and ArtifactMaxRunSize are the upload quotas in bytes.
+	ArtifactMaxFileSize int64
+	ArtifactMaxRunSize  int64
+	// Janitor sweeps the storage every RetentionInterval, it may be nil.
+	Janitor           *retention.Janitor
+	RetentionInterval time.Duration
+	// Settings may be replaced while the server runs with Server.Reload.
+	Settings
+}
+
+// Settings are the parts of the configuration reloaded without restarting the server.
+type Settings struct {
+	// Policy overrides the permissions declared by the commands.
+	Policy *policy.Policy
+	// DisabledPlugins are not run on any repository.
+	DisabledPlugins map[string]bool
+	// PerfWatchBatch is the number of default branch commits measured by a single run-perf run,
+	// 0 disables the runs on push.
+	PerfWatchBatch int
 }
 
 type Server struct {
 	Config Config
 	wg     sync.WaitGroup
+	// mu guards Config.Settings.
+	mu sync.RWMutex
+}
+
+// Reload replaces the settings, the events received from now on use them.
+func (s *Server) Reload(settings Settings) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Config.Settings = settings
+}
+
+func (s *Server) settings() Settings {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.Config.Settings
 }
 
-func NewConfig(StorageBackend utils.StorageInterface, ctx context.Context, Logger zerolog.Logger, GithubToken, WebhookToken, Address, Region, Bucket, Endpoint, templateDir, staticDir string) Config {
+func NewConfig(StorageBackend utils.StorageInterface, Registry registry.Registry, Signer *registry.Signer, ctx context.Context, Logger zerolog.Logger, GithubToken, WebhookToken, Address, Region, Bucket, Endpoint, templateDir, staticDir string) Config {
 	return Config{
 		StorageEndpoint: StorageBackend,
+		Registry:        Registry,
+		Signer:          Signer,
 		ctx:             ctx,
 		Logger:          Logger,
 		GithubToken:     GithubToken,
@@ -61,6 +135,9 @@ func NewConfig(StorageBackend utils.StorageInterface, ctx context.Context, Logge
 		Endpoint:        Endpoint,
 		TemplateDir:     templateDir,
 		StaticDir:       staticDir,
+
+		ArtifactMaxFileSize: DefaultArtifactMaxFileSize,
+		ArtifactMaxRunSize:  DefaultArtifactMaxRunSize,
 	}
 }
 
@@ -72,6 +149,12 @@ func NewServer(cfg Config) Server {
 
 func (s *Server) Start() {
 	s.RegistEndpoints()
+	if s.Config.Scheduler != nil {
+		s.Config.Scheduler.Start(s.Config.ctx, s.dispatchJob, s.expireJob, time.Minute)
+	}
+	if s.Config.Janitor != nil {
+		s.Config.Janitor.Start(s.Config.ctx, s.Config.RetentionInterval)
+	}
 	err := http.ListenAndServe(s.Config.Address, nil)
 	panic(err)
 }
@@ -108,7 +191,11 @@ func (s *Server) payload(w http.ResponseWriter, req *http.Request) {
 			http.Error(w, "issue_comment type must be 'created'", http.StatusOK)
 			return
 		}
+		settings, repoConfig := s.settings(), s.repoConfig(e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName())
 		for name, handler := range plugins.IssueCommentHandlers {
+			if !settings.enabled(repoConfig, name) {
+				continue
+			}
 			s.wg.Add(1)
 			go func(n string, h plugins.IssueCommentHandler) {
 				defer s.wg.Done()
@@ -116,7 +203,8 @@ func (s *Server) payload(w http.ResponseWriter, req *http.Request) {
 				if err != nil {
 					s.Config.Logger.Error().Msgf("Cannot build github client given event %s, %s", *e.Action, err.Error())
 				}
-				agent := plugins.NewAgent(client, s.Config.Region, s.Config.Bucket, s.Config.Endpoint, s.Config.GithubToken)
+				agent := s.newAgent(client)
+				agent.RepoConfig = repoConfig
 				err = h(agent, e)
 				if err != nil {
 					s.Config.Logger.Error().Msgf("Cannot process handler %s, %s", n, err.Error())
@@ -126,7 +214,15 @@ func (s *Server) payload(w http.ResponseWriter, req *http.Request) {
 	case *github.PushEvent:
 		log.Info().Msgf("ref %s received push event %s in pull requests %s owner %s repo %s", e.GetHeadCommit().GetMessage(),
 			e.GetHeadCommit().GetID(), e.GetPusher().GetName(), e.GetRepo().GetName(), e.GetRepo().GetOwner().GetName())
+		if s.Config.RepoConfigs != nil && e.GetRef() == "refs/heads/"+e.GetRepo().GetDefaultBranch() {
+			// the configuration may have changed
+			s.Config.RepoConfigs.Invalidate(e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName())
+		}
+		settings, repoConfig := s.settings(), s.repoConfig(e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName())
 		for name, handler := range plugins.PushHandlers {
+			if !settings.enabled(repoConfig, name) {
+				continue
+			}
 			s.wg.Add(1)
 			go func(n string, h plugins.PushHandler) {
 				defer s.wg.Done()
@@ -134,7 +230,30 @@ func (s *Server) payload(w http.ResponseWriter, req *http.Request) {
 				if err != nil {
 					s.Config.Logger.Error().Msgf("Cannot build github client given event %s, %s", e.GetHeadCommit().GetID(), err.Error())
 				}
-				agent := plugins.NewAgent(client, s.Config.Region, s.Config.Bucket, s.Config.Endpoint, s.Config.GithubToken)
+				agent := s.newAgent(client)
+				agent.RepoConfig = repoConfig
+				err = h(agent, e)
+				if err != nil {
+					s.Config.Logger.Error().Msgf("Cannot process handler %s, %s", n, err.Error())
+				}
+			}(name, handler)
+		}
+	case *github.PullRequestEvent:
+		log.Info().Msgf("received pull request %d event %s", e.GetNumber(), e.GetAction())
+		settings, repoConfig := s.settings(), s.repoConfig(e.GetRepo().GetOwner().GetLogin(), e.GetRepo().GetName())
+		for name, handler := range plugins.PullRequestHandlers {
+			if !settings.enabled(repoConfig, name) {
+				continue
+			}
+			s.wg.Add(1)
+			go func(n string, h plugins.PullRequestHandler) {
+				defer s.wg.Done()
+				client, err := githubcli.NewGithubClientByPullRequest(context.Background(), e, s.Config.GithubToken)
+				if err != nil {
+					s.Config.Logger.Error().Msgf("Cannot build github client given pull request %d, %s", e.GetNumber(), err.Error())
+				}
+				agent := s.newAgent(client)
+				agent.RepoConfig = repoConfig
 				err = h(agent, e)
 				if err != nil {
 					s.Config.Logger.Error().Msgf("Cannot process handler %s, %s", n, err.Error())
@@ -146,8 +265,8 @@ func (s *Server) payload(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
-func (s *Server) processReqFile(req *http.Request, fileName string) error {
-	file, _, err := req.FormFile(fileName)
+func (s *Server) processReqFile(req *http.Request, key registry.Key, fileName string) error {
+	file, header, err := req.FormFile(fileName)
 	if err == http.ErrMissingFile {
 		return nil
 	}
@@ -155,22 +274,13 @@ func (s *Server) processReqFile(req *http.Request, fileName string) error {
 		s.Config.Logger.Error().Msgf("unable to process file %s result, %v", fileName, err.Error())
 		return fmt.Errorf("unable to process file %s result, %v", fileName, err.Error())
 	}
-	owner := req.FormValue("OWNER")
-	repo := req.FormValue("REPO")
-	pr := req.FormValue("PR")
-	sha := req.FormValue("SHA")
-	uuid := req.FormValue("UUID")
+	defer file.Close()
 
-	s.Config.Logger.Info().Msgf("received SHA %s from PR %s", sha, pr)
-	fileBytes, err := ioutil.ReadAll(file)
-	if err != nil {
-		s.Config.Logger.Error().Msgf("unable to read result")
-		return fmt.Errorf("unable to read file %s result, %v", fileName, err.Error())
-	}
-	err = s.Config.StorageEndpoint.Store(s.Config.ctx, owner, repo, pr, sha, uuid, fileName, fileBytes)
+	s.Config.Logger.Info().Msgf("received SHA %s from PR %s", key.SHA, key.PR)
+	_, err = s.storeArtifact(s.Config.ctx, key, fileName, header.Header.Get("Content-Type"), header.Size, file)
 	if err != nil {
 		s.Config.Logger.Error().Msgf("unable to store result file, %s", err.Error())
-		return fmt.Errorf("unable to store file %s result, %v", fileName, err.Error())
+		return fmt.Errorf("unable to store file %s result, %w", fileName, err)
 	}
 	return nil
 }
@@ -184,11 +294,30 @@ func (s *Server) upload(w http.ResponseWriter, req *http.Request) {
 	err := req.ParseMultipartForm(32 << 20)
 	if err != nil {
 		log.Error().Msgf("Unable to parse file form, %+v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key := registry.Key{
+		Org:  req.FormValue("OWNER"),
+		Repo: req.FormValue("REPO"),
+		PR:   req.FormValue("PR"),
+		SHA:  req.FormValue("SHA"),
+		UUID: req.FormValue("UUID"),
+	}
+	if key.PR == "" {
+		key.Branch = req.FormValue("BRANCH")
+	}
+	if err := s.verifyRun(key, req.FormValue("TOKEN")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
-	files := []string{"compare.html", "current.log", "ref.log"}
+	files := []string{"compare.html", "compare.json", "current.log", "ref.log"}
 	for _, f := range files {
-		err = s.processReqFile(req, f)
+		err = s.processReqFile(req, key, f)
+		if errors.Is(err, errQuotaExceeded) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
 		if err != nil {
 			http.Error(w, err.Error(), 503)
 			return
@@ -204,6 +333,7 @@ type StatusMeta struct {
 	CommitSHA    string `json:"commitSHA,omitempty"`
 	RunId        string `json:"run_id,omitempty"`
 	Author       string `json:"author,omitempty"`
+	Branch       string `json:"branch,omitempty"`
 	UUID         string `json:"uuid,omitempty"`
 	DispatchName string `json:"dispatch_name,omitempty"`
 	Current      string `json:"current,omitempty"`
@@ -215,6 +345,38 @@ type StatusMeta struct {
 	CurrentLog   string `json:"currentLog,omitempty"`
 	RefLog       string `json:"refLog,omitempty"`
 	StartTime    string `json:"start_time,omitempty"`
+	// Token is the callback token minted when the run was dispatched, it is never recorded.
+	Token string `json:"token,omitempty"`
+}
+
+// Run converts the status update to the registry representation,
+// the branch identifies the run only when the update has no pull request.
+func (m StatusMeta) Run() registry.Run {
+	key := registry.Key{
+		Org:  m.Organization,
+		Repo: m.Repository,
+		PR:   m.PRNumber,
+		SHA:  m.CommitSHA,
+		UUID: m.UUID,
+	}
+	if key.PR == "" {
+		key.Branch = m.Branch
+	}
+	return registry.Run{
+		Key:          key,
+		DispatchName: m.DispatchName,
+		RunID:        m.RunId,
+		Author:       m.Author,
+		Current:      m.Current,
+		Ref:          m.Ref,
+		Compare:      m.Compare,
+		PRLink:       m.PRLink,
+		CurrentLog:   m.CurrentLog,
+		RefLog:       m.RefLog,
+		StartTime:    m.StartTime,
+		Status:       m.Status,
+		Conclusion:   m.Conclusion,
+	}
 }
 
 // status endpoint will receive status update from github workflow
@@ -230,43 +392,153 @@ func (s *Server) status(w http.ResponseWriter, req *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	jb, err := json.Marshal(status)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := s.verifyRun(status.Run().Key, status.Token); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
 	}
 	switch status.DispatchName {
-	case "build-docker":
-		err := s.HandleStatus(status)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		}
-	case "run-perf":
-		err := s.HandleStatus(status)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		}
-		err = s.HandleReport(status, jb)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-		}
+	case "build-docker", "run-perf":
 	default:
 		http.Error(w, fmt.Sprintf("Not support dispatch %s for now", status.DispatchName), http.StatusBadRequest)
 		return
 	}
+	run, err := s.HandleStatus(status)
+	if err == registry.ErrCompleted {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.HandleReport(run)
 }
 
-// HandleStatus TODO report status dashboard
-func (s *Server) HandleStatus(meta StatusMeta) error {
+// verifyRun checks that the callback comes from the workflow of a run registered at dispatch time
+func (s *Server) verifyRun(key registry.Key, token string) error {
+	if err := key.Validate(); err != nil {
+		return err
+	}
+	if err := s.Config.Signer.Verify(key, token); err != nil {
+		s.Config.Logger.Warn().Msgf("rejected callback of run %s, %s", key.String(), err.Error())
+		return err
+	}
+	if _, err := s.Config.Registry.Get(key); err != nil {
+		s.Config.Logger.Warn().Msgf("rejected callback of unknown run %s, %s", key.String(), err.Error())
+		return err
+	}
 	return nil
 }
 
-// HandleReport TODO report dashboard
-func (s *Server) HandleReport(meta StatusMeta, jb []byte) error {
-	return nil
+// HandleStatus records the status update in the run registry
+func (s *Server) HandleStatus(meta StatusMeta) (*registry.Run, error) {
+	run, err := s.Config.Registry.Record(meta.Run())
+	if err != nil {
+		s.Config.Logger.Error().Msgf("unable to record status of run %s, %s", meta.UUID, err.Error())
+		return nil, err
+	}
+	s.Config.Logger.Info().Msgf("run %s is %s %s", run.Key.String(), run.Status, run.Conclusion)
+	if run.Status == "completed" && s.Config.Scheduler != nil {
+		// the runner is free for the next queued run
+		if err := s.Config.Scheduler.Finish(run.Key); err != nil {
+			s.Config.Logger.Error().Msgf("unable to schedule the runs queued after %s, %s", run.Key.String(), err.Error())
+		}
+	}
+	return run, nil
+}
+
+// dispatchJob starts the workflow of a run given a runner by the scheduler.
+func (s *Server) dispatchJob(job scheduler.Job) error {
+	// the runs of a branch have no pull request
+	pr, _ := strconv.Atoi(job.Run.PR)
+	client, err := githubcli.NewGithubClientByRun(context.Background(), job.Run.Org, job.Run.Repo, pr, job.Run.SHA, s.Config.GithubToken)
+	if err != nil {
+		return err
+	}
+	return s.newAgent(client).StartRun(client, job.EventType, job.Run, job.Payloads)
+}
+
+// expireJob fails the run of a job timed out by the scheduler. The status handlers follow up on the runs
+// without a pull request status, e.g. to fail a bisection.
+func (s *Server) expireJob(job scheduler.Job) {
+	pr, _ := strconv.Atoi(job.Run.PR)
+	client, err := githubcli.NewGithubClientByRun(context.Background(), job.Run.Org, job.Run.Repo, pr, job.Run.SHA, s.Config.GithubToken)
+	if err != nil {
+		s.Config.Logger.Error().Msgf("unable to expire run %s, %s", job.Run.Key.String(), err.Error())
+		return
+	}
+	run, err := s.newAgent(client).ExpireRun(client, job.Run)
+	if err != nil {
+		s.Config.Logger.Error().Msgf("unable to expire run %s, %s", job.Run.Key.String(), err.Error())
+		return
+	}
+	if run.PR == "" || run.Bisection != "" {
+		s.HandleReport(run)
+	}
+}
+
+// HandleReport runs the status handlers on the recorded run, e.g. to report the results on the PR
+func (s *Server) HandleReport(run *registry.Run) {
+	settings, repoConfig := s.settings(), s.repoConfig(run.Org, run.Repo)
+	for name, handler := range plugins.StatusHandlers {
+		if !settings.enabled(repoConfig, name) {
+			continue
+		}
+		s.wg.Add(1)
+		go func(n string, h plugins.StatusHandler) {
+			defer s.wg.Done()
+			// the handlers build the github client when they need it, most updates don't
+			agent := s.newAgent(nil)
+			agent.RepoConfig = repoConfig
+			err := h(agent, run)
+			if err != nil {
+				s.Config.Logger.Error().Msgf("Cannot process status handler %s on run %s, %s", n, run.Key.String(), err.Error())
+			}
+		}(name, handler)
+	}
+}
+
+// enabled returns whether the plugin runs on the repository with the configuration repoConfig.
+func (st Settings) enabled(repoConfig *repoconfig.Config, plugin string) bool {
+	return !st.DisabledPlugins[plugin] && repoConfig.Enabled(plugin)
+}
+
+// repoConfig returns the configuration of the repository, the last valid one when it cannot be read,
+// nil for the defaults when it has none.
+func (s *Server) repoConfig(owner, repo string) *repoconfig.Config {
+	if s.Config.RepoConfigs == nil || owner == "" || repo == "" {
+		return nil
+	}
+	client, err := githubcli.NewGithubClientByRun(context.Background(), owner, repo, 0, "", s.Config.GithubToken)
+	if err != nil {
+		s.Config.Logger.Error().Msgf("Cannot build github client for the configuration of %s/%s, %s", owner, repo, err.Error())
+		return nil
+	}
+	cfg, err := s.Config.RepoConfigs.Load(owner, repo, client)
+	if err != nil && cfg != nil {
+		s.Config.Logger.Error().Msgf("Cannot load the configuration of %s/%s, using the last valid one, %s", owner, repo, err.Error())
+	} else if err != nil {
+		s.Config.Logger.Error().Msgf("Cannot load the configuration of %s/%s, using the defaults, %s", owner, repo, err.Error())
+	}
+	return cfg
 }
 
-type Metas struct {
-	Items []StatusMeta
+// newAgent returns the agent given to the plugin handlers
+func (s *Server) newAgent(client *githubcli.GithubClient) *plugins.Agent {
+	agent := plugins.NewAgent(client, s.Config.Region, s.Config.Bucket, s.Config.Endpoint, s.Config.GithubToken, s.Config.Registry, s.Config.Signer)
+	agent.Store = s.Config.StorageEndpoint
+	agent.StorageType = s.Config.StorageType
+	agent.DashboardURL = s.Config.DashboardURL
+	agent.Trends = s.Config.Trends
+	agent.Bisections = s.Config.Bisections
+	agent.Scheduler = s.Config.Scheduler
+	settings := s.settings()
+	agent.Policy = settings.Policy
+	agent.PolicyStore = s.Config.PolicyStore
+	agent.DisabledPlugins = settings.DisabledPlugins
+	agent.Limiter = s.Config.Limiter
+	agent.PerfWatchBatch = settings.PerfWatchBatch
+	return agent
 }
 
 func (s *Server) RegistEndpoints() {
@@ -274,7 +546,14 @@ func (s *Server) RegistEndpoints() {
 	http.HandleFunc(payloadEndpoint, s.payload)
 	http.HandleFunc(uploadEndpoint, s.upload)
 	http.HandleFunc(statusEndpoint, s.status)
-	m := Metas{Items: []StatusMeta{}}
-	http.HandleFunc(indexEndpoint, s.handleSimpleTemplate("index.html", m))
+	http.HandleFunc(indexEndpoint, s.handleTemplate("index.html", s.dashboard))
+	http.HandleFunc(benchmarkResultEndpoint, s.benchmark)
+	http.HandleFunc(artifactsEndpoint, s.artifacts)
+	http.HandleFunc(trendsEndpoint, s.handleTemplate("trends.html", s.trendsPage))
+	http.HandleFunc(queueEndpoint, s.handleTemplate("queue.html", s.queuePage))
+	http.HandleFunc(apiTrendsEndpoint, s.apiTrends)
+	http.HandleFunc(apiBaselineEndpoint, s.apiBaseline)
+	http.HandleFunc(compareBaselineEndpoint, s.apiCompareBaseline)
+	http.HandleFunc(apiAuditEndpoint, s.apiAudit)
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 }
@@ -0,0 +1,123 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"datafuselabs/test-infra/chatbots/registry"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallbackAuthentication(t *testing.T) {
+	s := newFakeServer(t)
+	key := registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "1"}
+	_, err := s.Config.Registry.Record(registry.Run{Key: key, DispatchName: "run-perf", Status: "queued"})
+	assert.NoError(t, err)
+	token := s.Config.Signer.Mint(key)
+	unknown := key
+	unknown.UUID = "2"
+	branch := registry.Key{Org: "datafuselabs", Repo: "databend", Branch: "main", SHA: "foo", UUID: "3"}
+	_, err = s.Config.Registry.Record(registry.Run{Key: branch, DispatchName: "run-perf", Status: "queued"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		key   registry.Key
+		token string
+		code  int
+	}{
+		{name: "valid", key: key, token: token, code: http.StatusOK},
+		{name: "missing token", key: key, token: "", code: http.StatusUnauthorized},
+		{name: "token of another run", key: unknown, token: token, code: http.StatusUnauthorized},
+		{name: "unknown run", key: unknown, token: s.Config.Signer.Mint(unknown), code: http.StatusUnauthorized},
+		{name: "branch run", key: branch, token: s.Config.Signer.Mint(branch), code: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" status", func(t *testing.T) {
+			body, err := json.Marshal(StatusMeta{
+				Organization: tt.key.Org,
+				Repository:   tt.key.Repo,
+				PRNumber:     tt.key.PR,
+				Branch:       tt.key.Branch,
+				CommitSHA:    tt.key.SHA,
+				UUID:         tt.key.UUID,
+				DispatchName: "run-perf",
+				Status:       "in_progress",
+				Token:        tt.token,
+			})
+			assert.NoError(t, err)
+			rec := httptest.NewRecorder()
+			s.status(rec, httptest.NewRequest(http.MethodPost, statusEndpoint, bytes.NewReader(body)))
+			assert.Equal(t, tt.code, rec.Code)
+		})
+
+		t.Run(tt.name+" upload", func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			for k, v := range map[string]string{"OWNER": tt.key.Org, "REPO": tt.key.Repo, "PR": tt.key.PR, "BRANCH": tt.key.Branch, "SHA": tt.key.SHA, "UUID": tt.key.UUID, "TOKEN": tt.token} {
+				assert.NoError(t, mw.WriteField(k, v))
+			}
+			fw, err := mw.CreateFormFile("current.log", "current.log")
+			assert.NoError(t, err)
+			_, err = fw.Write([]byte("log " + tt.name))
+			assert.NoError(t, err)
+			assert.NoError(t, mw.Close())
+
+			req := httptest.NewRequest(http.MethodPost, uploadEndpoint, &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			rec := httptest.NewRecorder()
+			s.upload(rec, req)
+			assert.Equal(t, tt.code, rec.Code)
+
+			r, err := s.Config.StorageEndpoint.Get(context.Background(), tt.key.Artifact("current.log"))
+			if tt.code == http.StatusOK {
+				assert.NoError(t, err)
+				data, err := ioutil.ReadAll(r)
+				r.Close()
+				assert.NoError(t, err)
+				assert.Equal(t, "log "+tt.name, string(data))
+			} else if tt.key == unknown {
+				assert.Error(t, err, "rejected uploads must not be stored")
+			}
+		})
+	}
+
+	run, err := s.Config.Registry.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "in_progress", run.Status)
+}
+
+func TestStatus_completed(t *testing.T) {
+	s := newFakeServer(t)
+	key := registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "1"}
+	_, err := s.Config.Registry.Record(registry.Run{Key: key, DispatchName: "run-perf", Status: "completed", Conclusion: "cancelled"})
+	assert.NoError(t, err)
+	body, err := json.Marshal(StatusMeta{
+		Organization: key.Org,
+		Repository:   key.Repo,
+		PRNumber:     key.PR,
+		CommitSHA:    key.SHA,
+		UUID:         key.UUID,
+		DispatchName: "run-perf",
+		Status:       "in_progress",
+		Token:        s.Config.Signer.Mint(key),
+	})
+	assert.NoError(t, err)
+	rec := httptest.NewRecorder()
+	s.status(rec, httptest.NewRequest(http.MethodPost, statusEndpoint, bytes.NewReader(body)))
+	assert.Equal(t, http.StatusConflict, rec.Code, "the late status of a cancelled run")
+
+	run, err := s.Config.Registry.Get(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "cancelled", run.Conclusion)
+}
@@ -3,7 +3,6 @@ package hook
 import (
 	"github.com/rs/zerolog/log"
 	"html/template"
-	"os"
 
 	"net/http"
 	"path"
@@ -32,9 +31,22 @@ func (s *Server) prepareBaseTemplate(t *template.Template) (*template.Template,
 }
 
 func (s *Server) handleSimpleTemplate(templateName string, param interface{}) http.HandlerFunc {
+	return s.handleTemplate(templateName, func(*http.Request) (interface{}, error) {
+		return param, nil
+	})
+}
+
+// handleTemplate renders templateName with the parameters computed for each request.
+func (s *Server) handleTemplate(templateName string, params func(r *http.Request) (interface{}, error)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		param, err := params(r)
+		if err != nil {
+			log.Error().Msgf("error preparing parameters of template %s, %v", templateName, err.Error())
+			http.Error(w, err.Error(), httpStatus(err))
+			return
+		}
 		t := template.New(templateName) // the name matters, and must match the filename.
-		t, err := s.prepareBaseTemplate(t)
+		t, err = s.prepareBaseTemplate(t)
 		if err != nil {
 			log.Error().Msgf(err.Error())
 			http.Error(w, "error preparing base template", http.StatusInternalServerError)
@@ -56,22 +68,11 @@ func (s *Server) handleSimpleTemplate(templateName string, param interface{}) ht
 			http.Error(w, "error parsing template", http.StatusInternalServerError)
 			return
 		}
-		println(t.DefinedTemplates())
 
 		if err := t.Execute(w, param); err != nil {
 			log.Error().Msgf("error executing template " + templateName + " %v", err.Error())
 			http.Error(w, "error executing template", http.StatusInternalServerError)
 			return
 		}
-		f, err := os.Create("./dat2")
-		if err != nil {
-			log.Info().Msgf(err.Error())
-		}
-
-		if err := t.Execute(f, param); err != nil {
-			log.Error().Msgf("error executing template " + templateName)
-			http.Error(w, "error executing template", http.StatusInternalServerError)
-			return
-		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,279 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package hook
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/trends"
+	"datafuselabs/test-infra/pkg/compare"
+)
+
+const (
+	defaultTrendBranch = "main"
+	// defaultTrendLimit is the number of points of a series returned by default.
+	defaultTrendLimit = 100
+	// defaultBaselineRuns is the number of branch runs of the default rolling baseline.
+	defaultBaselineRuns = 10
+
+	chartWidth  = 600
+	chartHeight = 160
+)
+
+var errTrendsDisabled = fmt.Errorf("trends are not configured")
+
+// intParam returns the positive integer query parameter name, or def when it is not set.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+func trendBranch(r *http.Request) string {
+	if b := r.URL.Query().Get("branch"); b != "" {
+		return b
+	}
+	return defaultTrendBranch
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// TrendSeries is the response of the trends api for a query.
+type TrendSeries struct {
+	Branch string         `json:"branch"`
+	Query  string         `json:"query"`
+	Points []trends.Point `json:"points"`
+}
+
+// TrendQueries is the response of the trends api without query, it lists the queries of the branch.
+type TrendQueries struct {
+	Branch  string   `json:"branch"`
+	Queries []string `json:"queries"`
+}
+
+// apiTrends serves /api/trends?branch=main&query=Q3&limit=100.
+func (s *Server) apiTrends(w http.ResponseWriter, r *http.Request) {
+	if s.Config.Trends == nil {
+		http.Error(w, errTrendsDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	branch, query := trendBranch(r), r.URL.Query().Get("query")
+	limit, err := intParam(r, "limit", defaultTrendLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if query == "" {
+		queries, err := s.Config.Trends.Queries(branch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if queries == nil {
+			queries = []string{}
+		}
+		writeJSON(w, TrendQueries{Branch: branch, Queries: queries})
+		return
+	}
+	points, err := s.Config.Trends.Series(branch, query, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if points == nil {
+		points = []trends.Point{}
+	}
+	writeJSON(w, TrendSeries{Branch: branch, Query: query, Points: points})
+}
+
+// apiBaseline serves /api/baseline?branch=main&n=10, the rolling baseline of the branch.
+// The response is accepted by infra compare --ref.
+func (s *Server) apiBaseline(w http.ResponseWriter, r *http.Request) {
+	if s.Config.Trends == nil {
+		http.Error(w, errTrendsDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	n, err := intParam(r, "n", defaultBaselineRuns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	baseline, err := s.Config.Trends.Baseline(trendBranch(r), n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, baseline)
+}
+
+// compareBaseline compares the current build of a run with the rolling baseline of a branch.
+func (s *Server) compareBaseline(run *registry.Run, branch string, n int) (*compare.Report, error) {
+	report, err := s.newAgent(nil).LoadReport(run)
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := s.Config.Trends.Baseline(branch, n)
+	if err != nil {
+		return nil, err
+	}
+	res, err := trends.Compare(report, baseline)
+	if err != nil {
+		return nil, err
+	}
+	res.Current = run.Current
+	res.Ref = fmt.Sprintf("%s baseline of the last %d runs", branch, n)
+	return res, nil
+}
+
+// apiCompareBaseline serves /api/compare-baseline/{owner}/{repo}/{scope}/{sha}/{uuid}?branch=main&n=10&format=json,
+// the comparison of a run with a rolling baseline instead of its reference build.
+func (s *Server) apiCompareBaseline(w http.ResponseWriter, r *http.Request) {
+	if s.Config.Trends == nil {
+		http.Error(w, errTrendsDisabled.Error(), http.StatusNotFound)
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, compareBaselineEndpoint), "/"), "/")
+	if len(parts) != 5 {
+		http.NotFound(w, r)
+		return
+	}
+	key := registry.NewKey(parts[0], parts[1], parts[2], parts[3], parts[4])
+	if err := key.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	n, err := intParam(r, "n", defaultBaselineRuns)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	run, err := s.Config.Registry.Get(key)
+	if err != nil {
+		http.Error(w, err.Error(), httpStatus(err))
+		return
+	}
+	report, err := s.compareBaseline(run, trendBranch(r), n)
+	if err != nil {
+		s.Config.Logger.Error().Msgf("unable to compare run %s with the baseline, %s", key.String(), err.Error())
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		err = report.WriteMarkdown(w)
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = report.WriteHTML(w)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		err = report.WriteJSON(w)
+	}
+	if err != nil {
+		s.Config.Logger.Error().Msgf("unable to write baseline comparison of run %s, %s", key.String(), err.Error())
+	}
+}
+
+// ChartPoint is a point of a trend chart, X and Y are in svg coordinates.
+type ChartPoint struct {
+	X, Y   float64
+	SHA    string
+	Median float64
+}
+
+// Chart is the svg line chart of a query series.
+type Chart struct {
+	Query    string
+	Width    int
+	Height   int
+	Min, Max float64
+	Points   []ChartPoint
+}
+
+// Polyline returns the svg points attribute of the chart line.
+func (c Chart) Polyline() string {
+	var b strings.Builder
+	for i, p := range c.Points {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "%.1f,%.1f", p.X, p.Y)
+	}
+	return b.String()
+}
+
+// newChart scales the series to the chart, the y axis spans the min and max medians.
+func newChart(query string, points []trends.Point) Chart {
+	c := Chart{Query: query, Width: chartWidth, Height: chartHeight, Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, p := range points {
+		c.Min = math.Min(c.Min, p.Median)
+		c.Max = math.Max(c.Max, p.Median)
+	}
+	span := c.Max - c.Min
+	if span == 0 {
+		span = 1
+	}
+	const margin = 10
+	for i, p := range points {
+		x := float64(margin)
+		if len(points) > 1 {
+			x += float64(i) * float64(c.Width-2*margin) / float64(len(points)-1)
+		}
+		y := float64(c.Height-margin) - (p.Median-c.Min)/span*float64(c.Height-2*margin)
+		c.Points = append(c.Points, ChartPoint{X: x, Y: y, SHA: p.SHA, Median: p.Median})
+	}
+	return c
+}
+
+// TrendsPage is the parameter of the trends template.
+type TrendsPage struct {
+	Branch string
+	Query  string
+	Limit  int
+	Charts []Chart
+}
+
+func (s *Server) trendsPage(r *http.Request) (interface{}, error) {
+	if s.Config.Trends == nil {
+		return nil, errPageNotFound
+	}
+	page := TrendsPage{Branch: trendBranch(r), Query: r.URL.Query().Get("query")}
+	limit, err := intParam(r, "limit", defaultTrendLimit)
+	if err != nil {
+		limit = defaultTrendLimit
+	}
+	page.Limit = limit
+	queries := []string{page.Query}
+	if page.Query == "" {
+		queries, err = s.Config.Trends.Queries(page.Branch)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, q := range queries {
+		points, err := s.Config.Trends.Series(page.Branch, q, limit)
+		if err != nil {
+			return nil, err
+		}
+		if len(points) > 0 {
+			page.Charts = append(page.Charts, newChart(q, points))
+		}
+	}
+	return page, nil
+}
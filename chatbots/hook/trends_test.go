@@ -0,0 +1,143 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/trends"
+	"datafuselabs/test-infra/pkg/compare"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func addFakePoints(t *testing.T, s *Server) {
+	start := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	var points []trends.Point
+	for i, sha := range []string{"a", "b", "c"} {
+		at := start.Add(time.Duration(i) * time.Hour)
+		points = append(points,
+			trends.Point{Branch: "main", Query: "Q1", SHA: sha, Time: at, Median: 1},
+			trends.Point{Branch: "main", Query: "Q2", SHA: sha, Time: at, Median: float64(i + 1)},
+		)
+	}
+	assert.NoError(t, s.Config.Trends.Add(points...))
+}
+
+func TestAPITrends(t *testing.T) {
+	s := newFakeServer(t)
+	addFakePoints(t, s)
+	tests := []struct {
+		name   string
+		query  string
+		code   int
+		expect string
+	}{
+		{name: "queries", query: "", code: http.StatusOK, expect: `{"branch":"main","queries":["Q1","Q2"]}`},
+		{name: "unknown branch", query: "?branch=dev", code: http.StatusOK, expect: `{"branch":"dev","queries":[]}`},
+		{name: "unknown query", query: "?query=Q3", code: http.StatusOK, expect: `{"branch":"main","query":"Q3","points":[]}`},
+		{name: "invalid limit", query: "?query=Q1&limit=x", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.apiTrends(rec, httptest.NewRequest(http.MethodGet, apiTrendsEndpoint+tt.query, nil))
+			assert.Equal(t, tt.code, rec.Code)
+			if tt.expect != "" {
+				assert.JSONEq(t, tt.expect, rec.Body.String())
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	s.apiTrends(rec, httptest.NewRequest(http.MethodGet, apiTrendsEndpoint+"?query=Q2&limit=2", nil))
+	var series TrendSeries
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&series))
+	assert.Len(t, series.Points, 2)
+	assert.Equal(t, "c", series.Points[1].SHA)
+}
+
+func TestAPIBaseline(t *testing.T) {
+	s := newFakeServer(t)
+	addFakePoints(t, s)
+	rec := httptest.NewRecorder()
+	s.apiBaseline(rec, httptest.NewRequest(http.MethodGet, apiBaselineEndpoint+"?n=2", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var baseline []compare.QueryRun
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&baseline))
+	assert.Equal(t, []compare.QueryRun{{Name: "Q1", Times: []float64{1, 1}}, {Name: "Q2", Times: []float64{2, 3}}}, baseline)
+
+	s.Config.Trends = nil
+	rec = httptest.NewRecorder()
+	s.apiBaseline(rec, httptest.NewRequest(http.MethodGet, apiBaselineEndpoint, nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAPICompareBaseline(t *testing.T) {
+	s := newFakeServer(t)
+	addFakePoints(t, s)
+	meta := StatusMeta{Organization: "datafuselabs", Repository: "databend", PRNumber: "233", CommitSHA: "foo", UUID: "1", DispatchName: "run-perf", Status: "completed", Conclusion: "success", Current: "foo"}
+	_, err := s.HandleStatus(meta)
+	assert.NoError(t, err)
+	report := &compare.Report{Options: compare.DefaultOptions(), Queries: []compare.QueryResult{
+		{Name: "Q1", CurrentTimes: []float64{2, 2, 2}},
+		{Name: "Q2", CurrentTimes: []float64{2, 2, 2}},
+	}}
+	var b bytes.Buffer
+	assert.NoError(t, report.WriteJSON(&b))
+	assert.NoError(t, s.Config.StorageEndpoint.Put(context.Background(), registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "1"}.Artifact("compare.json"), &b))
+
+	tests := []struct {
+		name     string
+		path     string
+		code     int
+		contains string
+	}{
+		{name: "json", path: "datafuselabs/databend/233/foo/1", code: http.StatusOK, contains: `"ref": "main baseline of the last 10 runs"`},
+		{name: "markdown", path: "datafuselabs/databend/233/foo/1?format=md&n=3", code: http.StatusOK, contains: "main baseline of the last 3 runs"},
+		{name: "unknown run", path: "datafuselabs/databend/233/foo/2", code: http.StatusNotFound},
+		{name: "invalid path", path: "datafuselabs/databend/233", code: http.StatusNotFound},
+		{name: "invalid n", path: "datafuselabs/databend/233/foo/1?n=0", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.apiCompareBaseline(rec, httptest.NewRequest(http.MethodGet, compareBaselineEndpoint+tt.path, nil))
+			assert.Equal(t, tt.code, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.contains)
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	s.apiCompareBaseline(rec, httptest.NewRequest(http.MethodGet, compareBaselineEndpoint+"datafuselabs/databend/233/foo/1", nil))
+	res, err := compare.ReadJSON(rec.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, compare.Regression, res.Queries[0].Class, "Q1 doubled compared with the baseline")
+}
+
+func TestTrendsPage(t *testing.T) {
+	s := newFakeServer(t)
+	addFakePoints(t, s)
+	p, err := s.trendsPage(httptest.NewRequest(http.MethodGet, trendsEndpoint+"?query=Q2", nil))
+	assert.NoError(t, err)
+	page := p.(TrendsPage)
+	assert.Len(t, page.Charts, 1)
+	chart := page.Charts[0]
+	assert.Equal(t, 1.0, chart.Min)
+	assert.Equal(t, 3.0, chart.Max)
+	assert.Equal(t, "10.0,150.0 300.0,80.0 590.0,10.0", chart.Polyline())
+
+	rec := httptest.NewRecorder()
+	s.handleTemplate("trends.html", s.trendsPage)(rec, httptest.NewRequest(http.MethodGet, trendsEndpoint, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<polyline")
+	assert.Contains(t, rec.Body.String(), `query=Q1`)
+}
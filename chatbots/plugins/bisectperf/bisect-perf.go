@@ -0,0 +1,216 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package bisectperf
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"datafuselabs/test-infra/chatbots/bisect"
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+
+	guuid "github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	pluginName = "bisect-perf"
+	// dispatchName is the recorded name of the run-perf runs, eventType the repository dispatch starting them
+	// unless the repository configures another one for run-perf.
+	dispatchName = "run-perf"
+	eventType    = "run_perf"
+)
+
+func init() {
+	log.Info().Msgf("registed plugin: %s", pluginName)
+	plugins.RegisterCommand(plugins.Command{
+		Name: pluginName,
+		Args: []plugins.Arg{
+			{Name: "good", Help: "a commit, branch or tag where the query is fast"},
+			{Name: "bad", Help: "a later commit, branch or tag where the query is slow"},
+			{Name: "query", Help: "the query to measure, e.g. Q3"},
+		},
+		Permission: plugins.Collaborator,
+		Help:       "Find the first commit between two refs regressing a query, with a binary search of run-perf runs.",
+		Handler:    handle,
+	})
+	plugins.RegisterStatusHandler(pluginName, handleStatus)
+}
+
+// handle starts the bisection of the commits between the good and the bad refs.
+func handle(agent *plugins.Agent, gc *githubcli.GithubClient, inv *plugins.Invocation) error {
+	good, bad, query := inv.String("good"), inv.String("bad"), inv.String("query")
+	if agent.Bisections == nil {
+		return fmt.Errorf("bisections are not configured")
+	}
+	cmp, err := gc.CompareCommits(good, bad)
+	if err != nil {
+		_ = gc.PostComment(fmt.Sprintf("cannot list the commits between %s and %s, %s", good, bad, err.Error()))
+		return err
+	}
+	// behind, diverged or identical refs have no range of commits from good to bad to search
+	if cmp.GetStatus() != "ahead" {
+		return gc.PostComment(fmt.Sprintf("Cannot bisect from %s to %s, the comparison is %s: the bad ref must be a later commit than the good one.",
+			good, bad, cmp.GetStatus()))
+	}
+	if cmp.GetTotalCommits() > len(cmp.Commits) {
+		return gc.PostComment(fmt.Sprintf("%d commits between %s and %s, at most %d can be bisected.",
+			cmp.GetTotalCommits(), good, bad, len(cmp.Commits)))
+	}
+	commits := []string{cmp.GetBaseCommit().GetSHA()}
+	for _, c := range cmp.Commits {
+		commits = append(commits, c.GetSHA())
+	}
+	b, err := bisect.New(guuid.New().String(), gc.Owner, gc.Repo, gc.Pr, query, good, bad, commits)
+	if err != nil {
+		return gc.PostComment(err.Error())
+	}
+	b.Author = gc.Author
+	if err := agent.Bisections.Create(b); err != nil {
+		return err
+	}
+	err = gc.PostComment(fmt.Sprintf("Bisecting %d commits between %s and %s on %s, about %d run-perf runs.",
+		len(commits)-1, good, bad, query, b.Steps()))
+	if err != nil {
+		return err
+	}
+	return step(agent, gc, b.ID)
+}
+
+// step dispatches the runs measuring the next commits of the bisection.
+func step(agent *plugins.Agent, gc *githubcli.GithubClient, id string) error {
+	next := map[string]string{}
+	b, err := agent.Bisections.Update(id, func(b *bisect.Bisection) error {
+		for _, sha := range b.Next() {
+			uuid := guuid.New().String()
+			b.Dispatched(uuid, sha)
+			next[uuid] = sha
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for uuid, sha := range next {
+		run := registry.Run{
+			Key: registry.Key{
+				Org:  b.Org,
+				Repo: b.Repo,
+				PR:   strconv.Itoa(b.Issue),
+				SHA:  sha,
+				UUID: uuid,
+			},
+			DispatchName: dispatchName,
+			Author:       b.Author,
+			Bisection:    b.ID,
+			Current:      sha,
+			Ref:          b.Commits[0],
+			StartTime:    strconv.Itoa(int(time.Now().Unix())),
+			Status:       "queued",
+		}
+		if err := agent.DispatchRun(gc, agent.RepoConfig.EventType(dispatchName, eventType), run, nil); err != nil {
+			reason := fmt.Sprintf("cannot dispatch run-perf on %s, %s", sha, err.Error())
+			b, uerr := agent.Bisections.Update(id, func(b *bisect.Bisection) error {
+				b.Fail(reason)
+				return nil
+			})
+			if uerr != nil {
+				return uerr
+			}
+			_ = gc.PostComment(renderResult(b))
+			return err
+		}
+		log.Info().Msgf("bisection %s dispatched run %s on %s", b.ID, uuid, sha)
+	}
+	return nil
+}
+
+// measurement returns the median of the query measured by the run, or the reason it has none.
+func measurement(agent *plugins.Agent, run *registry.Run, query string) (float64, string) {
+	if run.Conclusion != "success" {
+		return 0, fmt.Sprintf("run-perf on %s concluded %s", run.SHA, run.Conclusion)
+	}
+	report, err := agent.LoadReport(run)
+	if err != nil {
+		return 0, fmt.Sprintf("no comparison report for %s, %s", run.SHA, err.Error())
+	}
+	for _, q := range report.Queries {
+		if q.Name == query {
+			return q.Current, ""
+		}
+	}
+	return 0, fmt.Sprintf("%s was not measured on %s", query, run.SHA)
+}
+
+// renderResult returns the comment posted once the bisection is over.
+func renderResult(b *bisect.Bisection) string {
+	var s strings.Builder
+	if b.Status == bisect.Failed {
+		fmt.Fprintf(&s, "Bisection of %s between %s and %s failed: %s\n", b.Query, b.Good, b.Bad, b.Reason)
+		return s.String()
+	}
+	fmt.Fprintf(&s, "First bad commit of %s between %s and %s: https://github.com/%s/%s/commit/%s\n\n",
+		b.Query, b.Good, b.Bad, b.Org, b.Repo, b.Result)
+	s.WriteString("| Commit | Median (s) | |\n")
+	s.WriteString("|---|---:|---|\n")
+	for i, sha := range b.Commits {
+		median, ok := b.Medians[sha]
+		if !ok {
+			continue
+		}
+		verdict := "good"
+		if i >= b.Hi {
+			verdict = "bad"
+		}
+		if sha == b.Result {
+			verdict = "**first bad**"
+		}
+		fmt.Fprintf(&s, "| %s | %.3f | %s |\n", sha, median, verdict)
+	}
+	return s.String()
+}
+
+// handleStatus feeds the concluded runs of a bisection to it, then dispatches the next runs or posts the result.
+func handleStatus(agent *plugins.Agent, run *registry.Run) error {
+	if run.DispatchName != dispatchName || run.Bisection == "" || run.Status != "completed" || agent.Bisections == nil {
+		return nil
+	}
+	b, err := agent.Bisections.Get(run.Bisection)
+	if err != nil {
+		return err
+	}
+	median, reason := measurement(agent, run, b.Query)
+	observed := false
+	b, err = agent.Bisections.Update(run.Bisection, func(b *bisect.Bisection) error {
+		if _, ok := b.Pending[run.UUID]; !ok || b.Status != bisect.Running {
+			return nil
+		}
+		observed = true
+		if reason != "" {
+			b.Fail(reason)
+			return nil
+		}
+		b.Observe(run.UUID, median)
+		return nil
+	})
+	if err != nil || !observed {
+		return err
+	}
+	gc := agent.GithubClient
+	if gc == nil {
+		gc, err = githubcli.NewGithubClientByRun(context.Background(), b.Org, b.Repo, b.Issue, run.SHA, agent.Token)
+		if err != nil {
+			return err
+		}
+	}
+	if b.Status == bisect.Running {
+		return step(agent, gc, b.ID)
+	}
+	return gc.PostComment(renderResult(b))
+}
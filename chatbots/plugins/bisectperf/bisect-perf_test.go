@@ -0,0 +1,155 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package bisectperf
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/stretchr/testify/assert"
+
+	"datafuselabs/test-infra/chatbots/bisect"
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/plugins/pluginstest"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/pkg/compare"
+)
+
+func newFakeAgent(t *testing.T, gc *githubcli.GithubClient) *plugins.Agent {
+	agent := pluginstest.NewAgent(t, gc)
+	var err error
+	agent.Bisections, err = bisect.NewStore(agent.Registry.(*registry.BoltRegistry).DB())
+	assert.NoError(t, err)
+	return agent
+}
+
+func TestParseCommand(t *testing.T) {
+	inv, err := plugins.ParseLine("/bisect-perf v0.4.0 main Q3")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"v0.4.0", "main", "Q3"}, []string{inv.String("good"), inv.String("bad"), inv.String("query")})
+	_, err = plugins.ParseLine("/bisect-perf v0.4.0 main")
+	assert.Error(t, err)
+	for _, body := range []string{"/run-perf main", "please /bisect-perf a b c"} {
+		inv, err := plugins.ParseLine(body)
+		assert.NoError(t, err)
+		assert.Nil(t, inv, body)
+	}
+}
+
+func TestBisectPerf(t *testing.T) {
+	f, gc := pluginstest.NewGithub(t)
+	agent := newFakeAgent(t, gc)
+	commits := []string{"c0", "c1", "c2", "c3", "c4", "c5"}
+	b, err := bisect.New("1", "datafuselabs", "databend", 233, "Q1", "v0.4.0", "main", commits)
+	assert.NoError(t, err)
+	assert.NoError(t, agent.Bisections.Create(b))
+	assert.NoError(t, step(agent, gc, "1"))
+
+	// c3 doubles Q1, the runs report in the order they were dispatched
+	for i := 0; i < len(f.Dispatches); i++ {
+		payload := f.Dispatches[i]
+		sha := payload["LAST_COMMIT_SHA"]
+		assert.Equal(t, "c0", payload["REF_BRANCH"])
+		assert.Equal(t, "datafuselabs/databend/233/"+sha+"/"+payload["UUID"], payload["STORAGE.PREFIX"], "the layout of the chatbot storage")
+		median := 1.0
+		if sha >= "c3" {
+			median = 2.0
+		}
+		var report bytes.Buffer
+		assert.NoError(t, (&compare.Report{Queries: []compare.QueryResult{{Name: "Q1", Current: median}}}).WriteJSON(&report))
+		assert.NoError(t, agent.Store.Put(context.Background(), registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: sha, UUID: payload["UUID"]}.Artifact(plugins.ReportFile), &report))
+		run, err := agent.Registry.Record(registry.Run{
+			Key:    registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: sha, UUID: payload["UUID"]},
+			Status: "completed", Conclusion: "success",
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, "1", run.Bisection, "the run belongs to the bisection")
+		assert.NoError(t, handleStatus(agent, run))
+		// a status reported twice is ignored
+		assert.NoError(t, handleStatus(agent, run))
+	}
+
+	b, err = agent.Bisections.Get("1")
+	assert.NoError(t, err)
+	assert.Equal(t, bisect.Done, b.Status)
+	assert.Equal(t, "c3", b.Result)
+	assert.Len(t, f.Dispatches, 4)
+	assert.Len(t, f.Comments, 1)
+	assert.Contains(t, f.Comments[0], "First bad commit of Q1 between v0.4.0 and main: https://github.com/datafuselabs/databend/commit/c3")
+	assert.Contains(t, f.Comments[0], "| c3 | 2.000 | **first bad** |")
+	assert.Contains(t, f.Comments[0], "| c2 | 1.000 | good |")
+}
+
+func TestHandle(t *testing.T) {
+	comparison := func(status string, commits ...string) *github.CommitsComparison {
+		cmp := &github.CommitsComparison{
+			Status:       github.String(status),
+			TotalCommits: github.Int(len(commits)),
+			BaseCommit:   &github.RepositoryCommit{SHA: github.String("c0")},
+		}
+		for _, c := range commits {
+			cmp.Commits = append(cmp.Commits, &github.RepositoryCommit{SHA: github.String(c)})
+		}
+		return cmp
+	}
+	tests := []struct {
+		name          string
+		compare       *github.CommitsComparison
+		expectComment string
+		expectRuns    int
+	}{
+		{name: "ahead", compare: comparison("ahead", "c1", "c2"), expectComment: "Bisecting 2 commits between v0.4.0 and main on Q1", expectRuns: 2},
+		{name: "behind", compare: comparison("behind", "c1", "c2"), expectComment: "Cannot bisect from v0.4.0 to main, the comparison is behind: the bad ref must be a later commit than the good one."},
+		{name: "diverged", compare: comparison("diverged", "c1"), expectComment: "the comparison is diverged"},
+		{name: "identical", compare: comparison("identical"), expectComment: "the comparison is identical"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, gc := pluginstest.NewGithub(t)
+			f.Handlers["/repos/datafuselabs/databend/compare/v0.4.0...main"] = pluginstest.JSON(t, tt.compare)
+			agent := newFakeAgent(t, gc)
+			inv, err := plugins.ParseLine("/bisect-perf v0.4.0 main Q1")
+			assert.NoError(t, err)
+
+			assert.NoError(t, handle(agent, gc, inv))
+			assert.Len(t, f.Comments, 1)
+			assert.Contains(t, f.Comments[0], tt.expectComment)
+			assert.Len(t, f.Dispatches, tt.expectRuns)
+		})
+	}
+}
+
+func TestBisectPerf_failedRun(t *testing.T) {
+	f, gc := pluginstest.NewGithub(t)
+	agent := newFakeAgent(t, gc)
+	b, err := bisect.New("1", "datafuselabs", "databend", 233, "Q1", "v0.4.0", "main", []string{"c0", "c1", "c2"})
+	assert.NoError(t, err)
+	assert.NoError(t, agent.Bisections.Create(b))
+	assert.NoError(t, step(agent, gc, "1"))
+	assert.Len(t, f.Dispatches, 2)
+
+	payload := f.Dispatches[0]
+	run, err := agent.Registry.Record(registry.Run{
+		Key:    registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: payload["LAST_COMMIT_SHA"], UUID: payload["UUID"]},
+		Status: "completed", Conclusion: "failure",
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, handleStatus(agent, run))
+	assert.Len(t, f.Comments, 1)
+	assert.True(t, strings.HasPrefix(f.Comments[0], "Bisection of Q1 between v0.4.0 and main failed: run-perf on "))
+	assert.Len(t, f.Dispatches, 2, "a failed bisection dispatches no more runs")
+}
+
+func TestHandleStatus_ignored(t *testing.T) {
+	// runs outside of a bisection never reach the store, the agent has none.
+	agent := &plugins.Agent{}
+	run := &registry.Run{DispatchName: dispatchName, Status: "completed", Conclusion: "success"}
+	assert.NoError(t, handleStatus(agent, run))
+	run.Bisection = "1"
+	assert.NoError(t, handleStatus(agent, run))
+}
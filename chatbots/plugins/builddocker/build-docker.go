@@ -4,15 +4,16 @@
 package builddocker
 
 import (
-	"context"
 	githubcli "datafuselabs/test-infra/chatbots/github"
 	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/repoconfig"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 
-	"github.com/google/go-github/v35/github"
-	"github.com/rs/zerolog"
+	guuid "github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 )
 
@@ -21,147 +22,77 @@ const (
 )
 
 var (
-	reg = regexp.MustCompile(`(?mi)^/build-docker\s*(?P<RELEASE>master|main|latest|current|v[0-9]+\.[0-9]+\.[0-9]+\S*)\s*$`)
+	release = regexp.MustCompile(`(?i)^(master|main|latest|current|v[0-9]+\.[0-9]+\.[0-9]+\S*)$`)
 )
 
 func init() {
 	log.Info().Msgf("registed plugin: %s", pluginName)
-	plugins.RegisterIssueCommentHandler(pluginName, handleIssueComment)
+	plugins.RegisterCommand(plugins.Command{
+		Name: pluginName,
+		Args: []plugins.Arg{
+			{Name: "ref", Pattern: release, Optional: true, Help: "the build: master, main, latest (the last release tag), current (the head of the pull request) or a release tag, the configured default when omitted"},
+		},
+		Permission: plugins.Collaborator,
+		Help:       "Build the docker image of a databend build.",
+		Handler:    handle,
+	})
 }
 
-func handleIssueComment(client *plugins.Agent, ic *github.IssueCommentEvent) error {
-	handler, err := newRunPerf(ic, log.With().Str("issue comment", "build-docker").Logger(), client)
-	if err != nil {
-		return err
-	}
-	err = handle(handler)
-	if err != nil {
-		return err
+// build returns the build of the docker image given ref on the pull request at sha.
+func build(ref, sha, lastTag string) string {
+	switch strings.ToLower(ref) {
+	case "latest":
+		return lastTag
+	case "current":
+		return sha
 	}
-	return nil
+	return ref
 }
 
-func extractCommand(s string) string {
-	s = strings.TrimLeft(s, "\r\n\t ")
-	if i := strings.Index(s, "\n"); i != -1 {
-		s = s[:i]
+// reference returns the build given to the command, or the default ref of the repository configuration cfg.
+func reference(cfg *repoconfig.Config, inv *plugins.Invocation) string {
+	if inv.IsSet("ref") {
+		return inv.String("ref")
 	}
-	s = strings.TrimRight(s, "\r\n\t ")
-	return s
+	return cfg.Ref(pluginName)
 }
 
-// Verify if user is allowed to perform activity.
-func (h handler) verifyUser() error {
-	var allowed bool
-	allowedAssociations := []string{"COLLABORATOR", "MEMBER", "OWNER"}
-	for _, a := range allowedAssociations {
-		if a == h.gc.AuthorAssociation {
-			allowed = true
-		}
-	}
-	if !allowed {
-		return fmt.Errorf("@%s is not a org, member nor a collaborator and cannot execute bendbench.", h.gc.Author)
-	}
-	h.log.Info().Msgf("author is a owner, member or collaborator")
-	return nil
-}
-
-// ok-to-bendbench <branch-name> will run bendbench test given branch reference
-func handle(h *handler) error {
-	if h == nil {
-		return nil
-	}
-	h.log.Info().Msgf(h.gc.GetIssueState())
-	lastSHA := h.gc.GetLastCommitSHA()
-	lastTag, err := h.gc.GetLatestTag()
+// handle dispatches the build of the docker image of the given reference.
+func handle(agent *plugins.Agent, gc *githubcli.GithubClient, inv *plugins.Invocation) error {
+	logger := log.With().Str("issue comment", "build-docker").Logger()
+	logger.Info().Msgf(gc.GetIssueState())
+	lastSHA := gc.GetLastCommitSHA()
+	lastTag, err := gc.GetLatestTag()
 	if err != nil {
 		return err
 	}
-	err = handlerhelper(h, lastSHA, lastTag)
-	if err != nil {
-		if strings.Contains(err.Error(), "is not a org, member nor a collaborator") {
-			h.gc.PostComment(err.Error())
-		}
-		return err
+	ref := reference(agent.RepoConfig, inv)
+	if ref == "" {
+		return gc.PostComment(fmt.Sprintf("cannot run `%s`: no <ref> given and no default ref configured for %s in %s.", inv.Line, pluginName, repoconfig.Path))
 	}
-	err = h.gc.CreateRepositoryDispatch("build-docker", h.Payloads)
-	if err != nil {
-		h.log.Error().Msgf("cannot create run-perf repository dispatch, %s", err.Error())
-		return err
+	ref = build(ref, lastSHA, lastTag)
+	logger.Info().Msgf("build image on branch: %s", ref)
+	run := registry.Run{
+		Key: registry.Key{
+			Org:  gc.Owner,
+			Repo: gc.Repo,
+			PR:   strconv.Itoa(gc.Pr),
+			SHA:  lastSHA,
+			UUID: guuid.New().String(),
+		},
+		DispatchName: pluginName,
+		Author:       gc.Author,
+		Ref:          ref,
+		Status:       "queued",
 	}
-	// err = h.gc.PostComment(fmt.Sprintf("run performance on sha %s reference on %s", h.Payloads["CURRENT_BRANCH"], h.Payloads["REF_BRANCH"]))
-	// if err != nil {
-	// 	return err
-	// }
-
-	return nil
-}
-
-func handlerhelper(h *handler, sha string, lastTag string) error {
-	command := extractCommand(h.gc.CommentBody)
-	h.log.Log().Msgf(command)
-	matches := h.regexp.FindAllStringSubmatch(command, -1)
-	if matches == nil {
-		return fmt.Errorf("there is no matching regex")
+	if err := agent.RegisterRun(run); err != nil {
+		logger.Error().Msgf("cannot register run %s, %s", run.Key.String(), err.Error())
+		return err
 	}
-	err := h.verifyUser()
+	err = agent.Dispatch(gc, agent.RepoConfig.EventType(pluginName, "build-docker"), run, map[string]interface{}{"REF": ref})
 	if err != nil {
+		logger.Error().Msgf("cannot create build-docker repository dispatch, %s", err.Error())
 		return err
 	}
-
-	if len(matches) < 1 || len(matches[0]) < 2 {
-		return nil
-	}
-	switch matches[0][1] {
-	case "latest":
-		h.RefBranch = lastTag
-	case "current":
-		h.RefBranch = sha
-	default:
-		h.RefBranch = matches[0][1]
-	}
-	h.log.Info().Msgf("build image on branch: %s", h.RefBranch)
-	err = extractPayload(h, sha)
-	return err
-}
-
-func extractPayload(h *handler, sha string) error {
-	h.Payloads["REF"] = h.RefBranch
-	h.Payloads["LAST_COMMIT_SHA"] = sha
 	return nil
-
-}
-
-// handler is a struct that contains data about a github event and provides functions to help handle it.
-type handler struct {
-
-	// regexp is the regular expression describing the command. It must have an optional 'un' prefix
-	// as the first subgroup and the arguments to the command as the second subgroup.
-	regexp *regexp.Regexp
-	// gc is the githubClient to use for creating response comments in the event of a failure.
-	gc *githubcli.GithubClient
-
-	// log define structed logging interface.
-	log zerolog.Logger
-
-	// define the branch that the current branch will be tested with
-	RefBranch string
-
-	// define a series of client-payloads that will be posted to workflow
-	Payloads map[string]string
-}
-
-func newRunPerf(e *github.IssueCommentEvent, log zerolog.Logger, client *plugins.Agent) (*handler, error) {
-	githubCli, err := githubcli.NewGithubClient(context.Background(), e, client.Token)
-	if err != nil {
-		log.Error().Msgf("Unable to initialize github client given issue comment event %s, %s", e.GetComment().String(), err.Error())
-
-		return nil, err
-	}
-	return &handler{
-		regexp:   reg,
-		gc:       githubCli,
-		log:      log,
-		Payloads: make(map[string]string),
-	}, nil
 }
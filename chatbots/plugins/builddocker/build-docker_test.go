@@ -4,157 +4,121 @@
 package builddocker
 
 import (
-	"fmt"
+	"net/http"
 	"testing"
 
-	"github.com/rs/zerolog/log"
+	"github.com/google/go-github/v35/github"
 	"github.com/stretchr/testify/assert"
 
-	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/plugins/pluginstest"
+	"datafuselabs/test-infra/chatbots/repoconfig"
 )
 
-func newFakeGithubClient(commentBody, author, authorAssociation string, pr_num int) *githubcli.GithubClient {
-	return &githubcli.GithubClient{
-		AuthorAssociation: authorAssociation,
-		CommentBody:       commentBody,
-		Author:            author,
-		Pr:                pr_num,
-	}
-}
-
-func newFakeHandler(commentBody, author, authorAssociation string, pr int) *handler {
-	return &handler{
-		regexp:   reg,
-		gc:       newFakeGithubClient(commentBody, author, authorAssociation, pr),
-		log:      log.With().Str("issue comment", "build-docker").Logger(),
-		Payloads: map[string]string{},
-	}
-}
-
-func Test_handlerhelper(t *testing.T) {
+func Test_build(t *testing.T) {
 	tests := []struct {
-		name              string
-		comment           string
-		author            string
-		authorAssociation string
-		pr                int
-		sha               string
-		lastTag           string
-		expectError       error
-		expectRefBranch   string
-		expectPayload     map[string]string
+		name        string
+		comment     string
+		config      string
+		sha         string
+		lastTag     string
+		expectError string
+		expectRef   string
 	}{
 		{
-			name:              "master",
-			comment:           "/build-docker master",
-			author:            "zhihanz",
-			authorAssociation: "OWNER",
-			pr:                233,
-			sha:               "foo",
-			expectError:       nil,
-			lastTag:           "v1.1.1-nightly",
-			expectRefBranch:   "master",
-			expectPayload:     map[string]string{"REF": "master", "LAST_COMMIT_SHA": "foo"},
-		},
-		{
-			name:              "master",
-			comment:           "/build-docker current",
-			author:            "zhihanz",
-			authorAssociation: "OWNER",
-			pr:                233,
-			sha:               "foo",
-			expectError:       nil,
-			lastTag:           "v1.1.1-nightly",
-			expectRefBranch:   "foo",
-			expectPayload:     map[string]string{"REF": "foo", "LAST_COMMIT_SHA": "foo"},
+			name:      "master",
+			comment:   "/build-docker master",
+			sha:       "foo",
+			lastTag:   "v1.1.1-nightly",
+			expectRef: "master",
 		},
 		{
-			name:              "newline",
-			comment:           "\r\n/build-docker latest\t",
-			author:            "zhihanz",
-			authorAssociation: "OWNER",
-			pr:                233,
-			lastTag:           "v1.1.1-nightly",
-			sha:               "bar",
-			expectError:       nil,
-			expectRefBranch:   "v1.1.1-nightly",
-			expectPayload:     map[string]string{"REF": "v1.1.1-nightly", "LAST_COMMIT_SHA": "bar"},
+			name:      "current",
+			comment:   "/build-docker current",
+			sha:       "foo",
+			lastTag:   "v1.1.1-nightly",
+			expectRef: "foo",
 		},
 		{
-			name:              "laetst",
-			comment:           "/build-docker latest",
-			author:            "zhihanz",
-			authorAssociation: "OWNER",
-			pr:                233,
-			lastTag:           "v1.1.1-nightly",
-			sha:               "bar",
-			expectError:       nil,
-			expectRefBranch:   "v1.1.1-nightly",
-			expectPayload:     map[string]string{"REF": "v1.1.1-nightly", "LAST_COMMIT_SHA": "bar"},
+			name:      "newline",
+			comment:   "\r\n/build-docker latest\t",
+			lastTag:   "v1.1.1-nightly",
+			sha:       "bar",
+			expectRef: "v1.1.1-nightly",
 		},
 		{
-			name:              "release",
-			comment:           "/build-docker v1.2.3-nightly",
-			author:            "zhihanz",
-			authorAssociation: "OWNER",
-			pr:                233,
-			lastTag:           "v1.1.1-nightly",
-			sha:               "bar",
-			expectError:       nil,
-			expectRefBranch:   "v1.2.3-nightly",
-			expectPayload:     map[string]string{"REF": "v1.2.3-nightly", "LAST_COMMIT_SHA": "bar"},
+			name:      "release",
+			comment:   "/build-docker v1.2.3-nightly",
+			lastTag:   "v1.1.1-nightly",
+			sha:       "bar",
+			expectRef: "v1.2.3-nightly",
 		},
 		{
-			name:              "empty",
-			comment:           "/build-docker",
-			author:            "zhihanz",
-			authorAssociation: "OWNER",
-			expectError:       fmt.Errorf("there is no matching regex"),
-			pr:                233,
-			sha:               "bar",
-			expectRefBranch:   "",
-			expectPayload:     map[string]string{},
+			name:      "configured",
+			comment:   "/build-docker",
+			config:    "commands:\n  build-docker:\n    ref: latest\n",
+			lastTag:   "v1.1.1-nightly",
+			sha:       "bar",
+			expectRef: "v1.1.1-nightly",
 		},
 		{
-			name:              "non-sense",
-			comment:           "/build-docker Wubba-Lubba-Dub-Dub",
-			author:            "zhihanz",
-			authorAssociation: "OWNER",
-			expectError:       fmt.Errorf("there is no matching regex"),
-			pr:                233,
-			sha:               "bar",
-			expectRefBranch:   "",
-			expectPayload:     map[string]string{},
+			name:      "configured overridden",
+			comment:   "/build-docker current",
+			config:    "commands:\n  build-docker:\n    ref: latest\n",
+			lastTag:   "v1.1.1-nightly",
+			sha:       "bar",
+			expectRef: "bar",
 		},
 		{
-			name:              "non-sense2",
-			comment:           "/build-docker Wubba Lubba Dub Dub",
-			author:            "zhihanz",
-			authorAssociation: "OWNER",
-			expectError:       fmt.Errorf("there is no matching regex"),
-			pr:                233,
-			sha:               "bar",
-			expectRefBranch:   "",
-			expectPayload:     map[string]string{},
+			name:        "non-sense",
+			comment:     "/build-docker Wubba-Lubba-Dub-Dub",
+			expectError: `invalid ref "Wubba-Lubba-Dub-Dub"`,
 		},
 		{
-			name:              "non-sense3",
-			comment:           "/build-dockermaster main",
-			author:            "zhihanz",
-			authorAssociation: "OWNER",
-			expectError:       fmt.Errorf("there is no matching regex"),
-			pr:                233,
-			sha:               "bar",
-			expectRefBranch:   "",
-			expectPayload:     map[string]string{},
+			name:        "non-sense2",
+			comment:     "/build-docker Wubba Lubba Dub Dub",
+			expectError: "too many arguments",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			handler := newFakeHandler(tt.comment, tt.author, tt.authorAssociation, tt.pr)
-			assert.Equal(t, handlerhelper(handler, tt.sha, tt.lastTag), tt.expectError)
-			assert.Equal(t, handler.RefBranch, tt.expectRefBranch)
-			assert.Equal(t, handler.Payloads, tt.expectPayload)
+			inv, err := plugins.ParseLine(tt.comment)
+			if tt.expectError != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectError, err.(*plugins.UsageError).Reason)
+				return
+			}
+			assert.NoError(t, err)
+			cfg, err := repoconfig.Parse([]byte(tt.config))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectRef, build(reference(cfg, inv), tt.sha, tt.lastTag))
 		})
 	}
+
+	inv, err := plugins.ParseLine("/build-dockermaster main")
+	assert.NoError(t, err)
+	assert.Nil(t, inv, "not a command")
+}
+
+func Test_handle(t *testing.T) {
+	f, gc := pluginstest.NewGithub(t)
+	f.Handlers["/repos/datafuselabs/databend/tags"] = pluginstest.JSON(t, []*github.RepositoryTag{{Name: github.String("v1.1.1-nightly")}})
+	agent := pluginstest.NewAgent(t, gc)
+	inv, err := plugins.ParseLine("/build-docker latest")
+	assert.NoError(t, err)
+
+	assert.NoError(t, handle(agent, gc, inv))
+	assert.Len(t, f.Dispatches, 1)
+	payload := f.Dispatches[0]
+	assert.Equal(t, "v1.1.1-nightly", payload["REF"])
+	assert.Equal(t, "233", payload["PR_NUMBER"], "the workflow sends back the key of the run")
+	assert.Equal(t, "abc", payload["LAST_COMMIT_SHA"])
+	assert.NotEmpty(t, payload["TOKEN"])
+
+	f.Handlers["/repos/datafuselabs/databend/dispatches"] = func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "dispatch failed", http.StatusInternalServerError)
+	}
+	assert.Error(t, handle(agent, gc, inv))
+	assert.Equal(t, "error", f.Statuses["abc"].GetState())
+	assert.Equal(t, "build-docker-status", f.Statuses["abc"].GetContext())
 }
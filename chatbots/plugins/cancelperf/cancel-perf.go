@@ -0,0 +1,147 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package cancelperf
+
+import (
+	"fmt"
+	"strconv"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/scheduler"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	pluginName = "cancel-perf"
+	// dispatchName is the recorded name of the cancelled runs.
+	dispatchName = "run-perf"
+	// statusName is the commit status set to pending when run-perf is dispatched.
+	statusName = "run-perf-status"
+)
+
+func init() {
+	log.Info().Msgf("registed plugin: %s", pluginName)
+	plugins.RegisterCommand(plugins.Command{
+		Name:       pluginName,
+		Permission: plugins.Collaborator,
+		Help:       "Cancel the run-perf runs of the pull request which are queued or running.",
+		Handler:    handle,
+	})
+	plugins.RegisterPullRequestHandler(pluginName, handlePullRequest)
+}
+
+// inFlight returns the run-perf runs which have not completed, except the ones measuring keep.
+// The runs of a bisection measure other commits than the pull request head, they are left alone.
+func inFlight(runs []registry.Run, keep string) []registry.Run {
+	var res []registry.Run
+	for _, r := range runs {
+		if r.DispatchName == dispatchName && r.Bisection == "" && r.Status != "completed" && (keep == "" || r.SHA != keep) {
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+// workflowRuns returns the workflow runs of the run: the one it reported, or the ones named after its UUID
+// while it didn't report yet. A run still queued by the scheduler has none.
+func workflowRuns(agent *plugins.Agent, gc *githubcli.GithubClient, run registry.Run) ([]int64, error) {
+	if run.RunID != "" {
+		id, err := strconv.ParseInt(run.RunID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid workflow run id %s, %v", run.RunID, err)
+		}
+		return []int64{id}, nil
+	}
+	if agent.Scheduler != nil {
+		if e, err := agent.Scheduler.Position(run.Key); err == nil && e.State == scheduler.Queued {
+			return nil, nil
+		}
+	}
+	return gc.FindWorkflowRuns(run.UUID)
+}
+
+// cancelRun stops the workflow of the run, or drops it from the queue, records it as cancelled
+// and sets its commit status to error with the reason.
+func cancelRun(agent *plugins.Agent, gc *githubcli.GithubClient, run registry.Run, reason string) error {
+	logger := log.With().Str("plugin", pluginName).Str("run", run.Key.String()).Logger()
+	ids, err := workflowRuns(agent, gc, run)
+	if err != nil {
+		// the run is cancelled anyway, its late status is refused
+		logger.Warn().Msgf("cannot find the workflow runs, %s", err.Error())
+	}
+	for _, id := range ids {
+		// the workflow may have completed meanwhile, the run is cancelled anyway
+		if err := gc.CancelWorkflowRun(id); err != nil {
+			logger.Warn().Msgf("cannot cancel workflow run %d, %s", id, err.Error())
+		}
+	}
+	if _, err := agent.Registry.Record(registry.Run{Key: run.Key, Status: "completed", Conclusion: "cancelled"}); err != nil {
+		return err
+	}
+	if agent.Scheduler != nil {
+		if err := agent.Scheduler.Finish(run.Key); err != nil {
+			logger.Error().Msgf("cannot free the runner, %s", err.Error())
+		}
+	}
+	status := *gc
+	status.LastSHA = run.SHA
+	logger.Info().Msgf("cancelled, %s", reason)
+	return status.UpdateStatusDescription(statusName, "error", agent.ReportURL(&run), reason)
+}
+
+// cancelRuns cancels the runs of the pull request in flight, except the ones measuring keep,
+// and returns how many were cancelled.
+func cancelRuns(agent *plugins.Agent, gc *githubcli.GithubClient, keep, reason string) (int, error) {
+	runs, err := agent.Registry.ListByPR(gc.Owner, gc.Repo, strconv.Itoa(gc.Pr))
+	if err != nil {
+		return 0, err
+	}
+	cancelled := 0
+	for _, run := range inFlight(runs, keep) {
+		if err := cancelRun(agent, gc, run, reason); err != nil {
+			return cancelled, err
+		}
+		cancelled++
+	}
+	return cancelled, nil
+}
+
+// handle cancels the runs of the pull request on /cancel-perf.
+func handle(agent *plugins.Agent, gc *githubcli.GithubClient, inv *plugins.Invocation) error {
+	n, err := cancelRuns(agent, gc, "", fmt.Sprintf("cancelled by @%s", gc.Author))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return gc.PostComment("No run-perf run in flight.")
+	}
+	return gc.PostComment(fmt.Sprintf("Cancelled %d run-perf runs.", n))
+}
+
+// handlePullRequest cancels the stale runs when new commits are pushed to the pull request,
+// and every run when it is closed.
+func handlePullRequest(agent *plugins.Agent, e *github.PullRequestEvent) error {
+	gc := agent.GithubClient
+	if gc == nil {
+		return nil
+	}
+	var keep, cause string
+	switch e.GetAction() {
+	case "synchronize":
+		keep, cause = e.GetPullRequest().GetHead().GetSHA(), "new commits were pushed"
+	case "closed":
+		cause = "the pull request was closed"
+	default:
+		return nil
+	}
+	n, err := cancelRuns(agent, gc, keep, "cancelled, "+cause)
+	if err != nil || n == 0 {
+		return err
+	}
+	return gc.PostComment(fmt.Sprintf("Cancelled %d run-perf runs, %s.", n, cause))
+}
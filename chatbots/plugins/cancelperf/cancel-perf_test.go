@@ -0,0 +1,146 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package cancelperf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/stretchr/testify/assert"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/plugins/pluginstest"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/scheduler"
+)
+
+func newFakeAgent(t *testing.T, gc *githubcli.GithubClient) *plugins.Agent {
+	agent := pluginstest.NewAgent(t, gc)
+	var err error
+	agent.Scheduler, err = scheduler.New(agent.Registry.(*registry.BoltRegistry).DB(), scheduler.Options{Limit: 1})
+	assert.NoError(t, err)
+	return agent
+}
+
+func record(t *testing.T, agent *plugins.Agent, runs ...registry.Run) {
+	for _, run := range runs {
+		run.Org, run.Repo, run.PR = "datafuselabs", "databend", "233"
+		if run.DispatchName == "" {
+			run.DispatchName = dispatchName
+		}
+		_, err := agent.Registry.Record(run)
+		assert.NoError(t, err)
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	runs := []registry.Run{
+		{Key: registry.Key{SHA: "a", UUID: "1"}, DispatchName: dispatchName, Status: "in_progress"},
+		{Key: registry.Key{SHA: "b", UUID: "2"}, DispatchName: dispatchName, Status: "queued"},
+		{Key: registry.Key{SHA: "a", UUID: "3"}, DispatchName: dispatchName, Status: "completed"},
+		{Key: registry.Key{SHA: "a", UUID: "4"}, DispatchName: "build-docker", Status: "queued"},
+		{Key: registry.Key{SHA: "a", UUID: "5"}, DispatchName: dispatchName, Status: "queued", Bisection: "1"},
+	}
+	uuids := func(runs []registry.Run) []string {
+		var res []string
+		for _, r := range runs {
+			res = append(res, r.UUID)
+		}
+		return res
+	}
+	assert.Equal(t, []string{"1", "2"}, uuids(inFlight(runs, "")))
+	assert.Equal(t, []string{"1"}, uuids(inFlight(runs, "b")))
+}
+
+func TestHandlePullRequest(t *testing.T) {
+	f, gc := pluginstest.NewGithub(t)
+	agent := newFakeAgent(t, gc)
+	record(t, agent,
+		registry.Run{Key: registry.Key{SHA: "old", UUID: "1"}, RunID: "42", Status: "in_progress"},
+		registry.Run{Key: registry.Key{SHA: "new", UUID: "2"}, Status: "queued"},
+	)
+	// the queued run of the old commit is dropped from the queue
+	_, err := agent.Scheduler.Enqueue(scheduler.NewJob("run_perf", registry.Run{Key: registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "old", UUID: "1"}}, nil))
+	assert.NoError(t, err)
+
+	e := &github.PullRequestEvent{Action: github.String("synchronize"), PullRequest: &github.PullRequest{Head: &github.PullRequestBranch{SHA: github.String("new")}}}
+	assert.NoError(t, handlePullRequest(agent, e))
+
+	run, err := agent.Registry.Get(registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "old", UUID: "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "cancelled", run.Conclusion)
+	run, err = agent.Registry.Get(registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "new", UUID: "2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "queued", run.Status, "the run of the new head goes on")
+
+	assert.Contains(t, f.Requests, "POST /repos/datafuselabs/databend/actions/runs/42/cancel")
+	assert.Equal(t, "error", f.Statuses["old"].GetState())
+	assert.Equal(t, statusName, f.Statuses["old"].GetContext())
+	assert.Equal(t, "cancelled, new commits were pushed", f.Statuses["old"].GetDescription())
+	assert.Equal(t, []string{"Cancelled 1 run-perf runs, new commits were pushed."}, f.Comments)
+	entries, err := agent.Scheduler.Queue()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	e.Action = github.String("closed")
+	assert.NoError(t, handlePullRequest(agent, e))
+	assert.Equal(t, "cancelled, the pull request was closed", f.Statuses["new"].GetDescription())
+
+	e.Action = github.String("opened")
+	assert.NoError(t, handlePullRequest(agent, e))
+	assert.Len(t, f.Comments, 2)
+}
+
+func TestCancelPerf(t *testing.T) {
+	f, gc := pluginstest.NewGithub(t)
+	agent := newFakeAgent(t, gc)
+	assert.NoError(t, plugins.RunCommands(agent, gc, "/cancel-perf"))
+	assert.Equal(t, []string{"No run-perf run in flight."}, f.Comments)
+
+	record(t, agent, registry.Run{Key: registry.Key{SHA: "a", UUID: "1"}, Status: "in_progress", StartTime: time.Now().String()})
+	assert.NoError(t, plugins.RunCommands(agent, gc, "thanks, the numbers look off\n/cancel-perf"))
+	assert.Equal(t, "Cancelled 1 run-perf runs.", f.Comments[1])
+	assert.Equal(t, "cancelled by @alice", f.Statuses["a"].GetDescription())
+
+	gc.AuthorAssociation = "CONTRIBUTOR"
+	assert.NoError(t, plugins.RunCommands(agent, gc, "/cancel-perf"))
+	assert.Equal(t, "@alice cannot run /cancel-perf, it may be run by a collaborator, member or owner.", f.Comments[2])
+}
+
+func TestWorkflowRuns(t *testing.T) {
+	f, gc := pluginstest.NewGithub(t)
+	agent := newFakeAgent(t, gc)
+	f.Handlers["/repos/datafuselabs/databend/actions/runs"] = pluginstest.JSON(t, &github.WorkflowRuns{
+		WorkflowRuns: []*github.WorkflowRun{{ID: github.Int64(7)}, {ID: github.Int64(8)}},
+	})
+	f.Handlers["/repos/datafuselabs/databend/actions/runs/7/jobs"] = pluginstest.JSON(t, &github.Jobs{
+		Jobs: []*github.WorkflowJob{{Name: github.String("perf 1")}},
+	})
+	f.Handlers["/repos/datafuselabs/databend/actions/runs/8/jobs"] = pluginstest.JSON(t, &github.Jobs{
+		Jobs: []*github.WorkflowJob{{Name: github.String("perf 99")}},
+	})
+	queued := registry.Run{Key: registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "b", UUID: "2"}, DispatchName: dispatchName}
+	_, err := agent.Scheduler.Enqueue(scheduler.NewJob("run_perf", queued, nil))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		run    registry.Run
+		expect []int64
+	}{
+		{name: "reported run id", run: registry.Run{Key: registry.Key{UUID: "1"}, RunID: "42"}, expect: []int64{42}},
+		{name: "named after the uuid", run: registry.Run{Key: registry.Key{UUID: "1"}}, expect: []int64{7}},
+		{name: "no workflow", run: registry.Run{Key: registry.Key{UUID: "3"}}},
+		{name: "queued by the scheduler", run: queued},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := workflowRuns(agent, gc, tt.run)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, ids)
+		})
+	}
+}
@@ -0,0 +1,484 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package plugins
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/rs/zerolog/log"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/policy"
+)
+
+// Commands are the chat commands declared by the plugins, by name.
+var Commands = map[string]*Command{}
+
+// ApproveCommand is the command allowing a user to run an approvable command once.
+const ApproveCommand = "ok-to-perf"
+
+// Permission is the author association a command requires.
+type Permission int
+
+const (
+	// Anyone may run the command.
+	Anyone Permission = iota
+	// Collaborator is a collaborator, a member or an owner of the repository.
+	Collaborator
+	// Member is a member or an owner of the organization.
+	Member
+	// Owner is an owner of the repository.
+	Owner
+)
+
+// Allows returns whether a comment author with the github author association may run the command.
+func (p Permission) Allows(association string) bool {
+	switch p {
+	case Anyone:
+		return true
+	case Collaborator:
+		return association == "COLLABORATOR" || association == "MEMBER" || association == "OWNER"
+	case Member:
+		return association == "MEMBER" || association == "OWNER"
+	default:
+		return association == "OWNER"
+	}
+}
+
+func (p Permission) String() string {
+	switch p {
+	case Anyone:
+		return "anyone"
+	case Collaborator:
+		return "a collaborator, member or owner"
+	case Member:
+		return "a member or owner"
+	default:
+		return "an owner"
+	}
+}
+
+// ArgType is the type of the value of an argument or a flag.
+type ArgType int
+
+const (
+	// String is any word.
+	String ArgType = iota
+	// Int is a positive integer.
+	Int
+	// List is a comma separated list of words.
+	List
+	// Bool is a flag without value, it is true when given.
+	Bool
+)
+
+// placeholder returns how a value of the type is shown in the usage.
+func (t ArgType) placeholder(name string) string {
+	switch t {
+	case Int:
+		return "<n>"
+	case List:
+		return "<" + name + ",...>"
+	default:
+		return "<" + name + ">"
+	}
+}
+
+// Arg declares a positional argument or a flag of a command.
+type Arg struct {
+	Name string
+	Type ArgType
+	Help string
+	// Pattern, when set, must match the value, every item of a list.
+	Pattern *regexp.Regexp
+	// Optional positional arguments come after the required ones, flags are always optional.
+	Optional bool
+}
+
+// validate returns why value is not a valid value of the argument, or nil.
+func (a Arg) validate(value string) error {
+	switch a.Type {
+	case Int:
+		if n, err := strconv.Atoi(value); err != nil || n <= 0 {
+			return fmt.Errorf("%s must be a positive integer, got %q", a.Name, value)
+		}
+	case List:
+		for _, item := range strings.Split(value, ",") {
+			if item == "" {
+				return fmt.Errorf("%s has an empty item in %q", a.Name, value)
+			}
+			if a.Pattern != nil && !a.Pattern.MatchString(item) {
+				return fmt.Errorf("invalid %s %q", a.Name, item)
+			}
+		}
+		return nil
+	}
+	if a.Pattern != nil && !a.Pattern.MatchString(value) {
+		return fmt.Errorf("invalid %s %q", a.Name, value)
+	}
+	return nil
+}
+
+// CommandHandler runs an invocation of a command. gc is the client of the pull request commented on.
+type CommandHandler func(*Agent, *githubcli.GithubClient, *Invocation) error
+
+// Command declares a chat command run by commenting /<Name> on a pull request.
+type Command struct {
+	Name       string
+	Args       []Arg
+	Flags      []Arg
+	Permission Permission
+	// Approvable commands may be run once by a user who is not allowed to, after a maintainer approves it.
+	Approvable bool
+	// Help is a one line description of the command.
+	Help    string
+	Handler CommandHandler
+	// Plugin is the plugin a repository configuration enables or disables the command with, Name by default.
+	Plugin string
+}
+
+// PluginName returns the plugin of the command.
+func (c *Command) PluginName() string {
+	if c.Plugin == "" {
+		return c.Name
+	}
+	return c.Plugin
+}
+
+// Usage returns the synopsis of the command, e.g. /run-perf <ref> [--iterations <n>].
+func (c *Command) Usage() string {
+	parts := []string{"/" + c.Name}
+	for _, a := range c.Args {
+		if a.Optional {
+			parts = append(parts, "[<"+a.Name+">]")
+		} else {
+			parts = append(parts, "<"+a.Name+">")
+		}
+	}
+	for _, f := range c.Flags {
+		if f.Type == Bool {
+			parts = append(parts, "[--"+f.Name+"]")
+		} else {
+			parts = append(parts, "[--"+f.Name+" "+f.Type.placeholder(f.Name)+"]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Reference returns the usage of the command followed by the help of its arguments and flags, as markdown.
+func (c *Command) Reference() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "`%s`\n%s\n", c.Usage(), c.Help)
+	for _, a := range c.Args {
+		fmt.Fprintf(&b, "- `<%s>`: %s\n", a.Name, a.Help)
+	}
+	for _, f := range c.Flags {
+		fmt.Fprintf(&b, "- `--%s`: %s\n", f.Name, f.Help)
+	}
+	return b.String()
+}
+
+func (c *Command) flag(name string) (Arg, bool) {
+	for _, f := range c.Flags {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Arg{}, false
+}
+
+// Invocation is a parsed command line.
+type Invocation struct {
+	Command *Command
+	Line    string
+	values  map[string]string
+}
+
+// IsSet returns whether the argument or the flag is given.
+func (i *Invocation) IsSet(name string) bool {
+	_, ok := i.values[name]
+	return ok
+}
+
+// String returns the value of the argument or the flag, or "" when it is not given.
+func (i *Invocation) String(name string) string {
+	return i.values[name]
+}
+
+// Int returns the value of the integer argument or flag, or def when it is not given.
+func (i *Invocation) Int(name string, def int) int {
+	v, ok := i.values[name]
+	if !ok {
+		return def
+	}
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+// List returns the items of the list argument or flag, or nil when it is not given.
+func (i *Invocation) List(name string) []string {
+	v, ok := i.values[name]
+	if !ok {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// Bool returns whether the boolean flag is given.
+func (i *Invocation) Bool(name string) bool {
+	return i.IsSet(name)
+}
+
+// UsageError is a command line which names a command but does not match its declaration.
+type UsageError struct {
+	Command *Command
+	Line    string
+	Reason  string
+}
+
+func (e *UsageError) Error() string {
+	return fmt.Sprintf("cannot run `%s`: %s.\n\nUsage: %s", e.Line, e.Reason, e.Command.Reference())
+}
+
+// ParseLine parses a comment line starting with /<command>.
+// It returns nil when the line names no registered command, a *UsageError when it is malformed.
+func ParseLine(line string) (*Invocation, error) {
+	line = strings.TrimSpace(line)
+	fields := strings.Fields(line)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return nil, nil
+	}
+	cmd, ok := Commands[strings.ToLower(strings.TrimPrefix(fields[0], "/"))]
+	if !ok {
+		return nil, nil
+	}
+	usage := func(format string, a ...interface{}) error {
+		return &UsageError{Command: cmd, Line: line, Reason: fmt.Sprintf(format, a...)}
+	}
+	inv := &Invocation{Command: cmd, Line: line, values: map[string]string{}}
+	var args []string
+	for i := 1; i < len(fields); i++ {
+		if !strings.HasPrefix(fields[i], "--") {
+			args = append(args, fields[i])
+			continue
+		}
+		name, value, hasValue := strings.TrimPrefix(fields[i], "--"), "", false
+		if j := strings.Index(name, "="); j != -1 {
+			name, value, hasValue = name[:j], name[j+1:], true
+		}
+		f, ok := cmd.flag(name)
+		if !ok {
+			return nil, usage("unknown flag --%s", name)
+		}
+		if inv.IsSet(name) {
+			return nil, usage("--%s is given twice", name)
+		}
+		if f.Type == Bool {
+			if hasValue {
+				return nil, usage("--%s takes no value", name)
+			}
+			inv.values[name] = "true"
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(fields) {
+				return nil, usage("--%s needs a value", name)
+			}
+			i++
+			value = fields[i]
+		}
+		if err := f.validate(value); err != nil {
+			return nil, usage("%s", err.Error())
+		}
+		inv.values[name] = value
+	}
+	if len(args) > len(cmd.Args) {
+		return nil, usage("too many arguments")
+	}
+	for i, a := range cmd.Args {
+		if i >= len(args) {
+			if !a.Optional {
+				return nil, usage("missing <%s>", a.Name)
+			}
+			continue
+		}
+		if err := a.validate(args[i]); err != nil {
+			return nil, usage("%s", err.Error())
+		}
+		inv.values[a.Name] = args[i]
+	}
+	return inv, nil
+}
+
+// ParseComment parses the command lines of a comment body, in order.
+// The lines of fenced code blocks are skipped, so a quoted command is not run.
+// Each malformed command line is returned as a *UsageError.
+func ParseComment(body string) ([]*Invocation, []error) {
+	var invocations []*Invocation
+	var errs []error
+	fenced := false
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			fenced = !fenced
+			continue
+		}
+		if fenced {
+			continue
+		}
+		inv, err := ParseLine(line)
+		if err != nil {
+			errs = append(errs, err)
+		} else if inv != nil {
+			invocations = append(invocations, inv)
+		}
+	}
+	return invocations, errs
+}
+
+// Who describes who may run the command on the repository, according to the policy
+// or else to the permission declared by the command.
+func (a *Agent) Who(owner, repo string, cmd *Command) string {
+	if a.Policy != nil {
+		if r, ok := a.Policy.Rule(owner, repo, cmd.Name); ok {
+			return r.String()
+		}
+	}
+	return cmd.Permission.String()
+}
+
+// Allowed returns whether the user with the author association may run the command on the repository of gc,
+// according to the policy or else to the permission declared by the command.
+func (a *Agent) Allowed(gc *githubcli.GithubClient, cmd *Command, user, association string) (bool, error) {
+	if a.Policy != nil {
+		allowed, ok, err := a.Policy.Allows(gc.Owner, gc.Repo, cmd.Name, user, association, gc)
+		if ok || err != nil {
+			return allowed, err
+		}
+	}
+	return cmd.Permission.Allows(association), nil
+}
+
+// Audit logs the decision and appends it to the audit log when it is configured.
+func (a *Agent) Audit(e policy.Entry) {
+	log.Info().Msgf("audit: %s /%s by @%s (%s) on %s/%s#%d, %s", e.Decision, e.Command, e.User, e.Association, e.Org, e.Repo, e.PR, e.Reason)
+	if a.PolicyStore == nil {
+		return
+	}
+	if err := a.PolicyStore.Audit(e); err != nil {
+		log.Error().Msgf("cannot append to the audit log, %s", err.Error())
+	}
+}
+
+// consumeApproval returns whether the author of gc was approved to run an approvable command
+// on the head of the pull request, and consumes the approval.
+func (a *Agent) consumeApproval(gc *githubcli.GithubClient, cmd *Command) bool {
+	if !cmd.Approvable || a.PolicyStore == nil {
+		return false
+	}
+	sha := gc.GetLastCommitSHA()
+	approval, ok, err := a.PolicyStore.Consume(gc.Owner, gc.Repo, gc.Pr, gc.Author, sha)
+	if err != nil {
+		log.Error().Msgf("cannot consume the approval of @%s, %s", gc.Author, err.Error())
+		return false
+	}
+	if ok {
+		a.Audit(policy.Entry{Org: gc.Owner, Repo: gc.Repo, PR: gc.Pr, User: gc.Author, Association: gc.AuthorAssociation,
+			Command: cmd.Name, Decision: policy.Consumed, Reason: fmt.Sprintf("approved by @%s on %s", approval.By, sha)})
+	}
+	return ok
+}
+
+// denial returns the reply to the author of gc who may not run the command.
+func (a *Agent) denial(gc *githubcli.GithubClient, cmd *Command) string {
+	msg := fmt.Sprintf("@%s cannot run /%s, it may be run by %s.", gc.Author, cmd.Name, a.Who(gc.Owner, gc.Repo, cmd))
+	if _, ok := Commands[ApproveCommand]; ok && cmd.Approvable {
+		msg += fmt.Sprintf(" A maintainer may allow a single run with `/%s @%s`.", ApproveCommand, gc.Author)
+	}
+	return msg
+}
+
+// RunCommands runs the commands of a comment by the author of gc, replying to the malformed
+// and the forbidden ones. It returns the first error of the handlers, all of them are run.
+func RunCommands(agent *Agent, gc *githubcli.GithubClient, body string) error {
+	invocations, errs := ParseComment(body)
+	for _, err := range errs {
+		log.Info().Msgf("usage error, %s", err.Error())
+		if perr := gc.PostComment(err.Error()); perr != nil {
+			return perr
+		}
+	}
+	var first error
+	for _, inv := range invocations {
+		cmd := inv.Command
+		if !agent.Enabled(cmd.PluginName()) {
+			log.Info().Msgf("%s is disabled on %s/%s", inv.Line, gc.Owner, gc.Repo)
+			if err := gc.PostComment(fmt.Sprintf("/%s is disabled on this repository.", cmd.Name)); err != nil && first == nil {
+				first = err
+			}
+			continue
+		}
+		allowed, err := agent.Allowed(gc, cmd, gc.Author, gc.AuthorAssociation)
+		reason := "may be run by " + agent.Who(gc.Owner, gc.Repo, cmd)
+		if err != nil {
+			log.Error().Msgf("cannot check the permission of @%s, %s", gc.Author, err.Error())
+			reason = err.Error()
+		}
+		if !allowed && !agent.consumeApproval(gc, cmd) {
+			agent.Audit(policy.Entry{Org: gc.Owner, Repo: gc.Repo, PR: gc.Pr, User: gc.Author, Association: gc.AuthorAssociation,
+				Command: cmd.Name, Decision: policy.Denied, Reason: reason})
+			if err := gc.PostComment(agent.denial(gc, cmd)); err != nil && first == nil {
+				first = err
+			}
+			continue
+		}
+		if wait := agent.Limiter.Allow(gc.Author); wait > 0 {
+			log.Info().Msgf("@%s is rate limited, %s", gc.Author, inv.Line)
+			if err := gc.PostComment(fmt.Sprintf("@%s ran too many commands, /%s may be run again in %s.", gc.Author, cmd.Name, wait.Round(time.Second))); err != nil && first == nil {
+				first = err
+			}
+			continue
+		}
+		log.Info().Msgf("@%s runs %s", gc.Author, inv.Line)
+		if err := cmd.Handler(agent, gc, inv); err != nil {
+			log.Error().Msgf("cannot run %s, %s", inv.Line, err.Error())
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// RegisterCommand declares a chat command, its handler is run on the comments of pull requests.
+func RegisterCommand(cmd Command) {
+	Commands[cmd.Name] = &cmd
+}
+
+// CommandNames returns the sorted names of the registered commands.
+func CommandNames() []string {
+	var names []string
+	for name := range Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterIssueCommentHandler("commands", handleCommands)
+}
+
+// handleCommands runs the commands of a new comment.
+func handleCommands(agent *Agent, ic *github.IssueCommentEvent) error {
+	if agent.GithubClient == nil {
+		return fmt.Errorf("no github client for comment %d", ic.GetComment().GetID())
+	}
+	return RunCommands(agent, agent.GithubClient, ic.GetComment().GetBody())
+}
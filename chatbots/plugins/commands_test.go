@@ -0,0 +1,194 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/stretchr/testify/assert"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/repoconfig"
+)
+
+var testCommand = Command{
+	Name: "test-cmd",
+	Args: []Arg{
+		{Name: "ref", Pattern: regexp.MustCompile(`^(main|v[0-9.]+)$`), Help: "the reference"},
+		{Name: "extra", Optional: true, Help: "an extra word"},
+	},
+	Flags: []Arg{
+		{Name: "iterations", Type: Int, Help: "the number of iterations"},
+		{Name: "queries", Type: List, Help: "the queries"},
+		{Name: "dry-run", Type: Bool, Help: "do nothing"},
+	},
+	Permission: Member,
+	Help:       "A test command.",
+}
+
+func init() {
+	RegisterCommand(testCommand)
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name        string
+		line        string
+		expectNil   bool
+		expectError string
+		expect      map[string]string
+	}{
+		{name: "not a command", line: "looks good", expectNil: true},
+		{name: "unknown command", line: "/lgtm", expectNil: true},
+		{name: "inline", line: "please /test-cmd main", expectNil: true},
+		{name: "args", line: "/test-cmd main", expect: map[string]string{"ref": "main"}},
+		{name: "case", line: "  /Test-Cmd v1.2 foo\r", expect: map[string]string{"ref": "v1.2", "extra": "foo"}},
+		{
+			name:   "flags",
+			line:   "/test-cmd --iterations 5 main --queries=q1,q3 --dry-run",
+			expect: map[string]string{"ref": "main", "iterations": "5", "queries": "q1,q3", "dry-run": "true"},
+		},
+		{name: "missing", line: "/test-cmd", expectError: "missing <ref>"},
+		{name: "pattern", line: "/test-cmd master", expectError: `invalid ref "master"`},
+		{name: "too many", line: "/test-cmd main a b", expectError: "too many arguments"},
+		{name: "unknown flag", line: "/test-cmd main --fast", expectError: "unknown flag --fast"},
+		{name: "twice", line: "/test-cmd main --dry-run --dry-run", expectError: "--dry-run is given twice"},
+		{name: "no value", line: "/test-cmd main --iterations", expectError: "--iterations needs a value"},
+		{name: "bool value", line: "/test-cmd main --dry-run=yes", expectError: "--dry-run takes no value"},
+		{name: "int", line: "/test-cmd main --iterations 0", expectError: `iterations must be a positive integer, got "0"`},
+		{name: "list", line: "/test-cmd main --queries q1,,q3", expectError: `queries has an empty item in "q1,,q3"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inv, err := ParseLine(tt.line)
+			if tt.expectError != "" {
+				assert.Nil(t, inv)
+				assert.IsType(t, &UsageError{}, err)
+				assert.Equal(t, tt.expectError, err.(*UsageError).Reason)
+				return
+			}
+			assert.NoError(t, err)
+			if tt.expectNil {
+				assert.Nil(t, inv)
+				return
+			}
+			assert.Equal(t, "test-cmd", inv.Command.Name)
+			assert.Equal(t, tt.expect, inv.values)
+		})
+	}
+}
+
+func TestInvocation(t *testing.T) {
+	inv, err := ParseLine("/test-cmd main --iterations 5 --queries q1,q3")
+	assert.NoError(t, err)
+	assert.Equal(t, "main", inv.String("ref"))
+	assert.Equal(t, 5, inv.Int("iterations", 3))
+	assert.Equal(t, []string{"q1", "q3"}, inv.List("queries"))
+	assert.False(t, inv.Bool("dry-run"))
+	assert.False(t, inv.IsSet("extra"))
+
+	inv, err = ParseLine("/test-cmd main")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, inv.Int("iterations", 3))
+	assert.Nil(t, inv.List("queries"))
+}
+
+func TestParseComment(t *testing.T) {
+	body := "Let's measure it.\n/test-cmd main\n```\n/test-cmd v1\n```\n> /test-cmd v2\n/test-cmd nope\n/test-cmd v3 --dry-run\n"
+	invocations, errs := ParseComment(body)
+	assert.Len(t, invocations, 2)
+	assert.Equal(t, "main", invocations[0].String("ref"))
+	assert.Equal(t, "v3", invocations[1].String("ref"))
+	assert.Len(t, errs, 1)
+	assert.Equal(t, "cannot run `/test-cmd nope`: invalid ref \"nope\".\n\nUsage: `/test-cmd <ref> [<extra>] [--iterations <n>] [--queries <queries,...>] [--dry-run]`\n"+
+		"A test command.\n- `<ref>`: the reference\n- `<extra>`: an extra word\n- `--iterations`: the number of iterations\n- `--queries`: the queries\n- `--dry-run`: do nothing\n",
+		errs[0].Error())
+}
+
+func TestPermission(t *testing.T) {
+	for _, tt := range []struct {
+		permission Permission
+		allowed    []string
+	}{
+		{Anyone, []string{"NONE", "CONTRIBUTOR", "COLLABORATOR", "MEMBER", "OWNER"}},
+		{Collaborator, []string{"COLLABORATOR", "MEMBER", "OWNER"}},
+		{Member, []string{"MEMBER", "OWNER"}},
+		{Owner, []string{"OWNER"}},
+	} {
+		var allowed []string
+		for _, a := range []string{"NONE", "CONTRIBUTOR", "COLLABORATOR", "MEMBER", "OWNER"} {
+			if tt.permission.Allows(a) {
+				allowed = append(allowed, a)
+			}
+		}
+		assert.Equal(t, tt.allowed, allowed, tt.permission.String())
+	}
+}
+
+func TestRunCommands(t *testing.T) {
+	var ran []string
+	cmd := testCommand
+	cmd.Name, cmd.Permission = "test-run", Anyone
+	cmd.Handler = func(agent *Agent, gc *githubcli.GithubClient, inv *Invocation) error {
+		ran = append(ran, inv.String("ref"))
+		return nil
+	}
+	RegisterCommand(cmd)
+	defer delete(Commands, cmd.Name)
+
+	gc := &githubcli.GithubClient{Author: "alice", AuthorAssociation: "NONE"}
+	assert.NoError(t, RunCommands(&Agent{}, gc, "/test-run main\r\n/test-run v1 --dry-run"))
+	assert.Equal(t, []string{"main", "v1"}, ran)
+}
+
+func TestRunCommands_disabled(t *testing.T) {
+	var comments []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/datafuselabs/databend/issues/233/comments", r.URL.Path)
+		var c github.IssueComment
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&c))
+		comments = append(comments, c.GetBody())
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+	clt := github.NewClient(srv.Client())
+	clt.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	var ran []string
+	for _, name := range []string{"test-on", "test-off"} {
+		cmd := Command{Name: name, Plugin: "test-" + name, Handler: func(agent *Agent, gc *githubcli.GithubClient, inv *Invocation) error {
+			ran = append(ran, inv.Command.Name)
+			return nil
+		}}
+		RegisterCommand(cmd)
+		defer delete(Commands, name)
+	}
+	cfg, err := repoconfig.Parse([]byte("plugins:\n  test-test-off: false\n  test-test-on: true\n"))
+	assert.NoError(t, err)
+
+	gc := &githubcli.GithubClient{Clt: clt, Owner: "datafuselabs", Repo: "databend", Pr: 233, Ctx: context.Background(), Author: "alice"}
+	assert.NoError(t, RunCommands(&Agent{RepoConfig: cfg}, gc, "/test-on\n/test-off"))
+	assert.Equal(t, []string{"test-on"}, ran)
+	assert.Equal(t, []string{"/test-off is disabled on this repository."}, comments)
+
+	// disabled on every repository
+	assert.NoError(t, RunCommands(&Agent{RepoConfig: cfg, DisabledPlugins: map[string]bool{"test-test-on": true}}, gc, "/test-on"))
+	assert.Equal(t, []string{"test-on"}, ran)
+	assert.Equal(t, "/test-on is disabled on this repository.", comments[1])
+
+	// rate limited
+	limiter := NewRateLimiter(1, time.Hour)
+	assert.NoError(t, RunCommands(&Agent{Limiter: limiter}, gc, "/test-on\n/test-on"))
+	assert.Equal(t, []string{"test-on", "test-on"}, ran)
+	assert.Equal(t, "@alice ran too many commands, /test-on may be run again in 1h0m0s.", comments[2])
+}
@@ -0,0 +1,70 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package help
+
+import (
+	"fmt"
+	"strings"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+
+	"github.com/rs/zerolog/log"
+)
+
+const pluginName = "help"
+
+func init() {
+	log.Info().Msgf("registed plugin: %s", pluginName)
+	plugins.RegisterCommand(plugins.Command{
+		Name: pluginName,
+		Args: []plugins.Arg{
+			{Name: "command", Optional: true, Help: "a command to describe, e.g. run-perf"},
+		},
+		Permission: plugins.Anyone,
+		Help:       "List the commands of the bot, or describe one of them.",
+		Handler:    handle,
+	})
+}
+
+// cell escapes the text of a markdown table cell.
+func cell(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), "|", `\|`)
+}
+
+// renderHelp returns the markdown table of the commands, generated from their declarations.
+// who describes who may run a command.
+func renderHelp(commands map[string]*plugins.Command, names []string, who func(*plugins.Command) string) string {
+	var b strings.Builder
+	b.WriteString("| Command | Description | Arguments | Who may run it |\n")
+	b.WriteString("|---|---|---|---|\n")
+	for _, name := range names {
+		c := commands[name]
+		var args []string
+		for _, a := range c.Args {
+			args = append(args, fmt.Sprintf("`<%s>`: %s", a.Name, cell(a.Help)))
+		}
+		for _, f := range c.Flags {
+			args = append(args, fmt.Sprintf("`--%s`: %s", f.Name, cell(f.Help)))
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", cell(c.Usage()), cell(c.Help), strings.Join(args, "<br>"), cell(who(c)))
+	}
+	return b.String()
+}
+
+// handle replies to /help with the table of the commands, or the reference of the given one.
+func handle(agent *plugins.Agent, gc *githubcli.GithubClient, inv *plugins.Invocation) error {
+	who := func(c *plugins.Command) string {
+		return agent.Who(gc.Owner, gc.Repo, c)
+	}
+	if !inv.IsSet("command") {
+		return gc.PostComment(renderHelp(plugins.Commands, plugins.CommandNames(), who))
+	}
+	name := strings.ToLower(strings.TrimPrefix(inv.String("command"), "/"))
+	c, ok := plugins.Commands[name]
+	if !ok {
+		return gc.PostComment(fmt.Sprintf("There is no /%s command, the commands are /%s.", name, strings.Join(plugins.CommandNames(), ", /")))
+	}
+	return gc.PostComment(fmt.Sprintf("%s\nMay be run by %s.", c.Reference(), who(c)))
+}
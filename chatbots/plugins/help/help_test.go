@@ -0,0 +1,59 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package help
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"datafuselabs/test-infra/chatbots/plugins"
+	_ "datafuselabs/test-infra/chatbots/plugins/perfqueue"
+	_ "datafuselabs/test-infra/chatbots/plugins/runperf"
+	"datafuselabs/test-infra/chatbots/policy"
+)
+
+func permission(c *plugins.Command) string {
+	return c.Permission.String()
+}
+
+func TestRenderHelp(t *testing.T) {
+	commands := map[string]*plugins.Command{
+		"b-cmd": {
+			Name:       "b-cmd",
+			Args:       []plugins.Arg{{Name: "ref", Help: "main | latest"}},
+			Flags:      []plugins.Arg{{Name: "iterations", Type: plugins.Int, Help: "the iterations"}},
+			Permission: plugins.Collaborator,
+			Help:       "Run b.",
+		},
+		"a-cmd": {Name: "a-cmd", Permission: plugins.Anyone, Help: "Run a."},
+	}
+	assert.Equal(t, "| Command | Description | Arguments | Who may run it |\n"+
+		"|---|---|---|---|\n"+
+		"| `/a-cmd` | Run a. |  | anyone |\n"+
+		"| `/b-cmd <ref> [--iterations <n>]` | Run b. | `<ref>`: main \\| latest<br>`--iterations`: the iterations | a collaborator, member or owner |\n",
+		renderHelp(commands, []string{"a-cmd", "b-cmd"}, permission))
+}
+
+func TestRenderHelp_registered(t *testing.T) {
+	help := renderHelp(plugins.Commands, plugins.CommandNames(), permission)
+	for _, name := range []string{"help", "perf-queue", "run-perf", "rerun-perf", "rerun-perf-all"} {
+		assert.Contains(t, help, "| `/"+name, name)
+	}
+	assert.Len(t, strings.Split(strings.TrimSpace(help), "\n"), len(plugins.Commands)+2)
+	assert.Contains(t, help, "| `/run-perf [<ref>] [--iterations <n>] [--queries <queries,...>]` | Benchmark the head of the pull request against a reference build. | "+
+		"`<ref>`: the reference build: master, main, latest (the last release tag) or a release tag, the configured default when omitted<br>")
+}
+
+func TestRenderHelp_policy(t *testing.T) {
+	p, err := policy.Parse([]byte("repos:\n  datafuselabs/databend:\n    commands:\n      run-perf:\n        teams: [perf]\n        users: [alice]\n"))
+	assert.NoError(t, err)
+	agent := &plugins.Agent{Policy: p}
+	help := renderHelp(plugins.Commands, plugins.CommandNames(), func(c *plugins.Command) string {
+		return agent.Who("datafuselabs", "databend", c)
+	})
+	assert.Contains(t, help, "| team perf or @alice |\n")
+	assert.Contains(t, help, "| `/perf-queue` | List the runs waiting for a runner. |  | anyone |\n")
+}
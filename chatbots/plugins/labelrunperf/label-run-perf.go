@@ -7,9 +7,10 @@ import (
 	"context"
 	githubcli "datafuselabs/test-infra/chatbots/github"
 	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/policy"
+	"datafuselabs/test-infra/chatbots/repoconfig"
 	"fmt"
-	"regexp"
-	"strings"
+	"time"
 
 	"github.com/google/go-github/v35/github"
 	"github.com/rs/zerolog"
@@ -20,11 +21,9 @@ const (
 	pluginName = "label-run-perf"
 )
 
-var (
-	reg      = regexp.MustCompile(`(?mi)^run-perf\s*(?P<RELEASE>master|main|latest|v[0-9]+\.[0-9]+\.[0-9]+\S*)\s*$`)
-	rerunAll = regexp.MustCompile(`(?mi)^rerun-perf-all\s*(?P<RELEASE>master|main|latest|v[0-9]+\.[0-9]+\.[0-9]+\S*)\s*$`)
-	rerun    = regexp.MustCompile(`(?mi)^rerun-perf\s*(?P<RELEASE>master|main|latest|v[0-9]+\.[0-9]+\.[0-9]+\S*)\s*$`)
-)
+// labelCommands are the commands a pull request label may run on each push, e.g. the label "run-perf master",
+// or a label mapped to one of them by the repository configuration.
+var labelCommands = map[string]bool{"run-perf": true, "rerun-perf-all": true, "rerun-perf": true}
 
 func init() {
 	log.Info().Msgf("regsited plugin: %s", pluginName)
@@ -43,28 +42,21 @@ func handlePushComment(client *plugins.Agent, ic *github.PushEvent) error {
 	return nil
 }
 
-func extractCommand(s string) string {
-	s = strings.TrimLeft(s, "\r\n\t ")
-	if i := strings.Index(s, "\n"); i != -1 {
-		s = s[:i]
-	}
-	s = strings.TrimRight(s, "\r\n\t ")
-	return s
-}
-
-// Verify if user is allowed to perform activity.
-func (h handler) verifyUser(pr *github.PullRequest) error {
-	var allowed bool
-	allowedAssociations := []string{"COLLABORATOR", "MEMBER", "OWNER"}
-	for _, a := range allowedAssociations {
-		if a == pr.GetAuthorAssociation() {
-			allowed = true
-		}
+// verifyUser checks that the author of the pull request, who labelled it, may run the command.
+// The pusher is not checked, pushes of anyone to a labelled pull request run the command.
+func (h handler) verifyUser(pr *github.PullRequest, cmd *plugins.Command) error {
+	author := pr.GetUser().GetLogin()
+	allowed, err := h.agent.Allowed(h.gc, cmd, author, pr.GetAuthorAssociation())
+	if err != nil {
+		return err
 	}
 	if !allowed {
-		return fmt.Errorf("@%s is not a org, member nor a collaborator and cannot execute bendbench.", h.gc.Author)
+		who := h.agent.Who(h.gc.Owner, h.gc.Repo, cmd)
+		h.agent.Audit(policy.Entry{Org: h.gc.Owner, Repo: h.gc.Repo, PR: pr.GetNumber(), User: author, Association: pr.GetAuthorAssociation(),
+			Command: cmd.Name, Decision: policy.Denied, Reason: "label, may be run by " + who})
+		return fmt.Errorf("@%s cannot run /%s from labels, it may be run by %s", author, cmd.Name, who)
 	}
-	h.log.Info().Msgf("author is a owner, member or collaborator")
+	h.log.Info().Msgf("@%s may run /%s", author, cmd.Name)
 	return nil
 }
 
@@ -74,70 +66,72 @@ func handle(h *handler) error {
 		return nil
 	}
 	prs := h.gc.ListAssociatedPR(h.gc.LastSHA)
+	var first error
 	for _, i := range prs {
-		err := h.verifyUser(i)
-		if err != nil {
-			continue
+		if err := h.runByLabel(i); err != nil {
+			h.log.Error().Msgf("cannot run the label command of pr %d, %s", i.GetNumber(), err.Error())
+			if first == nil {
+				first = err
+			}
 		}
-		h.commentByLabel(i, h.gc.LastSHA)
 	}
-	return nil
+	return first
 }
 
-func (h *handler) commentByLabel(pr *github.PullRequest, sha string) error {
-	labels := pr.Labels
-	if labels == nil || len(labels) == 0 {
-		return nil
-	}
-	for _, l := range labels {
-		h.log.Info().Msgf("current label %s in pr %s", l.GetName(), pr.GetNumber())
-		comment := make_comment(*h, l)
-		if comment == "" {
+// runByLabel runs the command of the first label of the pull request mapped to one, as the author of the pull request.
+// The handler of the command is called directly, a comment of the bot would run it as the bot.
+func (h *handler) runByLabel(pr *github.PullRequest) error {
+	for _, l := range pr.Labels {
+		h.log.Info().Msgf("current label %s in pr %d", l.GetName(), pr.GetNumber())
+		inv := labelCommand(h.agent.RepoConfig, l)
+		if inv == nil {
 			continue
 		}
-		h.gc.Pr = pr.GetNumber()
-		h.log.Info().Msgf("post comment for push commit with owner %s repo %s pr %s", pr.GetBase().Repo.Owner, pr.GetBase().Repo.GetName(), pr.GetNumber())
-		err := h.gc.PostComment(comment)
-		if err != nil {
-			h.log.Error().Msgf("cannot post comment %s", err.Error())
+		cmd := inv.Command
+		if !h.agent.Enabled(cmd.PluginName()) {
+			h.log.Info().Msgf("%s is disabled on %s/%s", inv.Line, h.gc.Owner, h.gc.Repo)
+			return nil
 		}
-		return nil
+		if err := h.verifyUser(pr, cmd); err != nil {
+			h.log.Info().Msgf(err.Error())
+			return nil
+		}
+		gc := *h.gc
+		gc.Pr, gc.Author, gc.AuthorAssociation = pr.GetNumber(), pr.GetUser().GetLogin(), pr.GetAuthorAssociation()
+		if wait := h.agent.Limiter.Allow(gc.Author); wait > 0 {
+			h.log.Info().Msgf("@%s is rate limited for %s, %s", gc.Author, wait.Round(time.Second), inv.Line)
+			return nil
+		}
+		h.log.Info().Msgf("@%s runs %s from a label of pr %d", gc.Author, inv.Line, gc.Pr)
+		return cmd.Handler(h.agent, &gc, inv)
 	}
 	return nil
 }
 
-func make_comment(h handler, l *github.Label) string {
-	m, _ := findMatches(h, l.GetName())
-	if m == nil || len(m) < 1 || len(m[0]) < 2 {
-		return ""
+// labelCommand returns the command run by the label, or nil when the label is not a valid run-perf command.
+// The labels of the repository configuration cfg run the configured command line, the other labels are command lines.
+func labelCommand(cfg *repoconfig.Config, l *github.Label) *plugins.Invocation {
+	line, ok := cfg.Label(l.GetName())
+	if !ok {
+		line = "/" + l.GetName()
 	}
-	return fmt.Sprintf("/%s", m[0][0])
-}
-
-func findMatches(h handler, command string) (matches [][]string, dispatch_name string) {
-	matches = nil
-	for name, regex := range h.regexp {
-		matches := regex.FindAllStringSubmatch(command, -1)
-		if matches != nil {
-			h.log.Log().Msgf("find match in command %s", name)
-			return matches, name
-		}
-
+	inv, err := plugins.ParseLine(line)
+	if err != nil || inv == nil || !labelCommands[inv.Command.Name] {
+		return nil
 	}
-	return nil, dispatch_name
+	return inv
 }
 
 // handler is a struct that contains data about a github event and provides functions to help handle it.
 type handler struct {
-
-	// regexp is the regular expression describing the command. It must have an optional 'un' prefix
-	// as the first subgroup and the arguments to the command as the second subgroup.
-	regexp map[string]*regexp.Regexp
 	// gc is the githubClient to use for creating response comments in the event of a failure.
 	gc *githubcli.GithubClient
 
 	// log define structed logging interface.
 	log zerolog.Logger
+
+	// agent checks the permission of the pull request authors.
+	agent *plugins.Agent
 }
 
 func newLabelRunPerf(e *github.PushEvent, log zerolog.Logger, client *plugins.Agent) (*handler, error) {
@@ -147,13 +141,9 @@ func newLabelRunPerf(e *github.PushEvent, log zerolog.Logger, client *plugins.Ag
 
 		return nil, err
 	}
-	regs := make(map[string]*regexp.Regexp)
-	regs["run_perf"] = reg
-	regs["rerun_perf_all"] = rerunAll
-	regs["rerun_perf"] = rerun
 	return &handler{
-		regexp: regs,
-		gc:     githubCli,
-		log:    log,
+		gc:    githubCli,
+		log:   log,
+		agent: client,
 	}, nil
 }
@@ -1,7 +1,7 @@
 package labelrunperf
 
 import (
-	"regexp"
+	"fmt"
 	"testing"
 
 	"github.com/google/go-github/v35/github"
@@ -9,6 +9,11 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/plugins/pluginstest"
+	_ "datafuselabs/test-infra/chatbots/plugins/runperf"
+	"datafuselabs/test-infra/chatbots/policy"
+	"datafuselabs/test-infra/chatbots/repoconfig"
 )
 
 func newFakeGithubClient(author string) *githubcli.GithubClient {
@@ -19,55 +24,130 @@ func newFakeGithubClient(author string) *githubcli.GithubClient {
 
 func newFakeHandler(author string) *handler {
 	return &handler{
-		regexp: map[string]*regexp.Regexp{"run_perf": reg, "rerun_perf_all": rerunAll, "rerun_perf": rerun},
-		gc:     newFakeGithubClient(author),
-		log:    log.With().Str("push", "run-perf").Logger(),
+		gc:    newFakeGithubClient(author),
+		log:   log.With().Str("push", "run-perf").Logger(),
+		agent: &plugins.Agent{},
 	}
 }
 
-func Test_makecomment(t *testing.T) {
+func Test_labelCommand(t *testing.T) {
 	tests := []struct {
-		name            string
-		labelName       string
-		author          string
-		expectedComment string
+		name         string
+		labelName    string
+		config       string
+		expectedLine string
 	}{
 		{
-			name:            "master",
-			labelName:       "run-perf master",
-			author:          "zhihanz",
-			expectedComment: "/run-perf master",
+			name:         "master",
+			labelName:    "run-perf master",
+			expectedLine: "/run-perf master",
 		},
 		{
-			name:            "rerun",
-			labelName:       "rerun-perf master",
-			author:          "zhihanz",
-			expectedComment: "/rerun-perf master",
+			name:         "rerun",
+			labelName:    "rerun-perf master",
+			expectedLine: "/rerun-perf master",
 		},
 		{
-			name:            "version",
-			labelName:       "run-perf v0.4.11-nightly",
-			author:          "zhihanz",
-			expectedComment: "/run-perf v0.4.11-nightly",
+			name:         "version",
+			labelName:    "run-perf v0.4.11-nightly",
+			expectedLine: "/run-perf v0.4.11-nightly",
 		},
 		{
-			name:            "latest",
-			labelName:       "run-perf latest",
-			author:          "zhihanz",
-			expectedComment: "/run-perf latest",
+			name:         "latest",
+			labelName:    "run-perf latest",
+			expectedLine: "/run-perf latest",
 		},
 		{
-			name:            "foo",
-			labelName:       "foo-bar",
-			author:          "zhihanz",
-			expectedComment: "",
+			name:         "invalid reference",
+			labelName:    "run-perf foo",
+			expectedLine: "",
+		},
+		{
+			name:         "not a label command",
+			labelName:    "build-docker master",
+			expectedLine: "",
+		},
+		{
+			name:         "configured",
+			labelName:    "perf",
+			config:       "labels:\n  perf: /run-perf main --iterations 3\n",
+			expectedLine: "/run-perf main --iterations 3",
+		},
+		{
+			name:         "configured not a label command",
+			labelName:    "docker",
+			config:       "labels:\n  docker: /build-docker master\n",
+			expectedLine: "",
+		},
+		{
+			name:         "foo",
+			labelName:    "foo-bar",
+			expectedLine: "",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			handler := newFakeHandler(tt.author)
-			comment := make_comment(*handler, &github.Label{Name: &tt.labelName})
-			assert.Equal(t, comment, tt.expectedComment)
+			cfg, err := repoconfig.Parse([]byte(tt.config))
+			assert.NoError(t, err)
+			var line string
+			if inv := labelCommand(cfg, &github.Label{Name: &tt.labelName}); inv != nil {
+				line = inv.Line
+			}
+			assert.Equal(t, tt.expectedLine, line)
 		})
 	}
 }
+
+func Test_verifyUser(t *testing.T) {
+	h := newFakeHandler("pusher")
+	h.gc.Owner, h.gc.Repo = "datafuselabs", "databend"
+	h.agent = &plugins.Agent{}
+	pr := &github.PullRequest{Number: github.Int(233), User: &github.User{Login: github.String("alice")}, AuthorAssociation: github.String("CONTRIBUTOR")}
+	inv, err := plugins.ParseLine("/run-perf master")
+	assert.NoError(t, err)
+
+	err = h.verifyUser(pr, inv.Command)
+	assert.EqualError(t, err, "@alice cannot run /run-perf from labels, it may be run by a collaborator, member or owner")
+
+	h.agent.Policy, err = policy.Parse([]byte("commands:\n  run-perf:\n    users: [alice]\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, h.verifyUser(pr, inv.Command))
+
+	pr.AuthorAssociation = github.String("OWNER")
+	pr.User.Login = github.String("carol")
+	assert.Error(t, h.verifyUser(pr, inv.Command), "the policy replaces the declared permission")
+}
+
+func Test_runByLabel(t *testing.T) {
+	var ran []string
+	runPerf := plugins.Commands["run-perf"]
+	cmd := *runPerf
+	cmd.Handler = func(agent *plugins.Agent, gc *githubcli.GithubClient, inv *plugins.Invocation) error {
+		ran = append(ran, fmt.Sprintf("%s #%d %s", gc.Author, gc.Pr, inv.Line))
+		return nil
+	}
+	plugins.Commands["run-perf"] = &cmd
+	defer func() { plugins.Commands["run-perf"] = runPerf }()
+
+	f, gc := pluginstest.NewGithub(t)
+	h := newFakeHandler("pusher")
+	h.gc = gc
+	pr := &github.PullRequest{
+		Number:            github.Int(233),
+		User:              &github.User{Login: github.String("alice")},
+		AuthorAssociation: github.String("MEMBER"),
+		Labels:            []*github.Label{{Name: github.String("bug")}, {Name: github.String("run-perf master")}},
+	}
+	assert.NoError(t, h.runByLabel(pr))
+	assert.Equal(t, []string{"alice #233 /run-perf master"}, ran, "run as the author of the pull request")
+	assert.Empty(t, f.Comments, "the command is not posted as a comment of the bot")
+
+	pr.AuthorAssociation = github.String("CONTRIBUTOR")
+	assert.NoError(t, h.runByLabel(pr))
+	assert.Len(t, ran, 1, "the author may not run the command")
+
+	pr.AuthorAssociation = github.String("MEMBER")
+	h.agent.DisabledPlugins = map[string]bool{"run-perf": true}
+	assert.NoError(t, h.runByLabel(pr))
+	assert.Len(t, ran, 1, "the plugin is disabled")
+}
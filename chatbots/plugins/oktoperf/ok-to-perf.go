@@ -0,0 +1,59 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package oktoperf
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/policy"
+
+	"github.com/rs/zerolog/log"
+)
+
+const pluginName = plugins.ApproveCommand
+
+var login = regexp.MustCompile(`^@?[A-Za-z0-9][A-Za-z0-9-]*$`)
+
+func init() {
+	log.Info().Msgf("registed plugin: %s", pluginName)
+	plugins.RegisterCommand(plugins.Command{
+		Name: pluginName,
+		Args: []plugins.Arg{
+			{Name: "user", Pattern: login, Help: "the github login of the contributor, e.g. @alice"},
+		},
+		Permission: plugins.Collaborator,
+		Help:       "Allow a contributor to run one benchmark command on the head of the pull request.",
+		Handler:    handle,
+	})
+}
+
+// handle approves a single run of the user on the head of the pull request.
+func handle(agent *plugins.Agent, gc *githubcli.GithubClient, inv *plugins.Invocation) error {
+	if agent.PolicyStore == nil {
+		return fmt.Errorf("approvals are not configured")
+	}
+	user := strings.TrimPrefix(inv.String("user"), "@")
+	sha := gc.GetLastCommitSHA()
+	if sha == "" {
+		return fmt.Errorf("cannot find the head of pull request %d", gc.Pr)
+	}
+	err := agent.PolicyStore.Approve(policy.Approval{Org: gc.Owner, Repo: gc.Repo, PR: gc.Pr, User: user, SHA: sha, By: gc.Author})
+	if err != nil {
+		return err
+	}
+	agent.Audit(policy.Entry{Org: gc.Owner, Repo: gc.Repo, PR: gc.Pr, User: gc.Author, Association: gc.AuthorAssociation,
+		Command: pluginName, Decision: policy.Approved, Reason: fmt.Sprintf("one run of @%s on %s", user, sha)})
+	var approvable []string
+	for _, name := range plugins.CommandNames() {
+		if plugins.Commands[name].Approvable {
+			approvable = append(approvable, "/"+name)
+		}
+	}
+	return gc.PostComment(fmt.Sprintf("@%s may run one of %s on %s, approved by @%s. New commits need a new approval.",
+		user, strings.Join(approvable, ", "), sha, gc.Author))
+}
@@ -0,0 +1,81 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package oktoperf
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/plugins/pluginstest"
+	"datafuselabs/test-infra/chatbots/policy"
+)
+
+func TestOkToPerf(t *testing.T) {
+	var ran []string
+	plugins.RegisterCommand(plugins.Command{
+		Name:       "test-perf",
+		Permission: plugins.Collaborator,
+		Approvable: true,
+		Handler: func(agent *plugins.Agent, gc *githubcli.GithubClient, inv *plugins.Invocation) error {
+			ran = append(ran, gc.Author)
+			return nil
+		},
+	})
+	defer delete(plugins.Commands, "test-perf")
+
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "policy.db"), 0600, nil)
+	assert.NoError(t, err)
+	defer db.Close()
+	store, err := policy.NewStore(db)
+	assert.NoError(t, err)
+	f, gc := pluginstest.NewGithub(t)
+	agent := &plugins.Agent{PolicyStore: store}
+	as := func(user, association string) *githubcli.GithubClient {
+		c := *gc
+		c.Author, c.AuthorAssociation = user, association
+		return &c
+	}
+
+	assert.NoError(t, plugins.RunCommands(agent, as("bob", "CONTRIBUTOR"), "/test-perf"))
+	assert.Empty(t, ran)
+	assert.Equal(t, "@bob cannot run /test-perf, it may be run by a collaborator, member or owner. "+
+		"A maintainer may allow a single run with `/ok-to-perf @bob`.", f.Comments[0])
+
+	assert.NoError(t, plugins.RunCommands(agent, as("bob", "CONTRIBUTOR"), "/ok-to-perf @bob"))
+	assert.Equal(t, "@bob cannot run /ok-to-perf, it may be run by a collaborator, member or owner.", f.Comments[1])
+
+	assert.NoError(t, plugins.RunCommands(agent, as("alice", "MEMBER"), "/ok-to-perf @bob"))
+	assert.Equal(t, "@bob may run one of /test-perf on abc, approved by @alice. New commits need a new approval.", f.Comments[2])
+
+	assert.NoError(t, plugins.RunCommands(agent, as("carol", "NONE"), "/test-perf"))
+	assert.NoError(t, plugins.RunCommands(agent, as("bob", "CONTRIBUTOR"), "/test-perf\n/test-perf"))
+	assert.Equal(t, []string{"bob"}, ran, "the approval is good for a single run of bob")
+
+	assert.NoError(t, plugins.RunCommands(agent, as("alice", "MEMBER"), "/ok-to-perf bob"))
+	f.Head = "def"
+	assert.NoError(t, plugins.RunCommands(agent, as("bob", "CONTRIBUTOR"), "/test-perf"))
+	assert.Equal(t, []string{"bob"}, ran, "new commits need a new approval")
+
+	entries, err := store.AuditLog(100)
+	assert.NoError(t, err)
+	var decisions []string
+	for _, e := range entries {
+		decisions = append(decisions, e.Decision+" "+e.Command+" "+e.User)
+	}
+	assert.Equal(t, []string{
+		"denied test-perf bob",
+		"approved ok-to-perf alice",
+		"denied test-perf bob",
+		"consumed test-perf bob",
+		"denied test-perf carol",
+		"approved ok-to-perf alice",
+		"denied ok-to-perf bob",
+		"denied test-perf bob",
+	}, decisions)
+}
@@ -0,0 +1,69 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package perfqueue
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/scheduler"
+
+	"github.com/rs/zerolog/log"
+)
+
+const pluginName = "perf-queue"
+
+func init() {
+	log.Info().Msgf("registed plugin: %s", pluginName)
+	plugins.RegisterCommand(plugins.Command{
+		Name:       pluginName,
+		Permission: plugins.Anyone,
+		Help:       "List the runs waiting for a runner.",
+		Handler:    handle,
+	})
+}
+
+// renderQueue returns the queue as a markdown table, the runs of pr are in bold.
+func renderQueue(entries []scheduler.Entry, pr string) string {
+	if len(entries) == 0 {
+		return "The run queue is empty."
+	}
+	var b strings.Builder
+	b.WriteString("| Position | Pull request | Commit | Action | State | Starts in |\n")
+	b.WriteString("|---:|---|---|---|---|---|\n")
+	for _, e := range entries {
+		position, eta := "", ""
+		if e.State == scheduler.Queued {
+			position, eta = strconv.Itoa(e.Position), "about "+e.ETA.Round(time.Minute).String()
+		}
+		sha := e.Run.SHA
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		cells := []string{position, e.Run.PR, sha, e.Run.DispatchName, e.State, eta}
+		for i, c := range cells {
+			if e.Run.PR == pr && c != "" {
+				cells[i] = "**" + c + "**"
+			}
+		}
+		row := strings.Join(cells, " | ")
+		b.WriteString("| " + row + " |\n")
+	}
+	return b.String()
+}
+
+// handle replies to /perf-queue with the runs waiting for a runner.
+func handle(agent *plugins.Agent, gc *githubcli.GithubClient, inv *plugins.Invocation) error {
+	if agent.Scheduler == nil {
+		return gc.PostComment("The run queue is not enabled, runs are dispatched right away.")
+	}
+	entries, err := agent.Scheduler.Queue()
+	if err != nil {
+		return err
+	}
+	return gc.PostComment(renderQueue(entries, strconv.Itoa(gc.Pr)))
+}
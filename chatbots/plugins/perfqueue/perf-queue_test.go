@@ -0,0 +1,33 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package perfqueue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/scheduler"
+)
+
+func TestRenderQueue(t *testing.T) {
+	entry := func(pr, sha, state string, position int, eta time.Duration) scheduler.Entry {
+		return scheduler.Entry{
+			Job:      scheduler.Job{Run: registry.Run{Key: registry.Key{PR: pr, SHA: sha}, DispatchName: "run-perf"}, State: state},
+			Position: position,
+			ETA:      eta,
+		}
+	}
+	queue := renderQueue([]scheduler.Entry{
+		entry("main", "0123456789", scheduler.Running, 0, 0),
+		entry("233", "abcdef0123", scheduler.Queued, 1, 20*time.Minute+10*time.Second),
+		entry("234", "foo", scheduler.Queued, 2, 65*time.Minute),
+	}, "233")
+	assert.Contains(t, queue, "|  | main | 0123456 | run-perf | running |  |\n")
+	assert.Contains(t, queue, "| **1** | **233** | **abcdef0** | **run-perf** | **queued** | **about 20m0s** |\n")
+	assert.Contains(t, queue, "| 2 | 234 | foo | run-perf | queued | about 1h5m0s |\n")
+	assert.Equal(t, "The run queue is empty.", renderQueue(nil, "233"))
+}
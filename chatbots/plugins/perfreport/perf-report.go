@@ -0,0 +1,134 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package perfreport
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/pkg/compare"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	pluginName = "perf-report"
+	// dispatchName is the run-perf repository dispatch whose results are reported.
+	dispatchName = "run-perf"
+	// statusName is the commit status set to pending when run-perf is dispatched.
+	statusName = "run-perf-status"
+	// commentMarker identifies the report comment so it is edited instead of posted again.
+	commentMarker = "<!-- test-infra:perf-report -->"
+	// topQueries is the number of regressions and improvements listed in the comment.
+	topQueries = 5
+)
+
+func init() {
+	log.Info().Msgf("registed plugin: %s", pluginName)
+	plugins.RegisterStatusHandler(pluginName, handleStatus)
+}
+
+// commitState maps the conclusion of a workflow to a commit status state.
+func commitState(conclusion string) string {
+	switch conclusion {
+	case "success":
+		return "success"
+	case "failure":
+		return "failure"
+	}
+	return "error"
+}
+
+func writeTable(b *strings.Builder, title string, queries []compare.QueryResult) {
+	fmt.Fprintf(b, "\n#### %s\n\n", title)
+	if len(queries) == 0 {
+		b.WriteString("None.\n")
+		return
+	}
+	b.WriteString("| Query | Current (s) | Reference (s) | Change |\n")
+	b.WriteString("|---|---:|---:|---:|\n")
+	for _, q := range queries {
+		fmt.Fprintf(b, "| %s | %.3f | %.3f | %+.1f%% |\n", q.Name, q.Current, q.Ref, q.Change*100)
+	}
+}
+
+// logLink is a link to a log of the run, omitted from the comment when url is empty.
+type logLink struct {
+	name string
+	url  string
+}
+
+// renderComment returns the report comment of the run, summary is nil when none was uploaded.
+func renderComment(run *registry.Run, summary *compare.Report, url string, logs []logLink) string {
+	var b strings.Builder
+	b.WriteString(commentMarker + "\n")
+	sha := run.SHA
+	if len(sha) > 7 {
+		sha = sha[:7]
+	}
+	fmt.Fprintf(&b, "### Performance report for %s\n\n", sha)
+	fmt.Fprintf(&b, "run-perf **%s**, current `%s` compared with reference `%s`.", run.Conclusion, run.Current, run.Ref)
+	if url != "" {
+		fmt.Fprintf(&b, " [Full report](%s)", url)
+	}
+	var links []string
+	for _, l := range logs {
+		if l.url != "" {
+			links = append(links, fmt.Sprintf("[%s](%s)", l.name, l.url))
+		}
+	}
+	if len(links) > 0 {
+		fmt.Fprintf(&b, " Logs: %s.", strings.Join(links, ", "))
+	}
+	b.WriteString("\n")
+	if summary == nil {
+		b.WriteString("\nNo comparison summary was uploaded for this run.\n")
+		return b.String()
+	}
+	fmt.Fprintf(&b, "\n%d regressions, %d improvements, %d unchanged queries.\n",
+		summary.Count(compare.Regression), summary.Count(compare.Improvement), summary.Count(compare.Noise))
+	writeTable(&b, "Top regressions", summary.Top(compare.Regression, topQueries))
+	writeTable(&b, "Top improvements", summary.Top(compare.Improvement, topQueries))
+	return b.String()
+}
+
+// handleStatus closes the loop of a run-perf run once it concluded:
+// the commit status left pending by run-perf is resolved and the report comment is posted or updated.
+func handleStatus(agent *plugins.Agent, run *registry.Run) error {
+	// branch runs have no pull request to report on, perf-watch and bisect-perf follow their runs
+	if run.DispatchName != dispatchName || run.Branch != "" || run.Bisection != "" || run.Status != "completed" || run.Conclusion == "" {
+		return nil
+	}
+	logger := log.With().Str("status", pluginName).Str("run", run.Key.String()).Logger()
+	pr, err := strconv.Atoi(run.PR)
+	if err != nil {
+		return fmt.Errorf("invalid pull request number %s, %v", run.PR, err)
+	}
+	gc := agent.GithubClient
+	if gc == nil {
+		gc, err = githubcli.NewGithubClientByRun(context.Background(), run.Org, run.Repo, pr, run.SHA, agent.Token)
+		if err != nil {
+			return err
+		}
+	}
+
+	url := agent.ReportURL(run)
+	err = gc.UpdateStatus(statusName, commitState(run.Conclusion), url)
+	if err != nil {
+		logger.Error().Msgf("cannot update status, %s", err.Error())
+		return err
+	}
+	summary, err := agent.LoadReport(run)
+	if err != nil {
+		logger.Warn().Msgf("no comparison summary, %s", err.Error())
+		summary = nil
+	}
+	logs := []logLink{{"current", agent.ArtifactURL(run, "current.log")}, {"reference", agent.ArtifactURL(run, "ref.log")}}
+	return gc.UpsertComment(commentMarker, renderComment(run, summary, url, logs))
+}
@@ -0,0 +1,122 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package perfreport
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/utils"
+	"datafuselabs/test-infra/pkg/compare"
+)
+
+func newFakeRun(status, conclusion string) *registry.Run {
+	return &registry.Run{
+		Key:          registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "0123456789abcdef", UUID: "1"},
+		DispatchName: dispatchName,
+		Current:      "0123456789abcdef",
+		Ref:          "main",
+		Compare:      "https://example.com/compare.html",
+		Status:       status,
+		Conclusion:   conclusion,
+	}
+}
+
+func TestRenderComment(t *testing.T) {
+	run := newFakeRun("completed", "success")
+	summary := &compare.Report{Queries: []compare.QueryResult{
+		{Name: "Q1", Current: 1.2, Ref: 1.0, Change: 0.2, Class: compare.Regression},
+		{Name: "Q2", Current: 0.5, Ref: 1.0, Change: -0.5, Class: compare.Improvement},
+		{Name: "Q3", Current: 1.0, Ref: 1.0, Change: 0.01, Class: compare.Noise},
+	}}
+	tests := []struct {
+		name     string
+		summary  *compare.Report
+		url      string
+		logs     []logLink
+		contains []string
+	}{
+		{
+			name:    "summary",
+			summary: summary,
+			url:     "https://perf.databend.rs/benchmark/datafuselabs/databend/233/0123456789abcdef",
+			logs:    []logLink{{"current", "https://perf.s3.amazonaws.com/current.log?X-Amz-Signature=1"}, {"reference", ""}},
+			contains: []string{
+				commentMarker,
+				"### Performance report for 0123456",
+				"[Full report](https://perf.databend.rs/benchmark/datafuselabs/databend/233/0123456789abcdef) Logs: [current](https://perf.s3.amazonaws.com/current.log?X-Amz-Signature=1).\n",
+				"| Q1 | 1.200 | 1.000 | +20.0% |",
+				"| Q2 | 0.500 | 1.000 | -50.0% |",
+				"1 regressions, 1 improvements, 1 unchanged queries.",
+			},
+		},
+		{
+			name:     "no summary",
+			contains: []string{commentMarker, "No comparison summary was uploaded"},
+		},
+		{
+			name:     "no changes",
+			summary:  &compare.Report{},
+			contains: []string{"#### Top regressions\n\nNone."},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comment := renderComment(run, tt.summary, tt.url, tt.logs)
+			for _, c := range tt.contains {
+				assert.Contains(t, comment, c)
+			}
+		})
+	}
+}
+
+func TestReportURL(t *testing.T) {
+	run := newFakeRun("completed", "success")
+	assert.Equal(t, run.Compare, (&plugins.Agent{}).ReportURL(run))
+	assert.Equal(t, "https://perf.databend.rs/benchmark/datafuselabs/databend/233/0123456789abcdef", (&plugins.Agent{DashboardURL: "https://perf.databend.rs/"}).ReportURL(run))
+	run.PR, run.Branch = "", "main"
+	assert.Equal(t, "https://perf.databend.rs/benchmark/datafuselabs/databend/branch:main/0123456789abcdef", (&plugins.Agent{DashboardURL: "https://perf.databend.rs"}).ReportURL(run), "a branch run")
+}
+
+// signingStorage is a storage serving its files from presigned urls.
+type signingStorage struct {
+	utils.FileStorage
+}
+
+func (s *signingStorage) SignedURL(ctx context.Context, key utils.ArtifactKey, ttl time.Duration) (string, error) {
+	return fmt.Sprintf("https://perf.s3.amazonaws.com/%s?X-Amz-Expires=%d", key.Path(), int(ttl.Seconds())), nil
+}
+
+func TestArtifactURL(t *testing.T) {
+	run := newFakeRun("completed", "success")
+	run.Artifacts = []registry.Artifact{{Path: "current.log"}}
+	assert.Empty(t, (&plugins.Agent{Store: &utils.FileStorage{}}).ArtifactURL(run, "current.log"), "not signed")
+	agent := &plugins.Agent{Store: &signingStorage{}}
+	assert.Equal(t, "https://perf.s3.amazonaws.com/datafuselabs/databend/233/0123456789abcdef/1/current.log?X-Amz-Expires=604800", agent.ArtifactURL(run, "current.log"))
+	assert.Empty(t, agent.ArtifactURL(run, "ref.log"), "not uploaded")
+}
+
+func TestCommitState(t *testing.T) {
+	for conclusion, state := range map[string]string{"success": "success", "failure": "failure", "cancelled": "error", "timed_out": "error"} {
+		assert.Equal(t, state, commitState(conclusion), conclusion)
+	}
+}
+
+func TestHandleStatus_ignored(t *testing.T) {
+	// updates which are not a concluded run-perf never reach github, the agent has no client.
+	agent := &plugins.Agent{}
+	build := newFakeRun("completed", "success")
+	build.DispatchName = "build-docker"
+	branch := newFakeRun("completed", "success")
+	branch.Branch = "main"
+	for _, run := range []*registry.Run{newFakeRun("in_progress", ""), newFakeRun("completed", ""), build, branch} {
+		assert.NoError(t, handleStatus(agent, run))
+	}
+}
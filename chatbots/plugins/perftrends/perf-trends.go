@@ -0,0 +1,59 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package perftrends
+
+import (
+	"regexp"
+
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/trends"
+	"datafuselabs/test-infra/pkg/compare"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	pluginName = "perf-trends"
+	// dispatchName is the run-perf repository dispatch whose results are recorded.
+	dispatchName = "run-perf"
+)
+
+var releaseTag = regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+\S*$`)
+
+func init() {
+	log.Info().Msgf("registed plugin: %s", pluginName)
+	plugins.RegisterStatusHandler(pluginName, handleStatus)
+}
+
+// points returns the trend points measured by a run: the current build of a branch run,
+// and the reference build when it is a tagged release.
+// Pull request builds and untagged references are not part of any series.
+func points(run *registry.Run, report *compare.Report) []trends.Point {
+	var res []trends.Point
+	if run.Branch != "" {
+		res = append(res, trends.FromReport(report, run.Branch, run.SHA, run.UUID, false, run.UpdatedAt)...)
+	}
+	if releaseTag.MatchString(run.Ref) {
+		res = append(res, trends.FromReport(report, trends.ReleasesBranch, run.Ref, run.UUID, true, run.UpdatedAt)...)
+	}
+	return res
+}
+
+// handleStatus records the query times of the successful run-perf runs in the trends store.
+func handleStatus(agent *plugins.Agent, run *registry.Run) error {
+	if run.DispatchName != dispatchName || run.Status != "completed" || run.Conclusion != "success" || agent.Trends == nil {
+		return nil
+	}
+	if run.Branch == "" && !releaseTag.MatchString(run.Ref) {
+		return nil
+	}
+	report, err := agent.LoadReport(run)
+	if err != nil {
+		return err
+	}
+	p := points(run, report)
+	log.Info().Msgf("recording %d trend points of run %s", len(p), run.Key.String())
+	return agent.Trends.Add(p...)
+}
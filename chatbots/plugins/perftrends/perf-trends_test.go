@@ -0,0 +1,50 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package perftrends
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/pkg/compare"
+)
+
+func Test_points(t *testing.T) {
+	report := &compare.Report{Queries: []compare.QueryResult{
+		{Name: "Q1", Current: 1.2, Ref: 1.0},
+		{Name: "Q2", Current: 0.5, Ref: 0.6},
+	}}
+	at := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	key := registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "1"}
+	tests := []struct {
+		name   string
+		branch string
+		ref    string
+		expect map[string]float64
+	}{
+		{name: "pull request against main", ref: "main", expect: map[string]float64{}},
+		{name: "pull request against a release", ref: "v0.4.0", expect: map[string]float64{"releases/Q1/v0.4.0": 1.0, "releases/Q2/v0.4.0": 0.6}},
+		{name: "main against main", branch: "main", ref: "main", expect: map[string]float64{"main/Q1/foo": 1.2, "main/Q2/foo": 0.5}},
+		{name: "main against a release", branch: "main", ref: "v0.4.0-nightly", expect: map[string]float64{
+			"main/Q1/foo": 1.2, "main/Q2/foo": 0.5, "releases/Q1/v0.4.0-nightly": 1.0, "releases/Q2/v0.4.0-nightly": 0.6,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			run := &registry.Run{Key: key, Ref: tt.ref, UpdatedAt: at}
+			if tt.branch != "" {
+				run.PR, run.Branch = "", tt.branch
+			}
+			got := map[string]float64{}
+			for _, p := range points(run, report) {
+				assert.Equal(t, at, p.Time)
+				got[p.Branch+"/"+p.Query+"/"+p.SHA] = p.Median
+			}
+			assert.Equal(t, tt.expect, got)
+		})
+	}
+}
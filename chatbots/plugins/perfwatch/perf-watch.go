@@ -0,0 +1,396 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package perfwatch
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/trends"
+	"datafuselabs/test-infra/pkg/compare"
+
+	"github.com/google/go-github/v35/github"
+	guuid "github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	pluginName = "perf-watch"
+	// dispatchName is the recorded name of the run-perf runs, eventType the repository dispatch starting them
+	// unless the repository configures another one for run-perf.
+	dispatchName = "run-perf"
+	eventType    = "run_perf"
+	// issueLabel is set on the regression issues so the open ones are listed cheaply.
+	issueLabel = "perf-regression"
+	// baselineRuns is the number of previous branch runs a run is compared with,
+	// a query measured by less than minBaselineRuns of them is not checked.
+	baselineRuns    = 10
+	minBaselineRuns = 3
+	// maxSuspects is the number of suspect commits listed in the issue.
+	maxSuspects = 50
+)
+
+func init() {
+	log.Info().Msgf("registed plugin: %s", pluginName)
+	plugins.RegisterPushHandler(pluginName, handlePush)
+	plugins.RegisterStatusHandler(pluginName, handleStatus)
+}
+
+// issueMarker identifies the regression issue of a branch so it is updated instead of filed again.
+func issueMarker(branch string) string {
+	return fmt.Sprintf("<!-- test-infra:perf-regression:%s -->", branch)
+}
+
+// statePrefix starts the marker recording what the regression issue and its updates reported,
+// a later run only comments when it changed.
+const statePrefix = "<!-- test-infra:perf-regression-state:"
+
+// regressionState is what was reported on a regression issue: the regressing queries, sorted,
+// and the last good commit starting the suspect range of their regressions.
+type regressionState struct {
+	Since   string
+	Queries []string
+}
+
+func (s regressionState) marker() string {
+	return statePrefix + s.Since + ":" + strings.Join(s.Queries, ",") + " -->"
+}
+
+// parseState returns the state of the last marker found in bodies, oldest first.
+func parseState(bodies ...string) regressionState {
+	var state regressionState
+	for _, body := range bodies {
+		i := strings.LastIndex(body, statePrefix)
+		if i < 0 {
+			continue
+		}
+		rest := body[i+len(statePrefix):]
+		end := strings.Index(rest, " -->")
+		if end < 0 {
+			continue
+		}
+		fields := strings.SplitN(rest[:end], ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		state = regressionState{Since: fields[0]}
+		if fields[1] != "" {
+			state.Queries = strings.Split(fields[1], ",")
+		}
+	}
+	return state
+}
+
+// regressingQueries returns the sorted names of the regressing queries of res.
+func regressingQueries(res *compare.Report) []string {
+	var queries []string
+	for _, q := range res.Top(compare.Regression, len(res.Queries)) {
+		queries = append(queries, q.Name)
+	}
+	sort.Strings(queries)
+	return queries
+}
+
+// nextState returns the state reported after a run regressing queries, since is the last good commit
+// before the run: the suspect range of the recorded state is kept unless a query starts regressing.
+func nextState(recorded regressionState, queries []string, since string) regressionState {
+	known := map[string]bool{}
+	for _, q := range recorded.Queries {
+		known[q] = true
+	}
+	state := regressionState{Since: recorded.Since, Queries: queries}
+	for _, q := range queries {
+		if !known[q] {
+			state.Since = since
+			break
+		}
+	}
+	return state
+}
+
+// watchedBranch returns the branch of a push to the default branch of the repository, or "" for any other push.
+func watchedBranch(e *github.PushEvent) string {
+	branch := e.GetRepo().GetDefaultBranch()
+	if branch == "" || e.GetDeleted() || e.GetHeadCommit().GetID() == "" || e.GetRef() != "refs/heads/"+branch {
+		return ""
+	}
+	return branch
+}
+
+// lastRun returns the most recent run-perf run among runs, most recent first, or nil.
+// With succeeded set, only a successful run is returned.
+func lastRun(runs []registry.Run, succeeded bool, before time.Time) *registry.Run {
+	for i := range runs {
+		r := &runs[i]
+		if r.DispatchName != dispatchName || (!before.IsZero() && !r.CreatedAt.Before(before)) {
+			continue
+		}
+		if succeeded && (r.Status != "completed" || r.Conclusion != "success") {
+			continue
+		}
+		return r
+	}
+	return nil
+}
+
+// handlePush dispatches run-perf on the head of the default branch once batch commits were pushed
+// since the last measured one.
+func handlePush(agent *plugins.Agent, e *github.PushEvent) error {
+	branch := watchedBranch(e)
+	gc := agent.GithubClient
+	if branch == "" || agent.PerfWatchBatch <= 0 || gc == nil {
+		return nil
+	}
+	logger := log.With().Str("push", pluginName).Str("branch", branch).Logger()
+	head := e.GetHeadCommit().GetID()
+	runs, err := agent.Registry.ListByBranch(gc.Owner, gc.Repo, branch)
+	if err != nil {
+		return err
+	}
+	if last := lastRun(runs, false, time.Time{}); last != nil {
+		cmp, err := gc.CompareCommits(last.SHA, head)
+		if err != nil {
+			return err
+		}
+		if cmp.GetTotalCommits() < agent.PerfWatchBatch {
+			logger.Info().Msgf("%d commits since %s, waiting for %d", cmp.GetTotalCommits(), last.SHA, agent.PerfWatchBatch)
+			return nil
+		}
+	}
+	return dispatch(agent, gc, branch, head)
+}
+
+// dispatch starts run-perf on a commit of the branch, compared with the latest release.
+func dispatch(agent *plugins.Agent, gc *githubcli.GithubClient, branch, sha string) error {
+	ref, err := gc.GetLatestTag()
+	if err != nil {
+		return err
+	}
+	id := guuid.New().String()
+	run := registry.Run{
+		Key: registry.Key{
+			Org:    gc.Owner,
+			Repo:   gc.Repo,
+			Branch: branch,
+			SHA:    sha,
+			UUID:   id,
+		},
+		DispatchName: dispatchName,
+		Current:      sha,
+		Ref:          ref,
+		StartTime:    strconv.Itoa(int(time.Now().Unix())),
+		Status:       "queued",
+	}
+	// the pusher is not the author of the commits, the run has none
+	err = agent.DispatchRun(gc, agent.RepoConfig.EventType(dispatchName, eventType), run, nil)
+	if err != nil {
+		return err
+	}
+	log.Info().Msgf("dispatched run-perf %s on %s, reference %s", id, sha, ref)
+	return nil
+}
+
+// detect compares the current build of a branch run with the baseline of the previous runs,
+// it returns nil when no query has enough history to be checked.
+func detect(report *compare.Report, baseline []compare.QueryRun) (*compare.Report, error) {
+	var checked []compare.QueryRun
+	for _, q := range baseline {
+		if len(q.Times) >= minBaselineRuns {
+			checked = append(checked, q)
+		}
+	}
+	if len(checked) == 0 {
+		return nil, nil
+	}
+	return trends.Compare(report, checked)
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
+
+func writeRegressions(b *strings.Builder, res *compare.Report) {
+	b.WriteString("| Query | Current (s) | Baseline (s) | Change |\n")
+	b.WriteString("|---|---:|---:|---:|\n")
+	for _, q := range res.Top(compare.Regression, len(res.Queries)) {
+		fmt.Fprintf(b, "| %s | %.3f | %.3f | %+.1f%% |\n", q.Name, q.Current, q.Ref, q.Change*100)
+	}
+}
+
+// renderIssue returns the body of the issue filed when run regressed, prev is the last good run if any.
+func renderIssue(run, prev *registry.Run, commits []*github.RepositoryCommit, res *compare.Report, url string) string {
+	var b strings.Builder
+	b.WriteString(issueMarker(run.Branch) + "\n")
+	state := regressionState{Queries: regressingQueries(res)}
+	if prev != nil {
+		state.Since = prev.SHA
+	}
+	b.WriteString(state.marker() + "\n")
+	fmt.Fprintf(&b, "%d queries regressed on `%s` at %s compared with the median of the previous %d runs.",
+		res.Count(compare.Regression), run.Branch, run.SHA, baselineRuns)
+	if url != "" {
+		fmt.Fprintf(&b, " [Full report](%s)", url)
+	}
+	b.WriteString("\n\n")
+	writeRegressions(&b, res)
+	b.WriteString("\n#### Suspect commits\n\n")
+	if prev == nil {
+		b.WriteString("No previous run, every commit up to " + run.SHA + " is a suspect.\n")
+		return b.String()
+	}
+	writeSuspects(&b, run, prev)
+	b.WriteString("\n")
+	for i, c := range commits {
+		if i == maxSuspects {
+			fmt.Fprintf(&b, "- and %d more\n", len(commits)-maxSuspects)
+			break
+		}
+		message := strings.SplitN(c.GetCommit().GetMessage(), "\n", 2)[0]
+		fmt.Fprintf(&b, "- %s %s (@%s)\n", shortSHA(c.GetSHA()), message, c.GetAuthor().GetLogin())
+	}
+	return b.String()
+}
+
+func writeSuspects(b *strings.Builder, run, prev *registry.Run) {
+	fmt.Fprintf(b, "Between the last good run at %s and %s: https://github.com/%s/%s/compare/%s...%s\n",
+		shortSHA(prev.SHA), shortSHA(run.SHA), run.Org, run.Repo, prev.SHA, run.SHA)
+}
+
+// renderUpdate returns the comment posted on the open issue by a later run, state is what it reports
+// and prev the last good run when queries started regressing since the issue or the last update.
+func renderUpdate(run, prev *registry.Run, res *compare.Report, url string, state regressionState) string {
+	var b strings.Builder
+	if res.Count(compare.Regression) == 0 {
+		fmt.Fprintf(&b, "Performance recovered at %s, closing.", run.SHA)
+	} else {
+		b.WriteString(state.marker() + "\n")
+		fmt.Fprintf(&b, "The regressed queries changed at %s.", run.SHA)
+	}
+	if url != "" {
+		fmt.Fprintf(&b, " [Full report](%s)", url)
+	}
+	b.WriteString("\n")
+	if res.Count(compare.Regression) > 0 {
+		b.WriteString("\n")
+		writeRegressions(&b, res)
+		if prev != nil {
+			b.WriteString("\n#### Suspect commits of the new regressions\n\n")
+			writeSuspects(&b, run, prev)
+		}
+	}
+	return b.String()
+}
+
+// updateIssue comments on the open regression issue when the regressing queries or the suspect range
+// changed since the last report, and closes it once the run recovered.
+func updateIssue(agent *plugins.Agent, gc *githubcli.GithubClient, issue *github.Issue, run *registry.Run, res *compare.Report, url string) error {
+	logger := log.With().Str("status", pluginName).Str("run", run.Key.String()).Logger()
+	number := issue.GetNumber()
+	if res.Count(compare.Regression) == 0 {
+		logger.Info().Msgf("closing regression issue #%d", number)
+		if err := gc.CommentIssue(number, renderUpdate(run, nil, res, url, regressionState{})); err != nil {
+			return err
+		}
+		return gc.CloseIssue(number)
+	}
+	comments, err := gc.ListIssueComments(number)
+	if err != nil {
+		return err
+	}
+	bodies := []string{issue.GetBody()}
+	for _, c := range comments {
+		bodies = append(bodies, c.GetBody())
+	}
+	recorded := parseState(bodies...)
+	runs, err := agent.Registry.ListByBranch(run.Org, run.Repo, run.Branch)
+	if err != nil {
+		return err
+	}
+	var since string
+	prev := lastRun(runs, true, run.CreatedAt)
+	if prev != nil {
+		since = prev.SHA
+	}
+	state := nextState(recorded, regressingQueries(res), since)
+	if state.marker() == recorded.marker() {
+		logger.Info().Msgf("regression issue #%d is unchanged", number)
+		return nil
+	}
+	if state.Since == recorded.Since {
+		// the suspect range already reported is kept
+		prev = nil
+	}
+	logger.Info().Msgf("updating regression issue #%d", number)
+	return gc.CommentIssue(number, renderUpdate(run, prev, res, url, state))
+}
+
+// handleStatus checks the successful branch runs against the baseline of the branch:
+// a regression files an issue listing the suspect commits, or comments on the open one,
+// and the issue is closed once a run is back to the baseline.
+func handleStatus(agent *plugins.Agent, run *registry.Run) error {
+	if run.DispatchName != dispatchName || run.Branch == "" || run.Status != "completed" || run.Conclusion != "success" || agent.Trends == nil {
+		return nil
+	}
+	logger := log.With().Str("status", pluginName).Str("run", run.Key.String()).Logger()
+	report, err := agent.LoadReport(run)
+	if err != nil {
+		return err
+	}
+	baseline, err := agent.Trends.BaselineBefore(run.Branch, run.UpdatedAt, baselineRuns)
+	if err != nil {
+		return err
+	}
+	res, err := detect(report, baseline)
+	if err != nil || res == nil {
+		return err
+	}
+	gc := agent.GithubClient
+	if gc == nil {
+		gc, err = githubcli.NewGithubClientByRun(context.Background(), run.Org, run.Repo, 0, run.SHA, agent.Token)
+		if err != nil {
+			return err
+		}
+	}
+	issue, err := gc.FindOpenIssue(issueLabel, issueMarker(run.Branch))
+	if err != nil {
+		return err
+	}
+	url := agent.ReportURL(run)
+	regressed := res.Count(compare.Regression) > 0
+	switch {
+	case issue != nil:
+		return updateIssue(agent, gc, issue, run, res, url)
+	case regressed:
+		runs, err := agent.Registry.ListByBranch(run.Org, run.Repo, run.Branch)
+		if err != nil {
+			return err
+		}
+		var commits []*github.RepositoryCommit
+		prev := lastRun(runs, true, run.CreatedAt)
+		if prev != nil {
+			cmp, err := gc.CompareCommits(prev.SHA, run.SHA)
+			if err != nil {
+				return err
+			}
+			commits = cmp.Commits
+		}
+		title := fmt.Sprintf("Performance regression on %s at %s", run.Branch, shortSHA(run.SHA))
+		created, err := gc.CreateIssue(title, renderIssue(run, prev, commits, res, url), []string{issueLabel})
+		if err != nil {
+			return err
+		}
+		logger.Info().Msgf("filed regression issue #%d", created.GetNumber())
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package perfwatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/stretchr/testify/assert"
+
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/pkg/compare"
+)
+
+func newFakeRun(sha, status, conclusion string, created time.Time) registry.Run {
+	return registry.Run{
+		Key:          registry.Key{Org: "datafuselabs", Repo: "databend", Branch: "main", SHA: sha, UUID: sha},
+		DispatchName: dispatchName,
+		Status:       status,
+		Conclusion:   conclusion,
+		CreatedAt:    created,
+	}
+}
+
+func TestWatchedBranch(t *testing.T) {
+	push := func(ref string, deleted bool) *github.PushEvent {
+		return &github.PushEvent{
+			Ref:        github.String(ref),
+			Deleted:    github.Bool(deleted),
+			HeadCommit: &github.HeadCommit{ID: github.String("abc")},
+			Repo:       &github.PushEventRepository{DefaultBranch: github.String("main")},
+		}
+	}
+	assert.Equal(t, "main", watchedBranch(push("refs/heads/main", false)))
+	assert.Equal(t, "", watchedBranch(push("refs/heads/feature", false)))
+	assert.Equal(t, "", watchedBranch(push("refs/tags/v0.4.0", false)))
+	assert.Equal(t, "", watchedBranch(push("refs/heads/main", true)))
+	assert.Equal(t, "", watchedBranch(&github.PushEvent{Ref: github.String("refs/heads/main")}))
+}
+
+func TestLastRun(t *testing.T) {
+	start := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	build := newFakeRun("x", "completed", "success", start.Add(4*time.Hour))
+	build.DispatchName = "build-docker"
+	// most recent first, as listed by the registry
+	runs := []registry.Run{
+		build,
+		newFakeRun("d", "queued", "", start.Add(3*time.Hour)),
+		newFakeRun("c", "completed", "failure", start.Add(2*time.Hour)),
+		newFakeRun("b", "completed", "success", start.Add(time.Hour)),
+		newFakeRun("a", "completed", "success", start),
+	}
+	tests := []struct {
+		name      string
+		succeeded bool
+		before    time.Time
+		expect    string
+	}{
+		{name: "any", expect: "d"},
+		{name: "succeeded", succeeded: true, expect: "b"},
+		{name: "succeeded before", succeeded: true, before: start.Add(time.Hour), expect: "a"},
+		{name: "none", succeeded: true, before: start, expect: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sha string
+			if r := lastRun(runs, tt.succeeded, tt.before); r != nil {
+				sha = r.SHA
+			}
+			assert.Equal(t, tt.expect, sha)
+		})
+	}
+}
+
+func TestDetect(t *testing.T) {
+	report := &compare.Report{Options: compare.DefaultOptions(), Queries: []compare.QueryResult{
+		{Name: "Q1", CurrentTimes: []float64{2, 2.1, 2.05}},
+		{Name: "Q2", CurrentTimes: []float64{1, 1.01, 0.99}},
+		{Name: "Q3", CurrentTimes: []float64{5, 5, 5}},
+	}}
+	res, err := detect(report, []compare.QueryRun{{Name: "Q1", Times: []float64{1}}})
+	assert.NoError(t, err)
+	assert.Nil(t, res, "a single previous run is not a baseline")
+
+	res, err = detect(report, []compare.QueryRun{
+		{Name: "Q1", Times: []float64{1, 1.02, 0.98, 1.01}},
+		{Name: "Q2", Times: []float64{1, 1.02, 0.98, 1.01}},
+		{Name: "Q3", Times: []float64{1}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, res.Count(compare.Regression))
+	assert.Equal(t, "Q1", res.Top(compare.Regression, 1)[0].Name)
+	assert.Equal(t, []string{"Q3"}, res.Missing, "queries without enough history are not checked")
+}
+
+func TestRenderIssue(t *testing.T) {
+	run := newFakeRun("0123456789abcdef", "completed", "success", time.Time{})
+	prev := newFakeRun("fedcba9876543210", "completed", "success", time.Time{})
+	res := &compare.Report{Queries: []compare.QueryResult{
+		{Name: "Q1", Current: 2, Ref: 1, Change: 1, Class: compare.Regression},
+		{Name: "Q2", Current: 1, Ref: 1, Class: compare.Noise},
+	}}
+	commits := []*github.RepositoryCommit{{
+		SHA:    github.String("1111111222222"),
+		Commit: &github.Commit{Message: github.String("Slow down the planner\n\nlong description")},
+		Author: &github.User{Login: github.String("alice")},
+	}}
+	body := renderIssue(&run, &prev, commits, res, "https://perf.databend.rs/benchmark/datafuselabs/databend/branch:main/0123456789abcdef")
+	for _, c := range []string{
+		issueMarker("main"),
+		"<!-- test-infra:perf-regression-state:fedcba9876543210:Q1 -->",
+		"1 queries regressed on `main` at 0123456789abcdef",
+		"[Full report](https://perf.databend.rs/benchmark/datafuselabs/databend/branch:main/0123456789abcdef)",
+		"| Q1 | 2.000 | 1.000 | +100.0% |",
+		"https://github.com/datafuselabs/databend/compare/fedcba9876543210...0123456789abcdef",
+		"- 1111111 Slow down the planner (@alice)",
+	} {
+		assert.Contains(t, body, c)
+	}
+	assert.NotContains(t, body, "Q2")
+	assert.Contains(t, renderIssue(&run, nil, nil, res, ""), "No previous run")
+
+	update := renderUpdate(&run, &prev, res, "", regressionState{Since: prev.SHA, Queries: []string{"Q1"}})
+	assert.Contains(t, update, "The regressed queries changed at 0123456789abcdef.")
+	assert.Contains(t, update, "<!-- test-infra:perf-regression-state:fedcba9876543210:Q1 -->")
+	assert.Contains(t, update, "https://github.com/datafuselabs/databend/compare/fedcba9876543210...0123456789abcdef")
+	assert.NotContains(t, renderUpdate(&run, nil, res, "", regressionState{}), "Suspect commits")
+	assert.Contains(t, renderUpdate(&run, nil, &compare.Report{}, "", regressionState{}), "Performance recovered at 0123456789abcdef, closing.")
+}
+
+func TestRegressionState(t *testing.T) {
+	issue := "<!-- test-infra:perf-regression:main -->\n" + regressionState{Since: "a", Queries: []string{"Q1", "Q2"}}.marker() + "\nbody"
+	update := regressionState{Since: "a", Queries: []string{"Q1"}}.marker() + "\ncomment"
+	assert.Equal(t, regressionState{Since: "a", Queries: []string{"Q1", "Q2"}}, parseState(issue))
+	assert.Equal(t, regressionState{Since: "a", Queries: []string{"Q1"}}, parseState(issue, update, "an unrelated comment"))
+	assert.Equal(t, regressionState{}, parseState("no marker"))
+
+	recorded := regressionState{Since: "a", Queries: []string{"Q1", "Q2"}}
+	tests := []struct {
+		name    string
+		queries []string
+		expect  regressionState
+		changed bool
+	}{
+		{name: "unchanged", queries: []string{"Q1", "Q2"}, expect: recorded},
+		{name: "a query recovered", queries: []string{"Q2"}, expect: regressionState{Since: "a", Queries: []string{"Q2"}}, changed: true},
+		{name: "a query regressed", queries: []string{"Q1", "Q2", "Q3"}, expect: regressionState{Since: "c", Queries: []string{"Q1", "Q2", "Q3"}}, changed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := nextState(recorded, tt.queries, "c")
+			assert.Equal(t, tt.expect, state)
+			assert.Equal(t, tt.changed, state.marker() != recorded.marker())
+		})
+	}
+}
+
+func TestHandleStatus_ignored(t *testing.T) {
+	// only the successful branch runs are checked, the agent has no trends store nor client.
+	agent := &plugins.Agent{}
+	pr := newFakeRun("a", "completed", "success", time.Time{})
+	pr.Branch, pr.PR = "", "1"
+	failed := newFakeRun("a", "completed", "failure", time.Time{})
+	branch := newFakeRun("a", "completed", "success", time.Time{})
+	for _, run := range []registry.Run{pr, failed, branch} {
+		assert.NoError(t, handleStatus(agent, &run))
+	}
+}
+
+func TestHandlePush_ignored(t *testing.T) {
+	e := &github.PushEvent{
+		Ref:        github.String("refs/heads/main"),
+		HeadCommit: &github.HeadCommit{ID: github.String("abc")},
+		Repo:       &github.PushEventRepository{DefaultBranch: github.String("main")},
+	}
+	// the watch is disabled without batch size
+	assert.NoError(t, handlePush(&plugins.Agent{}, e))
+}
@@ -4,27 +4,64 @@
 package plugins
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
 	"github.com/google/go-github/v35/github"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
+	"datafuselabs/test-infra/chatbots/bisect"
 	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/policy"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/repoconfig"
+	"datafuselabs/test-infra/chatbots/scheduler"
+	"datafuselabs/test-infra/chatbots/trends"
 	"datafuselabs/test-infra/chatbots/utils"
+	"datafuselabs/test-infra/pkg/compare"
 )
 
 var (
 	IssueCommentHandlers = map[string]IssueCommentHandler{}
 	PushHandlers         = map[string]PushHandler{}
+	PullRequestHandlers  = map[string]PullRequestHandler{}
+	StatusHandlers       = map[string]StatusHandler{}
 )
 
 type Agent struct {
 	GithubClient *githubcli.GithubClient
 	Logger       zerolog.Logger
-	Store        *utils.StorageInterface
+	Store        utils.StorageInterface
 	Region       string
 	Bucket       string
 	Endpoint     string
-	Token        string
+	// StorageType is the type of the bucket given to the perf workflows, COS or S3.
+	StorageType string
+	Token       string
+	Registry    registry.Registry
+	Signer      *registry.Signer
+	Trends      *trends.Store
+	Bisections  *bisect.Store
+	Scheduler   *scheduler.Scheduler
+	// Policy overrides the permissions declared by the commands, PolicyStore keeps the approvals and the audit log.
+	Policy      *policy.Policy
+	PolicyStore *policy.Store
+	// RepoConfig is the configuration of the repository of the event, nil for the defaults.
+	RepoConfig *repoconfig.Config
+	// DisabledPlugins are disabled on every repository.
+	DisabledPlugins map[string]bool
+	// Limiter limits the commands run by each user, nil for no limit.
+	Limiter *RateLimiter
+	// DashboardURL is the public url of the chatbot dashboard, used to link reports.
+	DashboardURL string
+	// PerfWatchBatch is the number of default branch commits measured by a single run-perf run,
+	// 0 disables the runs on push.
+	PerfWatchBatch int
 }
 
 // IssueCommentHandler defines the function contract for a github.IssueCommentEvent handler.
@@ -33,7 +70,14 @@ type IssueCommentHandler func(*Agent, *github.IssueCommentEvent) error
 // PushHandler defines the function contract for a github.IssueCommentEvent handler.
 type PushHandler func(*Agent, *github.PushEvent) error
 
-func NewAgent(gitClient *githubcli.GithubClient, region, bucket, endpoint, token string) *Agent {
+// PullRequestHandler defines the function contract for a github.PullRequestEvent handler.
+type PullRequestHandler func(*Agent, *github.PullRequestEvent) error
+
+// StatusHandler defines the function contract for a handler of the run status updates reported by the workflows.
+// The run holds the recorded state after the update.
+type StatusHandler func(*Agent, *registry.Run) error
+
+func NewAgent(gitClient *githubcli.GithubClient, region, bucket, endpoint, token string, runRegistry registry.Registry, signer *registry.Signer) *Agent {
 	return &Agent{
 		GithubClient: gitClient,
 		Logger:       log.With().Str("test-infra", "agent").Logger(),
@@ -41,8 +85,205 @@ func NewAgent(gitClient *githubcli.GithubClient, region, bucket, endpoint, token
 		Bucket:       bucket,
 		Endpoint:     endpoint,
 		Token:        token,
+		Registry:     runRegistry,
+		Signer:       signer,
+	}
+}
+
+// Enabled returns whether the plugin runs, it may be disabled globally or by the repository configuration.
+func (a *Agent) Enabled(plugin string) bool {
+	return !a.DisabledPlugins[plugin] && a.RepoConfig.Enabled(plugin)
+}
+
+// ReportFile is the JSON comparison report written by infra compare, uploaded next to compare.html.
+const ReportFile = "compare.json"
+
+// LoadReport reads the comparison report uploaded by the run.
+func (a *Agent) LoadReport(run *registry.Run) (*compare.Report, error) {
+	if a.Store == nil {
+		return nil, fmt.Errorf("storage is not configured")
+	}
+	r, err := a.Store.Get(context.Background(), run.Key.Artifact(ReportFile))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return compare.ReadJSON(r)
+}
+
+// ArtifactLinkTTL is the validity of the artifact links posted on github.
+const ArtifactLinkTTL = 7 * 24 * time.Hour
+
+// ArtifactURL returns a presigned url of an artifact uploaded by the run,
+// empty unless the storage serves its files from presigned urls.
+func (a *Agent) ArtifactURL(run *registry.Run, name string) string {
+	if _, uploaded := run.Artifact(name); a.Store == nil || !uploaded {
+		return ""
+	}
+	u, err := a.Store.SignedURL(context.Background(), run.Key.Artifact(name), ArtifactLinkTTL)
+	if errors.Is(err, utils.ErrSignedURLNotSupported) {
+		return ""
+	}
+	if err != nil {
+		a.Logger.Error().Msgf("cannot sign the url of %s of run %s, %s", name, run.Key.String(), err.Error())
+		return ""
 	}
+	return u
 }
+
+// ReportURL returns the dashboard page of the run, or the report url sent by the workflow.
+func (a *Agent) ReportURL(run *registry.Run) string {
+	if a.DashboardURL == "" {
+		return run.Compare
+	}
+	return strings.TrimRight(a.DashboardURL, "/") + strings.Join([]string{"/benchmark", run.Org, run.Repo, run.Scope(), run.SHA}, "/")
+}
+
+// RegisterRun records a run about to be started with Dispatch.
+func (a *Agent) RegisterRun(run registry.Run) error {
+	if a.Registry == nil || a.Signer == nil {
+		return fmt.Errorf("run registry is not configured")
+	}
+	_, err := a.Registry.Record(run)
+	return err
+}
+
+// DispatchRun registers the run and starts its workflow with the repository dispatch eventType.
+// With a scheduler the run is queued instead, superseding the queued run of the same pull request,
+// and started once a runner is free. extra adds to the payloads describing the run to the workflow.
+func (a *Agent) DispatchRun(gc *githubcli.GithubClient, eventType string, run registry.Run, extra map[string]string) error {
+	if a.Registry == nil || a.Signer == nil {
+		return fmt.Errorf("run registry is not configured")
+	}
+	if _, err := a.Registry.Record(run); err != nil {
+		return err
+	}
+	if a.Scheduler == nil {
+		return a.StartRun(gc, eventType, run, extra)
+	}
+	superseded, err := a.Scheduler.Enqueue(scheduler.NewJob(eventType, run, extra))
+	for _, j := range superseded {
+		a.Logger.Info().Msgf("run %s is superseded by %s", j.Run.Key.String(), run.Key.String())
+		j.Run.Status, j.Run.Conclusion = "completed", "cancelled"
+		if _, rerr := a.Registry.Record(j.Run); rerr != nil {
+			a.Logger.Error().Msgf("cannot record superseded run %s, %s", j.Run.Key.String(), rerr.Error())
+		}
+		a.errorStatus(gc, j.Run, "superseded by a newer run")
+	}
+	return err
+}
+
+// ExpireRun records the run of a job timed out by the scheduler as failed and sets its commit status to error.
+// A run which completed meanwhile is left as is.
+func (a *Agent) ExpireRun(gc *githubcli.GithubClient, run registry.Run) (*registry.Run, error) {
+	recorded, err := a.Registry.Get(run.Key)
+	if err != nil {
+		return nil, err
+	}
+	if recorded.Status == "completed" {
+		return recorded, nil
+	}
+	recorded, err = a.Registry.Record(registry.Run{Key: run.Key, Status: "completed", Conclusion: "failure"})
+	if err != nil {
+		return nil, err
+	}
+	a.errorStatus(gc, *recorded, "the run timed out")
+	return recorded, nil
+}
+
+// RunPerfDispatch is the recorded dispatch name of the run-perf runs, RunPerfStatus the commit status
+// tracking them on the pull requests.
+const (
+	RunPerfDispatch = "run-perf"
+	RunPerfStatus   = "run-perf-status"
+)
+
+// errorStatus sets the commit status of a pull request run which will never report to error, with the reason
+// as its description. The status of a run is named after its dispatch name, e.g. RunPerfStatus.
+func (a *Agent) errorStatus(gc *githubcli.GithubClient, run registry.Run, reason string) {
+	if run.PR == "" || run.Bisection != "" {
+		return
+	}
+	status := *gc
+	status.LastSHA = run.SHA
+	if err := status.UpdateStatusDescription(run.DispatchName+"-status", "error", a.ReportURL(&run), reason); err != nil {
+		a.Logger.Error().Msgf("cannot set the status of run %s, %s", run.Key.String(), err.Error())
+	}
+}
+
+// StartRun starts the workflow of a registered run-perf run, extra adds to its payloads, see Dispatch.
+func (a *Agent) StartRun(gc *githubcli.GithubClient, eventType string, run registry.Run, extra map[string]string) error {
+	payloads := map[string]interface{}{
+		"CURRENT_BRANCH": run.Current,
+		"REF_BRANCH":     run.Ref,
+		// the bucket is a single property, github accepts at most 10 top-level properties in a client payload
+		"STORAGE": map[string]string{
+			"TYPE":     a.StorageType,
+			"REGION":   a.Region,
+			"BUCKET":   a.Bucket,
+			"ENDPOINT": a.Endpoint,
+			"PREFIX":   run.Key.Artifact("").Path(),
+		},
+	}
+	for k, v := range extra {
+		payloads[k] = v
+	}
+	return a.Dispatch(gc, eventType, run, payloads)
+}
+
+// Dispatch mints the callback token of a registered run and starts its workflow with the repository
+// dispatch eventType. The key of the run is sent along with the payloads: PR_NUMBER or BRANCH,
+// LAST_COMMIT_SHA, UUID and TOKEN. A failed dispatch is recorded as a failed run and its commit status set to error.
+func (a *Agent) Dispatch(gc *githubcli.GithubClient, eventType string, run registry.Run, payloads map[string]interface{}) error {
+	if a.Signer == nil {
+		return fmt.Errorf("run registry is not configured")
+	}
+	if run.Branch != "" {
+		payloads["BRANCH"] = run.Branch
+	} else {
+		payloads["PR_NUMBER"] = run.PR
+	}
+	payloads["LAST_COMMIT_SHA"] = run.SHA
+	payloads["UUID"] = run.UUID
+	payloads["TOKEN"] = a.Signer.Mint(run.Key)
+	err := gc.CreateRepositoryDispatch(eventType, payloads)
+	if err != nil {
+		run.Status, run.Conclusion = "completed", "failure"
+		if _, rerr := a.Registry.Record(run); rerr != nil {
+			a.Logger.Error().Msgf("cannot record failed dispatch of run %s, %s", run.Key.String(), rerr.Error())
+		}
+		a.errorStatus(gc, run, "cannot dispatch the run")
+		return err
+	}
+	return nil
+}
+
+// PluginNames returns the sorted names of the registered handlers and of the plugins of the commands.
+func PluginNames() []string {
+	known := map[string]bool{}
+	for name := range IssueCommentHandlers {
+		known[name] = true
+	}
+	for name := range PushHandlers {
+		known[name] = true
+	}
+	for name := range PullRequestHandlers {
+		known[name] = true
+	}
+	for name := range StatusHandlers {
+		known[name] = true
+	}
+	for _, cmd := range Commands {
+		known[cmd.PluginName()] = true
+	}
+	var names []string
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func RegisterIssueCommentHandler(name string, fn IssueCommentHandler) {
 	IssueCommentHandlers[name] = fn
 }
@@ -50,3 +291,11 @@ func RegisterIssueCommentHandler(name string, fn IssueCommentHandler) {
 func RegisterPushHandler(name string, fn PushHandler) {
 	PushHandlers[name] = fn
 }
+
+func RegisterPullRequestHandler(name string, fn PullRequestHandler) {
+	PullRequestHandlers[name] = fn
+}
+
+func RegisterStatusHandler(name string, fn StatusHandler) {
+	StatusHandlers[name] = fn
+}
@@ -0,0 +1,166 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package plugins
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/stretchr/testify/assert"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/scheduler"
+)
+
+// fakeDispatchGithub records the uuids and the client payloads of the repository dispatches and
+// the commit statuses by sha, the dispatches fail with failing set.
+type fakeDispatchGithub struct {
+	mu         sync.Mutex
+	failing    bool
+	dispatches []string
+	payloads   []map[string]json.RawMessage
+	statuses   map[string]string
+}
+
+func newFakeDispatchAgent(t *testing.T) (*fakeDispatchGithub, *Agent, *githubcli.GithubClient) {
+	f := &fakeDispatchGithub{statuses: map[string]string{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		switch {
+		case r.URL.Path == "/repos/datafuselabs/databend/dispatches":
+			if f.failing {
+				http.Error(w, "dispatch failed", http.StatusInternalServerError)
+				return
+			}
+			var d struct {
+				ClientPayload map[string]json.RawMessage `json:"client_payload"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&d))
+			var uuid string
+			assert.NoError(t, json.Unmarshal(d.ClientPayload["UUID"], &uuid))
+			f.dispatches = append(f.dispatches, uuid)
+			f.payloads = append(f.payloads, d.ClientPayload)
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasPrefix(r.URL.Path, "/repos/datafuselabs/databend/statuses/"):
+			var s github.RepoStatus
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&s))
+			assert.Equal(t, RunPerfStatus, s.GetContext())
+			f.statuses[strings.TrimPrefix(r.URL.Path, "/repos/datafuselabs/databend/statuses/")] = s.GetState() + ": " + s.GetDescription()
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	clt := github.NewClient(srv.Client())
+	clt.BaseURL, _ = url.Parse(srv.URL + "/")
+	gc := &githubcli.GithubClient{Clt: clt, Owner: "datafuselabs", Repo: "databend", Pr: 233, Ctx: context.Background()}
+
+	r, err := registry.NewBoltRegistry(filepath.Join(t.TempDir(), "registry.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	signer, err := registry.NewSigner("secret", time.Hour)
+	assert.NoError(t, err)
+	agent := NewAgent(gc, "", "", "", "", r, signer)
+	agent.Scheduler, err = scheduler.New(r.DB(), scheduler.Options{Limit: 1})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	agent.Scheduler.Start(ctx, func(j scheduler.Job) error {
+		return agent.StartRun(gc, j.EventType, j.Run, j.Payloads)
+	}, nil, time.Hour)
+	return f, agent, gc
+}
+
+func newDispatchRun(sha, uuid string) registry.Run {
+	return registry.Run{
+		Key:          registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: sha, UUID: uuid},
+		DispatchName: RunPerfDispatch,
+		Status:       "queued",
+	}
+}
+
+func TestDispatchRun_superseded(t *testing.T) {
+	f, agent, gc := newFakeDispatchAgent(t)
+	for _, run := range []registry.Run{newDispatchRun("a", "1"), newDispatchRun("b", "2"), newDispatchRun("c", "3")} {
+		assert.NoError(t, agent.DispatchRun(gc, "run_perf", run, nil))
+	}
+	assert.Equal(t, []string{"1"}, f.dispatches, "a single runner")
+
+	superseded, err := agent.Registry.Get(newDispatchRun("b", "2").Key)
+	assert.NoError(t, err)
+	assert.Equal(t, "cancelled", superseded.Conclusion)
+	assert.Equal(t, map[string]string{"b": "error: superseded by a newer run"}, f.statuses)
+}
+
+func TestDispatchRun_failed(t *testing.T) {
+	f, agent, gc := newFakeDispatchAgent(t)
+	f.failing = true
+	run := newDispatchRun("a", "1")
+	assert.Error(t, agent.DispatchRun(gc, "run_perf", run, nil), "the dispatch error of the run given a runner")
+
+	failed, err := agent.Registry.Get(run.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, "failure", failed.Conclusion)
+	assert.Equal(t, map[string]string{"a": "error: cannot dispatch the run"}, f.statuses)
+	entries, err := agent.Scheduler.Queue()
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "the failed run frees its runner")
+}
+
+func TestStartRun_payload(t *testing.T) {
+	f, agent, gc := newFakeDispatchAgent(t)
+	agent.Bucket, agent.StorageType = "perf", "S3"
+	run := newDispatchRun("a", "1")
+	run.PR, run.Branch = "", "main"
+	extra := map[string]string{"ITERATION": "3", "QUERIES": "Q1,Q2"}
+	assert.NoError(t, agent.StartRun(gc, "run_perf", run, extra))
+	assert.Len(t, f.payloads, 1)
+	assert.LessOrEqual(t, len(f.payloads[0]), githubcli.MaxClientPayloadKeys, "github rejects the larger payloads")
+	assert.JSONEq(t, `{"TYPE": "S3", "REGION": "", "BUCKET": "perf", "ENDPOINT": "", "PREFIX": "datafuselabs/databend/branch:main/a/1"}`, string(f.payloads[0]["STORAGE"]))
+	assert.JSONEq(t, `"main"`, string(f.payloads[0]["BRANCH"]))
+	assert.NotContains(t, f.payloads[0], "PR_NUMBER")
+
+	for i := 0; i < githubcli.MaxClientPayloadKeys; i++ {
+		extra[fmt.Sprintf("EXTRA_%d", i)] = "x"
+	}
+	assert.Error(t, agent.StartRun(gc, "run_perf", run, extra))
+	assert.Len(t, f.payloads, 1, "the payload is not sent")
+}
+
+func TestExpireRun(t *testing.T) {
+	f, agent, gc := newFakeDispatchAgent(t)
+	run := newDispatchRun("a", "1")
+	run.Status = "in_progress"
+	_, err := agent.Registry.Record(run)
+	assert.NoError(t, err)
+
+	expired, err := agent.ExpireRun(gc, run)
+	assert.NoError(t, err)
+	assert.Equal(t, "completed", expired.Status)
+	assert.Equal(t, "failure", expired.Conclusion)
+	assert.Equal(t, map[string]string{"a": "error: the run timed out"}, f.statuses)
+
+	completed := newDispatchRun("b", "2")
+	completed.Status, completed.Conclusion = "completed", "success"
+	_, err = agent.Registry.Record(completed)
+	assert.NoError(t, err)
+	expired, err = agent.ExpireRun(gc, completed)
+	assert.NoError(t, err)
+	assert.Equal(t, "success", expired.Conclusion, "a completed run is left as is")
+	assert.NotContains(t, f.statuses, "b")
+}
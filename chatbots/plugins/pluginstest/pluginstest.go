@@ -0,0 +1,128 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+
+// Package pluginstest fakes the github api and the agent for the tests of the plugins.
+package pluginstest
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/stretchr/testify/assert"
+
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/utils"
+)
+
+const (
+	repoPath     = "/repos/datafuselabs/databend"
+	statusesPath = repoPath + "/statuses/"
+)
+
+var cancelPath = regexp.MustCompile(`^` + repoPath + `/actions/runs/[0-9]+/cancel$`)
+
+// Github is a fake github api of the repository datafuselabs/databend and its pull request 233.
+// It records the requests, the comments posted on the pull request, the repository dispatches
+// and the commit statuses by sha, and serves Head as the head commit of the pull request.
+// The properties of the objects of a client payload are recorded as OBJECT.PROPERTY, e.g. STORAGE.PREFIX.
+type Github struct {
+	mu         sync.Mutex
+	Requests   []string
+	Comments   []string
+	Dispatches []map[string]string
+	Statuses   map[string]*github.RepoStatus
+	Head       string
+	// Handlers serve the other paths of the api, by path.
+	Handlers map[string]http.HandlerFunc
+}
+
+// NewGithub returns the fake api and a client of pull request 233 commented by the member alice.
+func NewGithub(t *testing.T) (*Github, *githubcli.GithubClient) {
+	f := &Github{Statuses: map[string]*github.RepoStatus{}, Head: "abc", Handlers: map[string]http.HandlerFunc{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.Requests = append(f.Requests, r.Method+" "+r.URL.Path)
+		if h, ok := f.Handlers[r.URL.Path]; ok {
+			h(w, r)
+			return
+		}
+		switch {
+		case r.URL.Path == repoPath+"/issues/233/comments":
+			var c github.IssueComment
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&c))
+			f.Comments = append(f.Comments, c.GetBody())
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+		case r.URL.Path == repoPath+"/dispatches":
+			var d struct {
+				ClientPayload map[string]json.RawMessage `json:"client_payload"`
+			}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&d))
+			assert.LessOrEqual(t, len(d.ClientPayload), githubcli.MaxClientPayloadKeys)
+			payload := map[string]string{}
+			for k, v := range d.ClientPayload {
+				var object map[string]string
+				if json.Unmarshal(v, &object) == nil {
+					for p, pv := range object {
+						payload[k+"."+p] = pv
+					}
+					continue
+				}
+				var s string
+				assert.NoError(t, json.Unmarshal(v, &s))
+				payload[k] = s
+			}
+			f.Dispatches = append(f.Dispatches, payload)
+			w.WriteHeader(http.StatusNoContent)
+		case strings.HasPrefix(r.URL.Path, statusesPath):
+			s := &github.RepoStatus{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(s))
+			f.Statuses[strings.TrimPrefix(r.URL.Path, statusesPath)] = s
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte("{}"))
+		case r.URL.Path == repoPath+"/pulls/233/commits":
+			assert.NoError(t, json.NewEncoder(w).Encode([]*github.RepositoryCommit{{SHA: github.String(f.Head)}}))
+		case cancelPath.MatchString(r.URL.Path):
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	clt := github.NewClient(srv.Client())
+	clt.BaseURL, _ = url.Parse(srv.URL + "/")
+	return f, &githubcli.GithubClient{Clt: clt, Owner: "datafuselabs", Repo: "databend", Pr: 233, Author: "alice", AuthorAssociation: "MEMBER", Ctx: context.Background()}
+}
+
+// JSON returns a handler serving v.
+func JSON(t *testing.T, v interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewEncoder(w).Encode(v))
+	}
+}
+
+// NewAgent returns an agent of gc recording its runs in a temporary registry and their artifacts in a temporary storage.
+func NewAgent(t *testing.T, gc *githubcli.GithubClient) *plugins.Agent {
+	r, err := registry.NewBoltRegistry(filepath.Join(t.TempDir(), "registry.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	signer, err := registry.NewSigner("secret", time.Hour)
+	assert.NoError(t, err)
+	agent := plugins.NewAgent(gc, "", "", "", "", r, signer)
+	agent.Store = &utils.FileStorage{BasePath: t.TempDir()}
+	return agent
+}
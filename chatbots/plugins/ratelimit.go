@@ -0,0 +1,57 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package plugins
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the number of commands each user runs in a sliding window, e.g. 10 per hour.
+type RateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	now    func() time.Time
+	// runs are the times of the commands run by each user in the window, oldest first.
+	runs map[string][]time.Time
+}
+
+// NewRateLimiter returns a limiter allowing limit commands per user in window, 0 disables the limit.
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{limit: limit, window: window, now: time.Now, runs: map[string][]time.Time{}}
+}
+
+// SetLimit replaces the limit, the commands already run count against the new one.
+func (l *RateLimiter) SetLimit(limit int, window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit, l.window = limit, window
+}
+
+// Allow records a command run by the user and returns 0 when allowed,
+// or the time left before the user may run another command. A nil limiter allows everything.
+func (l *RateLimiter) Allow(user string) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit <= 0 {
+		return 0
+	}
+	user = strings.ToLower(user)
+	now := l.now()
+	runs := l.runs[user]
+	for len(runs) > 0 && now.Sub(runs[0]) >= l.window {
+		runs = runs[1:]
+	}
+	if len(runs) >= l.limit {
+		l.runs[user] = runs
+		return runs[len(runs)-l.limit].Add(l.window).Sub(now)
+	}
+	l.runs[user] = append(runs, now)
+	return 0
+}
@@ -0,0 +1,34 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package plugins
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(2, time.Hour)
+	start := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	l.now = func() time.Time { return now }
+
+	assert.Zero(t, l.Allow("alice"))
+	now = now.Add(10 * time.Minute)
+	assert.Zero(t, l.Allow("Alice"))
+	assert.Equal(t, 50*time.Minute, l.Allow("alice"), "the first run leaves the window in 50 minutes")
+	assert.Zero(t, l.Allow("bob"), "the users have their own window")
+
+	now = start.Add(time.Hour)
+	assert.Zero(t, l.Allow("alice"))
+	assert.Equal(t, 10*time.Minute, l.Allow("alice"))
+
+	l.SetLimit(0, time.Hour)
+	assert.Zero(t, l.Allow("alice"), "no limit")
+
+	var nilLimiter *RateLimiter
+	assert.Zero(t, nilLimiter.Allow("alice"))
+}
@@ -4,18 +4,19 @@
 package runperf
 
 import (
-	"context"
-	githubcli "datafuselabs/test-infra/chatbots/github"
-	"datafuselabs/test-infra/chatbots/plugins"
 	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/google/go-github/v35/github"
+	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/repoconfig"
+	"datafuselabs/test-infra/chatbots/scheduler"
+
 	guuid "github.com/google/uuid"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 )
 
@@ -24,215 +25,124 @@ const (
 )
 
 var (
-	reg      = regexp.MustCompile(`(?mi)^/run-perf\s*(?P<RELEASE>master|main|latest|v[0-9]+\.[0-9]+\.[0-9]+\S*)\s*$`)
-	rerunAll = regexp.MustCompile(`(?mi)^/rerun-perf-all\s*(?P<RELEASE>master|main|latest|v[0-9]+\.[0-9]+\.[0-9]+\S*)\s*$`)
-	rerun    = regexp.MustCompile(`(?mi)^/rerun-perf\s*(?P<RELEASE>master|main|latest|v[0-9]+\.[0-9]+\.[0-9]+\S*)\s*$`)
-)
+	release = regexp.MustCompile(`(?i)^(master|main|latest|v[0-9]+\.[0-9]+\.[0-9]+\S*)$`)
 
-func init() {
-	log.Info().Msgf("regsited plugin: %s", pluginName)
-	plugins.RegisterIssueCommentHandler(pluginName, handleIssueComment)
-}
-
-func handleIssueComment(client *plugins.Agent, ic *github.IssueCommentEvent) error {
-	handler, err := newRunPerf(ic, log.With().Str("issue comment", "bendbench-local").Logger(), client)
-	if err != nil {
-		return err
-	}
-	err = handle(handler)
-	if err != nil {
-		return err
+	// eventTypes are the repository dispatches started by the commands.
+	eventTypes = map[string]string{
+		"run-perf":       "run_perf",
+		"rerun-perf-all": "rerun_perf_all",
+		"rerun-perf":     "rerun_perf",
 	}
-	return nil
-}
 
-func extractCommand(s string) string {
-	s = strings.TrimLeft(s, "\r\n\t ")
-	if i := strings.Index(s, "\n"); i != -1 {
-		s = s[:i]
+	help = map[string]string{
+		"run-perf":       "Benchmark the head of the pull request against a reference build.",
+		"rerun-perf-all": "Benchmark again both the head of the pull request and the reference build.",
+		"rerun-perf":     "Benchmark again the head of the pull request against the reference build.",
 	}
-	s = strings.TrimRight(s, "\r\n\t ")
-	return s
-}
+)
 
-// Verify if user is allowed to perform activity.
-func (h handler) verifyUser() error {
-	var allowed bool
-	allowedAssociations := []string{"COLLABORATOR", "MEMBER", "OWNER"}
-	for _, a := range allowedAssociations {
-		if a == h.gc.AuthorAssociation {
-			allowed = true
-		}
-	}
-	if !allowed {
-		return fmt.Errorf("@%s is not a org, member nor a collaborator and cannot execute bendbench.", h.gc.Author)
-	}
-	h.log.Info().Msgf("author is a owner, member or collaborator")
-	return nil
+func init() {
+	log.Info().Msgf("regsited plugin: %s", pluginName)
+	for name := range eventTypes {
+		plugins.RegisterCommand(plugins.Command{
+			Name: name,
+			Args: []plugins.Arg{
+				{Name: "ref", Pattern: release, Optional: true, Help: "the reference build: master, main, latest (the last release tag) or a release tag, the configured default when omitted"},
+			},
+			Flags: []plugins.Arg{
+				{Name: "iterations", Type: plugins.Int, Help: "the number of times each query is run"},
+				{Name: "queries", Type: plugins.List, Help: "the queries to run, all of them by default"},
+			},
+			Permission: plugins.Collaborator,
+			Approvable: true,
+			Help:       help[name],
+			Handler:    handle,
+			Plugin:     pluginName,
+		})
+	}
 }
 
-func waitForReady(h *handler) error {
-	h.log.Info().Msgf("wait for other tasks finish")
-	retries := 200
-	for i := 0; i < retries; i++ {
-		has, info := h.gc.HasUnfinishedWorkflow()
-		if info != "" {
-			return fmt.Errorf("has failed jobs %s", info)
-		}
-		if !has {
-			return nil
-		}
-		time.Sleep(30 * time.Second)
-		h.log.Info().Msgf("retry times: %d", i)
-	}
-	return nil
+// newRun returns the run of the command measuring sha, and the payloads it adds for the workflow.
+// The ref and the iterations default to the repository configuration cfg.
+func newRun(gc *githubcli.GithubClient, cfg *repoconfig.Config, inv *plugins.Invocation, sha, lastTag, start, uuid string) (registry.Run, map[string]string, error) {
+	name := inv.Command.Name
+	ref := inv.String("ref")
+	if ref == "" {
+		ref = cfg.Ref(name)
+	}
+	if ref == "" {
+		return registry.Run{}, nil, fmt.Errorf("no <ref> given and no default ref configured for %s in %s", name, repoconfig.Path)
+	}
+	if strings.EqualFold(ref, "latest") {
+		ref = lastTag
+	}
+	run := registry.Run{
+		Key: registry.Key{
+			Org:  gc.Owner,
+			Repo: gc.Repo,
+			PR:   strconv.Itoa(gc.Pr),
+			SHA:  sha,
+			UUID: uuid,
+		},
+		DispatchName: pluginName,
+		Author:       gc.Author,
+		Current:      sha,
+		Ref:          ref,
+		StartTime:    start,
+		Status:       "queued",
+	}
+	extra := map[string]string{}
+	if inv.IsSet("iterations") {
+		extra["ITERATION"] = inv.String("iterations")
+	} else if n := cfg.Iterations(name); n > 0 {
+		extra["ITERATION"] = strconv.Itoa(n)
+	}
+	if inv.IsSet("queries") {
+		extra["QUERIES"] = inv.String("queries")
+	}
+	return run, extra, nil
 }
 
-// ok-to-bendbench <branch-name> will run bendbench test given branch reference
-func handle(h *handler) error {
-	if h == nil {
-		return nil
-	}
-	h.log.Info().Msgf(h.gc.GetIssueState())
-	lastSHA := h.gc.GetLastCommitSHA()
-	lastTag, err := h.gc.GetLatestTag()
-	id := guuid.New()
+// handle dispatches run-perf on the head of the pull request against the given reference.
+func handle(agent *plugins.Agent, gc *githubcli.GithubClient, inv *plugins.Invocation) error {
+	logger := log.With().Str("issue comment", "bendbench-local").Logger()
+	logger.Info().Msgf(gc.GetIssueState())
+	lastSHA := gc.GetLastCommitSHA()
+	lastTag, err := gc.GetLatestTag()
 	if err != nil {
 		return err
 	}
 	start := strconv.Itoa(int(time.Now().Unix()))
-	name, err := handlerhelper(h, lastSHA, lastTag, start, id.String())
+	run, extra, err := newRun(gc, agent.RepoConfig, inv, lastSHA, lastTag, start, guuid.New().String())
 	if err != nil {
-		if strings.Contains(err.Error(), "is not an owner, member nor a collaborator") {
-			err := h.gc.PostComment(err.Error())
-			if err != nil {
-				return err
-			}
-		}
-		return err
+		return gc.PostComment(fmt.Sprintf("cannot run `%s`: %s.", inv.Line, err.Error()))
 	}
-	// wait for the rest workflow success
-	err = waitForReady(h)
+	logger.Info().Msgf("current testing branch: %s, reference branch: %s", run.Current, run.Ref)
+	err = agent.DispatchRun(gc, agent.RepoConfig.EventType(inv.Command.Name, eventTypes[inv.Command.Name]), run, extra)
 	if err != nil {
-		h.gc.PostComment(err.Error())
+		logger.Error().Msgf("cannot dispatch run-perf %s, %s", run.Key.String(), err.Error())
 		return err
 	}
-
-	err = h.gc.CreateRepositoryDispatch(name, h.Payloads)
-	if err != nil {
-		h.log.Error().Msgf("cannot create run-perf repository dispatch, %s", err.Error())
-		return err
-	}
-	err = h.gc.PostComment(fmt.Sprintf("run performance on sha %s reference on %s", h.Payloads["CURRENT_BRANCH"], h.Payloads["REF_BRANCH"]))
+	err = gc.PostComment(fmt.Sprintf("run performance on sha %s reference on %s%s", run.Current, run.Ref, queueStatus(agent, run.Key)))
 	if err != nil {
 		return err
 	}
-	err = h.gc.UpdateStatus("run-perf-status", "pending", "")
+	err = gc.UpdateStatus("run-perf-status", "pending", "")
 	if err != nil {
-		h.log.Error().Msgf("cannot update status, %s", err.Error())
+		logger.Error().Msgf("cannot update status, %s", err.Error())
 		return err
 	}
 	return nil
 }
 
-func findMatches(h *handler, command string) (matches [][]string, dispatch_name string) {
-	matches = nil
-	for name, regex := range h.regexp {
-		matches := regex.FindAllStringSubmatch(command, -1)
-		if matches != nil {
-			h.log.Log().Msgf("start to run command %s", name)
-			return matches, name
-		}
-
+// queueStatus describes the place of a queued run in the scheduler queue.
+func queueStatus(agent *plugins.Agent, key registry.Key) string {
+	if agent.Scheduler == nil {
+		return ""
 	}
-	return nil, dispatch_name
-}
-
-func handlerhelper(h *handler, sha string, lastTag, startTime, uuid string) (string, error) {
-	command := extractCommand(h.gc.CommentBody)
-	h.log.Log().Msgf(command)
-	matches, name := findMatches(h, command)
-	if matches == nil {
-		return "", fmt.Errorf("there is no matching regex")
-	}
-	err := h.verifyUser()
-	if err != nil {
-		return "", err
+	e, err := agent.Scheduler.Position(key)
+	if err != nil || e.State != scheduler.Queued {
+		return ""
 	}
-
-	if len(matches) < 1 || len(matches[0]) < 2 {
-		return "", nil
-	}
-	switch matches[0][1] {
-	case "latest":
-		h.RefBranch = lastTag
-	default:
-		h.RefBranch = matches[0][1]
-	}
-	h.CurrentBranch = sha
-	h.log.Info().Msgf("current testing branch: %s, reference branch: %s", h.CurrentBranch, h.RefBranch)
-	err = extractPayload(h, sha, startTime, uuid)
-	return name, err
-}
-
-func extractPayload(h *handler, sha, start, uuid string) error {
-	h.Payloads["CURRENT_BRANCH"] = h.CurrentBranch
-	h.Payloads["PR_NUMBER"] = strconv.Itoa(h.gc.Pr)
-	h.Payloads["LAST_COMMIT_SHA"] = sha
-	h.Payloads["REF_BRANCH"] = h.RefBranch
-	h.Payloads["REGION"] = h.Region
-	h.Payloads["BUCKET"] = h.Bucket
-	h.Payloads["ENDPOINT"] = h.Endpoint
-	h.Payloads["UUID"] = uuid
-	return nil
-
-}
-
-// handler is a struct that contains data about a github event and provides functions to help handle it.
-type handler struct {
-
-	// regexp is the regular expression describing the command. It must have an optional 'un' prefix
-	// as the first subgroup and the arguments to the command as the second subgroup.
-	regexp map[string]*regexp.Regexp
-	// gc is the githubClient to use for creating response comments in the event of a failure.
-	gc *githubcli.GithubClient
-
-	// log define structed logging interface.
-	log zerolog.Logger
-
-	// define the branch that the current branch will be tested with
-	RefBranch string
-
-	// define the current branch we want to test
-	CurrentBranch string
-
-	// define a series of client-payloads that will be posted to workflow
-	Payloads map[string]string
-
-	// define S3 storage configuration
-	Region   string
-	Bucket   string
-	Endpoint string
-}
-
-func newRunPerf(e *github.IssueCommentEvent, log zerolog.Logger, client *plugins.Agent) (*handler, error) {
-	githubCli, err := githubcli.NewGithubClient(context.Background(), e, client.Token)
-	if err != nil {
-		log.Error().Msgf("Unable to initialize github client given issue comment event %s, %s", e.GetComment().String(), err.Error())
-
-		return nil, err
-	}
-	regs := make(map[string]*regexp.Regexp)
-	regs["run_perf"] = reg
-	regs["rerun_perf_all"] = rerunAll
-	regs["rerun_perf"] = rerun
-	return &handler{
-		regexp:   regs,
-		gc:       githubCli,
-		log:      log,
-		Payloads: make(map[string]string),
-		Region:   client.Region,
-		Bucket:   client.Bucket,
-		Endpoint: client.Endpoint,
-	}, nil
+	return fmt.Sprintf(", queued at position %d, starting in about %s", e.Position, e.ETA.Round(time.Minute))
 }
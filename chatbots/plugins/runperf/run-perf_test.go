@@ -4,229 +4,160 @@
 package runperf
 
 import (
-	"fmt"
-	"regexp"
-	"strconv"
 	"testing"
 
-	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 
 	githubcli "datafuselabs/test-infra/chatbots/github"
+	"datafuselabs/test-infra/chatbots/plugins"
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/repoconfig"
 )
 
-func newFakeGithubClient(commentBody, author, authorAssociation string, pr_num int) *githubcli.GithubClient {
+func newFakeGithubClient(author string, pr_num int) *githubcli.GithubClient {
 	return &githubcli.GithubClient{
-		AuthorAssociation: authorAssociation,
-		CommentBody:       commentBody,
-		Author:            author,
-		Pr:                pr_num,
+		Owner:  "datafuselabs",
+		Repo:   "databend",
+		Author: author,
+		Pr:     pr_num,
 	}
 }
 
-func newFakeHandler(commentBody, author, authorAssociation string, pr int, region, bucket, endpoint string ) *handler {
-	return &handler{
-		regexp:   map[string]*regexp.Regexp{"run_perf": reg, "rerun_perf_all": rerunAll, "rerun_perf": rerun},
-		gc:       newFakeGithubClient(commentBody, author, authorAssociation, pr),
-		log:      log.With().Str("issue comment", "bendbench-local").Logger(),
-		Region: region,
-		Bucket: bucket,
-		Endpoint: endpoint,
-		Payloads: map[string]string{},
-	}
-}
-
-func Test_handlerhelper(t *testing.T) {
+func Test_newRun(t *testing.T) {
 	tests := []struct {
-		name                string
-		comment             string
-		author              string
-		authorAssociation   string
-		pr                  int
-		sha                 string
-		StartTime 			string
-		UUID string
-		lastTag             string
-		expectName string
-		expectError         error
-		expectCurrentBranch string
-		expectRefBranch     string
-		expectPayload       map[string]string
+		name            string
+		comment         string
+		config          string
+		sha             string
+		lastTag         string
+		expectEventType string
+		expectError     string
+		expectRunError  string
+		expectRef       string
+		expectExtra     map[string]string
 	}{
 		{
-			name:                "master",
-			comment:             "/run-perf master",
-			author:              "zhihanz",
-			authorAssociation:   "OWNER",
-			pr:                  233,
-			sha:                 "foo",
-			expectName: "run_perf",
-			expectError:         nil,
-			lastTag:             "v1.1.1-nightly",
-			expectCurrentBranch: "foo",
-			StartTime: "1",
-			UUID: "12",
-			expectRefBranch:     "master",
-			expectPayload:       map[string]string{"CURRENT_BRANCH": "foo", "PR_NUMBER": strconv.Itoa(233),
-				"LAST_COMMIT_SHA": "foo", "REF_BRANCH": "master", "UUID": "12",
-				"REGION": "foo-region", "BUCKET": "foo-bucket", "ENDPOINT": "foo-endpoint"},
+			name:            "master",
+			comment:         "/run-perf master",
+			sha:             "foo",
+			lastTag:         "v1.1.1-nightly",
+			expectEventType: "run_perf",
+			expectRef:       "master",
+			expectExtra:     map[string]string{},
+		},
+		{
+			name:            "rerun all",
+			comment:         "/rerun-perf-all master",
+			sha:             "foo",
+			lastTag:         "v1.1.1-nightly",
+			expectEventType: "rerun_perf_all",
+			expectRef:       "master",
+			expectExtra:     map[string]string{},
+		},
+		{
+			name:            "rerun",
+			comment:         "/rerun-perf master",
+			sha:             "foo",
+			lastTag:         "v1.1.1-nightly",
+			expectEventType: "rerun_perf",
+			expectRef:       "master",
+			expectExtra:     map[string]string{},
+		},
+		{
+			name:            "newline",
+			comment:         "\r\n/run-perf latest\t",
+			sha:             "bar",
+			lastTag:         "v1.1.1-nightly",
+			expectEventType: "run_perf",
+			expectRef:       "v1.1.1-nightly",
+			expectExtra:     map[string]string{},
 		},
 		{
-			name:                "master",
-			comment:             "/rerun-perf-all master",
-			author:              "zhihanz",
-			authorAssociation:   "OWNER",
-			pr:                  233,
-			sha:                 "foo",
-			expectName: "rerun_perf_all",
-			expectError:         nil,
-			lastTag:             "v1.1.1-nightly",
-			expectCurrentBranch: "foo",
-			StartTime: "1",
-			UUID: "12",
-			expectRefBranch:     "master",
-			expectPayload:       map[string]string{"CURRENT_BRANCH": "foo", "PR_NUMBER": strconv.Itoa(233),
-				"LAST_COMMIT_SHA": "foo", "REF_BRANCH": "master", "UUID": "12",
-				"REGION": "foo-region", "BUCKET": "foo-bucket", "ENDPOINT": "foo-endpoint"},
+			name:            "release",
+			comment:         "/run-perf v1.2.3-nightly",
+			sha:             "bar",
+			lastTag:         "v1.1.1-nightly",
+			expectEventType: "run_perf",
+			expectRef:       "v1.2.3-nightly",
+			expectExtra:     map[string]string{},
 		},
 		{
-			name:                "master",
-			comment:             "/rerun-perf master",
-			author:              "zhihanz",
-			authorAssociation:   "OWNER",
-			pr:                  233,
-			sha:                 "foo",
-			expectName: "rerun_perf",
-			expectError:         nil,
-			lastTag:             "v1.1.1-nightly",
-			expectCurrentBranch: "foo",
-			StartTime: "1",
-			UUID: "12",
-			expectRefBranch:     "master",
-			expectPayload:       map[string]string{"CURRENT_BRANCH": "foo", "PR_NUMBER": strconv.Itoa(233),
-				"LAST_COMMIT_SHA": "foo", "REF_BRANCH": "master", "UUID": "12",
-				"REGION": "foo-region", "BUCKET": "foo-bucket", "ENDPOINT": "foo-endpoint"},
+			name:            "flags",
+			comment:         "/run-perf main --iterations 5 --queries=q1,q3",
+			sha:             "bar",
+			expectEventType: "run_perf",
+			expectRef:       "main",
+			expectExtra:     map[string]string{"ITERATION": "5", "QUERIES": "q1,q3"},
 		},
 		{
-			name:                "newline",
-			comment:             "\r\n/run-perf latest\t",
-			author:              "zhihanz",
-			authorAssociation:   "OWNER",
-			pr:                  233,
-			lastTag:             "v1.1.1-nightly",
-			sha:                 "bar",
-			UUID: "12",
-			expectName: "run_perf",
-			expectError:         nil,
-			StartTime: "1",
-			expectCurrentBranch: "bar",
-			expectRefBranch:     "v1.1.1-nightly",
-			expectPayload:       map[string]string{"CURRENT_BRANCH": "bar", "PR_NUMBER": strconv.Itoa(233),
-				"LAST_COMMIT_SHA": "bar", "REF_BRANCH": "v1.1.1-nightly", "UUID": "12",
-				"REGION": "foo-region", "BUCKET": "foo-bucket", "ENDPOINT": "foo-endpoint"},
+			name:            "configured",
+			comment:         "/run-perf",
+			config:          "commands:\n  run-perf:\n    eventType: perf\n    ref: latest\n    iterations: 3\n",
+			sha:             "bar",
+			lastTag:         "v1.1.1-nightly",
+			expectEventType: "perf",
+			expectRef:       "v1.1.1-nightly",
+			expectExtra:     map[string]string{"ITERATION": "3"},
 		},
 		{
-			name:                "laetst",
-			comment:             "/run-perf latest",
-			author:              "zhihanz",
-			authorAssociation:   "OWNER",
-			pr:                  233,
-			lastTag:             "v1.1.1-nightly",
-			sha:                 "bar",
-			expectName: "run_perf",
-			expectError:         nil,
-			StartTime: "1",
-			UUID: "12",
-			expectCurrentBranch: "bar",
-			expectRefBranch:     "v1.1.1-nightly",
-			expectPayload:       map[string]string{"CURRENT_BRANCH": "bar", "PR_NUMBER": strconv.Itoa(233),
-				"LAST_COMMIT_SHA": "bar", "REF_BRANCH": "v1.1.1-nightly", "UUID": "12",
-				"REGION": "foo-region", "BUCKET": "foo-bucket", "ENDPOINT": "foo-endpoint"},
+			name:            "configured overridden",
+			comment:         "/run-perf main --iterations 5",
+			config:          "commands:\n  run-perf:\n    ref: latest\n    iterations: 3\n",
+			sha:             "bar",
+			expectEventType: "run_perf",
+			expectRef:       "main",
+			expectExtra:     map[string]string{"ITERATION": "5"},
 		},
 		{
-			name:                "release",
-			comment:             "/run-perf v1.2.3-nightly",
-			author:              "zhihanz",
-			authorAssociation:   "OWNER",
-			pr:                  233,
-			lastTag:             "v1.1.1-nightly",
-			sha:                 "bar",
-			StartTime: "1",
-			UUID: "12",
-			expectName: "run_perf",
-			expectError:         nil,
-			expectCurrentBranch: "bar",
-			expectRefBranch:     "v1.2.3-nightly",
-			expectPayload:       map[string]string{"CURRENT_BRANCH": "bar", "PR_NUMBER": strconv.Itoa(233),
-				"LAST_COMMIT_SHA": "bar", "REF_BRANCH": "v1.2.3-nightly", "UUID": "12",
-				"REGION": "foo-region", "BUCKET": "foo-bucket", "ENDPOINT": "foo-endpoint"},
+			name:            "empty",
+			comment:         "/run-perf",
+			expectEventType: "run_perf",
+			expectRunError:  "no <ref> given and no default ref configured for run-perf in .github/test-infra.yaml",
 		},
 		{
-			name:                "empty",
-			comment:             "/run-perf-local",
-			author:              "zhihanz",
-			authorAssociation:   "OWNER",
-			expectName: "",
-			expectError:         fmt.Errorf("there is no matching regex"),
-			pr:                  233,
-			StartTime: "1",
-			sha:                 "bar",
-			expectCurrentBranch: "",
-			expectRefBranch:     "",
-			expectPayload:       map[string]string{},
+			name:        "non-sense",
+			comment:     "/run-perf Wubba-Lubba-Dub-Dub",
+			expectError: `invalid ref "Wubba-Lubba-Dub-Dub"`,
 		},
 		{
-			name:                "non-sense",
-			comment:             "/run-perf Wubba-Lubba-Dub-Dub",
-			author:              "zhihanz",
-			authorAssociation:   "OWNER",
-			expectName: "",
-			expectError:         fmt.Errorf("there is no matching regex"),
-			pr:                  233,
-			StartTime: "1",
-			sha:                 "bar",
-			expectCurrentBranch: "",
-			expectRefBranch:     "",
-			expectPayload:       map[string]string{},
+			name:        "non-sense2",
+			comment:     "/run-perf Wubba Lubba Dub Dub",
+			expectError: "too many arguments",
 		},
 		{
-			name:                "non-sense2",
-			comment:             "/run-perf Wubba Lubba Dub Dub",
-			author:              "zhihanz",
-			authorAssociation:   "OWNER",
-			expectName: "",
-			expectError:         fmt.Errorf("there is no matching regex"),
-			pr:                  233,
-			StartTime: "1",
-			sha:                 "bar",
-			expectCurrentBranch: "",
-			expectRefBranch:     "",
-			expectPayload:       map[string]string{},
+			name:        "two refs",
+			comment:     "/run-perf master main",
+			expectError: "too many arguments",
 		},
 		{
-			name:                "non-sense3",
-			comment:             "/run-perf master main",
-			author:              "zhihanz",
-			authorAssociation:   "OWNER",
-			expectName: "",
-			expectError:         fmt.Errorf("there is no matching regex"),
-			pr:                  233,
-			StartTime: "1",
-			sha:                 "bar",
-			expectCurrentBranch: "",
-			expectRefBranch:     "",
-			expectPayload:       map[string]string{},
+			name:        "iterations",
+			comment:     "/run-perf master --iterations many",
+			expectError: `iterations must be a positive integer, got "many"`,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			handler := newFakeHandler(tt.comment, tt.author, tt.authorAssociation, tt.pr, "foo-region", "foo-bucket", "foo-endpoint")
-			name, err := handlerhelper(handler, tt.sha, tt.lastTag, tt.StartTime, tt.UUID)
-			assert.Equal(t, name, tt.expectName)
-			assert.Equal(t, err, tt.expectError)
-			assert.Equal(t, handler.CurrentBranch, tt.expectCurrentBranch)
-			assert.Equal(t, handler.RefBranch, tt.expectRefBranch)
-			assert.Equal(t, handler.Payloads, tt.expectPayload)
+			inv, err := plugins.ParseLine(tt.comment)
+			if tt.expectError != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tt.expectError, err.(*plugins.UsageError).Reason)
+				return
+			}
+			assert.NoError(t, err)
+			cfg, err := repoconfig.Parse([]byte(tt.config))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectEventType, cfg.EventType(inv.Command.Name, eventTypes[inv.Command.Name]))
+			run, extra, err := newRun(newFakeGithubClient("zhihanz", 233), cfg, inv, tt.sha, tt.lastTag, "1", "12")
+			if tt.expectRunError != "" {
+				assert.EqualError(t, err, tt.expectRunError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, registry.Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: tt.sha, UUID: "12"}, run.Key)
+			assert.Equal(t, tt.sha, run.Current)
+			assert.Equal(t, tt.expectRef, run.Ref)
+			assert.Equal(t, "zhihanz", run.Author)
+			assert.Equal(t, tt.expectExtra, extra)
 		})
 	}
 }
@@ -0,0 +1,193 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package policy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// associations are the github author associations a rule may allow.
+var associations = map[string]bool{
+	"OWNER": true, "MEMBER": true, "COLLABORATOR": true, "CONTRIBUTOR": true,
+	"FIRST_TIME_CONTRIBUTOR": true, "FIRST_TIMER": true, "MANNEQUIN": true, "NONE": true,
+}
+
+// TeamResolver tells whether a user is an active member of the team slug of org.
+type TeamResolver interface {
+	IsTeamMember(org, slug, user string) (bool, error)
+}
+
+// Rule lists who may run a command, a user matching any of the lists may.
+type Rule struct {
+	// Associations are github author associations, e.g. MEMBER.
+	Associations []string `yaml:"associations,omitempty"`
+	// Teams are team slugs, org/slug or a slug of the organization owning the repository.
+	Teams []string `yaml:"teams,omitempty"`
+	// Users are github logins.
+	Users []string `yaml:"users,omitempty"`
+}
+
+// String describes who the rule allows, e.g. "MEMBER, OWNER, team datafuselabs/perf or @alice".
+func (r Rule) String() string {
+	var who []string
+	who = append(who, r.Associations...)
+	for _, t := range r.Teams {
+		who = append(who, "team "+t)
+	}
+	for _, u := range r.Users {
+		who = append(who, "@"+u)
+	}
+	switch len(who) {
+	case 0:
+		return "nobody"
+	case 1:
+		return who[0]
+	}
+	return strings.Join(who[:len(who)-1], ", ") + " or " + who[len(who)-1]
+}
+
+func (r Rule) validate() error {
+	for _, a := range r.Associations {
+		if !associations[a] {
+			return fmt.Errorf("unknown author association %s", a)
+		}
+	}
+	for _, t := range r.Teams {
+		if t == "" || strings.Count(t, "/") > 1 {
+			return fmt.Errorf("invalid team %q", t)
+		}
+	}
+	return nil
+}
+
+// allows returns whether the user with the author association on a repository of org matches the rule.
+func (r Rule) allows(org, user, association string, teams TeamResolver) (bool, error) {
+	for _, a := range r.Associations {
+		if a == association {
+			return true, nil
+		}
+	}
+	for _, u := range r.Users {
+		if strings.EqualFold(u, user) {
+			return true, nil
+		}
+	}
+	for _, t := range r.Teams {
+		teamOrg, slug := org, t
+		if i := strings.Index(t, "/"); i != -1 {
+			teamOrg, slug = t[:i], t[i+1:]
+		}
+		ok, err := teams.IsTeamMember(teamOrg, slug, user)
+		if err != nil {
+			return false, fmt.Errorf("cannot resolve team %s/%s, %v", teamOrg, slug, err)
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RepoPolicy overrides the command rules on a repository.
+type RepoPolicy struct {
+	Commands map[string]Rule `yaml:"commands"`
+}
+
+// Policy maps the commands to who may run them, it is loaded from a file such as
+//
+//	commands:
+//	  run-perf:
+//	    associations: [COLLABORATOR, MEMBER, OWNER]
+//	    teams: [perf]
+//	  bisect-perf:
+//	    users: [alice]
+//	repos:
+//	  datafuselabs/databend:
+//	    commands:
+//	      run-perf:
+//	        teams: [datafuselabs/maintainers]
+//
+// A command without a rule keeps the permission it declares.
+type Policy struct {
+	Commands map[string]Rule `yaml:"commands"`
+	// Repos are keyed by owner/repo, their rules replace the global rule of the same command.
+	Repos map[string]RepoPolicy `yaml:"repos"`
+}
+
+// Load reads the policy file at path.
+func Load(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
+
+// Parse decodes a yaml policy, unknown fields are rejected so a typo doesn't silently open a command.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&p); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("invalid policy, %v", err)
+	}
+	return &p, nil
+}
+
+// Validate checks the associations and the teams of the rules, and that they name known commands.
+func (p *Policy) Validate(commands []string) error {
+	known := map[string]bool{}
+	for _, c := range commands {
+		known[c] = true
+	}
+	check := func(scope string, rules map[string]Rule) error {
+		for name, r := range rules {
+			if !known[name] {
+				return fmt.Errorf("%s: unknown command %s", scope, name)
+			}
+			if err := r.validate(); err != nil {
+				return fmt.Errorf("%s: command %s: %v", scope, name, err)
+			}
+		}
+		return nil
+	}
+	if err := check("policy", p.Commands); err != nil {
+		return err
+	}
+	for repo, rp := range p.Repos {
+		if strings.Count(repo, "/") != 1 {
+			return fmt.Errorf("policy: invalid repository %q, expected owner/repo", repo)
+		}
+		if err := check("repository "+repo, rp.Commands); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rule returns the rule of the command on the repository, and false when the policy has none.
+func (p *Policy) Rule(org, repo, command string) (Rule, bool) {
+	if r, ok := p.Repos[org+"/"+repo].Commands[command]; ok {
+		return r, true
+	}
+	r, ok := p.Commands[command]
+	return r, ok
+}
+
+// Allows returns whether the user with the author association may run the command on the repository.
+// ok is false when the policy has no rule for the command.
+func (p *Policy) Allows(org, repo, command, user, association string, teams TeamResolver) (allowed, ok bool, err error) {
+	r, ok := p.Rule(org, repo, command)
+	if !ok {
+		return false, false, nil
+	}
+	allowed, err = r.allows(org, user, association, teams)
+	return allowed, true, err
+}
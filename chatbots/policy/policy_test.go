@@ -0,0 +1,106 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package policy
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeTeams maps org/slug to the logins of the team.
+type fakeTeams map[string][]string
+
+func (f fakeTeams) IsTeamMember(org, slug, user string) (bool, error) {
+	members, ok := f[org+"/"+slug]
+	if !ok {
+		return false, fmt.Errorf("no team %s/%s", org, slug)
+	}
+	for _, m := range members {
+		if m == user {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+const testPolicy = `
+commands:
+  run-perf:
+    associations: [COLLABORATOR, MEMBER, OWNER]
+    teams: [perf]
+  bisect-perf:
+    users: [Alice]
+repos:
+  datafuselabs/test-infra:
+    commands:
+      run-perf:
+        teams: [datafuselabs/maintainers]
+`
+
+func TestPolicy(t *testing.T) {
+	p, err := Parse([]byte(testPolicy))
+	assert.NoError(t, err)
+	assert.NoError(t, p.Validate([]string{"run-perf", "bisect-perf"}))
+	teams := fakeTeams{"datafuselabs/perf": {"bob"}, "datafuselabs/maintainers": {"carol"}}
+
+	tests := []struct {
+		name          string
+		repo          string
+		command       string
+		user          string
+		association   string
+		expectAllowed bool
+		expectOk      bool
+	}{
+		{name: "association", repo: "databend", command: "run-perf", user: "dave", association: "MEMBER", expectAllowed: true, expectOk: true},
+		{name: "team", repo: "databend", command: "run-perf", user: "bob", association: "CONTRIBUTOR", expectAllowed: true, expectOk: true},
+		{name: "denied", repo: "databend", command: "run-perf", user: "eve", association: "CONTRIBUTOR", expectOk: true},
+		{name: "user", repo: "databend", command: "bisect-perf", user: "alice", association: "NONE", expectAllowed: true, expectOk: true},
+		{name: "owner is not a user", repo: "databend", command: "bisect-perf", user: "dave", association: "OWNER", expectOk: true},
+		{name: "repo override", repo: "test-infra", command: "run-perf", user: "carol", association: "NONE", expectAllowed: true, expectOk: true},
+		{name: "repo override replaces", repo: "test-infra", command: "run-perf", user: "dave", association: "MEMBER", expectOk: true},
+		{name: "repo falls back", repo: "test-infra", command: "bisect-perf", user: "alice", association: "NONE", expectAllowed: true, expectOk: true},
+		{name: "no rule", repo: "databend", command: "perf-queue", user: "eve", association: "NONE"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, ok, err := p.Allows("datafuselabs", tt.repo, tt.command, tt.user, tt.association, teams)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectAllowed, allowed)
+			assert.Equal(t, tt.expectOk, ok)
+		})
+	}
+
+	_, _, err = p.Allows("other", "databend", "run-perf", "eve", "NONE", teams)
+	assert.EqualError(t, err, "cannot resolve team other/perf, no team other/perf")
+}
+
+func TestRule_String(t *testing.T) {
+	assert.Equal(t, "nobody", Rule{}.String())
+	assert.Equal(t, "@alice", Rule{Users: []string{"alice"}}.String())
+	assert.Equal(t, "MEMBER, OWNER, team perf or @alice", Rule{Associations: []string{"MEMBER", "OWNER"}, Teams: []string{"perf"}, Users: []string{"alice"}}.String())
+}
+
+func TestPolicy_invalid(t *testing.T) {
+	_, err := Parse([]byte("commands:\n  run-perf:\n    user: [alice]\n"))
+	assert.Error(t, err, "unknown field")
+
+	p, err := Parse(nil)
+	assert.NoError(t, err)
+	assert.NoError(t, p.Validate(nil))
+
+	for policy, expect := range map[string]string{
+		"commands:\n  lgtm:\n    users: [alice]\n":                           "policy: unknown command lgtm",
+		"commands:\n  run-perf:\n    associations: [MAINTAINER]\n":           "policy: command run-perf: unknown author association MAINTAINER",
+		"commands:\n  run-perf:\n    teams: [a/b/c]\n":                       `policy: command run-perf: invalid team "a/b/c"`,
+		"repos:\n  databend:\n    commands: {}\n":                            `policy: invalid repository "databend", expected owner/repo`,
+		"repos:\n  a/b:\n    commands:\n      lgtm:\n        users: [bob]\n": "repository a/b: unknown command lgtm",
+	} {
+		p, err := Parse([]byte(policy))
+		assert.NoError(t, err)
+		assert.EqualError(t, p.Validate([]string{"run-perf"}), expect)
+	}
+}
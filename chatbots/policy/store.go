@@ -0,0 +1,167 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package policy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	approvalsBucket = []byte("approvals")
+	auditBucket     = []byte("audit")
+)
+
+// auditRetention is the number of audit entries kept, the oldest are dropped.
+const auditRetention = 10000
+
+// Audit decisions.
+const (
+	Denied   = "denied"
+	Approved = "approved"
+	// Consumed is a command run thanks to an approval.
+	Consumed = "consumed"
+)
+
+// Approval lets a user who may not run the approvable commands run one of them on a commit of a pull request.
+type Approval struct {
+	Org  string `json:"org"`
+	Repo string `json:"repo"`
+	PR   int    `json:"pr"`
+	User string `json:"user"`
+	// SHA is the head of the pull request when it was approved, new commits need a new approval.
+	SHA string    `json:"sha"`
+	By  string    `json:"by"`
+	At  time.Time `json:"at"`
+}
+
+func approvalKey(org, repo string, pr int, user string) []byte {
+	return []byte(strings.Join([]string{org, repo, strconv.Itoa(pr), strings.ToLower(user)}, "/"))
+}
+
+// Entry is an audit log entry.
+type Entry struct {
+	Time        time.Time `json:"time"`
+	Org         string    `json:"org"`
+	Repo        string    `json:"repo"`
+	PR          int       `json:"pr"`
+	User        string    `json:"user"`
+	Association string    `json:"association,omitempty"`
+	Command     string    `json:"command"`
+	Decision    string    `json:"decision"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// Store keeps the approvals and the audit log in bbolt buckets.
+type Store struct {
+	db  *bolt.DB
+	now func() time.Time
+	// retention is the number of audit entries kept.
+	retention uint64
+}
+
+// NewStore returns a store keeping its buckets in db, usually the run registry database.
+func NewStore(db *bolt.DB) (*Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{approvalsBucket, auditBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db, now: time.Now, retention: auditRetention}, nil
+}
+
+// Approve records the approval, replacing the previous approval of the user on the pull request.
+func (s *Store) Approve(a Approval) error {
+	a.At = s.now()
+	v, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(approvalsBucket).Put(approvalKey(a.Org, a.Repo, a.PR, a.User), v)
+	})
+}
+
+// Consume removes and returns the approval of the user on the pull request at sha.
+// It returns false when there is none, or when it was given on another commit.
+func (s *Store) Consume(org, repo string, pr int, user, sha string) (Approval, bool, error) {
+	var a Approval
+	found := false
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(approvalsBucket)
+		key := approvalKey(org, repo, pr, user)
+		v := b.Get(key)
+		if v == nil {
+			return nil
+		}
+		if err := json.Unmarshal(v, &a); err != nil {
+			return err
+		}
+		if a.SHA != sha {
+			return nil
+		}
+		found = true
+		return b.Delete(key)
+	})
+	return a, found, err
+}
+
+// Audit appends the entry to the audit log, dropping the oldest entries beyond the retention.
+func (s *Store) Audit(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = s.now()
+	}
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(auditBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		if err := b.Put(key, v); err != nil {
+			return err
+		}
+		// the keys are the sequence numbers, the entries before the last retention ones go
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k)+s.retention <= seq; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// AuditLog returns the last limit entries of the audit log, most recent first.
+func (s *Store) AuditLog(limit int) ([]Entry, error) {
+	var res []Entry
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(auditBucket).Cursor()
+		for k, v := c.Last(); k != nil && len(res) < limit; k, v = c.Prev() {
+			var e Entry
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			res = append(res, e)
+		}
+		return nil
+	})
+	return res, err
+}
@@ -0,0 +1,71 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package policy
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestStore(t *testing.T) *Store {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "policy.db"), 0600, nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	s, err := NewStore(db)
+	assert.NoError(t, err)
+	now := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time {
+		now = now.Add(time.Minute)
+		return now
+	}
+	return s
+}
+
+func TestStore_approvals(t *testing.T) {
+	s := newTestStore(t)
+	assert.NoError(t, s.Approve(Approval{Org: "datafuselabs", Repo: "databend", PR: 233, User: "Bob", SHA: "a", By: "alice"}))
+
+	_, ok, err := s.Consume("datafuselabs", "databend", 233, "bob", "b")
+	assert.NoError(t, err)
+	assert.False(t, ok, "approved on another commit")
+	_, ok, err = s.Consume("datafuselabs", "databend", 234, "bob", "a")
+	assert.NoError(t, err)
+	assert.False(t, ok, "approved on another pull request")
+
+	a, ok, err := s.Consume("datafuselabs", "databend", 233, "bob", "a")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", a.By)
+	assert.False(t, a.At.IsZero())
+
+	_, ok, err = s.Consume("datafuselabs", "databend", 233, "bob", "a")
+	assert.NoError(t, err)
+	assert.False(t, ok, "an approval is good for a single run")
+}
+
+func TestStore_audit(t *testing.T) {
+	s := newTestStore(t)
+	s.retention = 3
+	entries, err := s.AuditLog(10)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+	for _, user := range []string{"a", "b", "c", "d", "e"} {
+		assert.NoError(t, s.Audit(Entry{User: user, Command: "run-perf", Decision: Denied}))
+	}
+	entries, err = s.AuditLog(10)
+	assert.NoError(t, err)
+	var users []string
+	for _, e := range entries {
+		users = append(users, e.User)
+		assert.False(t, e.Time.IsZero())
+	}
+	assert.Equal(t, []string{"e", "d", "c"}, users)
+	entries, err = s.AuditLog(1)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
@@ -0,0 +1,175 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var runsBucket = []byte("runs")
+
+// BoltRegistry is a Registry backed by an embedded bbolt database file.
+// Runs are keyed by Key.String() so the runs of a PR or of a commit are a prefix scan,
+// the other queries scan the whole bucket which is fine for the number of runs we keep.
+type BoltRegistry struct {
+	db  *bolt.DB
+	now func() time.Time
+}
+
+// NewBoltRegistry opens or creates the registry database at path.
+func NewBoltRegistry(path string) (*BoltRegistry, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(runsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltRegistry{db: db, now: time.Now}, nil
+}
+
+// DB returns the underlying database so other chatbot stores can keep their buckets in the same file.
+func (r *BoltRegistry) DB() *bolt.DB {
+	return r.db
+}
+
+func (r *BoltRegistry) Record(update Run) (*Run, error) {
+	if err := update.Key.Validate(); err != nil {
+		return nil, err
+	}
+	var run Run
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		key := []byte(update.Key.String())
+		if v := b.Get(key); v != nil {
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			if run.Status == "completed" && update.Status != "" && update.Status != "completed" {
+				return ErrCompleted
+			}
+		} else {
+			run.Key = update.Key
+		}
+		run.merge(update, r.now())
+		v, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (r *BoltRegistry) Get(key Key) (*Run, error) {
+	var run *Run
+	err := r.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(runsBucket).Get([]byte(key.String()))
+		if v == nil {
+			return ErrNotFound
+		}
+		run = &Run{}
+		return json.Unmarshal(v, run)
+	})
+	return run, err
+}
+
+func (r *BoltRegistry) Purge(key Key) (*Run, error) {
+	var run Run
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(runsBucket)
+		k := []byte(key.String())
+		v := b.Get(k)
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, &run); err != nil {
+			return err
+		}
+		now := r.now()
+		run.Artifacts = nil
+		run.PurgedAt = &now
+		run.UpdatedAt = now
+		v, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return b.Put(k, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (r *BoltRegistry) ListByPR(org, repo, pr string) ([]Run, error) {
+	prefix := []byte(strings.Join([]string{org, repo, pr, ""}, "/"))
+	return r.list(prefix, func(Run) bool { return true })
+}
+
+func (r *BoltRegistry) ListByBranch(org, repo, branch string) ([]Run, error) {
+	prefix := []byte(strings.Join([]string{org, repo, Key{Branch: branch}.Scope(), ""}, "/"))
+	return r.list(prefix, func(Run) bool { return true })
+}
+
+func (r *BoltRegistry) ListByCommit(org, repo, scope, sha string) ([]Run, error) {
+	prefix := []byte(strings.Join([]string{org, repo, scope, sha, ""}, "/"))
+	return r.list(prefix, func(Run) bool { return true })
+}
+
+func (r *BoltRegistry) ListByStatus(status string) ([]Run, error) {
+	return r.list(nil, func(run Run) bool {
+		return run.Status == status
+	})
+}
+
+func (r *BoltRegistry) ListByTime(from, to time.Time) ([]Run, error) {
+	return r.list(nil, func(run Run) bool {
+		return !run.CreatedAt.Before(from) && run.CreatedAt.Before(to)
+	})
+}
+
+func (r *BoltRegistry) Close() error {
+	return r.db.Close()
+}
+
+// list returns the runs whose key starts with prefix and accepted by filter,
+// sorted by creation time, most recent first.
+func (r *BoltRegistry) list(prefix []byte, filter func(Run) bool) ([]Run, error) {
+	var runs []Run
+	err := r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var run Run
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			if filter(run) {
+				runs = append(runs, run)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].CreatedAt.After(runs[j].CreatedAt)
+	})
+	return runs, nil
+}
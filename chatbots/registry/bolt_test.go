@@ -0,0 +1,172 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package registry
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeRegistry(t *testing.T) (*BoltRegistry, *time.Time) {
+	r, err := NewBoltRegistry(filepath.Join(t.TempDir(), "registry.db"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	now := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	return r, &now
+}
+
+func newFakeRun(pr, sha, uuid, status, conclusion string) Run {
+	return Run{
+		Key:          Key{Org: "datafuselabs", Repo: "databend", PR: pr, SHA: sha, UUID: uuid},
+		DispatchName: "run-perf",
+		Status:       status,
+		Conclusion:   conclusion,
+	}
+}
+
+func TestBoltRegistry_Record(t *testing.T) {
+	r, now := newFakeRegistry(t)
+
+	run, err := r.Record(newFakeRun("233", "foo", "1", "queued", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, "queued", run.Status)
+	assert.Len(t, run.Transitions, 1)
+
+	*now = now.Add(time.Minute)
+	update := newFakeRun("233", "foo", "1", "", "")
+	update.RunID = "42"
+	run, err = r.Record(update)
+	assert.NoError(t, err)
+	assert.Equal(t, "42", run.RunID)
+	assert.Equal(t, "queued", run.Status)
+	assert.Len(t, run.Transitions, 1, "an update without status is not a transition")
+
+	*now = now.Add(10 * time.Minute)
+	run, err = r.Record(newFakeRun("233", "foo", "1", "completed", "success"))
+	assert.NoError(t, err)
+	assert.Equal(t, "42", run.RunID, "empty fields don't overwrite recorded ones")
+	assert.Equal(t, "success", run.Conclusion)
+	assert.Len(t, run.Transitions, 2)
+	assert.Equal(t, 11*time.Minute, run.Duration())
+
+	_, err = r.Record(newFakeRun("233", "foo", "1", "in_progress", ""))
+	assert.Equal(t, ErrCompleted, err, "the late status of a completed run")
+	run, err = r.Record(newFakeRun("233", "foo", "1", "completed", "cancelled"))
+	assert.NoError(t, err)
+	assert.Equal(t, "cancelled", run.Conclusion, "a completed run may change its conclusion")
+	assert.Len(t, run.Transitions, 3)
+
+	update = newFakeRun("233", "foo", "1", "", "")
+	update.Artifacts = []Artifact{{Path: "current.log", Size: 10}, {Path: "flamegraphs/q1.svg", Size: 5}}
+	_, err = r.Record(update)
+	assert.NoError(t, err)
+	update.Artifacts = []Artifact{{Path: "current.log", Size: 3, SHA256: "abc"}}
+	run, err = r.Record(update)
+	assert.NoError(t, err)
+	assert.Len(t, run.Artifacts, 2, "an upload replaces the artifact with the same path")
+	a, ok := run.Artifact("current.log")
+	assert.True(t, ok)
+	assert.Equal(t, "abc", a.SHA256)
+	assert.Equal(t, int64(8), run.ArtifactsSize(""))
+	assert.Equal(t, int64(5), run.ArtifactsSize("current.log"))
+	assert.Len(t, run.Transitions, 3, "artifacts are not transitions")
+
+	stored, err := r.Get(run.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, run, stored)
+
+	_, err = r.Get(Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "2"})
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = r.Record(newFakeRun("233", "foo", "", "queued", ""))
+	assert.Error(t, err)
+	_, err = r.Record(newFakeRun("233", "../foo", "1", "queued", ""))
+	assert.Error(t, err)
+}
+
+func TestBoltRegistry_Purge(t *testing.T) {
+	r, now := newFakeRegistry(t)
+	update := newFakeRun("233", "foo", "1", "completed", "success")
+	update.Artifacts = []Artifact{{Path: "compare.json", Size: 10}}
+	_, err := r.Record(update)
+	assert.NoError(t, err)
+
+	*now = now.Add(time.Hour)
+	run, err := r.Purge(update.Key)
+	assert.NoError(t, err)
+	assert.Empty(t, run.Artifacts)
+	assert.Equal(t, now, run.PurgedAt)
+	assert.Equal(t, "success", run.Conclusion, "the history of the run is kept")
+	stored, err := r.Get(update.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, run, stored)
+
+	_, err = r.Purge(newFakeRun("233", "foo", "2", "", "").Key)
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestBoltRegistry_List(t *testing.T) {
+	r, now := newFakeRegistry(t)
+	start := *now
+	for _, run := range []Run{
+		newFakeRun("233", "foo", "1", "completed", "success"),
+		newFakeRun("233", "bar", "2", "in_progress", ""),
+		newFakeRun("2330", "foo", "3", "in_progress", ""),
+		newFakeRun("12", "baz", "4", "completed", "failure"),
+	} {
+		*now = now.Add(time.Hour)
+		_, err := r.Record(run)
+		assert.NoError(t, err)
+	}
+	uuids := func(runs []Run) []string {
+		var res []string
+		for _, r := range runs {
+			res = append(res, r.UUID)
+		}
+		return res
+	}
+
+	runs, err := r.ListByPR("datafuselabs", "databend", "233")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "1"}, uuids(runs))
+
+	*now = now.Add(time.Hour)
+	other := newFakeRun("233", "foo", "5", "completed", "success")
+	other.Repo = "fuse-store"
+	_, err = r.Record(other)
+	assert.NoError(t, err)
+	runs, err = r.ListByCommit("datafuselabs", "databend", "233", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1"}, uuids(runs), "the same pull request number and commit in another repository")
+	runs, err = r.ListByCommit("datafuselabs", "fuse-store", "233", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"5"}, uuids(runs))
+
+	*now = now.Add(time.Hour)
+	branch := newFakeRun("", "foo", "6", "completed", "success")
+	branch.Branch = "233"
+	_, err = r.Record(branch)
+	assert.NoError(t, err)
+	runs, err = r.ListByPR("datafuselabs", "databend", "233")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2", "1"}, uuids(runs), "a branch named as a pull request")
+	runs, err = r.ListByBranch("datafuselabs", "databend", "233")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"6"}, uuids(runs))
+	runs, err = r.ListByCommit("datafuselabs", "databend", "branch:233", "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"6"}, uuids(runs))
+
+	runs, err = r.ListByStatus("in_progress")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3", "2"}, uuids(runs))
+
+	runs, err = r.ListByTime(start.Add(2*time.Hour), start.Add(4*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3", "2"}, uuids(runs))
+}
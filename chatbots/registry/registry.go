@@ -0,0 +1,229 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"datafuselabs/test-infra/chatbots/utils"
+)
+
+// ErrNotFound is returned when no run matches the requested key.
+var ErrNotFound = errors.New("run not found")
+
+// ErrCompleted is returned when an update moves a completed run back to queued or in progress,
+// e.g. the late status of a cancelled run.
+var ErrCompleted = errors.New("run is completed")
+
+// branchScope prefixes the branch in the path of the branch runs, a branch never collides with a pull request number.
+const branchScope = "branch:"
+
+// Key identifies a single run dispatched by the chatbot.
+type Key struct {
+	Org  string `json:"org"`
+	Repo string `json:"repo"`
+	// PR is the pull request, or the issue of a bisection, the run belongs to. It is empty for the runs of a branch.
+	PR string `json:"pr,omitempty"`
+	// Branch is the branch the run belongs to, empty for the runs of a pull request.
+	Branch string `json:"branch,omitempty"`
+	SHA    string `json:"sha"`
+	UUID   string `json:"uuid"`
+}
+
+// NewKey returns the key of the run at the path org/repo/scope/sha/uuid, scope is the pull request number or branch:{name}.
+func NewKey(org, repo, scope, sha, uuid string) Key {
+	k := Key{Org: org, Repo: repo, SHA: sha, UUID: uuid}
+	if strings.HasPrefix(scope, branchScope) {
+		k.Branch = strings.TrimPrefix(scope, branchScope)
+	} else {
+		k.PR = scope
+	}
+	return k
+}
+
+// Scope returns the path segment of the pull request or of the branch of the run.
+func (k Key) Scope() string {
+	if k.Branch != "" {
+		return branchScope + k.Branch
+	}
+	return k.PR
+}
+
+// String returns the key as a path, it is also the storage key of the run.
+func (k Key) String() string {
+	return strings.Join([]string{k.Org, k.Repo, k.Scope(), k.SHA, k.UUID}, "/")
+}
+
+// Artifact returns the storage key of the file name uploaded by the run.
+func (k Key) Artifact(name string) utils.ArtifactKey {
+	return utils.ArtifactKey{Owner: k.Org, Repo: k.Repo, PR: k.Scope(), SHA: k.SHA, UUID: k.UUID, Name: name}
+}
+
+// Validate checks that every field of the key is set, the pull request or the branch, and can't escape its path segment.
+func (k Key) Validate() error {
+	fields := map[string]string{"org": k.Org, "repo": k.Repo, "sha": k.SHA, "uuid": k.UUID}
+	switch {
+	case k.PR != "" && k.Branch != "":
+		return errors.New("run has both a pr and a branch")
+	case k.Branch != "":
+		fields["branch"] = k.Branch
+	default:
+		fields["pr"] = k.PR
+	}
+	for name, v := range fields {
+		if v == "" {
+			return fmt.Errorf("missing run %s", name)
+		}
+		if strings.ContainsAny(v, "/\\") || v == "." || v == ".." || (name == "pr" && strings.HasPrefix(v, branchScope)) {
+			return fmt.Errorf("invalid run %s %q", name, v)
+		}
+	}
+	return nil
+}
+
+// Transition is a single status update reported for a run.
+type Transition struct {
+	Status     string    `json:"status"`
+	Conclusion string    `json:"conclusion,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// Artifact is a file uploaded by the workflow of a run.
+type Artifact struct {
+	// Path is the artifact path relative to the run, e.g. flamegraphs/q1.svg.
+	Path        string    `json:"path"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType,omitempty"`
+	SHA256      string    `json:"sha256,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+// Run is the recorded state and history of a run.
+type Run struct {
+	Key
+	DispatchName string `json:"dispatchName,omitempty"`
+	RunID        string `json:"runId,omitempty"`
+	Author       string `json:"author,omitempty"`
+	// Bisection is the id of the bisection which dispatched the run, empty otherwise.
+	Bisection  string `json:"bisection,omitempty"`
+	Current    string `json:"current,omitempty"`
+	Ref        string `json:"ref,omitempty"`
+	Compare    string `json:"compare,omitempty"`
+	PRLink     string `json:"prLink,omitempty"`
+	CurrentLog string `json:"currentLog,omitempty"`
+	RefLog     string `json:"refLog,omitempty"`
+	StartTime  string `json:"startTime,omitempty"`
+	// Status and Conclusion are the latest reported values.
+	Status     string `json:"status,omitempty"`
+	Conclusion string `json:"conclusion,omitempty"`
+	// Transitions holds every status change in the order they were recorded.
+	Transitions []Transition `json:"transitions,omitempty"`
+	// Artifacts holds the files uploaded for the run, an upload replaces the artifact with the same path.
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	// PurgedAt is when the retention deleted the artifacts of the run from the storage, nil while they are kept.
+	PurgedAt  *time.Time `json:"purgedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+}
+
+// Duration returns the time between the first and the last recorded transition.
+func (r Run) Duration() time.Duration {
+	if len(r.Transitions) < 2 {
+		return 0
+	}
+	return r.Transitions[len(r.Transitions)-1].Time.Sub(r.Transitions[0].Time)
+}
+
+// Artifact returns the artifact stored at path.
+func (r Run) Artifact(path string) (Artifact, bool) {
+	for _, a := range r.Artifacts {
+		if a.Path == path {
+			return a, true
+		}
+	}
+	return Artifact{}, false
+}
+
+// ArtifactsSize returns the total size of the artifacts of the run, except the one stored at path.
+func (r Run) ArtifactsSize(except string) int64 {
+	var size int64
+	for _, a := range r.Artifacts {
+		if a.Path != except {
+			size += a.Size
+		}
+	}
+	return size
+}
+
+// Registry records the runs reported by the workflows and answers queries on their history.
+type Registry interface {
+	// Record merges the non empty fields of the update into the stored run, creating it if needed,
+	// and appends a transition when the status or the conclusion changed.
+	// A completed run is never queued or in progress again, such an update returns ErrCompleted.
+	Record(update Run) (*Run, error)
+	// Get returns the run with the given key or ErrNotFound.
+	Get(key Key) (*Run, error)
+	// Purge drops the artifacts of the run, deleted from the storage, and records when, or returns ErrNotFound.
+	Purge(key Key) (*Run, error)
+	// ListByPR returns the runs of a pull request, most recent first.
+	ListByPR(org, repo, pr string) ([]Run, error)
+	// ListByBranch returns the runs of a branch, most recent first.
+	ListByBranch(org, repo, branch string) ([]Run, error)
+	// ListByCommit returns the runs of a commit of a pull request or of a branch, most recent first.
+	// scope is the Key.Scope of the runs.
+	ListByCommit(org, repo, scope, sha string) ([]Run, error)
+	// ListByStatus returns the runs whose latest status matches, most recent first.
+	ListByStatus(status string) ([]Run, error)
+	// ListByTime returns the runs created in [from, to), most recent first.
+	ListByTime(from, to time.Time) ([]Run, error)
+	// Close releases the underlying store.
+	Close() error
+}
+
+// merge applies the non empty fields of update on r and records a transition if the status changed.
+func (r *Run) merge(update Run, now time.Time) {
+	set := func(dst *string, v string) {
+		if v != "" {
+			*dst = v
+		}
+	}
+	set(&r.DispatchName, update.DispatchName)
+	set(&r.RunID, update.RunID)
+	set(&r.Author, update.Author)
+	set(&r.Bisection, update.Bisection)
+	set(&r.Current, update.Current)
+	set(&r.Ref, update.Ref)
+	set(&r.Compare, update.Compare)
+	set(&r.PRLink, update.PRLink)
+	set(&r.CurrentLog, update.CurrentLog)
+	set(&r.RefLog, update.RefLog)
+	set(&r.StartTime, update.StartTime)
+
+	for _, a := range update.Artifacts {
+		a.UpdatedAt = now
+		replaced := false
+		for i := range r.Artifacts {
+			if r.Artifacts[i].Path == a.Path {
+				r.Artifacts[i] = a
+				replaced = true
+			}
+		}
+		if !replaced {
+			r.Artifacts = append(r.Artifacts, a)
+		}
+	}
+
+	if update.Status != "" && (update.Status != r.Status || update.Conclusion != r.Conclusion) {
+		r.Status = update.Status
+		r.Conclusion = update.Conclusion
+		r.Transitions = append(r.Transitions, Transition{Status: update.Status, Conclusion: update.Conclusion, Time: now})
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = now
+	}
+	r.UpdatedAt = now
+}
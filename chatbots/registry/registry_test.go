@@ -0,0 +1,45 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package registry
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name        string
+		scope       string
+		expect      Key
+		expectError string
+	}{
+		{name: "pull request", scope: "233", expect: Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "1"}},
+		{name: "branch", scope: "branch:main", expect: Key{Org: "datafuselabs", Repo: "databend", Branch: "main", SHA: "foo", UUID: "1"}},
+		{name: "missing pull request", scope: "", expectError: "missing run pr"},
+		{name: "missing branch", scope: "branch:", expectError: "missing run pr"},
+		{name: "escaping branch", scope: "branch:..", expectError: "invalid run branch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := NewKey("datafuselabs", "databend", tt.scope, "foo", "1")
+			err := k.Validate()
+			if tt.expectError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, k)
+			assert.Equal(t, tt.scope, k.Scope())
+			assert.Equal(t, "datafuselabs/databend/"+tt.scope+"/foo/1", k.String())
+			assert.Equal(t, k, NewKey(k.Org, k.Repo, k.Scope(), k.SHA, k.UUID))
+		})
+	}
+	err := Key{Org: "datafuselabs", Repo: "databend", PR: "233", Branch: "main", SHA: "foo", UUID: "1"}.Validate()
+	assert.EqualError(t, err, "run has both a pr and a branch")
+	err = Key{Org: "datafuselabs", Repo: "databend", PR: "branch:main", SHA: "foo", UUID: "1"}.Validate()
+	assert.EqualError(t, err, `invalid run pr "branch:main"`, "a pull request can't take the path of a branch")
+}
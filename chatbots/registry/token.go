@@ -0,0 +1,70 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package registry
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned when a callback token wasn't minted for the run.
+	ErrInvalidToken = errors.New("invalid run token")
+	// ErrTokenExpired is returned when a callback token is older than the signer ttl.
+	ErrTokenExpired = errors.New("run token expired")
+)
+
+// Signer mints and verifies the per-run tokens the workflows send back on their callbacks.
+// A token is "<issued unix time>.<hex hmac-sha256 of the run key and the issued time>",
+// so it is bound to a single run and expires without keeping any state.
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewSigner returns a signer using secret, the tokens it mints are valid for ttl.
+func NewSigner(secret string, ttl time.Duration) (*Signer, error) {
+	if secret == "" {
+		return nil, errors.New("missing run token secret")
+	}
+	return &Signer{secret: []byte(secret), ttl: ttl, now: time.Now}, nil
+}
+
+// Mint returns a new token for the run.
+func (s *Signer) Mint(key Key) string {
+	issued := strconv.FormatInt(s.now().Unix(), 10)
+	return issued + "." + s.sign(key, issued)
+}
+
+// Verify checks that token was minted for the run and is not expired.
+func (s *Signer) Verify(key Key, token string) error {
+	i := strings.Index(token, ".")
+	if i == -1 {
+		return ErrInvalidToken
+	}
+	issued, mac := token[:i], token[i+1:]
+	if !hmac.Equal([]byte(mac), []byte(s.sign(key, issued))) {
+		return ErrInvalidToken
+	}
+	unix, err := strconv.ParseInt(issued, 10, 64)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if s.now().Sub(time.Unix(unix, 0)) > s.ttl {
+		return ErrTokenExpired
+	}
+	return nil
+}
+
+func (s *Signer) sign(key Key, issued string) string {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(key.String() + "\n" + issued))
+	return hex.EncodeToString(h.Sum(nil))
+}
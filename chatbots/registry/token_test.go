@@ -0,0 +1,51 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigner(t *testing.T) {
+	s, err := NewSigner("secret", time.Hour)
+	assert.NoError(t, err)
+	now := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	key := Key{Org: "datafuselabs", Repo: "databend", PR: "233", SHA: "foo", UUID: "1"}
+	token := s.Mint(key)
+	other := key
+	other.UUID = "2"
+	resigned, err := NewSigner("other", time.Hour)
+	assert.NoError(t, err)
+	resigned.now = s.now
+
+	tests := []struct {
+		name   string
+		signer *Signer
+		key    Key
+		token  string
+		after  time.Duration
+		err    error
+	}{
+		{name: "valid", signer: s, key: key, token: token},
+		{name: "other run", signer: s, key: other, token: token, err: ErrInvalidToken},
+		{name: "other secret", signer: resigned, key: key, token: token, err: ErrInvalidToken},
+		{name: "empty", signer: s, key: key, token: "", err: ErrInvalidToken},
+		{name: "tampered time", signer: s, key: key, token: "1" + token, err: ErrInvalidToken},
+		{name: "expired", signer: s, key: key, token: token, after: 2 * time.Hour, err: ErrTokenExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.signer.now = func() time.Time { return now.Add(tt.after) }
+			assert.Equal(t, tt.err, tt.signer.Verify(tt.key, tt.token))
+		})
+	}
+
+	_, err = NewSigner("", time.Hour)
+	assert.Error(t, err)
+}
@@ -0,0 +1,210 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package repoconfig
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Path is the configuration file of a repository, read from its default branch.
+const Path = ".github/test-infra.yaml"
+
+// DefaultTTL is how long the head of the default branch of a repository is cached.
+const DefaultTTL = 5 * time.Minute
+
+var eventTypePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,100}$`)
+
+// Command configures a command on the repository.
+type Command struct {
+	// EventType is the repository dispatch started by the command.
+	EventType string `yaml:"eventType,omitempty"`
+	// Ref is the reference used when the command is run without one.
+	Ref string `yaml:"ref,omitempty"`
+	// Iterations is the number of times each query is run when the command has no --iterations.
+	Iterations int `yaml:"iterations,omitempty"`
+}
+
+// Config is the bot configuration of a repository, e.g.
+//
+//	plugins:
+//	  build-docker: false
+//	commands:
+//	  run-perf:
+//	    eventType: run_perf
+//	    ref: main
+//	    iterations: 5
+//	labels:
+//	  perf: /run-perf main --iterations 3
+//
+// The methods of a nil Config return the defaults.
+type Config struct {
+	// Plugins enables or disables the plugins by name, they are enabled by default.
+	Plugins map[string]bool `yaml:"plugins,omitempty"`
+	// Commands configure the commands by name.
+	Commands map[string]Command `yaml:"commands,omitempty"`
+	// Labels map pull request labels to the command run by label-run-perf on each push.
+	Labels map[string]string `yaml:"labels,omitempty"`
+}
+
+// Parse decodes and validates a repository configuration.
+func Parse(data []byte) (*Config, error) {
+	var c Config
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (c *Config) validate() error {
+	for name, cmd := range c.Commands {
+		if cmd.EventType != "" && !eventTypePattern.MatchString(cmd.EventType) {
+			return fmt.Errorf("command %s: invalid eventType %q", name, cmd.EventType)
+		}
+		if cmd.Iterations < 0 {
+			return fmt.Errorf("command %s: negative iterations %d", name, cmd.Iterations)
+		}
+	}
+	for label, line := range c.Labels {
+		if !strings.HasPrefix(line, "/") {
+			return fmt.Errorf("label %s: %q is not a command", label, line)
+		}
+	}
+	return nil
+}
+
+// Enabled returns whether the plugin runs on the repository.
+func (c *Config) Enabled(plugin string) bool {
+	if c == nil {
+		return true
+	}
+	enabled, ok := c.Plugins[plugin]
+	return !ok || enabled
+}
+
+// EventType returns the repository dispatch started by the command, def when it is not configured.
+func (c *Config) EventType(command, def string) string {
+	if c == nil || c.Commands[command].EventType == "" {
+		return def
+	}
+	return c.Commands[command].EventType
+}
+
+// Ref returns the default reference of the command, or "".
+func (c *Config) Ref(command string) string {
+	if c == nil {
+		return ""
+	}
+	return c.Commands[command].Ref
+}
+
+// Iterations returns the default iterations of the command, or 0.
+func (c *Config) Iterations(command string) int {
+	if c == nil {
+		return 0
+	}
+	return c.Commands[command].Iterations
+}
+
+// Label returns the command line run by the label, and false when it is not configured.
+func (c *Config) Label(name string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	line, ok := c.Labels[name]
+	return line, ok
+}
+
+// Source reads the configuration file of a repository.
+type Source interface {
+	// DefaultBranchSHA returns the head commit of the default branch.
+	DefaultBranchSHA() (string, error)
+	// GetFile returns the content of the file at ref, and false when it does not exist.
+	GetFile(path, ref string) ([]byte, bool, error)
+}
+
+type entry struct {
+	sha       string
+	checkedAt time.Time
+	// config is the configuration at sha, or the last valid one when err is set.
+	config *Config
+	err    error
+}
+
+// Cache keeps the configuration of each repository at the head of its default branch. The head is checked
+// again after the TTL, or once invalidated, and the file is only fetched again once the branch moved.
+type Cache struct {
+	TTL     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	entries map[string]entry
+}
+
+func NewCache() *Cache {
+	return &Cache{TTL: DefaultTTL, now: time.Now, entries: map[string]entry{}}
+}
+
+// Invalidate checks the head of the default branch of owner/repo on the next Load, e.g. after a push on it.
+func (c *Cache) Invalidate(owner, repo string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[owner+"/"+repo]; ok {
+		e.checkedAt = time.Time{}
+		c.entries[owner+"/"+repo] = e
+	}
+}
+
+// Load returns the configuration of owner/repo, the empty configuration when the repository has none.
+// An invalid file is reported until the default branch moves, along with the last valid configuration,
+// nil when there was none. The last valid configuration is returned too when the file cannot be read.
+func (c *Cache) Load(owner, repo string, src Source) (*Config, error) {
+	key := owner + "/" + repo
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && c.now().Sub(e.checkedAt) < c.TTL {
+		return e.config, e.err
+	}
+	sha, err := src.DefaultBranchSHA()
+	if err != nil {
+		return e.config, err
+	}
+	if ok && e.sha == sha {
+		e.checkedAt = c.now()
+		c.store(key, e)
+		return e.config, e.err
+	}
+	next := entry{sha: sha, checkedAt: c.now(), config: &Config{}}
+	data, found, err := src.GetFile(Path, sha)
+	if err != nil {
+		return e.config, err
+	}
+	if found {
+		next.config, next.err = Parse(data)
+		if next.err != nil {
+			next.config = e.config
+			next.err = fmt.Errorf("invalid %s of %s at %s, %v", Path, key, sha, next.err)
+		}
+	}
+	c.store(key, next)
+	return next.config, next.err
+}
+
+func (c *Cache) store(key string, e entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = e
+}
@@ -0,0 +1,157 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package repoconfig
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testConfig = `
+plugins:
+  build-docker: false
+  perf-watch: true
+commands:
+  run-perf:
+    eventType: perf_run
+    ref: main
+    iterations: 5
+labels:
+  perf: /run-perf main --iterations 3
+`
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(testConfig))
+	assert.NoError(t, err)
+	assert.False(t, c.Enabled("build-docker"))
+	assert.True(t, c.Enabled("perf-watch"))
+	assert.True(t, c.Enabled("run-perf"))
+	assert.Equal(t, "perf_run", c.EventType("run-perf", "run_perf"))
+	assert.Equal(t, "rerun_perf", c.EventType("rerun-perf", "rerun_perf"))
+	assert.Equal(t, "main", c.Ref("run-perf"))
+	assert.Equal(t, "", c.Ref("build-docker"))
+	assert.Equal(t, 5, c.Iterations("run-perf"))
+	line, ok := c.Label("perf")
+	assert.True(t, ok)
+	assert.Equal(t, "/run-perf main --iterations 3", line)
+	_, ok = c.Label("bug")
+	assert.False(t, ok)
+
+	empty, err := Parse(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &Config{}, empty)
+
+	tests := []struct {
+		name        string
+		config      string
+		expectError string
+	}{
+		{name: "unknown field", config: "plugin:\n  run-perf: false\n", expectError: "yaml: unmarshal errors:\n  line 1: field plugin not found in type repoconfig.Config"},
+		{name: "event type", config: "commands:\n  run-perf:\n    eventType: run perf\n", expectError: `command run-perf: invalid eventType "run perf"`},
+		{name: "iterations", config: "commands:\n  run-perf:\n    iterations: -1\n", expectError: "command run-perf: negative iterations -1"},
+		{name: "label", config: "labels:\n  perf: run-perf main\n", expectError: `label perf: "run-perf main" is not a command`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.config))
+			assert.EqualError(t, err, tt.expectError)
+		})
+	}
+}
+
+func TestNilConfig(t *testing.T) {
+	var c *Config
+	assert.True(t, c.Enabled("run-perf"))
+	assert.Equal(t, "run_perf", c.EventType("run-perf", "run_perf"))
+	assert.Equal(t, "", c.Ref("run-perf"))
+	assert.Equal(t, 0, c.Iterations("run-perf"))
+	_, ok := c.Label("perf")
+	assert.False(t, ok)
+}
+
+// fakeSource serves the files of each commit, and counts the reads.
+type fakeSource struct {
+	head  string
+	files map[string]string
+	reads int
+}
+
+func (f *fakeSource) DefaultBranchSHA() (string, error) {
+	if f.head == "" {
+		return "", fmt.Errorf("no default branch")
+	}
+	return f.head, nil
+}
+
+func (f *fakeSource) GetFile(path, ref string) ([]byte, bool, error) {
+	f.reads++
+	if path != Path {
+		return nil, false, fmt.Errorf("unexpected path %s", path)
+	}
+	data, ok := f.files[ref]
+	return []byte(data), ok, nil
+}
+
+func TestCache(t *testing.T) {
+	src := &fakeSource{head: "a", files: map[string]string{
+		"b": testConfig,
+		"c": "plugins:\n  build-docker: false\n",
+		"d": "plugins: [run-perf]\n",
+	}}
+	cache := NewCache()
+	now := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	// no file, the defaults
+	c, err := cache.Load("datafuselabs", "databend", src)
+	assert.NoError(t, err)
+	assert.Equal(t, &Config{}, c)
+	_, err = cache.Load("datafuselabs", "databend", src)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, src.reads)
+
+	// the default branch moved, it is seen after the TTL
+	src.head = "b"
+	c, err = cache.Load("datafuselabs", "databend", src)
+	assert.NoError(t, err)
+	assert.True(t, c.Enabled("build-docker"), "the head is cached")
+	now = now.Add(DefaultTTL)
+	c, err = cache.Load("datafuselabs", "databend", src)
+	assert.NoError(t, err)
+	assert.False(t, c.Enabled("build-docker"))
+	assert.Equal(t, 2, src.reads)
+
+	// another repository
+	_, err = cache.Load("datafuselabs", "test-infra", src)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, src.reads)
+
+	// an invalid file is reported with the last valid configuration, and not read again
+	src.head = "c"
+	cache.Invalidate("datafuselabs", "databend")
+	c, err = cache.Load("datafuselabs", "databend", src)
+	assert.NoError(t, err)
+	assert.False(t, c.Enabled("build-docker"))
+	src.head = "d"
+	cache.Invalidate("datafuselabs", "databend")
+	for i := 0; i < 2; i++ {
+		c, err = cache.Load("datafuselabs", "databend", src)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid .github/test-infra.yaml of datafuselabs/databend at d, ")
+		assert.True(t, c.Enabled("run-perf"))
+		assert.False(t, c.Enabled("build-docker"), "the plugins disabled by the last valid file stay disabled")
+	}
+	assert.Equal(t, 5, src.reads)
+
+	src.head = ""
+	now = now.Add(DefaultTTL)
+	c, err = cache.Load("datafuselabs", "databend", src)
+	assert.EqualError(t, err, "no default branch")
+	assert.False(t, c.Enabled("build-docker"))
+	_, err = cache.Load("datafuselabs", "bend", src)
+	assert.EqualError(t, err, "no default branch")
+}
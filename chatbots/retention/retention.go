@@ -0,0 +1,282 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package retention
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/rs/zerolog/log"
+
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/utils"
+)
+
+// closedRecheck is how long the state of a closed pull request is cached, a reopened pull request
+// keeps its runs from then on. The open pull requests are checked on every sweep.
+const closedRecheck = 24 * time.Hour
+
+// metrics counts what the sweeps reclaimed, served with the other expvars on /debug/vars.
+var metrics = expvar.NewMap("retention")
+
+// Policy selects the runs deleted from the storage, the zero limits are off.
+type Policy struct {
+	// KeepRuns is the number of most recent runs kept per pull request.
+	KeepRuns int
+	// ClosedPRAge is how long the runs of a closed pull request are kept.
+	ClosedPRAge time.Duration
+	// MaxTotalSize is the size of the storage in bytes, the oldest runs are deleted beyond it.
+	MaxTotalSize int64
+}
+
+// PullRequests tells when the pull requests were closed.
+type PullRequests interface {
+	// ClosedAt returns when the pull request was closed, zero while it is open.
+	ClosedAt(ctx context.Context, owner, repo string, number int) (time.Time, error)
+}
+
+// GithubPullRequests reads the pull requests from github, and the issues of the bisections.
+type GithubPullRequests struct {
+	Client *github.Client
+}
+
+func (g GithubPullRequests) ClosedAt(ctx context.Context, owner, repo string, number int) (time.Time, error) {
+	issue, _, err := g.Client.Issues.Get(ctx, owner, repo, number)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return issue.GetClosedAt(), nil
+}
+
+// Stats are what a sweep reclaimed.
+type Stats struct {
+	Runs  int
+	Files int
+	Bytes int64
+}
+
+// run is a run and its files.
+type run struct {
+	key     utils.ArtifactKey
+	files   []utils.ObjectInfo
+	size    int64
+	modTime time.Time
+}
+
+// closedState is the cached closing time of a pull request.
+type closedState struct {
+	closedAt  time.Time
+	checkedAt time.Time
+}
+
+// Janitor deletes the runs outside of the policy from the storage and purges them in the registry.
+type Janitor struct {
+	store    utils.StorageInterface
+	registry registry.Registry
+	prs      PullRequests
+	now      func() time.Time
+	// mu guards policy.
+	mu     sync.RWMutex
+	policy Policy
+	// closedMu guards closed, the closed pull requests by owner, repo and number.
+	closedMu sync.Mutex
+	closed   map[utils.ArtifactKey]closedState
+}
+
+// New returns a janitor of store and of the runs recorded in runRegistry, prs may be nil when the policy keeps
+// the runs of the closed pull requests.
+func New(store utils.StorageInterface, runRegistry registry.Registry, prs PullRequests, policy Policy) *Janitor {
+	return &Janitor{store: store, registry: runRegistry, prs: prs, now: time.Now, policy: policy, closed: map[utils.ArtifactKey]closedState{}}
+}
+
+// SetPolicy replaces the policy, it applies from the next sweep.
+func (j *Janitor) SetPolicy(policy Policy) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.policy = policy
+}
+
+func (j *Janitor) currentPolicy() Policy {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.policy
+}
+
+// closedAt returns when the pull request was closed, zero while it is open. The closed pull requests
+// are cached for closedRecheck.
+func (j *Janitor) closedAt(ctx context.Context, pr utils.ArtifactKey, number int) (time.Time, error) {
+	j.closedMu.Lock()
+	c, ok := j.closed[pr]
+	j.closedMu.Unlock()
+	if ok && j.now().Sub(c.checkedAt) < closedRecheck {
+		return c.closedAt, nil
+	}
+	closedAt, err := j.prs.ClosedAt(ctx, pr.Owner, pr.Repo, number)
+	if err != nil {
+		return time.Time{}, err
+	}
+	j.closedMu.Lock()
+	defer j.closedMu.Unlock()
+	if closedAt.IsZero() {
+		delete(j.closed, pr)
+	} else {
+		j.closed[pr] = closedState{closedAt: closedAt, checkedAt: j.now()}
+	}
+	return closedAt, nil
+}
+
+// runs groups the files of the storage by run, the most recent runs first.
+func runs(files []utils.ObjectInfo) []*run {
+	byKey := map[utils.ArtifactKey]*run{}
+	var runs []*run
+	for _, f := range files {
+		k := f.Key.File("")
+		r, ok := byKey[k]
+		if !ok {
+			r = &run{key: k}
+			byKey[k] = r
+			runs = append(runs, r)
+		}
+		r.files = append(r.files, f)
+		r.size += f.Size
+		if f.ModTime.After(r.modTime) {
+			r.modTime = f.ModTime
+		}
+	}
+	sort.SliceStable(runs, func(i, j int) bool { return runs[i].modTime.After(runs[j].modTime) })
+	return runs
+}
+
+// expired returns the runs outside of the policy. The runs of the branches have no pull request,
+// only MaxTotalSize applies to them.
+func (j *Janitor) expired(ctx context.Context, policy Policy, runs []*run) []*run {
+	var expired []*run
+	deleted := map[*run]bool{}
+	kept := map[utils.ArtifactKey]int{}
+	closed := map[utils.ArtifactKey]bool{}
+	j.closedMu.Lock()
+	for pr, c := range j.closed {
+		if j.now().Sub(c.checkedAt) >= closedRecheck {
+			delete(j.closed, pr)
+		}
+	}
+	j.closedMu.Unlock()
+	for _, r := range runs {
+		number, err := strconv.Atoi(r.key.PR)
+		if err != nil {
+			continue
+		}
+		pr := utils.ArtifactKey{Owner: r.key.Owner, Repo: r.key.Repo, PR: r.key.PR}
+		if policy.ClosedPRAge > 0 && j.prs != nil {
+			if _, ok := closed[pr]; !ok {
+				closedAt, err := j.closedAt(ctx, pr, number)
+				if err != nil {
+					log.Error().Msgf("cannot get the state of pull request %s, %s", pr, err.Error())
+				}
+				closed[pr] = err == nil && !closedAt.IsZero() && j.now().Sub(closedAt) > policy.ClosedPRAge
+			}
+		}
+		kept[pr]++
+		if closed[pr] || policy.KeepRuns > 0 && kept[pr] > policy.KeepRuns {
+			deleted[r] = true
+			expired = append(expired, r)
+		}
+	}
+	if policy.MaxTotalSize <= 0 {
+		return expired
+	}
+	var total int64
+	for _, r := range runs {
+		if !deleted[r] {
+			total += r.size
+		}
+	}
+	for i := len(runs) - 1; i >= 0 && total > policy.MaxTotalSize; i-- {
+		if r := runs[i]; !deleted[r] {
+			total -= r.size
+			expired = append(expired, r)
+		}
+	}
+	return expired
+}
+
+// Sweep deletes the runs outside of the policy and returns what it reclaimed. It goes on
+// after a failed deletion, the run is deleted by a later sweep, and returns the first error.
+func (j *Janitor) Sweep(ctx context.Context) (Stats, error) {
+	var stats Stats
+	files, err := j.store.List(ctx, utils.ArtifactKey{})
+	if err != nil {
+		metrics.Add("errors", 1)
+		return stats, err
+	}
+	var first error
+	for _, r := range j.expired(ctx, j.currentPolicy(), runs(files)) {
+		var failed bool
+		for _, f := range r.files {
+			if err := j.store.Delete(ctx, f.Key); err != nil {
+				log.Error().Msgf("cannot delete %s, %s", f.Key, err.Error())
+				if first == nil {
+					first = err
+				}
+				failed = true
+				continue
+			}
+			stats.Files++
+			stats.Bytes += f.Size
+		}
+		if failed {
+			continue
+		}
+		stats.Runs++
+		j.purge(r)
+	}
+	metrics.Add("sweeps", 1)
+	metrics.Add("runs_deleted", int64(stats.Runs))
+	metrics.Add("files_deleted", int64(stats.Files))
+	metrics.Add("bytes_reclaimed", stats.Bytes)
+	if first != nil {
+		metrics.Add("errors", 1)
+	}
+	return stats, first
+}
+
+// purge drops the artifacts of the deleted run from its record, the runs recorded before the registry
+// have no record.
+func (j *Janitor) purge(r *run) {
+	if j.registry == nil {
+		return
+	}
+	key := registry.NewKey(r.key.Owner, r.key.Repo, r.key.PR, r.key.SHA, r.key.UUID)
+	if _, err := j.registry.Purge(key); err != nil && !errors.Is(err, registry.ErrNotFound) {
+		log.Error().Msgf("cannot purge the run %s, %s", r.key, err.Error())
+	}
+}
+
+// Start sweeps the storage every interval until ctx is done.
+func (j *Janitor) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				stats, err := j.Sweep(ctx)
+				if err != nil {
+					log.Error().Msgf("cannot sweep the storage, %s", err.Error())
+				}
+				if stats.Runs > 0 || stats.Files > 0 {
+					log.Info().Msgf("retention deleted %d runs, %d files, %d bytes", stats.Runs, stats.Files, stats.Bytes)
+				}
+			}
+		}
+	}()
+}
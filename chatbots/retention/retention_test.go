@@ -0,0 +1,231 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package retention
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"datafuselabs/test-infra/chatbots/registry"
+	"datafuselabs/test-infra/chatbots/utils"
+
+	"github.com/google/go-github/v35/github"
+	"github.com/stretchr/testify/assert"
+)
+
+var now = time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+
+// fakePullRequests are closed at the given times, the others are open.
+type fakePullRequests map[int]time.Time
+
+func (f fakePullRequests) ClosedAt(ctx context.Context, owner, repo string, number int) (time.Time, error) {
+	if number == 500 {
+		return time.Time{}, errors.New("github is down")
+	}
+	return f[number], nil
+}
+
+// countingPullRequests counts the calls to ClosedAt by pull request.
+type countingPullRequests struct {
+	fakePullRequests
+	mu    sync.Mutex
+	calls map[int]int
+}
+
+func (c *countingPullRequests) ClosedAt(ctx context.Context, owner, repo string, number int) (time.Time, error) {
+	c.mu.Lock()
+	c.calls[number]++
+	c.mu.Unlock()
+	return c.fakePullRequests.ClosedAt(ctx, owner, repo, number)
+}
+
+// brokenStorage fails to delete the files of the commit broken.
+type brokenStorage struct {
+	utils.FileStorage
+}
+
+func (s *brokenStorage) Delete(ctx context.Context, key utils.ArtifactKey) error {
+	if key.SHA == "broken" {
+		return errors.New("permission denied")
+	}
+	return s.FileStorage.Delete(ctx, key)
+}
+
+// putRun stores a run of size bytes in two files, modified age ago.
+func putRun(t *testing.T, s *brokenStorage, pr, sha, uuid string, size int, age time.Duration) {
+	key := utils.ArtifactKey{Owner: "datafuselabs", Repo: "databend", PR: pr, SHA: sha, UUID: uuid}
+	for _, name := range []string{"compare.json", "logs/current.log"} {
+		assert.NoError(t, s.Put(context.Background(), key.File(name), strings.NewReader(strings.Repeat("x", size/2))))
+		p := filepath.Join(s.BasePath, filepath.FromSlash(key.File(name).Path()))
+		assert.NoError(t, os.Chtimes(p, now.Add(-age), now.Add(-age)))
+	}
+}
+
+// remaining returns the runs left in the storage.
+func remaining(t *testing.T, s utils.StorageInterface) []string {
+	files, err := s.List(context.Background(), utils.ArtifactKey{})
+	assert.NoError(t, err)
+	var left []string
+	for _, r := range runs(files) {
+		left = append(left, r.key.PR+"/"+r.key.UUID)
+	}
+	return left
+}
+
+func TestSweep(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      Policy
+		expect      []string
+		expectStats Stats
+		expectError string
+	}{
+		{name: "no limit", expect: []string{"1/c", "1/b", "2/a", "main/b", "3/a", "1/a", "main/a", "4/a", "500/a"}},
+		{name: "keep runs", policy: Policy{KeepRuns: 1}, expect: []string{"1/c", "2/a", "main/b", "3/a", "main/a", "4/a", "500/a"},
+			expectStats: Stats{Runs: 2, Files: 4, Bytes: 2000}},
+		{name: "closed", policy: Policy{ClosedPRAge: 7 * 24 * time.Hour}, expect: []string{"1/c", "1/b", "main/b", "3/a", "1/a", "main/a", "500/a"},
+			expectStats: Stats{Runs: 2, Files: 4, Bytes: 2000}},
+		{name: "max total size", policy: Policy{MaxTotalSize: 5000}, expect: []string{"1/c", "1/b", "2/a", "main/b", "3/a"},
+			expectStats: Stats{Runs: 4, Files: 8, Bytes: 4000}},
+		{name: "every limit", policy: Policy{KeepRuns: 2, ClosedPRAge: 7 * 24 * time.Hour, MaxTotalSize: 3000}, expect: []string{"1/c", "1/b", "main/b"},
+			expectStats: Stats{Runs: 6, Files: 12, Bytes: 6000}},
+		{name: "failed delete", policy: Policy{KeepRuns: 1}, expect: []string{"1/c", "2/a", "main/b", "3/a", "main/a", "1/x", "4/a", "500/a"},
+			expectStats: Stats{Runs: 2, Files: 4, Bytes: 2000}, expectError: "permission denied"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &brokenStorage{utils.FileStorage{BasePath: t.TempDir()}}
+			putRun(t, s, "1", "sha1", "c", 1000, time.Hour)
+			putRun(t, s, "1", "sha1", "b", 1000, 2*time.Hour)
+			putRun(t, s, "1", "sha0", "a", 1000, 5*24*time.Hour)
+			putRun(t, s, "2", "sha2", "a", 1000, 3*time.Hour)
+			putRun(t, s, "3", "sha3", "a", 1000, 4*24*time.Hour)
+			putRun(t, s, "4", "sha4", "a", 1000, 20*24*time.Hour)
+			putRun(t, s, "500", "sha5", "a", 1000, 30*24*time.Hour)
+			putRun(t, s, "main", "sha6", "a", 1000, 6*24*time.Hour)
+			putRun(t, s, "main", "sha7", "b", 1000, 3*24*time.Hour)
+			if tt.expectError != "" {
+				putRun(t, s, "1", "broken", "x", 1000, 10*24*time.Hour)
+			}
+			j := New(s, nil, fakePullRequests{2: now.Add(-8 * 24 * time.Hour), 3: now.Add(-time.Hour), 4: now.Add(-10 * 24 * time.Hour)}, tt.policy)
+			j.now = func() time.Time { return now }
+
+			stats, err := j.Sweep(context.Background())
+			if tt.expectError != "" {
+				assert.EqualError(t, err, tt.expectError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectStats, stats)
+			assert.Equal(t, tt.expect, remaining(t, s))
+		})
+	}
+}
+
+func TestSweep_registry(t *testing.T) {
+	s := &brokenStorage{utils.FileStorage{BasePath: t.TempDir()}}
+	r, err := registry.NewBoltRegistry(filepath.Join(t.TempDir(), "registry.db"))
+	assert.NoError(t, err)
+	defer r.Close()
+	putRun(t, s, "1", "sha1", "b", 1000, time.Hour)
+	putRun(t, s, "1", "sha1", "a", 1000, 2*time.Hour)
+	putRun(t, s, "1", "sha0", "legacy", 1000, 3*time.Hour)
+	for _, uuid := range []string{"a", "b"} {
+		_, err := r.Record(registry.Run{
+			Key:       registry.Key{Org: "datafuselabs", Repo: "databend", PR: "1", SHA: "sha1", UUID: uuid},
+			Status:    "completed",
+			Artifacts: []registry.Artifact{{Path: "compare.json", Size: 500}, {Path: "logs/current.log", Size: 500}},
+		})
+		assert.NoError(t, err)
+	}
+
+	stats, err := New(s, r, nil, Policy{KeepRuns: 1}).Sweep(context.Background())
+	assert.NoError(t, err, "the runs without a record are deleted too")
+	assert.Equal(t, 2, stats.Runs)
+
+	kept, err := r.Get(registry.Key{Org: "datafuselabs", Repo: "databend", PR: "1", SHA: "sha1", UUID: "b"})
+	assert.NoError(t, err)
+	assert.Len(t, kept.Artifacts, 2)
+	assert.Nil(t, kept.PurgedAt)
+	purged, err := r.Get(registry.Key{Org: "datafuselabs", Repo: "databend", PR: "1", SHA: "sha1", UUID: "a"})
+	assert.NoError(t, err)
+	assert.Empty(t, purged.Artifacts)
+	assert.NotNil(t, purged.PurgedAt)
+}
+
+func TestSweep_closedCache(t *testing.T) {
+	s := &brokenStorage{utils.FileStorage{BasePath: t.TempDir()}}
+	putRun(t, s, "1", "sha1", "a", 1000, time.Hour)
+	putRun(t, s, "2", "sha2", "a", 1000, time.Hour)
+	prs := &countingPullRequests{fakePullRequests: fakePullRequests{2: now.Add(-time.Hour)}, calls: map[int]int{}}
+	j := New(s, nil, prs, Policy{ClosedPRAge: 7 * 24 * time.Hour})
+	current := now
+	j.now = func() time.Time { return current }
+
+	for i := 0; i < 3; i++ {
+		_, err := j.Sweep(context.Background())
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, map[int]int{1: 3, 2: 1}, prs.calls, "the open pull requests are checked on every sweep, the closed ones are cached")
+
+	current = now.Add(closedRecheck)
+	_, err := j.Sweep(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, prs.calls[2], "the closed pull requests are checked again after closedRecheck")
+	assert.Equal(t, []string{"1/a", "2/a"}, remaining(t, s))
+}
+
+func TestSweep_metrics(t *testing.T) {
+	s := &brokenStorage{utils.FileStorage{BasePath: t.TempDir()}}
+	putRun(t, s, "1", "sha1", "b", 1000, time.Hour)
+	putRun(t, s, "1", "sha1", "a", 1000, 2*time.Hour)
+	reclaimed := func() int64 {
+		if v, ok := metrics.Get("bytes_reclaimed").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := reclaimed()
+	_, err := New(s, nil, nil, Policy{KeepRuns: 1}).Sweep(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, before+1000, reclaimed())
+}
+
+func TestGithubPullRequests(t *testing.T) {
+	closedAt := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/datafuselabs/databend/issues/233":
+			fmt.Fprintf(w, `{"number":233,"state":"closed","closed_at":%q}`, closedAt.Format(time.RFC3339))
+		case "/repos/datafuselabs/databend/issues/234":
+			fmt.Fprint(w, `{"number":234,"state":"open"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	clt := github.NewClient(srv.Client())
+	clt.BaseURL, _ = url.Parse(srv.URL + "/")
+	prs := GithubPullRequests{Client: clt}
+
+	at, err := prs.ClosedAt(context.Background(), "datafuselabs", "databend", 233)
+	assert.NoError(t, err)
+	assert.True(t, closedAt.Equal(at))
+	at, err = prs.ClosedAt(context.Background(), "datafuselabs", "databend", 234)
+	assert.NoError(t, err)
+	assert.True(t, at.IsZero(), "open")
+	_, err = prs.ClosedAt(context.Background(), "datafuselabs", "databend", 1)
+	assert.Error(t, err)
+}
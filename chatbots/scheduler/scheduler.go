@@ -0,0 +1,381 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"datafuselabs/test-infra/chatbots/registry"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// Queued jobs wait for a free runner, Running jobs were dispatched and wait for their run to complete.
+	Queued  = "queued"
+	Running = "running"
+
+	// PriorityBranch is the priority of the runs measuring a branch, they go before the pull requests.
+	PriorityBranch = 0
+	PriorityPR     = 1
+
+	DefaultLimit    = 1
+	DefaultTimeout  = 3 * time.Hour
+	DefaultDuration = 45 * time.Minute
+)
+
+var queueBucket = []byte("queue")
+
+// Job is a run waiting for a runner or running on one.
+type Job struct {
+	Run registry.Run `json:"run"`
+	// EventType is the repository dispatch starting the workflow, Payloads are sent along.
+	EventType  string            `json:"eventType"`
+	Payloads   map[string]string `json:"payloads,omitempty"`
+	Priority   int               `json:"priority"`
+	State      string            `json:"state"`
+	EnqueuedAt time.Time         `json:"enqueuedAt"`
+	StartedAt  time.Time         `json:"startedAt,omitempty"`
+}
+
+// NewJob returns the job of a run, the runs of a branch have priority over the pull requests.
+func NewJob(eventType string, run registry.Run, payloads map[string]string) Job {
+	priority := PriorityPR
+	if run.Branch != "" {
+		priority = PriorityBranch
+	}
+	return Job{Run: run, EventType: eventType, Payloads: payloads, Priority: priority}
+}
+
+// group returns the jobs superseding each other: the runs of the same dispatch on a pull request or a branch.
+// The runs of a bisection measure different commits on purpose, they are never superseded.
+func (j Job) group() string {
+	if j.Run.Bisection != "" {
+		return ""
+	}
+	return strings.Join([]string{j.Run.Org, j.Run.Repo, j.Run.Scope(), j.Run.DispatchName}, "/")
+}
+
+// Entry is a job of the queue with its position among the queued jobs, from 1, and its estimated start.
+// Running jobs have no position.
+type Entry struct {
+	Job
+	Position int           `json:"position,omitempty"`
+	ETA      time.Duration `json:"eta"`
+}
+
+// Dispatcher starts the workflow of a job.
+type Dispatcher func(Job) error
+
+// Expirer is given the running jobs freed after Options.Timeout, their run never completed.
+type Expirer func(Job)
+
+// Options configures the scheduler.
+type Options struct {
+	// Limit is the number of jobs running at once, the capacity of the runners.
+	Limit int
+	// Timeout frees the runner of a job whose run never completed.
+	Timeout time.Duration
+	// Duration is the expected duration of a run, used to estimate when the queued jobs start.
+	Duration time.Duration
+}
+
+// Scheduler keeps the queue of the dispatched runs in a bbolt bucket, usually in the run registry database,
+// and dispatches them while runners are free. The queue survives restarts.
+type Scheduler struct {
+	db  *bolt.DB
+	now func() time.Time
+
+	// optsMu guards opts, which may be changed while the scheduler runs.
+	optsMu sync.RWMutex
+	opts   Options
+
+	// mu serializes the scheduling so a runner is not given twice.
+	mu       sync.Mutex
+	dispatch Dispatcher
+	expire   Expirer
+}
+
+// withDefaults returns the options with the defaults in place of the unset ones.
+func (o Options) withDefaults() Options {
+	if o.Limit <= 0 {
+		o.Limit = DefaultLimit
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.Duration <= 0 {
+		o.Duration = DefaultDuration
+	}
+	return o
+}
+
+// New returns a scheduler keeping its queue in db. It dispatches nothing until started.
+func New(db *bolt.DB, opts Options) (*Scheduler, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(queueBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Scheduler{db: db, opts: opts.withDefaults(), now: time.Now}, nil
+}
+
+// SetOptions replaces the options, they apply from the next scheduling.
+func (s *Scheduler) SetOptions(opts Options) {
+	s.optsMu.Lock()
+	defer s.optsMu.Unlock()
+	s.opts = opts.withDefaults()
+}
+
+func (s *Scheduler) options() Options {
+	s.optsMu.RLock()
+	defer s.optsMu.RUnlock()
+	return s.opts
+}
+
+// Limit returns the number of jobs running at once.
+func (s *Scheduler) Limit() int {
+	return s.options().Limit
+}
+
+// Start dispatches the queued jobs with dispatch, then checks the runners every interval until ctx is done.
+// The timed out jobs are given to expire.
+func (s *Scheduler) Start(ctx context.Context, dispatch Dispatcher, expire Expirer, interval time.Duration) {
+	s.mu.Lock()
+	s.dispatch, s.expire = dispatch, expire
+	s.mu.Unlock()
+	if err := s.Schedule(); err != nil {
+		log.Error().Msgf("cannot schedule the queued runs, %s", err.Error())
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Schedule(); err != nil {
+					log.Error().Msgf("cannot schedule the queued runs, %s", err.Error())
+				}
+			}
+		}
+	}()
+}
+
+func (s *Scheduler) jobs(tx *bolt.Tx) ([]Job, error) {
+	var jobs []Job
+	err := tx.Bucket(queueBucket).ForEach(func(_, v []byte) error {
+		var j Job
+		if err := json.Unmarshal(v, &j); err != nil {
+			return err
+		}
+		jobs = append(jobs, j)
+		return nil
+	})
+	return jobs, err
+}
+
+func put(tx *bolt.Tx, j Job) error {
+	v, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(queueBucket).Put([]byte(j.Run.Key.String()), v)
+}
+
+// Enqueue adds a job to the queue and returns the queued jobs it superseded,
+// the older job of the same pull request and dispatch. Running jobs are never superseded.
+// The error of the job dispatched right away is returned, the job is dropped from the queue.
+func (s *Scheduler) Enqueue(job Job) ([]Job, error) {
+	if err := job.Run.Key.Validate(); err != nil {
+		return nil, err
+	}
+	job.State, job.EnqueuedAt, job.StartedAt = Queued, s.now(), time.Time{}
+	var superseded []Job
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		jobs, err := s.jobs(tx)
+		if err != nil {
+			return err
+		}
+		for _, j := range jobs {
+			if j.State == Queued && job.group() != "" && j.group() == job.group() {
+				if err := tx.Bucket(queueBucket).Delete([]byte(j.Run.Key.String())); err != nil {
+					return err
+				}
+				superseded = append(superseded, j)
+			}
+		}
+		return put(tx, job)
+	})
+	if err != nil {
+		return nil, err
+	}
+	failed, err := s.schedule()
+	if err != nil {
+		return superseded, err
+	}
+	return superseded, failed[job.Run.Key]
+}
+
+// Finish frees the runner of the run, or drops it from the queue, and dispatches the next jobs.
+func (s *Scheduler) Finish(key registry.Key) error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(queueBucket).Delete([]byte(key.String()))
+	})
+	if err != nil {
+		return err
+	}
+	return s.Schedule()
+}
+
+// order sorts the jobs: running first, then by priority and arrival.
+func order(jobs []Job) {
+	sort.SliceStable(jobs, func(a, b int) bool {
+		ja, jb := jobs[a], jobs[b]
+		if ja.State != jb.State {
+			return ja.State == Running
+		}
+		if ja.Priority != jb.Priority {
+			return ja.Priority < jb.Priority
+		}
+		return ja.EnqueuedAt.Before(jb.EnqueuedAt)
+	})
+}
+
+// Schedule frees the runners of the timed out jobs, given to the expirer, and dispatches the queued jobs
+// while runners are free. A job whose dispatch fails is dropped, its run is recorded as failed by the dispatcher.
+func (s *Scheduler) Schedule() error {
+	_, err := s.schedule()
+	return err
+}
+
+// schedule is Schedule returning the dispatch errors of the dropped jobs.
+func (s *Scheduler) schedule() (map[registry.Key]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dispatch == nil {
+		return nil, nil
+	}
+	var next, timedOut []Job
+	opts := s.options()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		jobs, err := s.jobs(tx)
+		if err != nil {
+			return err
+		}
+		order(jobs)
+		running := 0
+		for _, j := range jobs {
+			switch {
+			case j.State == Running && s.now().Sub(j.StartedAt) > opts.Timeout:
+				log.Warn().Msgf("run %s timed out after %s, freeing its runner", j.Run.Key.String(), opts.Timeout)
+				if err := tx.Bucket(queueBucket).Delete([]byte(j.Run.Key.String())); err != nil {
+					return err
+				}
+				timedOut = append(timedOut, j)
+			case j.State == Running:
+				running++
+			case running+len(next) < opts.Limit:
+				j.State, j.StartedAt = Running, s.now()
+				if err := put(tx, j); err != nil {
+					return err
+				}
+				next = append(next, j)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range timedOut {
+		if s.expire != nil {
+			s.expire(j)
+		}
+	}
+	failed := map[registry.Key]error{}
+	for _, j := range next {
+		log.Info().Msgf("dispatching run %s", j.Run.Key.String())
+		if derr := s.dispatch(j); derr != nil {
+			log.Error().Msgf("cannot dispatch run %s, %s", j.Run.Key.String(), derr.Error())
+			failed[j.Run.Key] = derr
+			if err := s.db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket(queueBucket).Delete([]byte(j.Run.Key.String()))
+			}); err != nil {
+				return failed, err
+			}
+		}
+	}
+	return failed, nil
+}
+
+// Queue returns the running jobs then the queued ones in the order they will be dispatched,
+// with an estimate of the time left before they start.
+func (s *Scheduler) Queue() ([]Entry, error) {
+	var jobs []Job
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		jobs, err = s.jobs(tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	order(jobs)
+	opts := s.options()
+	// free holds the time left before each runner is free
+	free := make([]time.Duration, opts.Limit)
+	slot, queued := 0, 0
+	var entries []Entry
+	for _, j := range jobs {
+		e := Entry{Job: j}
+		if j.State == Running {
+			if slot < len(free) {
+				left := opts.Duration - s.now().Sub(j.StartedAt)
+				if left < 0 {
+					left = 0
+				}
+				free[slot] = left
+				slot++
+			}
+		} else {
+			queued++
+			e.Position = queued
+			earliest := 0
+			for i := range free {
+				if free[i] < free[earliest] {
+					earliest = i
+				}
+			}
+			e.ETA = free[earliest]
+			free[earliest] += opts.Duration
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Position returns the queue entry of the run.
+func (s *Scheduler) Position(key registry.Key) (Entry, error) {
+	entries, err := s.Queue()
+	if err != nil {
+		return Entry{}, err
+	}
+	for _, e := range entries {
+		if e.Run.Key == key {
+			return e, nil
+		}
+	}
+	return Entry{}, fmt.Errorf("run %s is not queued", key.String())
+}
@@ -0,0 +1,149 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package scheduler
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"datafuselabs/test-infra/chatbots/registry"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newFakeScheduler(t *testing.T, limit int) (*Scheduler, *fakeClock, *[]string) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "queue.db"), 0600, nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	s, err := New(db, Options{Limit: limit, Timeout: 2 * time.Hour, Duration: time.Hour})
+	assert.NoError(t, err)
+	clock := &fakeClock{t: time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)}
+	s.now = clock.now
+	var dispatched []string
+	s.dispatch = func(j Job) error {
+		if j.Run.SHA == "broken" {
+			return fmt.Errorf("dispatch failed")
+		}
+		dispatched = append(dispatched, j.Run.UUID)
+		return nil
+	}
+	return s, clock, &dispatched
+}
+
+// newFakeJob returns a run-perf job of a pull request or, with a "branch:" scope, of a branch.
+func newFakeJob(scope, uuid string) Job {
+	return NewJob("run_perf", registry.Run{
+		Key:          registry.NewKey("datafuselabs", "databend", scope, "foo", uuid),
+		DispatchName: "run-perf",
+	}, nil)
+}
+
+func uuids(entries []Entry) []string {
+	var res []string
+	for _, e := range entries {
+		res = append(res, e.Run.UUID)
+	}
+	return res
+}
+
+func TestScheduler(t *testing.T) {
+	s, clock, dispatched := newFakeScheduler(t, 1)
+	var expired []string
+	s.expire = func(j Job) { expired = append(expired, j.Run.UUID) }
+	enqueue := func(j Job) []Job {
+		clock.t = clock.t.Add(time.Minute)
+		superseded, err := s.Enqueue(j)
+		assert.NoError(t, err)
+		return superseded
+	}
+	assert.Empty(t, enqueue(newFakeJob("1", "a")))
+	assert.Empty(t, enqueue(newFakeJob("2", "b")))
+	assert.Empty(t, enqueue(newFakeJob("3", "c")))
+	// a newer request of pull request 2 supersedes its queued one
+	superseded := enqueue(newFakeJob("2", "d"))
+	assert.Equal(t, []string{"b"}, uuids([]Entry{{Job: superseded[0]}}))
+	// the running job of pull request 1 is not superseded
+	assert.Empty(t, enqueue(newFakeJob("1", "e")))
+	// the default branch goes first
+	assert.Empty(t, enqueue(newFakeJob("branch:main", "f")))
+	assert.Equal(t, []string{"a"}, *dispatched, "one runner")
+
+	entries, err := s.Queue()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "f", "c", "d", "e"}, uuids(entries))
+	assert.Equal(t, 0, entries[0].Position)
+	assert.Equal(t, []int{1, 2, 3, 4}, []int{entries[1].Position, entries[2].Position, entries[3].Position, entries[4].Position})
+	// a started 5 minutes ago and takes an hour
+	assert.Equal(t, []time.Duration{55 * time.Minute, 115 * time.Minute}, []time.Duration{entries[1].ETA, entries[2].ETA})
+
+	assert.NoError(t, s.Finish(entries[0].Run.Key))
+	assert.Equal(t, []string{"a", "f"}, *dispatched)
+
+	// the runner of a run which never completes is freed after the timeout
+	clock.t = clock.t.Add(3 * time.Hour)
+	assert.NoError(t, s.Schedule())
+	assert.Equal(t, []string{"a", "f", "c"}, *dispatched)
+	assert.Equal(t, []string{"f"}, expired, "the timed out job is reported")
+
+	e, err := s.Position(newFakeJob("1", "e").Run.Key)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, e.Position)
+	_, err = s.Position(newFakeJob("1", "a").Run.Key)
+	assert.Error(t, err)
+}
+
+func TestScheduler_limit(t *testing.T) {
+	s, _, dispatched := newFakeScheduler(t, 2)
+	broken := newFakeJob("1", "a")
+	broken.Run.SHA = "broken"
+	_, err := s.Enqueue(broken)
+	assert.EqualError(t, err, "dispatch failed", "the dispatch error of the enqueued job")
+	for _, j := range []Job{newFakeJob("2", "b"), newFakeJob("3", "c"), newFakeJob("4", "d")} {
+		_, err := s.Enqueue(j)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, []string{"b", "c"}, *dispatched, "the failed dispatch is dropped and frees its runner")
+	entries, err := s.Queue()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "d"}, uuids(entries))
+	assert.Equal(t, time.Hour, entries[2].ETA)
+
+	// a larger pool takes the queued job
+	s.SetOptions(Options{Limit: 3})
+	assert.Equal(t, 3, s.Limit())
+	assert.Equal(t, DefaultDuration, s.options().Duration)
+	assert.NoError(t, s.Schedule())
+	assert.Equal(t, []string{"b", "c", "d"}, *dispatched)
+}
+
+func TestScheduler_bisection(t *testing.T) {
+	s, _, _ := newFakeScheduler(t, 1)
+	for _, uuid := range []string{"a", "b", "c"} {
+		j := newFakeJob("1", uuid)
+		j.Run.Bisection = "1"
+		superseded, err := s.Enqueue(j)
+		assert.NoError(t, err)
+		assert.Empty(t, superseded, "the runs of a bisection don't supersede each other")
+	}
+	entries, err := s.Queue()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3)
+}
+
+func TestScheduler_notStarted(t *testing.T) {
+	s, _, dispatched := newFakeScheduler(t, 1)
+	s.dispatch = nil
+	_, err := s.Enqueue(newFakeJob("1", "a"))
+	assert.NoError(t, err)
+	assert.Empty(t, *dispatched)
+	_, err = s.Enqueue(Job{})
+	assert.Error(t, err)
+}
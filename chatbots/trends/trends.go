@@ -0,0 +1,216 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package trends
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"datafuselabs/test-infra/pkg/compare"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ReleasesBranch is the series of the tagged releases, their points are keyed by tag.
+const ReleasesBranch = "releases"
+
+var trendsBucket = []byte("trends")
+
+// Point is the measurement of a query for a commit of a branch.
+type Point struct {
+	Branch string `json:"branch"`
+	Query  string `json:"query"`
+	// SHA is the measured commit, or the tag for ReleasesBranch.
+	SHA string `json:"sha"`
+	// UUID is the run which measured the commit.
+	UUID string    `json:"uuid,omitempty"`
+	Time time.Time `json:"time"`
+	// Median is the median of Times, in seconds.
+	Median float64   `json:"median"`
+	Times  []float64 `json:"times,omitempty"`
+}
+
+func (p Point) key() []byte {
+	return []byte(strings.Join([]string{p.Branch, p.Query, p.SHA}, "/"))
+}
+
+func (p Point) validate() error {
+	for name, v := range map[string]string{"branch": p.Branch, "query": p.Query, "sha": p.SHA} {
+		if v == "" {
+			return fmt.Errorf("missing point %s", name)
+		}
+		if strings.Contains(v, "/") {
+			return fmt.Errorf("invalid point %s %q", name, v)
+		}
+	}
+	return nil
+}
+
+// Store keeps the per-query time series of the branches in a bbolt bucket.
+// A point is keyed by branch, query and commit so measuring a commit again replaces its point.
+type Store struct {
+	db *bolt.DB
+}
+
+// NewStore returns a store keeping its bucket in db, usually the run registry database.
+func NewStore(db *bolt.DB) (*Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(trendsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+// Add records the points, replacing the previous points of the same commits.
+func (s *Store) Add(points ...Point) error {
+	for _, p := range points {
+		if err := p.validate(); err != nil {
+			return err
+		}
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(trendsBucket)
+		for _, p := range points {
+			v, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(p.key(), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// scan returns the points whose key starts with prefix, oldest first.
+func (s *Store) scan(prefix []byte) ([]Point, error) {
+	var points []Point
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(trendsBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var p Point
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			points = append(points, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+	return points, nil
+}
+
+// Series returns the last limit points of a query on a branch, oldest first, every point if limit <= 0.
+func (s *Store) Series(branch, query string, limit int) ([]Point, error) {
+	points, err := s.scan([]byte(branch + "/" + query + "/"))
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(points) > limit {
+		points = points[len(points)-limit:]
+	}
+	return points, nil
+}
+
+// Queries returns the sorted names of the queries measured on a branch.
+func (s *Store) Queries(branch string) ([]string, error) {
+	points, err := s.scan([]byte(branch + "/"))
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var queries []string
+	for _, p := range points {
+		if !seen[p.Query] {
+			seen[p.Query] = true
+			queries = append(queries, p.Query)
+		}
+	}
+	sort.Strings(queries)
+	return queries, nil
+}
+
+// Baseline returns the rolling baseline of a branch: for each query, the medians of its last n points.
+// Comparing a run with it measures the change against the recent history of the branch
+// instead of a single reference build.
+func (s *Store) Baseline(branch string, n int) ([]compare.QueryRun, error) {
+	return s.BaselineBefore(branch, time.Time{}, n)
+}
+
+// BaselineBefore returns the rolling baseline of a branch made of the points measured before t,
+// so a run is not part of the baseline it is compared with. A zero t keeps every point.
+func (s *Store) BaselineBefore(branch string, t time.Time, n int) ([]compare.QueryRun, error) {
+	queries, err := s.Queries(branch)
+	if err != nil {
+		return nil, err
+	}
+	baseline := []compare.QueryRun{}
+	for _, q := range queries {
+		points, err := s.Series(branch, q, 0)
+		if err != nil {
+			return nil, err
+		}
+		if !t.IsZero() {
+			i := sort.Search(len(points), func(i int) bool { return !points[i].Time.Before(t) })
+			points = points[:i]
+		}
+		if n > 0 && len(points) > n {
+			points = points[len(points)-n:]
+		}
+		if len(points) == 0 {
+			continue
+		}
+		run := compare.QueryRun{Name: q}
+		for _, p := range points {
+			run.Times = append(run.Times, p.Median)
+		}
+		baseline = append(baseline, run)
+	}
+	return baseline, nil
+}
+
+// Compare compares the current build of a report with a baseline, with the options of the report.
+func Compare(report *compare.Report, baseline []compare.QueryRun) (*compare.Report, error) {
+	var current []compare.QueryRun
+	for _, q := range report.Queries {
+		current = append(current, compare.QueryRun{Name: q.Name, Query: q.Query, Times: q.CurrentTimes})
+	}
+	opts := report.Options
+	if opts.Method == "" {
+		opts = compare.DefaultOptions()
+	}
+	res, err := compare.Compare(current, baseline, opts)
+	if err != nil {
+		return nil, err
+	}
+	res.Current = report.Current
+	return res, nil
+}
+
+// FromReport returns the points of one side of a comparison report.
+// ref selects the reference times instead of the current ones.
+func FromReport(report *compare.Report, branch, sha, uuid string, ref bool, at time.Time) []Point {
+	var points []Point
+	for _, q := range report.Queries {
+		p := Point{Branch: branch, Query: q.Name, SHA: sha, UUID: uuid, Time: at, Median: q.Current, Times: q.CurrentTimes}
+		if ref {
+			p.Median, p.Times = q.Ref, q.RefTimes
+		}
+		points = append(points, p)
+	}
+	return points
+}
@@ -0,0 +1,99 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package trends
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"datafuselabs/test-infra/pkg/compare"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newFakeStore(t *testing.T) *Store {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "trends.db"), 0600, nil)
+	assert.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	s, err := NewStore(db)
+	assert.NoError(t, err)
+	return s
+}
+
+func TestStore(t *testing.T) {
+	s := newFakeStore(t)
+	start := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	var points []Point
+	for i, sha := range []string{"a", "b", "c", "d"} {
+		at := start.Add(time.Duration(i) * time.Hour)
+		points = append(points,
+			Point{Branch: "main", Query: "Q1", SHA: sha, Time: at, Median: float64(i + 1)},
+			Point{Branch: "main", Query: "Q10", SHA: sha, Time: at, Median: 10},
+		)
+	}
+	points = append(points, Point{Branch: "main-2", Query: "Q2", SHA: "a", Time: start, Median: 1})
+	assert.NoError(t, s.Add(points...))
+	// measuring a commit again replaces its point
+	assert.NoError(t, s.Add(Point{Branch: "main", Query: "Q1", SHA: "d", Time: start.Add(5 * time.Hour), Median: 8}))
+
+	shas := func(points []Point) []string {
+		var res []string
+		for _, p := range points {
+			res = append(res, p.SHA)
+		}
+		return res
+	}
+	tests := []struct {
+		name   string
+		query  string
+		limit  int
+		expect []string
+	}{
+		{name: "all", query: "Q1", expect: []string{"a", "b", "c", "d"}},
+		{name: "limited", query: "Q1", limit: 2, expect: []string{"c", "d"}},
+		{name: "prefix of another query", query: "Q10", limit: 1, expect: []string{"d"}},
+		{name: "unknown", query: "Q3", expect: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			series, err := s.Series("main", tt.query, tt.limit)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, shas(series))
+		})
+	}
+
+	queries, err := s.Queries("main")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Q1", "Q10"}, queries)
+
+	baseline, err := s.Baseline("main", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []compare.QueryRun{
+		{Name: "Q1", Times: []float64{2, 3, 8}},
+		{Name: "Q10", Times: []float64{10, 10, 10}},
+	}, baseline)
+
+	baseline, err = s.BaselineBefore("main", start.Add(2*time.Hour), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []compare.QueryRun{
+		{Name: "Q1", Times: []float64{1, 2}},
+		{Name: "Q10", Times: []float64{10, 10}},
+	}, baseline, "the points measured from the cut off time are not part of the baseline")
+
+	assert.Error(t, s.Add(Point{Branch: "main", Query: "Q/1", SHA: "a"}))
+	assert.Error(t, s.Add(Point{Branch: "main", Query: "Q1"}))
+}
+
+func TestFromReport(t *testing.T) {
+	report := &compare.Report{Queries: []compare.QueryResult{
+		{Name: "Q1", Current: 1.2, Ref: 1.0, CurrentTimes: []float64{1.2}, RefTimes: []float64{1.0}},
+	}}
+	at := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []Point{{Branch: "main", Query: "Q1", SHA: "a", UUID: "1", Time: at, Median: 1.2, Times: []float64{1.2}}},
+		FromReport(report, "main", "a", "1", false, at))
+	assert.Equal(t, []Point{{Branch: ReleasesBranch, Query: "Q1", SHA: "v0.4.0", UUID: "1", Time: at, Median: 1.0, Times: []float64{1.0}}},
+		FromReport(report, ReleasesBranch, "v0.4.0", "1", true, at))
+}
@@ -0,0 +1,113 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// The storage backends.
+const (
+	FileBackend = "file"
+	COSBackend  = "cos"
+	S3Backend   = "s3"
+)
+
+// Backends are the names of the storage backends.
+var Backends = []string{FileBackend, COSBackend, S3Backend}
+
+// lookupEnv reads the credentials of the environment.
+var lookupEnv = os.LookupEnv
+
+// StorageOptions selects and configures a storage backend.
+type StorageOptions struct {
+	Backend string
+	// Path is the root directory of the file backend.
+	Path   string
+	Region string
+	Bucket string
+	// Endpoint is the service endpoint of the cos and s3 backends, e.g. cos.ap-hongkong.myqcloud.com,
+	// the default one of the region when empty. The scheme is https unless given.
+	Endpoint  string
+	SecretID  string
+	SecretKey string
+	// CredentialsFile and Profile are the shared credentials the s3 backend reads
+	// when no secret is given and none is in the environment, see LoadS3Credentials.
+	CredentialsFile string
+	Profile         string
+	// PathStyle addresses the s3 bucket as a path of the endpoint rather than as a subdomain.
+	PathStyle bool
+	// ServerSideEncryption and KMSKeyID encrypt the objects of the s3 backend, see S3Options.
+	ServerSideEncryption string
+	KMSKeyID             string
+}
+
+// withScheme returns the endpoint with the https scheme unless it has one.
+func withScheme(endpoint string) string {
+	if strings.Contains(endpoint, "://") {
+		return endpoint
+	}
+	return "https://" + endpoint
+}
+
+// cosBucketURL returns the url of the bucket, a subdomain of the endpoint.
+func (o StorageOptions) cosBucketURL() (string, error) {
+	if o.Endpoint == "" {
+		return fmt.Sprintf("https://%s.cos.%s.myqcloud.com", o.Bucket, o.Region), nil
+	}
+	u, err := url.Parse(withScheme(o.Endpoint))
+	if err != nil {
+		return "", fmt.Errorf("cos: invalid endpoint, %v", err)
+	}
+	u.Host = o.Bucket + "." + u.Host
+	return u.String(), nil
+}
+
+// NewStorage returns the storage backend selected by the options.
+func NewStorage(o StorageOptions) (StorageInterface, error) {
+	switch o.Backend {
+	case FileBackend:
+		if o.Path == "" {
+			return nil, fmt.Errorf("file: path is required")
+		}
+		return &FileStorage{BasePath: o.Path}, nil
+	case COSBackend:
+		if o.Bucket == "" || o.Endpoint == "" && o.Region == "" {
+			return nil, fmt.Errorf("cos: bucket and region or endpoint are required")
+		}
+		bucketURL, err := o.cosBucketURL()
+		if err != nil {
+			return nil, err
+		}
+		return NewCOSStorage(bucketURL, o.SecretID, o.SecretKey, nil)
+	case S3Backend:
+		endpoint := o.Endpoint
+		if endpoint != "" {
+			endpoint = withScheme(endpoint)
+		}
+		creds := S3Credentials{AccessKeyID: o.SecretID, SecretAccessKey: o.SecretKey}
+		if o.SecretID == "" || o.SecretKey == "" {
+			var err error
+			creds, err = LoadS3Credentials(lookupEnv, o.CredentialsFile, o.Profile)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return NewS3Storage(S3Options{
+			Endpoint:             endpoint,
+			Region:               o.Region,
+			Bucket:               o.Bucket,
+			AccessKeyID:          creds.AccessKeyID,
+			SecretAccessKey:      creds.SecretAccessKey,
+			SessionToken:         creds.SessionToken,
+			PathStyle:            o.PathStyle,
+			ServerSideEncryption: o.ServerSideEncryption,
+			KMSKeyID:             o.KMSKeyID,
+		})
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", o.Backend)
+}
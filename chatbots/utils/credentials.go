@@ -0,0 +1,113 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The environment variables of the AWS credentials, as the AWS tools read them.
+const (
+	EnvAWSAccessKeyID     = "AWS_ACCESS_KEY_ID"
+	EnvAWSSecretAccessKey = "AWS_SECRET_ACCESS_KEY"
+	EnvAWSSessionToken    = "AWS_SESSION_TOKEN"
+	EnvAWSProfile         = "AWS_PROFILE"
+	EnvAWSCredentialsFile = "AWS_SHARED_CREDENTIALS_FILE"
+)
+
+// S3Credentials are the credentials of the requests to an S3 compatible service.
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is set with temporary credentials.
+	SessionToken string
+}
+
+// LoadS3Credentials reads the credentials from the environment with lookup, else from the profile of
+// the shared credentials file. An empty file defaults to $AWS_SHARED_CREDENTIALS_FILE then ~/.aws/credentials,
+// an empty profile to $AWS_PROFILE then default.
+func LoadS3Credentials(lookup func(string) (string, bool), file, profile string) (S3Credentials, error) {
+	get := func(name string) string {
+		v, _ := lookup(name)
+		return v
+	}
+	if id, key := get(EnvAWSAccessKeyID), get(EnvAWSSecretAccessKey); id != "" && key != "" {
+		return S3Credentials{AccessKeyID: id, SecretAccessKey: key, SessionToken: get(EnvAWSSessionToken)}, nil
+	}
+	if file == "" {
+		file = get(EnvAWSCredentialsFile)
+	}
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return S3Credentials{}, fmt.Errorf("s3: no credentials in the environment, %v", err)
+		}
+		file = filepath.Join(home, ".aws", "credentials")
+	}
+	if profile == "" {
+		profile = get(EnvAWSProfile)
+	}
+	if profile == "" {
+		profile = "default"
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return S3Credentials{}, fmt.Errorf("s3: no credentials in the environment, %v", err)
+	}
+	c, err := parseCredentials(data, profile)
+	if err != nil {
+		return S3Credentials{}, fmt.Errorf("s3: %s: %v", file, err)
+	}
+	return c, nil
+}
+
+// parseCredentials returns the credentials of the profile of an ini credentials file.
+func parseCredentials(data []byte, profile string) (S3Credentials, error) {
+	var c S3Credentials
+	section, found := "", false
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			found = found || section == profile
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "aws_access_key_id":
+			c.AccessKeyID = v
+		case "aws_secret_access_key":
+			c.SecretAccessKey = v
+		case "aws_session_token":
+			c.SessionToken = v
+		}
+	}
+	if err := s.Err(); err != nil {
+		return c, err
+	}
+	if !found {
+		return c, fmt.Errorf("no profile %s", profile)
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return c, fmt.Errorf("profile %s has no aws_access_key_id or aws_secret_access_key", profile)
+	}
+	return c, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidKey is returned for the keys which are incomplete or would escape the layout.
+	ErrInvalidKey = errors.New("invalid artifact key")
+	// ErrSignedURLNotSupported is returned by the storages which can't sign urls, the chatbot serves their files.
+	ErrSignedURLNotSupported = errors.New("signed urls are not supported")
+)
+
+// ArtifactKey identifies a file uploaded by a run. With its trailing fields empty it is the prefix
+// of the files of an owner, a repository, a pull request, a commit or a run.
+type ArtifactKey struct {
+	Owner string
+	Repo  string
+	PR    string
+	SHA   string
+	UUID  string
+	// Name is the path of the file in the run, e.g. logs/current.log.
+	Name string
+}
+
+func (k ArtifactKey) fields() []string {
+	return []string{k.Owner, k.Repo, k.PR, k.SHA, k.UUID, k.Name}
+}
+
+// Path returns the key as the path owner/repo/pr/sha/uuid/name, the layout shared with the perf workflows.
+// The fields after the first empty one are ignored.
+func (k ArtifactKey) Path() string {
+	var parts []string
+	for _, f := range k.fields() {
+		if f == "" {
+			break
+		}
+		parts = append(parts, f)
+	}
+	return path.Join(parts...)
+}
+
+// File returns the key of the file name of the run k.
+func (k ArtifactKey) File(name string) ArtifactKey {
+	k.Name = name
+	return k
+}
+
+func (k ArtifactKey) String() string {
+	return k.Path()
+}
+
+// checkPrefix checks the fields up to the first empty one are clean relative paths, single elements but for the name.
+func (k ArtifactKey) checkPrefix() error {
+	for i, f := range k.fields() {
+		if f == "" {
+			return nil
+		}
+		clean := path.Clean(f)
+		if clean != f || path.IsAbs(f) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") || i < 5 && strings.Contains(f, "/") {
+			return fmt.Errorf("%w %q", ErrInvalidKey, k.Path())
+		}
+	}
+	return nil
+}
+
+// check checks the key is complete and valid.
+func (k ArtifactKey) check() error {
+	for _, f := range k.fields() {
+		if f == "" {
+			return fmt.Errorf("%w %q, incomplete", ErrInvalidKey, k.Path())
+		}
+	}
+	return k.checkPrefix()
+}
+
+// keyFromPath parses the path of a complete key.
+func keyFromPath(p string) (ArtifactKey, bool) {
+	parts := strings.SplitN(p, "/", 6)
+	if len(parts) != 6 {
+		return ArtifactKey{}, false
+	}
+	k := ArtifactKey{Owner: parts[0], Repo: parts[1], PR: parts[2], SHA: parts[3], UUID: parts[4], Name: parts[5]}
+	return k, k.check() == nil
+}
+
+// listPrefix returns the path prefix of the files under the prefix key.
+func listPrefix(prefix ArtifactKey) string {
+	if p := prefix.Path(); p != "" {
+		return p + "/"
+	}
+	return ""
+}
+
+// ObjectInfo describes a stored file.
+type ObjectInfo struct {
+	Key     ArtifactKey
+	Size    int64
+	ModTime time.Time
+}
@@ -0,0 +1,403 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The server-side encryptions of the objects.
+const (
+	SSES3  = "AES256"
+	SSEKMS = "aws:kms"
+)
+
+// DefaultPartSize is the size of the parts of a multipart upload, larger uploads are sent in parts.
+const DefaultPartSize = 16 << 20
+
+// S3Options configures an S3 compatible bucket.
+type S3Options struct {
+	// Endpoint is the url of the service, https://s3.<region>.amazonaws.com by default.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// AccessKeyID, SecretAccessKey and SessionToken are the credentials of the requests.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// PathStyle addresses the bucket as a path of the endpoint, as MinIO expects,
+	// rather than as a subdomain.
+	PathStyle bool
+	// ServerSideEncryption encrypts the stored objects, SSES3 or SSEKMS with the KMSKeyID key,
+	// the default key of the account when empty.
+	ServerSideEncryption string
+	KMSKeyID             string
+	// PartSize is the size of the parts of the multipart uploads, DefaultPartSize when 0.
+	PartSize int
+	// Client sends the requests, http.DefaultClient by default.
+	Client *http.Client
+}
+
+// S3Storage keeps the files as the objects owner/repo/pr/sha/uuid/filename of an S3 compatible bucket.
+type S3Storage struct {
+	opts     S3Options
+	endpoint *url.URL
+	signer   sigV4
+	now      func() time.Time
+	// pageSize is the number of objects listed by request.
+	pageSize int
+}
+
+// s3Error is the error document of the S3 API.
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+// completedPart is a part of a multipart upload.
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+// NewS3Storage returns the storage of the bucket.
+func NewS3Storage(opts S3Options) (*S3Storage, error) {
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("s3: bucket is required")
+	}
+	if opts.Region == "" {
+		return nil, fmt.Errorf("s3: region is required")
+	}
+	switch {
+	case opts.ServerSideEncryption != "" && opts.ServerSideEncryption != SSES3 && opts.ServerSideEncryption != SSEKMS:
+		return nil, fmt.Errorf("s3: unknown server-side encryption %q", opts.ServerSideEncryption)
+	case opts.KMSKeyID != "" && opts.ServerSideEncryption != SSEKMS:
+		return nil, fmt.Errorf("s3: a kms key requires the %s server-side encryption", SSEKMS)
+	case opts.PartSize < 0:
+		return nil, fmt.Errorf("s3: part size must not be negative")
+	}
+	if opts.PartSize == 0 {
+		opts.PartSize = DefaultPartSize
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", opts.Region)
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("s3: invalid endpoint, %v", err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" || endpoint.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", opts.Endpoint)
+	}
+	return &S3Storage{
+		opts:     opts,
+		endpoint: endpoint,
+		signer: sigV4{
+			accessKeyID:     opts.AccessKeyID,
+			secretAccessKey: opts.SecretAccessKey,
+			sessionToken:    opts.SessionToken,
+			region:          opts.Region,
+			service:         "s3",
+		},
+		now:      time.Now,
+		pageSize: 1000,
+	}, nil
+}
+
+// objectURL returns the url of the object key.
+func (r *S3Storage) objectURL(key string) *url.URL {
+	u := *r.endpoint
+	p := "/" + key
+	if r.opts.PathStyle {
+		p = "/" + r.opts.Bucket + p
+	} else {
+		u.Host = r.opts.Bucket + "." + u.Host
+	}
+	u.Path = path.Join(strings.TrimSuffix(u.Path, "/"), p)
+	// the path is sent as signed
+	u.RawPath = escape(u.Path, true)
+	return &u
+}
+
+// contentType returns the type the object key is served with.
+func contentType(key string) string {
+	ext := path.Ext(key)
+	if ext == ".log" {
+		return "text/plain; charset=utf-8"
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// encryption sets the server-side encryption headers of the uploads.
+func (r *S3Storage) encryption(header http.Header) {
+	if r.opts.ServerSideEncryption == "" {
+		return
+	}
+	header.Set("X-Amz-Server-Side-Encryption", r.opts.ServerSideEncryption)
+	if r.opts.KMSKeyID != "" {
+		header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", r.opts.KMSKeyID)
+	}
+}
+
+// do sends a signed request on the object key and returns the response of a successful one.
+// A missing object is reported with an error matching os.ErrNotExist.
+func (r *S3Storage) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u := r.objectURL(key)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	r.signer.sign(req, hashSHA256(body), r.now())
+	resp, err := r.opts.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	var e s3Error
+	_ = xml.Unmarshal(data, &e)
+	if resp.StatusCode == http.StatusNotFound && (e.Code == "" || e.Code == "NoSuchKey") {
+		return nil, fmt.Errorf("s3: %s %s: %w", method, key, os.ErrNotExist)
+	}
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+	return nil, fmt.Errorf("s3: %s %s: %s %s", method, key, e.Code, e.Message)
+}
+
+// result decodes the xml document of a successful response into v.
+// Some requests fail after their response started, with an error document and a 200 status.
+func result(method, key string, resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var e s3Error
+	if xml.Unmarshal(data, &e) == nil {
+		return fmt.Errorf("s3: %s %s: %s %s", method, key, e.Code, e.Message)
+	}
+	if v == nil {
+		return nil
+	}
+	return xml.Unmarshal(data, v)
+}
+
+// put uploads the object key in a single request.
+func (r *S3Storage) put(ctx context.Context, key string, data []byte) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType(key))
+	r.encryption(header)
+	resp, err := r.do(ctx, http.MethodPut, key, nil, header, data)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// putMultipart uploads the object key in parts of data.
+// The upload is aborted when it fails, so no part is kept.
+func (r *S3Storage) putMultipart(ctx context.Context, key string, data io.Reader) error {
+	header := http.Header{}
+	header.Set("Content-Type", contentType(key))
+	r.encryption(header)
+	resp, err := r.do(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return err
+	}
+	var initiated initiateMultipartUploadResult
+	if err := result(http.MethodPost, key, resp, &initiated); err != nil {
+		return err
+	}
+	upload := url.Values{"uploadId": {initiated.UploadID}}
+
+	err = r.uploadParts(ctx, key, initiated.UploadID, data)
+	if err != nil {
+		// the upload is aborted even if ctx is done
+		if resp, aerr := r.do(context.Background(), http.MethodDelete, key, upload, nil, nil); aerr == nil {
+			resp.Body.Close()
+		}
+	}
+	return err
+}
+
+// uploadParts sends the parts of the upload, read in a single part buffer, and completes it.
+func (r *S3Storage) uploadParts(ctx context.Context, key, uploadID string, data io.Reader) error {
+	var completed completeMultipartUpload
+	buf := make([]byte, r.opts.PartSize)
+	for n := 1; ; n++ {
+		size, err := io.ReadFull(data, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		resp, err := r.do(ctx, http.MethodPut, key, url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {uploadID}}, nil, buf[:size])
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		completed.Parts = append(completed.Parts, completedPart{PartNumber: n, ETag: resp.Header.Get("ETag")})
+		if size < len(buf) {
+			break
+		}
+	}
+	body, err := xml.Marshal(completed)
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	return result(http.MethodPost, key, resp, nil)
+}
+
+func (r *S3Storage) Put(ctx context.Context, key ArtifactKey, data io.Reader) error {
+	if err := key.check(); err != nil {
+		return err
+	}
+	// the body is sent in a single request when it fits a part, else in a multipart upload:
+	// reading a byte past the part tells them apart, a small body takes no more memory than its size
+	var head bytes.Buffer
+	if _, err := head.ReadFrom(io.LimitReader(data, int64(r.opts.PartSize)+1)); err != nil {
+		return err
+	}
+	if head.Len() <= r.opts.PartSize {
+		return r.put(ctx, key.Path(), head.Bytes())
+	}
+	return r.putMultipart(ctx, key.Path(), io.MultiReader(&head, data))
+}
+
+func (r *S3Storage) Get(ctx context.Context, key ArtifactKey) (io.ReadCloser, error) {
+	if err := key.check(); err != nil {
+		return nil, err
+	}
+	resp, err := r.do(ctx, http.MethodGet, key.Path(), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (r *S3Storage) Stat(ctx context.Context, key ArtifactKey) (ObjectInfo, error) {
+	if err := key.check(); err != nil {
+		return ObjectInfo{}, err
+	}
+	resp, err := r.do(ctx, http.MethodHead, key.Path(), nil, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// listBucketResult is the result of ListObjectsV2.
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (r *S3Storage) List(ctx context.Context, prefix ArtifactKey) ([]ObjectInfo, error) {
+	if err := prefix.checkPrefix(); err != nil {
+		return nil, err
+	}
+	var infos []ObjectInfo
+	query := url.Values{"list-type": {"2"}, "prefix": {listPrefix(prefix)}, "max-keys": {strconv.Itoa(r.pageSize)}}
+	for {
+		resp, err := r.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var res listBucketResult
+		if err := result(http.MethodGet, listPrefix(prefix), resp, &res); err != nil {
+			return nil, err
+		}
+		for _, o := range res.Contents {
+			if key, ok := keyFromPath(o.Key); ok {
+				infos = append(infos, ObjectInfo{Key: key, Size: o.Size, ModTime: o.LastModified})
+			}
+		}
+		if !res.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", res.NextContinuationToken)
+	}
+	return infos, nil
+}
+
+func (r *S3Storage) Delete(ctx context.Context, key ArtifactKey) error {
+	if err := key.check(); err != nil {
+		return err
+	}
+	resp, err := r.do(ctx, http.MethodDelete, key.Path(), nil, nil, nil)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// SignedURL returns a presigned GET url of the file valid for ttl, at most 7 days.
+func (r *S3Storage) SignedURL(ctx context.Context, key ArtifactKey, ttl time.Duration) (string, error) {
+	if err := key.check(); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.objectURL(key.Path()).String(), nil)
+	if err != nil {
+		return "", err
+	}
+	return r.signer.presign(req, r.now(), ttl)
+}
+
+// GetBasePath returns the url of the bucket.
+func (r *S3Storage) GetBasePath() string {
+	return strings.TrimSuffix(r.objectURL("").String(), "/")
+}
@@ -0,0 +1,145 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat  = "20060102T150405Z"
+	// unsignedPayload replaces the hash of a body which is not signed, e.g. in presigned urls.
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// sigV4 signs the requests to an S3 compatible service with AWS signature version 4.
+type sigV4 struct {
+	accessKeyID     string
+	secretAccessKey string
+	// sessionToken is set with temporary credentials.
+	sessionToken string
+	region       string
+	service      string
+}
+
+func hashSHA256(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escape encodes s as the signature expects, keeping the slashes of paths when path is true.
+func escape(s string, path bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && path:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery returns the query sorted by key then value, encoded as the signature expects.
+func canonicalQuery(q url.Values) string {
+	var pairs []string
+	for k, vs := range q {
+		for _, v := range vs {
+			pairs = append(pairs, escape(k, false)+"="+escape(v, false))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// canonicalHeaders returns the signed header names and their canonical form, the host and every header set on req.
+func canonicalHeaders(req *http.Request) (string, string) {
+	values := map[string]string{"host": req.URL.Host}
+	for k, vs := range req.Header {
+		values[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+	var names []string
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k + ":" + values[k] + "\n")
+	}
+	return strings.Join(names, ";"), b.String()
+}
+
+func (s sigV4) scope(t time.Time) string {
+	return strings.Join([]string{t.Format("20060102"), s.region, s.service, "aws4_request"}, "/")
+}
+
+// signature returns the signature of the canonical request made at t.
+func (s sigV4) signature(t time.Time, canonicalRequest string) string {
+	toSign := strings.Join([]string{sigV4Algorithm, t.Format(amzDateFormat), s.scope(t), hashSHA256([]byte(canonicalRequest))}, "\n")
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), t.Format("20060102"))
+	for _, part := range []string{s.region, s.service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+// sign sets the authorization header of req made at t, payloadHash is the hex sha256 of its body.
+// The headers set on req are signed, those the transport adds later are not.
+func (s sigV4) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	signed, headers := canonicalHeaders(req)
+	canonical := strings.Join([]string{req.Method, escape(req.URL.Path, true), canonicalQuery(req.URL.Query()), headers, signed, payloadHash}, "\n")
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, s.accessKeyID, s.scope(t), signed, s.signature(t, canonical)))
+}
+
+// maxPresignExpiry is the longest validity of a presigned url.
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// presign returns the url of req made at t signed in its query, valid for expires.
+// Only the host is signed and the body is not, any client may then send the request.
+func (s sigV4) presign(req *http.Request, t time.Time, expires time.Duration) (string, error) {
+	if expires < time.Second || expires > maxPresignExpiry {
+		return "", fmt.Errorf("presigned url expiry %s must be between 1s and %s", expires, maxPresignExpiry)
+	}
+	t = t.UTC()
+	q := req.URL.Query()
+	q.Set("X-Amz-Algorithm", sigV4Algorithm)
+	q.Set("X-Amz-Credential", s.accessKeyID+"/"+s.scope(t))
+	q.Set("X-Amz-Date", t.Format(amzDateFormat))
+	q.Set("X-Amz-Expires", fmt.Sprint(int64(expires/time.Second)))
+	q.Set("X-Amz-SignedHeaders", "host")
+	if s.sessionToken != "" {
+		q.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	query := canonicalQuery(q)
+	canonical := strings.Join([]string{req.Method, escape(req.URL.Path, true), query, "host:" + req.URL.Host + "\n", "host", unsignedPayload}, "\n")
+	u := *req.URL
+	u.RawQuery = query + "&X-Amz-Signature=" + s.signature(t, canonical)
+	return u.String(), nil
+}
@@ -2,111 +2,290 @@ package utils
 
 import (
 	"context"
-	"github.com/tencentyun/cos-go-sdk-v5"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/tencentyun/cos-go-sdk-v5"
 )
 
+// StorageInterface keeps the files uploaded by the runs.
+// The missing files are reported with an error matching os.ErrNotExist.
 type StorageInterface interface {
-	Store(ctx context.Context, owner, repo, pr, sha, uuid, filename string, info []byte) error
-	Retrieve(ctx context.Context, owner, repo, pr, sha, uuid, filename string) ([]byte, error)
+	// Put streams the file content from r, the file is not kept if reading r fails.
+	Put(ctx context.Context, key ArtifactKey, r io.Reader) error
+	// Get opens the file for streaming, the caller closes it.
+	Get(ctx context.Context, key ArtifactKey) (io.ReadCloser, error)
+	Stat(ctx context.Context, key ArtifactKey) (ObjectInfo, error)
+	// List returns the files under the prefix key sorted by path.
+	List(ctx context.Context, prefix ArtifactKey) ([]ObjectInfo, error)
+	// Delete removes the file, deleting a missing file is not an error.
+	Delete(ctx context.Context, key ArtifactKey) error
+	// SignedURL returns a url downloading the file for ttl, or ErrSignedURLNotSupported.
+	SignedURL(ctx context.Context, key ArtifactKey, ttl time.Duration) (string, error)
 	GetBasePath() string
-	GetFilePath(owner, repo, pr, sha, uuid, filename string) string
 }
 
+// COSStorage keeps the files as the objects owner/repo/pr/sha/uuid/filename of a Tencent COS bucket.
 type COSStorage struct {
-	url string
-	secretId string
+	url       string
+	secretId  string
 	secretKey string
-	client *cos.Client
+	client    *cos.Client
+	// pageSize is the number of objects listed by request.
+	pageSize int
 }
 
-func (r *COSStorage) Store(ctx context.Context, owner, repo, pr, sha, uuid, filename string, data []byte) error {
-	location := filepath.Join(owner, repo, pr, sha, uuid, filename)
-	f := strings.NewReader(string(data))
-	_, err :=  r.client.Object.Put(ctx, location, f, nil)
+// NewCOSStorage returns the storage of the bucket at bucketURL, e.g. https://examplebucket-1250000000.cos.ap-guangzhou.myqcloud.com.
+// client sends the requests, it may be nil.
+func NewCOSStorage(bucketURL, secretID, secretKey string, client *http.Client) (*COSStorage, error) {
+	u, err := url.Parse(bucketURL)
+	if err != nil {
+		return nil, fmt.Errorf("cos: invalid bucket url, %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("cos: invalid bucket url %q", bucketURL)
+	}
+	transport := http.DefaultTransport
+	if client != nil && client.Transport != nil {
+		transport = client.Transport
+	}
+	return &COSStorage{
+		url:       bucketURL,
+		secretId:  secretID,
+		secretKey: secretKey,
+		client: cos.NewClient(&cos.BaseURL{BucketURL: u}, &http.Client{
+			Transport: &cos.AuthorizationTransport{SecretID: secretID, SecretKey: secretKey, Transport: transport},
+		}),
+		pageSize: 1000,
+	}, nil
+}
+
+// cosError reports a missing object with an error matching os.ErrNotExist.
+func cosError(key ArtifactKey, err error) error {
+	if cos.IsNotFoundError(err) {
+		return fmt.Errorf("cos: %s: %w", key, os.ErrNotExist)
+	}
 	return err
 }
 
-func (r *COSStorage) Retrieve(ctx context.Context, owner, repo, pr, sha, uuid, filename string) ([]byte, error) {
-	location := filepath.Join(owner, repo, pr, sha, uuid, filename)
-	resp, err :=  r.client.Object.Get(ctx, location,nil)
-	if err != nil {
+// putOptions sets the type the object is served with.
+func putOptions(location string) *cos.ObjectPutOptions {
+	return &cos.ObjectPutOptions{ObjectPutHeaderOptions: &cos.ObjectPutHeaderOptions{ContentType: contentType(location)}}
+}
+
+func (r *COSStorage) Put(ctx context.Context, key ArtifactKey, data io.Reader) error {
+	if err := key.check(); err != nil {
+		return err
+	}
+	_, err := r.client.Object.Put(ctx, key.Path(), data, putOptions(key.Path()))
+	return err
+}
+
+func (r *COSStorage) Get(ctx context.Context, key ArtifactKey) (io.ReadCloser, error) {
+	if err := key.check(); err != nil {
 		return nil, err
 	}
-	bs, _ := ioutil.ReadAll(resp.Body)
-	err = resp.Body.Close()
+	resp, err := r.client.Object.Get(ctx, key.Path(), nil)
 	if err != nil {
+		return nil, cosError(key, err)
+	}
+	return resp.Body, nil
+}
+
+func (r *COSStorage) Stat(ctx context.Context, key ArtifactKey) (ObjectInfo, error) {
+	if err := key.check(); err != nil {
+		return ObjectInfo{}, err
+	}
+	resp, err := r.client.Object.Head(ctx, key.Path(), nil)
+	if err != nil {
+		return ObjectInfo{}, cosError(key, err)
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return ObjectInfo{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (r *COSStorage) List(ctx context.Context, prefix ArtifactKey) ([]ObjectInfo, error) {
+	if err := prefix.checkPrefix(); err != nil {
 		return nil, err
 	}
-	return bs, nil
+	var infos []ObjectInfo
+	opt := &cos.BucketGetOptions{Prefix: listPrefix(prefix), MaxKeys: r.pageSize}
+	for {
+		res, _, err := r.client.Bucket.Get(ctx, opt)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range res.Contents {
+			key, ok := keyFromPath(o.Key)
+			if !ok {
+				continue
+			}
+			modTime, _ := time.Parse(time.RFC3339, o.LastModified)
+			infos = append(infos, ObjectInfo{Key: key, Size: o.Size, ModTime: modTime})
+		}
+		if !res.IsTruncated {
+			break
+		}
+		opt.Marker = res.NextMarker
+	}
+	return infos, nil
 }
 
-func (r *COSStorage) GetURL(ctx context.Context, owner, repo, pr, sha, uuid, filename string) string {
-	location := filepath.Join(owner, repo, pr, sha, uuid, filename)
-	u :=  r.client.Object.GetObjectURL(location)
-	return u.String()
+func (r *COSStorage) Delete(ctx context.Context, key ArtifactKey) error {
+	if err := key.check(); err != nil {
+		return err
+	}
+	_, err := r.client.Object.Delete(ctx, key.Path())
+	if cos.IsNotFoundError(err) {
+		return nil
+	}
+	return err
+}
+
+// SignedURL returns a presigned GET url of the file valid for ttl.
+func (r *COSStorage) SignedURL(ctx context.Context, key ArtifactKey, ttl time.Duration) (string, error) {
+	if err := key.check(); err != nil {
+		return "", err
+	}
+	u, err := r.client.Object.GetPresignedURL(ctx, http.MethodGet, key.Path(), r.secretId, r.secretKey, ttl, nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
 }
 
 func (r *COSStorage) GetBasePath() string {
 	return r.url
 }
 
+// FileStorage keeps the files under BasePath/owner/repo/pr/sha/uuid/filename.
+// The files are written to a temporary file renamed once complete, the readers never see a partial file.
 type FileStorage struct {
 	BasePath string // root for all file
-	FilePath string //
 }
 
-// Store define the location you want to store your files
-// TODO refactor
-func (r *FileStorage) Store(ctx context.Context, owner, repo, pr, sha, uuid, filename string, data []byte) error {
-	basePath, err := filepath.Abs(r.GetBasePath())
+// tempPrefix starts the names of the files being written, they are neither listed nor stored.
+const tempPrefix = ".tmp-"
+
+func isTemp(p string) bool {
+	return strings.HasPrefix(path.Base(p), tempPrefix)
+}
+
+// path returns the location of the file of key.
+func (r *FileStorage) path(key ArtifactKey) string {
+	return filepath.Join(r.BasePath, filepath.FromSlash(key.Path()))
+}
+
+func (r *FileStorage) Put(ctx context.Context, key ArtifactKey, data io.Reader) error {
+	if err := key.check(); err != nil {
+		return err
+	}
+	if isTemp(key.Name) {
+		return fmt.Errorf("%w %q, reserved name", ErrInvalidKey, key.Path())
+	}
+	address := r.path(key)
+	if err := os.MkdirAll(filepath.Dir(address), 0777); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(address), tempPrefix+"*")
 	if err != nil {
 		return err
 	}
+	_, err = io.Copy(f, data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// TempFile creates the file readable by the owner only
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), address)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
 
-	if _, err := os.Stat(basePath); os.IsNotExist(err) {
-		err := os.Mkdir(basePath, 0777)
-		if err != nil {
+func (r *FileStorage) Get(ctx context.Context, key ArtifactKey) (io.ReadCloser, error) {
+	if err := key.check(); err != nil {
+		return nil, err
+	}
+	return os.Open(r.path(key))
+}
+
+func (r *FileStorage) Stat(ctx context.Context, key ArtifactKey) (ObjectInfo, error) {
+	if err := key.check(); err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(r.path(key))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if fi.IsDir() {
+		return ObjectInfo{}, fmt.Errorf("%s: %w", key, os.ErrNotExist)
+	}
+	return ObjectInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()}, nil
+}
+
+func (r *FileStorage) List(ctx context.Context, prefix ArtifactKey) ([]ObjectInfo, error) {
+	if err := prefix.checkPrefix(); err != nil {
+		return nil, err
+	}
+	var infos []ObjectInfo
+	err := filepath.Walk(r.path(prefix), func(p string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || fi.IsDir() {
 			return err
 		}
-	}
-	PRPath := filepath.Join(basePath, pr)
-	if _, err := os.Stat(PRPath); os.IsNotExist(err) {
-		err := os.Mkdir(PRPath, 0777)
+		rel, err := filepath.Rel(r.BasePath, p)
 		if err != nil {
 			return err
 		}
+		if key, ok := keyFromPath(filepath.ToSlash(rel)); ok && !isTemp(key.Name) {
+			infos = append(infos, ObjectInfo{Key: key, Size: fi.Size(), ModTime: fi.ModTime()})
+		}
+		return nil
+	})
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Key.Path() < infos[j].Key.Path() })
+	return infos, err
+}
+
+func (r *FileStorage) Delete(ctx context.Context, key ArtifactKey) error {
+	if err := key.check(); err != nil {
+		return err
 	}
-	SHAPath := filepath.Join(PRPath, sha)
-	if _, err := os.Stat(SHAPath); os.IsNotExist(err) {
-		err := os.Mkdir(SHAPath, 0777)
-		if err != nil {
-			return err
+	address := r.path(key)
+	if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// the directories left empty are removed up to the base path, removing a directory in use fails
+	for dir := filepath.Dir(address); dir != filepath.Clean(r.BasePath); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
 		}
 	}
-	filename = filepath.Join(SHAPath, filename)
-	err = ioutil.WriteFile(filename, data, 0666)
-	return err
+	return nil
 }
 
-// Retrieve will read the file and return the data
-func (r *FileStorage) Retrieve(ctx context.Context, owner, repo, pr, sha, uuid, filename string) ([]byte, error) {
-	address := filepath.Join(r.BasePath, owner, repo, pr, sha, uuid, filename)
-	content, err := ioutil.ReadFile(address)
-	if err != nil {
-		return nil, err
-	}
-	return content, nil
+// SignedURL is not supported, the chatbot serves the files.
+func (r *FileStorage) SignedURL(ctx context.Context, key ArtifactKey, ttl time.Duration) (string, error) {
+	return "", ErrSignedURLNotSupported
 }
 
 func (r *FileStorage) GetBasePath() string {
 	return r.BasePath
 }
-
-func (r *FileStorage) GetFilePath(owner, repo, pr, sha, uuid, filename string) string {
-	address := filepath.Join(r.BasePath, owner, repo, pr, sha, uuid, filename)
-	return address
-}
\ No newline at end of file
@@ -0,0 +1,563 @@
+// Copyright 2020-2021 The Datafuse Authors.
+//
+// SPDX-License-Identifier: Apache-2.0.
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeObjectStore is a MinIO-style stand-in of the S3 and COS object APIs, keeping the objects by path.
+type fakeObjectStore struct {
+	t *testing.T
+	// auth is the prefix of the authorization header of the requests.
+	auth string
+	// root is the path of the bucket, the objects are under root/.
+	root    string
+	mu      sync.Mutex
+	objects map[string][]byte
+	// headers are the headers of the last upload of each object.
+	headers map[string]http.Header
+	// uploads are the parts of the multipart uploads in progress, completed the part counts of the completed ones.
+	uploads   map[string]map[int][]byte
+	completed []int
+	modTime   time.Time
+}
+
+func newFakeObjectStore(t *testing.T, auth, root string) (*fakeObjectStore, *httptest.Server) {
+	f := &fakeObjectStore{t: t, auth: auth, root: root, objects: map[string][]byte{}, headers: map[string]http.Header{}, uploads: map[string]map[int][]byte{},
+		modTime: time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// list answers ListObjectsV2 and the COS Get Bucket, max-keys objects at a time.
+func (f *fakeObjectStore) list(w http.ResponseWriter, query url.Values) {
+	prefix := f.root + "/" + query.Get("prefix")
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	_, v2 := query["list-type"]
+	start := query.Get("marker")
+	if v2 {
+		start = query.Get("continuation-token")
+	}
+	for len(keys) > 0 && start != "" && keys[0] <= f.root+"/"+start {
+		keys = keys[1:]
+	}
+	max, _ := strconv.Atoi(query.Get("max-keys"))
+	truncated := max > 0 && len(keys) > max
+	if truncated {
+		keys = keys[:max]
+	}
+	fmt.Fprint(w, "<ListBucketResult>")
+	for _, k := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>",
+			strings.TrimPrefix(k, f.root+"/"), len(f.objects[k]), f.modTime.Format("2006-01-02T15:04:05.000Z"))
+	}
+	fmt.Fprintf(w, "<IsTruncated>%t</IsTruncated>", truncated)
+	if truncated && v2 {
+		fmt.Fprintf(w, "<NextContinuationToken>%s</NextContinuationToken>", strings.TrimPrefix(keys[len(keys)-1], f.root+"/"))
+	} else if truncated {
+		fmt.Fprintf(w, "<NextMarker>%s</NextMarker>", strings.TrimPrefix(keys[len(keys)-1], f.root+"/"))
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func (f *fakeObjectStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	presigned := r.Method == http.MethodGet && r.URL.Query().Get("X-Amz-Signature") != "" &&
+		strings.HasPrefix(r.URL.Query().Get("X-Amz-Credential"), strings.TrimPrefix(f.auth, sigV4Algorithm+" Credential="))
+	if !presigned && !strings.HasPrefix(r.Header.Get("Authorization"), f.auth) {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, "<Error><Code>AccessDenied</Code><Message>unsigned request</Message></Error>")
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := r.URL.Path
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if sum := r.Header.Get("X-Amz-Content-Sha256"); sum != "" && sum != unsignedPayload && sum != hashSHA256(data) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, "<Error><Code>XAmzContentSHA256Mismatch</Code></Error>")
+		return
+	}
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	if _, ok := f.uploads[uploadID]; uploadID != "" && !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "<Error><Code>NoSuchUpload</Code></Error>")
+		return
+	}
+	_, initiate := query["uploads"]
+	switch {
+	case r.Method == http.MethodGet && strings.TrimSuffix(key, "/") == f.root:
+		f.list(w, query)
+	case r.Method == http.MethodPost && initiate:
+		uploadID = strconv.Itoa(len(f.uploads)+1) + "+" + key
+		f.uploads[uploadID] = map[int][]byte{}
+		f.headers[key] = r.Header
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", uploadID)
+	case r.Method == http.MethodPut && uploadID != "":
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		f.uploads[uploadID][n] = data
+		w.Header().Set("ETag", fmt.Sprintf("%q", hashSHA256(data)))
+	case r.Method == http.MethodPost && uploadID != "":
+		var completed completeMultipartUpload
+		assert.NoError(f.t, xml.Unmarshal(data, &completed))
+		var object []byte
+		for i, p := range completed.Parts {
+			part := f.uploads[uploadID][p.PartNumber]
+			if p.PartNumber != i+1 || p.ETag != fmt.Sprintf("%q", hashSHA256(part)) {
+				fmt.Fprint(w, "<Error><Code>InvalidPart</Code></Error>")
+				return
+			}
+			object = append(object, part...)
+		}
+		delete(f.uploads, uploadID)
+		f.completed = append(f.completed, len(completed.Parts))
+		f.objects[key] = object
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && uploadID != "":
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.objects[key] = data
+		f.headers[key] = r.Header
+		w.Header().Set("X-Cos-Hash-Crc64ecma", strconv.FormatUint(crc64.Checksum(data, crc64.MakeTable(crc64.ECMA)), 10))
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok && r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", f.modTime.Format(http.TimeFormat))
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// failingReader fails after returning some data.
+type failingReader struct{ read bool }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.read {
+		return 0, errors.New("connection reset")
+	}
+	r.read = true
+	return copy(p, "partial"), nil
+}
+
+// put stores data at key.
+func put(t *testing.T, s StorageInterface, key ArtifactKey, data string) {
+	assert.NoError(t, s.Put(context.Background(), key, strings.NewReader(data)), key.Path())
+}
+
+// get returns the content of key.
+func get(s StorageInterface, key ArtifactKey) (string, error) {
+	r, err := s.Get(context.Background(), key)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	return string(data), err
+}
+
+// paths returns the paths of the listed files.
+func paths(infos []ObjectInfo) []string {
+	var p []string
+	for _, i := range infos {
+		p = append(p, i.Key.Path())
+	}
+	return p
+}
+
+// testStorageConformance checks the behavior every StorageInterface implementation shares, on an empty storage.
+func testStorageConformance(t *testing.T, s StorageInterface) {
+	ctx := context.Background()
+	run := ArtifactKey{Owner: "datafuselabs", Repo: "databend", PR: "233", SHA: "abc", UUID: "uuid-1"}
+	file := func(uuid, name string) ArtifactKey {
+		k := run
+		k.UUID, k.Name = uuid, name
+		return k
+	}
+
+	t.Run("put and get", func(t *testing.T) {
+		put(t, s, file("uuid-1", "compare.json"), `{"a":1}`)
+		data, err := get(s, file("uuid-1", "compare.json"))
+		assert.NoError(t, err)
+		assert.Equal(t, `{"a":1}`, data)
+
+		put(t, s, file("uuid-1", "compare.json"), `{"a":2}`)
+		data, err = get(s, file("uuid-1", "compare.json"))
+		assert.NoError(t, err)
+		assert.Equal(t, `{"a":2}`, data, "overwritten")
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		content := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+		assert.NoError(t, s.Put(ctx, file("uuid-1", "logs/server.log"), bytes.NewReader(content)))
+		data, err := get(s, file("uuid-1", "logs/server.log"))
+		assert.NoError(t, err)
+		assert.Equal(t, string(content), data)
+
+		info, err := s.Stat(ctx, file("uuid-1", "logs/server.log"))
+		assert.NoError(t, err)
+		assert.Equal(t, file("uuid-1", "logs/server.log"), info.Key)
+		assert.Equal(t, int64(len(content)), info.Size)
+		assert.False(t, info.ModTime.IsZero())
+	})
+
+	t.Run("names", func(t *testing.T) {
+		for _, name := range []string{"a b+c=d.txt", "nested/dir/q1 (1).json", "€.html"} {
+			put(t, s, file("uuid-1", name), name)
+			data, err := get(s, file("uuid-1", name))
+			assert.NoError(t, err, name)
+			assert.Equal(t, name, data)
+		}
+	})
+
+	t.Run("runs", func(t *testing.T) {
+		put(t, s, file("uuid-2", "result.csv"), "2")
+		put(t, s, file("uuid-3", "result.csv"), "3")
+		data, err := get(s, file("uuid-2", "result.csv"))
+		assert.NoError(t, err)
+		assert.Equal(t, "2", data, "the runs of a commit don't overwrite each other")
+	})
+
+	t.Run("list", func(t *testing.T) {
+		put(t, s, ArtifactKey{Owner: "datafuselabs", Repo: "databend", PR: "2330", SHA: "abc", UUID: "uuid-1", Name: "compare.json"}, "{}")
+		infos, err := s.List(ctx, ArtifactKey{Owner: "datafuselabs", Repo: "databend", PR: "233"})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{
+			"datafuselabs/databend/233/abc/uuid-1/a b+c=d.txt",
+			"datafuselabs/databend/233/abc/uuid-1/compare.json",
+			"datafuselabs/databend/233/abc/uuid-1/logs/server.log",
+			"datafuselabs/databend/233/abc/uuid-1/nested/dir/q1 (1).json",
+			"datafuselabs/databend/233/abc/uuid-1/€.html",
+			"datafuselabs/databend/233/abc/uuid-2/result.csv",
+			"datafuselabs/databend/233/abc/uuid-3/result.csv",
+		}, paths(infos), "the pull request 2330 is not under 233")
+		assert.Equal(t, int64(len("2")), infos[5].Size)
+
+		infos, err = s.List(ctx, file("uuid-1", "nested"))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"datafuselabs/databend/233/abc/uuid-1/nested/dir/q1 (1).json"}, paths(infos))
+		infos, err = s.List(ctx, ArtifactKey{})
+		assert.NoError(t, err)
+		assert.Len(t, infos, 8, "everything")
+		infos, err = s.List(ctx, ArtifactKey{Owner: "datafuselabs", Repo: "fuse-query"})
+		assert.NoError(t, err)
+		assert.Empty(t, infos)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		assert.NoError(t, s.Delete(ctx, file("uuid-3", "result.csv")))
+		_, err := get(s, file("uuid-3", "result.csv"))
+		assert.True(t, errors.Is(err, os.ErrNotExist), "%v", err)
+		assert.NoError(t, s.Delete(ctx, file("uuid-3", "result.csv")), "already deleted")
+		data, err := get(s, file("uuid-2", "result.csv"))
+		assert.NoError(t, err)
+		assert.Equal(t, "2", data, "only the file is deleted")
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		_, err := s.Get(ctx, file("uuid-1", "missing.txt"))
+		assert.True(t, errors.Is(err, os.ErrNotExist), "%v", err)
+		_, err = s.Stat(ctx, file("uuid-1", "missing.txt"))
+		assert.True(t, errors.Is(err, os.ErrNotExist), "%v", err)
+		_, err = s.Stat(ctx, file("uuid-1", "nested"))
+		assert.True(t, errors.Is(err, os.ErrNotExist), "a directory is not a file, %v", err)
+	})
+
+	t.Run("failed stream", func(t *testing.T) {
+		err := s.Put(ctx, file("uuid-1", "broken.log"), &failingReader{})
+		assert.Error(t, err)
+		_, err = s.Stat(ctx, file("uuid-1", "broken.log"))
+		assert.True(t, errors.Is(err, os.ErrNotExist), "a failed upload is not kept, %v", err)
+	})
+
+	t.Run("invalid keys", func(t *testing.T) {
+		for _, key := range []ArtifactKey{run, file("uuid-1", "../../../../../../etc/passwd"), file("..", "compare.json"), file("a/b", "c")} {
+			assert.True(t, errors.Is(s.Put(ctx, key, strings.NewReader("x")), ErrInvalidKey), key.Path())
+			_, err := s.Get(ctx, key)
+			assert.True(t, errors.Is(err, ErrInvalidKey), key.Path())
+		}
+		_, err := s.List(ctx, ArtifactKey{Owner: "..", Repo: "x"})
+		assert.True(t, errors.Is(err, ErrInvalidKey))
+	})
+}
+
+func TestFileStorage(t *testing.T) {
+	s := &FileStorage{BasePath: t.TempDir()}
+	testStorageConformance(t, s)
+	_, err := s.SignedURL(context.Background(), ArtifactKey{Owner: "o", Repo: "r", PR: "1", SHA: "sha", UUID: "uuid", Name: "compare.html"}, time.Hour)
+	assert.Equal(t, ErrSignedURLNotSupported, err)
+}
+
+func TestFileStorage_layout(t *testing.T) {
+	ctx := context.Background()
+	s := &FileStorage{BasePath: t.TempDir()}
+	key := ArtifactKey{Owner: "datafuselabs", Repo: "databend", PR: "233", SHA: "abc", UUID: "uuid-1", Name: "logs/current.log"}
+	put(t, s, key, "current")
+	data, err := ioutil.ReadFile(filepath.Join(s.BasePath, "datafuselabs", "databend", "233", "abc", "uuid-1", "logs", "current.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "current", string(data), "the file is written where it is read")
+
+	dir := filepath.Join(s.BasePath, "datafuselabs", "databend", "233", "abc", "uuid-1", "logs")
+	assert.Error(t, s.Put(ctx, key.File("logs/broken.log"), &failingReader{}))
+	entries, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "the temporary file of the failed write is removed")
+	assert.Equal(t, os.FileMode(0644), entries[0].Mode().Perm())
+
+	// a write in progress
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, tempPrefix+"123"), []byte("partial"), 0600))
+	infos, err := s.List(ctx, ArtifactKey{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{key.Path()}, paths(infos))
+	assert.True(t, errors.Is(s.Put(ctx, key.File("logs/"+tempPrefix+"1"), strings.NewReader("x")), ErrInvalidKey))
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, tempPrefix+"123")))
+	assert.NoError(t, s.Delete(ctx, key))
+	entries, err = ioutil.ReadDir(s.BasePath)
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "the empty directories are removed")
+}
+
+func TestCOSStorage(t *testing.T) {
+	f, srv := newFakeObjectStore(t, "q-sign-algorithm=sha1&q-ak=AKID", "")
+	s, err := NewCOSStorage(srv.URL, "AKID", "secret", srv.Client())
+	assert.NoError(t, err)
+	s.pageSize = 3
+	testStorageConformance(t, s)
+	assert.Contains(t, f.objects, "/datafuselabs/databend/233/abc/uuid-1/compare.json")
+	assert.Equal(t, "application/json", f.headers["/datafuselabs/databend/233/abc/uuid-1/compare.json"].Get("Content-Type"))
+
+	u, err := s.SignedURL(context.Background(), ArtifactKey{Owner: "o", Repo: "r", PR: "1", SHA: "sha", UUID: "uuid", Name: "compare.html"}, time.Hour)
+	assert.NoError(t, err)
+	signed, err := url.Parse(u)
+	assert.NoError(t, err)
+	assert.Equal(t, "/o/r/1/sha/uuid/compare.html", signed.Path)
+	assert.Equal(t, "AKID", signed.Query().Get("q-ak"))
+}
+
+func TestNewStorage(t *testing.T) {
+	lookupEnv = func(string) (string, bool) { return "", false }
+	defer func() { lookupEnv = os.LookupEnv }()
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, ioutil.WriteFile(credentials, []byte("[default]\naws_access_key_id = id\naws_secret_access_key = key\n"), 0600))
+
+	tests := []struct {
+		name        string
+		options     StorageOptions
+		expectBase  string
+		expectError string
+	}{
+		{name: "file", options: StorageOptions{Backend: FileBackend, Path: "/var/lib/chatbot"}, expectBase: "/var/lib/chatbot"},
+		{name: "file without path", options: StorageOptions{Backend: FileBackend}, expectError: "file: path is required"},
+		{name: "cos region", options: StorageOptions{Backend: COSBackend, Region: "ap-hongkong", Bucket: "perf-1250000000"},
+			expectBase: "https://perf-1250000000.cos.ap-hongkong.myqcloud.com"},
+		{name: "cos endpoint", options: StorageOptions{Backend: COSBackend, Endpoint: "cos.ap-hongkong.myqcloud.com", Bucket: "perf-1250000000"},
+			expectBase: "https://perf-1250000000.cos.ap-hongkong.myqcloud.com"},
+		{name: "cos without bucket", options: StorageOptions{Backend: COSBackend, Region: "ap-hongkong"}, expectError: "cos: bucket and region or endpoint are required"},
+		{name: "s3", options: StorageOptions{Backend: S3Backend, Region: "us-east-2", Bucket: "perf", SecretID: "id", SecretKey: "key"},
+			expectBase: "https://perf.s3.us-east-2.amazonaws.com"},
+		{name: "s3 path style", options: StorageOptions{Backend: S3Backend, Endpoint: "minio:9000", Region: "us-east-1", Bucket: "perf", PathStyle: true, CredentialsFile: credentials},
+			expectBase: "https://minio:9000/perf"},
+		{name: "s3 without credentials", options: StorageOptions{Backend: S3Backend, Region: "us-east-1", Bucket: "perf", CredentialsFile: credentials, Profile: "perf"},
+			expectError: "s3: " + credentials + ": no profile perf"},
+		{name: "s3 without region", options: StorageOptions{Backend: S3Backend, Bucket: "perf", SecretID: "id", SecretKey: "key"}, expectError: "s3: region is required"},
+		{name: "unknown", options: StorageOptions{Backend: "ftp"}, expectError: `unknown storage backend "ftp"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewStorage(tt.options)
+			if tt.expectError != "" {
+				assert.EqualError(t, err, tt.expectError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectBase, s.GetBasePath())
+		})
+	}
+}
+
+var (
+	_ StorageInterface = &FileStorage{}
+	_ StorageInterface = &COSStorage{}
+	_ StorageInterface = &S3Storage{}
+	_ io.Reader        = &failingReader{}
+)
+
+func TestS3Storage(t *testing.T) {
+	f, srv := newFakeObjectStore(t, "AWS4-HMAC-SHA256 Credential=minio/", "/perf")
+	s, err := NewS3Storage(S3Options{Endpoint: srv.URL, Region: "us-east-1", Bucket: "perf", AccessKeyID: "minio", SecretAccessKey: "minio123", PathStyle: true})
+	assert.NoError(t, err)
+	s.pageSize = 3
+	testStorageConformance(t, s)
+	assert.Contains(t, f.objects, "/perf/datafuselabs/databend/233/abc/uuid-1/compare.json")
+	assert.Equal(t, srv.URL+"/perf", s.GetBasePath())
+
+	s, err = NewS3Storage(S3Options{Endpoint: srv.URL, Region: "us-east-1", Bucket: "perf", AccessKeyID: "other", PathStyle: true})
+	key := ArtifactKey{Owner: "o", Repo: "r", PR: "1", SHA: "sha", UUID: "uuid", Name: "f"}
+	assert.NoError(t, err)
+	err = s.Put(context.Background(), key, strings.NewReader(""))
+	assert.EqualError(t, err, "s3: PUT o/r/1/sha/uuid/f: AccessDenied unsigned request")
+}
+
+func TestS3Storage_multipart(t *testing.T) {
+	ctx := context.Background()
+	f, srv := newFakeObjectStore(t, "AWS4-HMAC-SHA256 Credential=minio/", "/perf")
+	s, err := NewS3Storage(S3Options{Endpoint: srv.URL, Region: "us-east-1", Bucket: "perf", AccessKeyID: "minio", PathStyle: true,
+		ServerSideEncryption: SSEKMS, KMSKeyID: "perf-key", PartSize: 1 << 10})
+	assert.NoError(t, err)
+
+	key := ArtifactKey{Owner: "o", Repo: "r", PR: "1", SHA: "sha", UUID: "uuid"}
+	content := bytes.Repeat([]byte("0123456789"), 350)
+	assert.NoError(t, s.Put(ctx, key.File("current.log"), bytes.NewReader(content)))
+	data, err := get(s, key.File("current.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(content), data, "4 parts")
+	assert.Empty(t, f.uploads)
+	header := f.headers["/perf/o/r/1/sha/uuid/current.log"]
+	assert.Equal(t, "text/plain; charset=utf-8", header.Get("Content-Type"))
+	assert.Equal(t, "aws:kms", header.Get("X-Amz-Server-Side-Encryption"))
+	assert.Equal(t, "perf-key", header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"))
+
+	assert.NoError(t, s.Put(ctx, key.File("part.log"), bytes.NewReader(content[:1<<10])))
+	data, err = get(s, key.File("part.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(content[:1<<10]), data, "a single part")
+	assert.NoError(t, s.Put(ctx, key.File("parts.log"), bytes.NewReader(content[:2<<10])))
+	data, err = get(s, key.File("parts.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(content[:2<<10]), data)
+	assert.Equal(t, []int{4, 2}, f.completed, "a body fitting a part is not a multipart upload, no empty part is sent")
+
+	err = s.Put(ctx, key.File("broken.log"), io.MultiReader(bytes.NewReader(content), &failingReader{read: true}))
+	assert.EqualError(t, err, "connection reset")
+	assert.Empty(t, f.uploads, "aborted")
+	_, err = s.Get(ctx, key.File("broken.log"))
+	assert.True(t, errors.Is(err, os.ErrNotExist), "%v", err)
+}
+
+func TestS3Storage_SignedURL(t *testing.T) {
+	_, srv := newFakeObjectStore(t, "AWS4-HMAC-SHA256 Credential=minio/", "/perf")
+	s, err := NewS3Storage(S3Options{Endpoint: srv.URL, Region: "us-east-1", Bucket: "perf", AccessKeyID: "minio", PathStyle: true})
+	assert.NoError(t, err)
+	key := ArtifactKey{Owner: "o", Repo: "r", PR: "1", SHA: "sha", UUID: "uuid", Name: "compare.html"}
+	put(t, s, key, "<html>")
+
+	u, err := s.SignedURL(context.Background(), key, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(u, srv.URL+"/perf/o/r/1/sha/uuid/compare.html?X-Amz-Algorithm="), u)
+	resp, err := http.Get(u)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "<html>", string(data))
+}
+
+func TestLoadS3Credentials(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(`
+[default]
+aws_access_key_id = default-id
+aws_secret_access_key = default-key
+
+# the perf bucket
+[perf]
+aws_access_key_id=perf-id
+aws_secret_access_key=perf-key
+aws_session_token=perf-token
+`), 0600))
+	tests := []struct {
+		name        string
+		env         map[string]string
+		file        string
+		profile     string
+		expect      S3Credentials
+		expectError string
+	}{
+		{name: "env", env: map[string]string{EnvAWSAccessKeyID: "env-id", EnvAWSSecretAccessKey: "env-key", EnvAWSSessionToken: "env-token"}, file: file,
+			expect: S3Credentials{AccessKeyID: "env-id", SecretAccessKey: "env-key", SessionToken: "env-token"}},
+		{name: "default", file: file, expect: S3Credentials{AccessKeyID: "default-id", SecretAccessKey: "default-key"}},
+		{name: "profile", file: file, profile: "perf", expect: S3Credentials{AccessKeyID: "perf-id", SecretAccessKey: "perf-key", SessionToken: "perf-token"}},
+		{name: "env profile", env: map[string]string{EnvAWSProfile: "perf", EnvAWSCredentialsFile: file},
+			expect: S3Credentials{AccessKeyID: "perf-id", SecretAccessKey: "perf-key", SessionToken: "perf-token"}},
+		{name: "missing profile", file: file, profile: "ci", expectError: "s3: " + file + ": no profile ci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := LoadS3Credentials(func(name string) (string, bool) {
+				v, ok := tt.env[name]
+				return v, ok
+			}, tt.file, tt.profile)
+			if tt.expectError != "" {
+				assert.EqualError(t, err, tt.expectError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, c)
+		})
+	}
+}
+
+func TestS3Storage_objectURL(t *testing.T) {
+	s, err := NewS3Storage(S3Options{Region: "ap-northeast-1", Bucket: "perf"})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://perf.s3.ap-northeast-1.amazonaws.com/o/r/1/a%20b.txt", s.objectURL("o/r/1/a b.txt").String())
+	s, err = NewS3Storage(S3Options{Endpoint: "http://minio:9000/", Region: "us-east-1", Bucket: "perf", PathStyle: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "http://minio:9000/perf/o/r/1/a%2Bb.txt", s.objectURL("o/r/1/a+b.txt").String())
+
+	_, err = NewS3Storage(S3Options{Region: "us-east-1"})
+	assert.EqualError(t, err, "s3: bucket is required")
+	_, err = NewS3Storage(S3Options{Region: "us-east-1", Bucket: "perf", ServerSideEncryption: "aes"})
+	assert.EqualError(t, err, `s3: unknown server-side encryption "aes"`)
+	_, err = NewS3Storage(S3Options{Region: "us-east-1", Bucket: "perf", KMSKeyID: "key"})
+	assert.EqualError(t, err, "s3: a kms key requires the aws:kms server-side encryption")
+	_, err = NewS3Storage(S3Options{Endpoint: "minio:9000", Region: "us-east-1", Bucket: "perf"})
+	assert.EqualError(t, err, `s3: invalid endpoint "minio:9000"`)
+}
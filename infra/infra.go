@@ -6,8 +6,11 @@ import (
 	"os"
 	"path/filepath"
 
+	comparecli "datafuselabs/test-infra/pkg/compare/cli"
 	"datafuselabs/test-infra/pkg/provider"
-	kind "datafuselabs/test-infra/pkg/provider/kind"
+	"datafuselabs/test-infra/pkg/provider/cli"
+	_ "datafuselabs/test-infra/pkg/provider/k3d"
+	_ "datafuselabs/test-infra/pkg/provider/kind"
 	"github.com/pkg/errors"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
@@ -26,31 +29,8 @@ func main() {
 		Short('v').
 		StringMapVar(&dr.FlagDeploymentVars)
 
-	k := kind.New(dr)
-	k8sKIND := app.Command("kind", `Kubernetes In Docker (KIND) provider - https://kind.sigs.k8s.io/docs/user/quick-start/`).
-		Action(k.SetupDeploymentResources)
-
-	k8sKIND.Command("info", "kind info -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(k.GetDeploymentVars)
-
-	//Cluster operations.
-	k8sKINDCluster := k8sKIND.Command("cluster", "manage KIND clusters").
-		Action(k.KINDDeploymentsParse)
-	k8sKINDCluster.Command("create", "kind cluster create -f File -v PR_NUMBER:$PR_NUMBER -v CLUSTER_NAME:$CLUSTER_NAME").
-		Action(k.ClusterCreate)
-	k8sKINDCluster.Command("delete", "kind cluster delete -f File -v PR_NUMBER:$PR_NUMBER -v CLUSTER_NAME:$CLUSTER_NAME").
-		Action(k.ClusterDelete)
-	k8sKINDCluster.Command("check-running", "gke nodes check-running -v CLUSTER_NAME:$CLUSTER_NAME").
-		Action(k.ClusterRunning)
-	// K8s resource operations.
-	k8sKINDResource := k8sKIND.Command("resource", `Apply and delete different k8s resources - deployments, services, config maps etc.`).
-		Action(k.NewK8sProvider).
-		Action(k.K8SDeploymentsParse)
-	k8sKINDResource.Command("apply", "kind resource apply -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(k.ResourceApply)
-	k8sKINDResource.Command("delete", "kind resource delete -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
-		Action(k.ResourceDelete)
-
+	cli.Register(app, dr)
+	comparecli.Register(app)
 
 	if _, err := app.Parse(os.Args[1:]); err != nil {
 		fmt.Fprintln(os.Stderr, errors.Wrapf(err, "Error parsing commandline arguments"))
@@ -0,0 +1,95 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"datafuselabs/test-infra/pkg/compare"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Compare holds the flags of the compare command.
+type Compare struct {
+	CurrentPath string
+	RefPath     string
+	CurrentName string
+	RefName     string
+	OutputDir   string
+	Formats     []string
+	Options     compare.Options
+}
+
+// Register adds the compare command to the app.
+func Register(app *kingpin.Application) {
+	c := &Compare{Options: compare.DefaultOptions()}
+	cmd := app.Command("compare", "compare the perf tool results of the current and reference runs, e.g. compare --current ./current --ref ./ref -o result").
+		Action(c.Run)
+	cmd.Flag("current", "perf tool JSON results of the current run, file or folder.").
+		Required().ExistingFileOrDirVar(&c.CurrentPath)
+	cmd.Flag("ref", "perf tool JSON results of the reference run, file or folder.").
+		Required().ExistingFileOrDirVar(&c.RefPath)
+	cmd.Flag("current-name", "name of the current build in the reports.").
+		Default("current").StringVar(&c.CurrentName)
+	cmd.Flag("ref-name", "name of the reference build in the reports.").
+		Default("ref").StringVar(&c.RefName)
+	cmd.Flag("output", "folder receiving compare.json, compare.md and compare.html.").
+		Short('o').Default(".").StringVar(&c.OutputDir)
+	cmd.Flag("format", "report formats to write.").
+		Default("json", "md", "html").EnumsVar(&c.Formats, "json", "md", "html")
+	cmd.Flag("regression-threshold", "relative slowdown from which a significant change is a regression.").
+		Default(fmt.Sprint(c.Options.RegressionThreshold)).Float64Var(&c.Options.RegressionThreshold)
+	cmd.Flag("improvement-threshold", "relative speedup from which a significant change is an improvement.").
+		Default(fmt.Sprint(c.Options.ImprovementThreshold)).Float64Var(&c.Options.ImprovementThreshold)
+	cmd.Flag("confidence", "confidence level of the interval and of the significance test.").
+		Default(fmt.Sprint(c.Options.Confidence)).Float64Var(&c.Options.Confidence)
+	cmd.Flag("method", "significance test.").
+		Default(c.Options.Method).EnumVar(&c.Options.Method, compare.Bootstrap, compare.MannWhitney)
+	cmd.Flag("resamples", "number of bootstrap resamples.").
+		Default(fmt.Sprint(c.Options.Resamples)).IntVar(&c.Options.Resamples)
+}
+
+// Run compares the results and writes the reports.
+func (c *Compare) Run(*kingpin.ParseContext) error {
+	current, err := compare.LoadResults(c.CurrentPath)
+	if err != nil {
+		return errors.Wrapf(err, "loading current results")
+	}
+	ref, err := compare.LoadResults(c.RefPath)
+	if err != nil {
+		return errors.Wrapf(err, "loading reference results")
+	}
+	report, err := compare.Compare(current, ref, c.Options)
+	if err != nil {
+		return err
+	}
+	report.Current, report.Ref = c.CurrentName, c.RefName
+
+	if err := os.MkdirAll(c.OutputDir, 0755); err != nil {
+		return err
+	}
+	writers := map[string]func(*os.File) error{
+		"json": func(f *os.File) error { return report.WriteJSON(f) },
+		"md":   func(f *os.File) error { return report.WriteMarkdown(f) },
+		"html": func(f *os.File) error { return report.WriteHTML(f) },
+	}
+	for _, format := range c.Formats {
+		path := filepath.Join(c.OutputDir, "compare."+format)
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = writers[format](f)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return errors.Wrapf(err, "writing %v", path)
+		}
+		log.Printf("compare report written to %v", path)
+	}
+	log.Printf("%d regressions, %d improvements", report.Count(compare.Regression), report.Count(compare.Improvement))
+	return nil
+}
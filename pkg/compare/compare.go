@@ -0,0 +1,199 @@
+package compare
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// Query classes.
+const (
+	Regression  = "regression"
+	Improvement = "improvement"
+	Noise       = "noise"
+)
+
+// Significance tests.
+const (
+	Bootstrap   = "bootstrap"
+	MannWhitney = "mann-whitney"
+)
+
+// Options configures the comparison and the classification of the queries.
+type Options struct {
+	// RegressionThreshold is the relative slowdown from which a significant change is a regression.
+	RegressionThreshold float64 `json:"regressionThreshold"`
+	// ImprovementThreshold is the relative speedup from which a significant change is an improvement.
+	ImprovementThreshold float64 `json:"improvementThreshold"`
+	// Confidence is the level of the confidence interval and of the significance test, e.g. 0.95.
+	Confidence float64 `json:"confidence"`
+	// Method is the significance test, Bootstrap checks that the interval excludes 0,
+	// MannWhitney that the p-value is under 1 - Confidence.
+	Method string `json:"method"`
+	// Resamples is the number of bootstrap resamples.
+	Resamples int `json:"resamples"`
+	// Seed makes the bootstrap reproducible.
+	Seed int64 `json:"seed"`
+}
+
+// DefaultOptions returns the options used when none are configured.
+func DefaultOptions() Options {
+	return Options{
+		RegressionThreshold:  0.05,
+		ImprovementThreshold: 0.05,
+		Confidence:           0.95,
+		Method:               Bootstrap,
+		Resamples:            2000,
+		Seed:                 1,
+	}
+}
+
+func (o Options) validate() error {
+	if o.RegressionThreshold < 0 || o.ImprovementThreshold < 0 {
+		return fmt.Errorf("thresholds must be positive")
+	}
+	if o.Confidence <= 0 || o.Confidence >= 1 {
+		return fmt.Errorf("confidence %v must be in (0, 1)", o.Confidence)
+	}
+	if o.Method != Bootstrap && o.Method != MannWhitney {
+		return fmt.Errorf("unknown method %v, expected %v or %v", o.Method, Bootstrap, MannWhitney)
+	}
+	return nil
+}
+
+// QueryResult is the comparison of a query between the current and the reference runs.
+type QueryResult struct {
+	Name  string `json:"name"`
+	Query string `json:"query,omitempty"`
+	// Current and Ref are the median times in seconds.
+	Current float64 `json:"current"`
+	Ref     float64 `json:"ref"`
+	// Change is the relative change of the medians (current - ref) / ref, positive when slower.
+	Change float64 `json:"change"`
+	// Low and High bound the bootstrap confidence interval of Change.
+	Low  float64 `json:"low"`
+	High float64 `json:"high"`
+	// PValue is the two-sided Mann-Whitney p-value.
+	PValue float64 `json:"pValue"`
+	// Class is Regression, Improvement or Noise.
+	Class        string    `json:"class"`
+	CurrentTimes []float64 `json:"currentTimes,omitempty"`
+	RefTimes     []float64 `json:"refTimes,omitempty"`
+}
+
+// Report is the comparison of all the queries of two runs.
+type Report struct {
+	// Current and Ref name the compared builds, e.g. a commit and a release.
+	Current string        `json:"current,omitempty"`
+	Ref     string        `json:"ref,omitempty"`
+	Options Options       `json:"options"`
+	Queries []QueryResult `json:"queries"`
+	// Missing lists the queries found in only one of the runs, they are not compared.
+	Missing []string `json:"missing,omitempty"`
+}
+
+// Compare compares the current runs with the reference runs, in the order of the current runs.
+// A query found in both runs must have times in both.
+func Compare(current, ref []QueryRun, opts Options) (*Report, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	refs := map[string]QueryRun{}
+	for _, r := range ref {
+		refs[r.Name] = r
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	report := &Report{Options: opts, Queries: []QueryResult{}}
+	compared := map[string]bool{}
+	for _, c := range current {
+		r, ok := refs[c.Name]
+		if !ok {
+			report.Missing = append(report.Missing, c.Name)
+			continue
+		}
+		if len(c.Times) == 0 {
+			return nil, fmt.Errorf("query %v has no current times", c.Name)
+		}
+		if len(r.Times) == 0 {
+			return nil, fmt.Errorf("query %v has no reference times", c.Name)
+		}
+		compared[c.Name] = true
+		report.Queries = append(report.Queries, compareQuery(c, r, opts, rng))
+	}
+	for _, r := range ref {
+		if !compared[r.Name] {
+			report.Missing = append(report.Missing, r.Name)
+		}
+	}
+	sort.Strings(report.Missing)
+	return report, nil
+}
+
+func compareQuery(current, ref QueryRun, opts Options, rng *rand.Rand) QueryResult {
+	q := QueryResult{
+		Name:         current.Name,
+		Query:        current.Query,
+		Current:      median(current.Times),
+		Ref:          median(ref.Times),
+		CurrentTimes: current.Times,
+		RefTimes:     ref.Times,
+	}
+	if q.Query == "" {
+		q.Query = ref.Query
+	}
+	q.Change = relativeChange(q.Current, q.Ref)
+	q.Low, q.High = bootstrapInterval(current.Times, ref.Times, opts.Confidence, opts.Resamples, rng)
+	q.PValue = mannWhitney(current.Times, ref.Times)
+	q.Class = classify(q, opts)
+	return q
+}
+
+// classify returns the class of a query, a change is only a regression or an improvement
+// when it is significant and beyond the threshold.
+func classify(q QueryResult, opts Options) string {
+	var significant bool
+	switch opts.Method {
+	case MannWhitney:
+		significant = q.PValue < 1-opts.Confidence
+	default:
+		significant = q.Low > 0 || q.High < 0
+	}
+	switch {
+	case significant && q.Change >= opts.RegressionThreshold:
+		return Regression
+	case significant && q.Change <= -opts.ImprovementThreshold:
+		return Improvement
+	}
+	return Noise
+}
+
+// Count returns the number of queries of the class.
+func (r *Report) Count(class string) int {
+	n := 0
+	for _, q := range r.Queries {
+		if q.Class == class {
+			n++
+		}
+	}
+	return n
+}
+
+// Top returns at most n queries of the class, the largest changes first.
+func (r *Report) Top(class string, n int) []QueryResult {
+	var res []QueryResult
+	for _, q := range r.Queries {
+		if q.Class == class {
+			res = append(res, q)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		if class == Improvement {
+			return res[i].Change < res[j].Change
+		}
+		return res[i].Change > res[j].Change
+	})
+	if len(res) > n {
+		res = res[:n]
+	}
+	return res
+}
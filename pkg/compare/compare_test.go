@@ -0,0 +1,181 @@
+package compare
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRuns() (current, ref []QueryRun) {
+	current = []QueryRun{
+		{Name: "Q1", Query: "SELECT 1", Times: []float64{1.20, 1.21, 1.19, 1.22, 1.20}},
+		{Name: "Q2", Query: "SELECT 2", Times: []float64{0.50, 0.51, 0.49, 0.50, 0.52}},
+		{Name: "Q3", Query: "SELECT 3", Times: []float64{1.00, 1.30, 0.80, 1.10, 0.90}},
+		{Name: "Q4", Query: "SELECT 4", Times: []float64{1.02, 1.03, 1.02, 1.01, 1.03}},
+		{Name: "Q5", Times: []float64{1}},
+	}
+	ref = []QueryRun{
+		{Name: "Q1", Times: []float64{1.00, 1.01, 0.99, 1.00, 1.02}},
+		{Name: "Q2", Times: []float64{1.00, 1.01, 0.99, 1.00, 1.02}},
+		{Name: "Q3", Times: []float64{1.10, 0.90, 1.20, 1.00, 0.95}},
+		{Name: "Q4", Times: []float64{1.00, 1.01, 0.99, 1.00, 1.00}},
+		{Name: "Q6", Times: []float64{1}},
+	}
+	return current, ref
+}
+
+func TestCompare(t *testing.T) {
+	current, ref := testRuns()
+	tests := []struct {
+		name        string
+		opts        func(*Options)
+		expectClass map[string]string
+		expectError bool
+	}{
+		{
+			name:        "bootstrap",
+			expectClass: map[string]string{"Q1": Regression, "Q2": Improvement, "Q3": Noise, "Q4": Noise},
+		},
+		{
+			name:        "mann-whitney",
+			opts:        func(o *Options) { o.Method = MannWhitney },
+			expectClass: map[string]string{"Q1": Regression, "Q2": Improvement, "Q3": Noise, "Q4": Noise},
+		},
+		{
+			name:        "low threshold",
+			opts:        func(o *Options) { o.RegressionThreshold = 0.01 },
+			expectClass: map[string]string{"Q1": Regression, "Q2": Improvement, "Q3": Noise, "Q4": Regression},
+		},
+		{
+			name:        "unknown method",
+			opts:        func(o *Options) { o.Method = "t-test" },
+			expectError: true,
+		},
+		{
+			name:        "invalid confidence",
+			opts:        func(o *Options) { o.Confidence = 1 },
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			if tt.opts != nil {
+				tt.opts(&opts)
+			}
+			report, err := Compare(current, ref, opts)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			classes := map[string]string{}
+			for _, q := range report.Queries {
+				classes[q.Name] = q.Class
+			}
+			assert.Equal(t, tt.expectClass, classes)
+			assert.Equal(t, []string{"Q5", "Q6"}, report.Missing)
+		})
+	}
+}
+
+func TestCompare_noTimes(t *testing.T) {
+	current, ref := testRuns()
+	current[0].Times = nil
+	_, err := Compare(current, ref, DefaultOptions())
+	assert.EqualError(t, err, "query Q1 has no current times")
+
+	current, ref = testRuns()
+	ref[1].Times = []float64{}
+	_, err = Compare(current, ref, DefaultOptions())
+	assert.EqualError(t, err, "query Q2 has no reference times")
+}
+
+func TestReport(t *testing.T) {
+	current, ref := testRuns()
+	report, err := Compare(current, ref, DefaultOptions())
+	assert.NoError(t, err)
+	report.Current, report.Ref = "0123456", "v0.4.0"
+
+	q1 := report.Queries[0]
+	assert.InDelta(t, 0.2, q1.Change, 1e-9)
+	assert.Equal(t, 1.2, q1.Current)
+	assert.Equal(t, 1.0, q1.Ref)
+	assert.Equal(t, "SELECT 3", report.Queries[2].Query)
+	assert.Equal(t, []string{"Q1"}, queryNames(report.Top(Regression, 5)))
+	assert.Equal(t, []string{"Q2"}, queryNames(report.Top(Improvement, 5)))
+	assert.Equal(t, []string{"Q4"}, queryNames(report.Top(Noise, 1)))
+
+	var js bytes.Buffer
+	assert.NoError(t, report.WriteJSON(&js))
+	decoded, err := ReadJSON(&js)
+	assert.NoError(t, err)
+	assert.Equal(t, report, decoded)
+
+	var md bytes.Buffer
+	assert.NoError(t, report.WriteMarkdown(&md))
+	assert.Contains(t, md.String(), "## Performance comparison of `0123456` with `v0.4.0`")
+	assert.Contains(t, md.String(), "1 regressions, 1 improvements, 2 unchanged queries")
+	assert.Contains(t, md.String(), "| Q1 | 1.200 | 1.000 | +20.0% |")
+	assert.Contains(t, md.String(), "only found in one of the runs: Q5, Q6.")
+
+	var html bytes.Buffer
+	assert.NoError(t, report.WriteHTML(&html))
+	assert.Contains(t, html.String(), `<tr class="regression"><td>Q1</td><td>1.200</td><td>1.000</td><td>&#43;20.0%</td>`)
+	assert.Contains(t, html.String(), "<code>SELECT 2</code>")
+}
+
+func TestLoadResults(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write("q1.json", `{"name": "Q1", "query": "SELECT 1", "times": [0.1, 0.2]}`)
+	write("q2.json", `[{"name": "Q2", "times": [0.3]}, {"name": "Q3", "times": [0.4]}]`)
+	write("notes.txt", "ignored")
+
+	runs, err := LoadResults(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Q1", "Q2", "Q3"}, runNames(runs))
+	assert.Equal(t, []float64{0.1, 0.2}, runs[0].Times)
+
+	runs, err = LoadResults(filepath.Join(dir, "q1.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Q1"}, runNames(runs))
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "duplicated", content: `{"name": "Q1", "times": [1]}`},
+		{name: "no times", content: `{"name": "Q4"}`},
+		{name: "no name", content: `{"times": [1]}`},
+		{name: "invalid", content: `{"name": `},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			write("z.json", tt.content)
+			_, err := LoadResults(dir)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func queryNames(queries []QueryResult) []string {
+	var res []string
+	for _, q := range queries {
+		res = append(res, q.Name)
+	}
+	return res
+}
+
+func runNames(runs []QueryRun) []string {
+	var res []string
+	for _, r := range runs {
+		res = append(res, r.Name)
+	}
+	return res
+}
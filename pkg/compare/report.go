@@ -0,0 +1,93 @@
+package compare
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+// WriteJSON writes the report as indented JSON, it is read back by ReadJSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(r)
+}
+
+// ReadJSON reads a report written by WriteJSON.
+func ReadJSON(rd io.Reader) (*Report, error) {
+	var r Report
+	if err := json.NewDecoder(rd).Decode(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// formatChange formats a relative change as a signed percentage.
+func formatChange(change float64) string {
+	return fmt.Sprintf("%+.1f%%", change*100)
+}
+
+// WriteMarkdown writes the report as a Markdown summary followed by the table of all the queries.
+func (r *Report) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Performance comparison of `%s` with `%s`\n\n", r.Current, r.Ref)
+	fmt.Fprintf(&b, "%d regressions, %d improvements, %d unchanged queries", r.Count(Regression), r.Count(Improvement), r.Count(Noise))
+	fmt.Fprintf(&b, " (%s, %.0f%% confidence, thresholds +%.1f%%/-%.1f%%).\n\n",
+		r.Options.Method, r.Options.Confidence*100, r.Options.RegressionThreshold*100, r.Options.ImprovementThreshold*100)
+	b.WriteString("| Query | Current (s) | Reference (s) | Change | Interval | p-value | Class |\n")
+	b.WriteString("|---|---:|---:|---:|---:|---:|---|\n")
+	for _, q := range r.Queries {
+		fmt.Fprintf(&b, "| %s | %.3f | %.3f | %s | [%s, %s] | %.3f | %s |\n",
+			q.Name, q.Current, q.Ref, formatChange(q.Change), formatChange(q.Low), formatChange(q.High), q.PValue, q.Class)
+	}
+	if len(r.Missing) > 0 {
+		fmt.Fprintf(&b, "\nNot compared, only found in one of the runs: %s.\n", strings.Join(r.Missing, ", "))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"change": formatChange,
+	"pct":    func(v float64) string { return fmt.Sprintf("%.0f%%", v*100) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="UTF-8">
+<title>Performance comparison of {{ .Current }} with {{ .Ref }}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #ddd; text-align: right; }
+th:first-child, td:first-child, td.query { text-align: left; }
+tr.regression { background: #fdd; }
+tr.improvement { background: #dfd; }
+</style>
+</head>
+<body>
+<h2>Performance comparison of <code>{{ .Current }}</code> with <code>{{ .Ref }}</code></h2>
+<p>{{ .Count "regression" }} regressions, {{ .Count "improvement" }} improvements, {{ .Count "noise" }} unchanged queries
+({{ .Options.Method }}, {{ pct .Options.Confidence }} confidence).</p>
+<table>
+<thead>
+<tr><th>Query</th><th>Current (s)</th><th>Reference (s)</th><th>Change</th><th>Interval</th><th>p-value</th><th>Class</th><th>SQL</th></tr>
+</thead>
+<tbody>
+{{- range .Queries }}
+<tr class="{{ .Class }}"><td>{{ .Name }}</td><td>{{ printf "%.3f" .Current }}</td><td>{{ printf "%.3f" .Ref }}</td><td>{{ change .Change }}</td><td>[{{ change .Low }}, {{ change .High }}]</td><td>{{ printf "%.3f" .PValue }}</td><td>{{ .Class }}</td><td class="query"><code>{{ .Query }}</code></td></tr>
+{{- end }}
+</tbody>
+</table>
+{{- if .Missing }}
+<p>Not compared, only found in one of the runs: {{ range $i, $m := .Missing }}{{ if $i }}, {{ end }}{{ $m }}{{ end }}.</p>
+{{- end }}
+</body>
+</html>
+`))
+
+// WriteHTML writes the report as a standalone HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	return htmlReport.Execute(w, r)
+}
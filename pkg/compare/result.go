@@ -0,0 +1,83 @@
+package compare
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// QueryRun holds the timings of a query measured by the perf tool, one per ITERATION repeat.
+// The perf tool writes one JSON document per query:
+//
+//	{"name": "Q1", "query": "SELECT avg(number) FROM numbers(100000000)", "times": [0.12, 0.11, 0.13]}
+//
+// A file may also hold an array of such documents.
+type QueryRun struct {
+	Name  string `json:"name"`
+	Query string `json:"query,omitempty"`
+	// Times are the durations of the repeats in seconds.
+	Times []float64 `json:"times"`
+}
+
+// LoadResults reads the query runs from a JSON file, or from every JSON file of a directory.
+func LoadResults(path string) ([]QueryRun, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+
+	var runs []QueryRun
+	seen := map[string]bool{}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := parseResults(data)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing %v", f)
+		}
+		for _, r := range parsed {
+			if r.Name == "" {
+				return nil, fmt.Errorf("%v: query without a name", f)
+			}
+			if len(r.Times) == 0 {
+				return nil, fmt.Errorf("%v: query %v has no times", f, r.Name)
+			}
+			if seen[r.Name] {
+				return nil, fmt.Errorf("%v: duplicated query %v", f, r.Name)
+			}
+			seen[r.Name] = true
+			runs = append(runs, r)
+		}
+	}
+	return runs, nil
+}
+
+func parseResults(data []byte) ([]QueryRun, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var runs []QueryRun
+		err := json.Unmarshal(data, &runs)
+		return runs, err
+	}
+	var run QueryRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, err
+	}
+	return []QueryRun{run}, nil
+}
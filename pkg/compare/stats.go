@@ -0,0 +1,156 @@
+package compare
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// median returns the median of xs, xs must not be empty: Compare rejects the queries without times.
+func median(xs []float64) float64 {
+	s := append([]float64{}, xs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// relativeChange returns (current - ref) / ref, 0 when ref is 0 as the change can't be measured.
+func relativeChange(current, ref float64) float64 {
+	if ref == 0 {
+		return 0
+	}
+	return (current - ref) / ref
+}
+
+// bootstrapInterval returns the confidence interval of the relative change of the medians,
+// computed by resampling both samples with replacement.
+func bootstrapInterval(current, ref []float64, confidence float64, resamples int, rng *rand.Rand) (float64, float64) {
+	if resamples <= 0 {
+		c := relativeChange(median(current), median(ref))
+		return c, c
+	}
+	changes := make([]float64, resamples)
+	cs := make([]float64, len(current))
+	rs := make([]float64, len(ref))
+	for i := range changes {
+		for j := range cs {
+			cs[j] = current[rng.Intn(len(current))]
+		}
+		for j := range rs {
+			rs[j] = ref[rng.Intn(len(ref))]
+		}
+		changes[i] = relativeChange(median(cs), median(rs))
+	}
+	sort.Float64s(changes)
+	alpha := (1 - confidence) / 2
+	return percentile(changes, alpha), percentile(changes, 1-alpha)
+}
+
+// percentile returns the p-th percentile of sorted xs, interpolating between the closest ranks.
+func percentile(xs []float64, p float64) float64 {
+	pos := p * float64(len(xs)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	if lo == hi {
+		return xs[lo]
+	}
+	return xs[lo] + (xs[hi]-xs[lo])*(pos-float64(lo))
+}
+
+// maxExactSize is the largest total sample size for which the exact Mann-Whitney distribution is computed.
+const maxExactSize = 40
+
+// mannWhitney returns the two-sided p-value of the Mann-Whitney U test of the two samples.
+// The exact distribution is used for small samples without ties, the normal approximation
+// with tie and continuity corrections otherwise.
+func mannWhitney(x, y []float64) float64 {
+	n1, n2 := len(x), len(y)
+	type value struct {
+		v     float64
+		first bool
+	}
+	all := make([]value, 0, n1+n2)
+	for _, v := range x {
+		all = append(all, value{v, true})
+	}
+	for _, v := range y {
+		all = append(all, value{v, false})
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].v < all[j].v })
+
+	var r1, tieTerm float64
+	ties := false
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].v == all[i].v {
+			j++
+		}
+		// ranks i+1..j share their average
+		rank := float64(i+1+j) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				r1 += rank
+			}
+		}
+		if t := float64(j - i); t > 1 {
+			ties = true
+			tieTerm += t*t*t - t
+		}
+		i = j
+	}
+	u := r1 - float64(n1*(n1+1))/2
+
+	if !ties && n1+n2 <= maxExactSize {
+		return exactMannWhitney(u, n1, n2)
+	}
+	n := float64(n1 + n2)
+	mu := float64(n1*n2) / 2
+	sigma := math.Sqrt(float64(n1*n2) / 12 * ((n + 1) - tieTerm/(n*(n-1))))
+	if sigma == 0 {
+		return 1
+	}
+	z := math.Max(math.Abs(u-mu)-0.5, 0) / sigma
+	return math.Min(1, math.Erfc(z/math.Sqrt2))
+}
+
+// exactMannWhitney returns the two-sided p-value of u under the exact null distribution of U.
+func exactMannWhitney(u float64, n1, n2 int) float64 {
+	// counts[m][n][k] is the number of arrangements of m and n values with U = k,
+	// using c(m, n, k) = c(m-1, n, k-n) + c(m, n-1, k).
+	maxU := n1 * n2
+	prev := make([][]float64, n2+1)
+	for n := range prev {
+		prev[n] = make([]float64, maxU+1)
+		prev[n][0] = 1
+	}
+	for m := 1; m <= n1; m++ {
+		cur := make([][]float64, n2+1)
+		cur[0] = make([]float64, maxU+1)
+		cur[0][0] = 1
+		for n := 1; n <= n2; n++ {
+			cur[n] = make([]float64, maxU+1)
+			for k := 0; k <= m*n; k++ {
+				if k >= n {
+					cur[n][k] += prev[n][k-n]
+				}
+				cur[n][k] += cur[n-1][k]
+			}
+		}
+		prev = cur
+	}
+	dist := prev[n2]
+	var total, lower, upper float64
+	for k, c := range dist {
+		total += c
+		if float64(k) <= u {
+			lower += c
+		}
+		if float64(k) >= u {
+			upper += c
+		}
+	}
+	return math.Min(1, 2*math.Min(lower, upper)/total)
+}
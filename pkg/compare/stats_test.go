@@ -0,0 +1,65 @@
+package compare
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_median(t *testing.T) {
+	tests := []struct {
+		name   string
+		xs     []float64
+		expect float64
+	}{
+		{name: "single", xs: []float64{3}, expect: 3},
+		{name: "odd", xs: []float64{3, 1, 2}, expect: 2},
+		{name: "even", xs: []float64{4, 1, 3, 2}, expect: 2.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, median(tt.xs))
+		})
+	}
+}
+
+func Test_mannWhitney(t *testing.T) {
+	tests := []struct {
+		name   string
+		x      []float64
+		y      []float64
+		expect float64
+	}{
+		// exact: 2 arrangements out of C(6, 3) = 20 are as extreme
+		{name: "exact separated 3", x: []float64{1, 2, 3}, y: []float64{4, 5, 6}, expect: 0.1},
+		{name: "exact separated reversed", x: []float64{4, 5, 6}, y: []float64{1, 2, 3}, expect: 0.1},
+		// exact: 2 out of C(10, 5) = 252
+		{name: "exact separated 5", x: []float64{1, 2, 3, 4, 5}, y: []float64{6, 7, 8, 9, 10}, expect: 2.0 / 252},
+		{name: "exact interleaved", x: []float64{1, 4, 5}, y: []float64{2, 3, 6}, expect: 1},
+		{name: "all tied", x: []float64{1, 1, 1}, y: []float64{1, 1, 1}, expect: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.expect, mannWhitney(tt.x, tt.y), 1e-9)
+		})
+	}
+
+	// ties use the normal approximation, separated samples are still significant
+	p := mannWhitney([]float64{1, 1, 2, 2, 3, 3, 4, 4}, []float64{5, 5, 6, 6, 7, 7, 8, 8})
+	assert.Less(t, p, 0.01)
+}
+
+func Test_bootstrapInterval(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	low, high := bootstrapInterval([]float64{1.2, 1.21, 1.19, 1.22, 1.2}, []float64{1.0, 1.01, 0.99, 1.0, 1.02}, 0.95, 2000, rng)
+	assert.Greater(t, low, 0.1, "a 20% slowdown interval excludes 0")
+	assert.Less(t, high, 0.3)
+
+	low, high = bootstrapInterval([]float64{1.0, 1.3, 0.8}, []float64{1.1, 0.9, 1.2}, 0.95, 2000, rng)
+	assert.Less(t, low, 0.0, "overlapping samples interval contains 0")
+	assert.Greater(t, high, 0.0)
+
+	low, high = bootstrapInterval([]float64{2}, []float64{1}, 0.95, 0, rng)
+	assert.Equal(t, []float64{1, 1}, []float64{low, high})
+}
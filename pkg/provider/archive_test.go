@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveDir(t *testing.T) {
+	src := t.TempDir()
+	files := map[string]string{
+		"cluster/nodes/control-plane.yaml":                "name: control-plane\n",
+		"cluster/namespaces/bench/events.txt":             "",
+		"cluster/namespaces/bench/logs/query-0/query.log": "started\n",
+		"node-logs/kubelet.log":                           "ready\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+	dst := filepath.Join(t.TempDir(), "bench-20210701T100000Z.tar.gz")
+
+	assert.NoError(t, ArchiveDir(src, dst))
+
+	f, err := os.Open(dst)
+	assert.NoError(t, err)
+	defer f.Close()
+	gr, err := gzip.NewReader(f)
+	assert.NoError(t, err)
+	tr := tar.NewReader(gr)
+	read := map[string]string{}
+	var dirs []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		if header.Typeflag == tar.TypeDir {
+			dirs = append(dirs, header.Name)
+			continue
+		}
+		content, err := ioutil.ReadAll(tr)
+		assert.NoError(t, err)
+		read[header.Name] = string(content)
+	}
+
+	expect := map[string]string{}
+	for name, content := range files {
+		expect["bench-20210701T100000Z/"+name] = content
+	}
+	assert.Equal(t, expect, read)
+	assert.Contains(t, dirs, "bench-20210701T100000Z", "the prefix directory")
+	assert.Contains(t, dirs, "bench-20210701T100000Z/cluster/namespaces/bench/logs/query-0")
+}
@@ -0,0 +1,94 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"datafuselabs/test-infra/pkg/provider"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Register adds the cluster and resource command tree of every registered provider to the app,
+// and the resource command tree for existing clusters.
+func Register(app *kingpin.Application, dr *provider.DeploymentResource) {
+	for _, r := range provider.Providers() {
+		registerProvider(app, dr, r)
+	}
+	registerExisting(app, dr)
+}
+
+func registerProvider(app *kingpin.Application, dr *provider.DeploymentResource, r provider.Registration) {
+	c := &Cluster{
+		DeploymentResource: dr,
+		registration:       r,
+		requiredVars:       []string{"CLUSTER_NAME"},
+		ctx:                context.Background(),
+	}
+	name := r.Name
+
+	k8sProviderCmd := app.Command(name, r.Help).
+		Action(c.SetupDeploymentResources)
+
+	k8sProviderCmd.Command("info", fmt.Sprintf("%s info -v hashStable:COMMIT1 -v hashTesting:COMMIT2", name)).
+		Action(c.GetDeploymentVars)
+
+	//Cluster operations.
+	k8sCluster := k8sProviderCmd.Command("cluster", fmt.Sprintf("manage %s clusters", name)).
+		Action(c.NewProvider)
+	k8sCluster.Command("create", fmt.Sprintf("%s cluster create -f File -v PR_NUMBER:$PR_NUMBER -v CLUSTER_NAME:$CLUSTER_NAME", name)).
+		Action(c.ClusterDeploymentsParse).
+		Action(c.ClusterCreate)
+	clusterDelete := k8sCluster.Command("delete", fmt.Sprintf("%s cluster delete -v PR_NUMBER:$PR_NUMBER -v CLUSTER_NAME:$CLUSTER_NAME --snapshot-dir DIR", name)).
+		Action(c.checkClusterName).
+		Action(c.ClusterDelete)
+	clusterDelete.Flag("snapshot-dir", "when set, the cluster objects, pod logs, events and node logs are saved to a tarball in this folder before deleting the cluster.").
+		StringVar(&c.SnapshotDir)
+	k8sCluster.Command("check-running", fmt.Sprintf("%s cluster check-running -f File -v CLUSTER_NAME:$CLUSTER_NAME", name)).
+		Action(c.ClusterDeploymentsParse).
+		Action(c.ClusterRunning)
+	loadImage := k8sCluster.Command("load-image", fmt.Sprintf("%s cluster load-image -v CLUSTER_NAME:$CLUSTER_NAME -i IMAGE", name)).
+		Action(c.checkClusterName).
+		Action(c.LoadImage)
+	loadImage.Flag("image", "image from the local docker daemon to load on the cluster nodes.").
+		Short('i').
+		Required().
+		StringsVar(&c.Images)
+
+	// K8s resource operations.
+	k8sResource := k8sProviderCmd.Command("resource", `Apply and delete different k8s resources - deployments, services, config maps etc.`).
+		Action(c.NewProvider).
+		Action(c.K8SDeploymentsParse).
+		Action(c.NewK8sProvider)
+	k8sResource.Command("apply", fmt.Sprintf("%s resource apply -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2", name)).
+		Action(c.ResourceApply)
+	k8sResource.Command("delete", fmt.Sprintf("%s resource delete -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2", name)).
+		Action(c.ResourceDelete)
+}
+
+// registerExisting adds the resource command tree for a cluster that is not managed by infra,
+// like a minikube cluster or a shared benchmark cluster, reached through a kubeconfig context.
+func registerExisting(app *kingpin.Application, dr *provider.DeploymentResource) {
+	c := &Cluster{
+		DeploymentResource: dr,
+		ctx:                context.Background(),
+	}
+
+	k8sExisting := app.Command("k8s", "Existing cluster reached through a kubeconfig context, it has no cluster lifecycle commands").
+		Action(c.SetupDeploymentResources)
+	k8sExisting.Flag("kubeconfig", "kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config.").
+		StringVar(&c.KubeconfigPath)
+	k8sExisting.Flag("context", "kubeconfig context, defaults to the current context.").
+		StringVar(&c.KubeconfigContext)
+
+	k8sExisting.Command("info", "k8s info -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
+		Action(c.GetDeploymentVars)
+
+	// K8s resource operations.
+	k8sResource := k8sExisting.Command("resource", `Apply and delete different k8s resources - deployments, services, config maps etc.`).
+		Action(c.K8SDeploymentsParse).
+		Action(c.NewK8sProvider)
+	k8sResource.Command("apply", "k8s resource apply --context CONTEXT -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
+		Action(c.ResourceApply)
+	k8sResource.Command("delete", "k8s resource delete --context CONTEXT -f manifestsFileOrFolder -v hashStable:COMMIT1 -v hashTesting:COMMIT2").
+		Action(c.ResourceDelete)
+}
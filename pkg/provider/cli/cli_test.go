@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"datafuselabs/test-infra/pkg/provider"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/alecthomas/kingpin.v2"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// fakeProvider records the calls of the command tree.
+type fakeProvider struct {
+	status  provider.ClusterStatus
+	calls   []string
+	configs []string
+	images  []string
+}
+
+func (f *fakeProvider) Create(name string, config []byte) error {
+	f.calls = append(f.calls, "create "+name)
+	f.configs = append(f.configs, string(config))
+	return nil
+}
+
+func (f *fakeProvider) Delete(name string) error {
+	f.calls = append(f.calls, "delete "+name)
+	return nil
+}
+
+func (f *fakeProvider) Status(name string) (provider.ClusterStatus, error) {
+	f.calls = append(f.calls, "status "+name)
+	return f.status, nil
+}
+
+func (f *fakeProvider) Kubeconfig(name string) (*clientcmdapi.Config, error) {
+	return clientcmdapi.NewConfig(), nil
+}
+
+func (f *fakeProvider) LoadImage(name string, images []string) error {
+	f.calls = append(f.calls, "load-image "+name)
+	f.images = append(f.images, images...)
+	return nil
+}
+
+// fake is the provider built by the fake command of the current test.
+var fake *fakeProvider
+
+func init() {
+	provider.Register("fake", "fake provider", func() provider.ClusterProvider {
+		return fake
+	})
+}
+
+// newApp builds the command tree with the global flags of the infra tool.
+func newApp() *kingpin.Application {
+	dr := provider.NewDeploymentResource()
+	app := kingpin.New("infra", "")
+	app.Flag("file", "").Short('f').ExistingFilesOrDirsVar(&dr.DeploymentFiles)
+	app.Flag("vars", "").Short('v').StringMapVar(&dr.FlagDeploymentVars)
+	Register(app, dr)
+	return app
+}
+
+func TestRegister(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "cluster.yaml")
+	assert.NoError(t, ioutil.WriteFile(config, []byte("name: {{ .CLUSTER_NAME }}\n"), 0644))
+
+	tests := []struct {
+		name          string
+		args          []string
+		status        provider.ClusterStatus
+		expectCalls   []string
+		expectConfigs []string
+		expectImages  []string
+		expectError   string
+	}{
+		{
+			name:          "create",
+			args:          []string{"fake", "cluster", "create", "-f", config, "-v", "CLUSTER_NAME:bench"},
+			expectCalls:   []string{"create bench"},
+			expectConfigs: []string{"name: bench\n"},
+		},
+		{
+			name:        "create without a cluster name",
+			args:        []string{"fake", "cluster", "create", "-f", config},
+			expectError: "missing required CLUSTER_NAME variable",
+		},
+		{
+			name:        "create without a config file",
+			args:        []string{"fake", "cluster", "create", "-v", "CLUSTER_NAME:bench"},
+			expectError: "missing deployment file(s)",
+		},
+		{
+			name:        "check running",
+			args:        []string{"fake", "cluster", "check-running", "-f", config, "-v", "CLUSTER_NAME:bench"},
+			status:      provider.ClusterRunning,
+			expectCalls: []string{"status bench"},
+		},
+		{
+			name:          "check running creates a missing cluster",
+			args:          []string{"fake", "cluster", "check-running", "-f", config, "-v", "CLUSTER_NAME:bench"},
+			status:        provider.ClusterNotFound,
+			expectCalls:   []string{"status bench", "create bench"},
+			expectConfigs: []string{"name: bench\n"},
+		},
+		{
+			name:        "delete",
+			args:        []string{"fake", "cluster", "delete", "-v", "CLUSTER_NAME:bench"},
+			expectCalls: []string{"delete bench"},
+		},
+		{
+			name:        "delete without a cluster name",
+			args:        []string{"fake", "cluster", "delete"},
+			expectError: "missing required CLUSTER_NAME variable",
+		},
+		{
+			name:         "load image",
+			args:         []string{"fake", "cluster", "load-image", "-v", "CLUSTER_NAME:bench", "-i", "databend:a", "-i", "databend:b"},
+			expectCalls:  []string{"load-image bench"},
+			expectImages: []string{"databend:a", "databend:b"},
+		},
+		{
+			name:        "load image without an image",
+			args:        []string{"fake", "cluster", "load-image", "-v", "CLUSTER_NAME:bench"},
+			expectError: "required flag --image not provided",
+		},
+		{
+			name:        "existing cluster has no lifecycle commands",
+			args:        []string{"k8s", "cluster", "create"},
+			expectError: `expected command but got "cluster"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake = &fakeProvider{status: tt.status}
+			_, err := newApp().Parse(tt.args)
+			if tt.expectError != "" {
+				assert.EqualError(t, err, tt.expectError)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectCalls, fake.calls)
+			assert.Equal(t, tt.expectConfigs, fake.configs)
+			assert.Equal(t, tt.expectImages, fake.images)
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"datafuselabs/test-infra/pkg/provider"
+	k8sProvider "datafuselabs/test-infra/pkg/provider/k8s"
+	"gopkg.in/alecthomas/kingpin.v2"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Cluster holds the state shared by the kingpin actions of a single provider command tree.
+type Cluster struct {
+	// The registered provider this command tree belongs to.
+	registration provider.Registration
+	// The cluster provider used for the cluster lifecycle.
+	provider provider.ClusterProvider
+	// The k8s provider used when we work with the manifest files.
+	k8sProvider *k8sProvider.K8s
+	// Final DeploymentFiles files.
+	DeploymentFiles []string
+	// Final DeploymentVars.
+	DeploymentVars map[string]string
+	// DeployResource to construct DeploymentVars and DeploymentFiles
+	DeploymentResource *provider.DeploymentResource
+	// Images to load on the cluster nodes.
+	Images []string
+	// Folder for the snapshot tarball taken before deleting a cluster.
+	SnapshotDir string
+	// Kubeconfig file and context used by the k8s provider when no cluster provider is used.
+	KubeconfigPath    string
+	KubeconfigContext string
+	// Deployment vars that must be set for the command tree.
+	requiredVars []string
+	// Content bytes after parsing the template variables, grouped by filename.
+	clusterResources []provider.Resource
+	// K8s resource.runtime objects after parsing the template variables, grouped by filename.
+	k8sResources []k8sProvider.Resource
+
+	ctx context.Context
+}
+
+// SetupDeploymentResources Sets up DeploymentVars and DeploymentFiles
+func (c *Cluster) SetupDeploymentResources(*kingpin.ParseContext) error {
+	c.DeploymentFiles = c.DeploymentResource.DeploymentFiles
+	c.DeploymentVars = provider.MergeDeploymentVars(
+		c.DeploymentResource.DefaultDeploymentVars,
+		c.DeploymentResource.FlagDeploymentVars,
+	)
+	return nil
+}
+
+// NewProvider instantiates the cluster provider selected on the command line.
+func (c *Cluster) NewProvider(*kingpin.ParseContext) error {
+	c.provider = c.registration.New()
+	return nil
+}
+
+// ClusterDeploymentsParse parses the cluster config files and saves the result as bytes grouped by the filename.
+// Any DeploymentVar will be replaced in the resources files following the golang text template format.
+func (c *Cluster) ClusterDeploymentsParse(*kingpin.ParseContext) error {
+	if err := c.checkDeploymentVarsAndFiles(); err != nil {
+		return err
+	}
+
+	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
+	if err != nil {
+		return err
+	}
+	c.clusterResources = deploymentResource
+	return nil
+}
+
+// K8SDeploymentsParse parses the k8s manifest files and saves the result as k8s objects grouped by the filename.
+func (c *Cluster) K8SDeploymentsParse(*kingpin.ParseContext) error {
+	if err := c.checkDeploymentVarsAndFiles(); err != nil {
+		return err
+	}
+
+	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
+	if err != nil {
+		return err
+	}
+	c.k8sResources, err = k8sProvider.Decode(deploymentResource)
+	if err != nil {
+		return err
+	}
+	return k8sProvider.ValidateQoS(c.k8sResources)
+}
+
+// checkDeploymentVarsAndFiles checks whether the required deployment vars and files are passed.
+func (c *Cluster) checkDeploymentVarsAndFiles() error {
+	for _, k := range c.requiredVars {
+		if v, ok := c.DeploymentVars[k]; !ok || v == "" {
+			return fmt.Errorf("missing required %v variable", k)
+		}
+	}
+	if len(c.DeploymentFiles) == 0 {
+		return fmt.Errorf("missing deployment file(s)")
+	}
+	return nil
+}
+
+// checkClusterName checks whether the cluster name is passed.
+func (c *Cluster) checkClusterName(*kingpin.ParseContext) error {
+	if v, ok := c.DeploymentVars["CLUSTER_NAME"]; !ok || v == "" {
+		return fmt.Errorf("missing required CLUSTER_NAME variable")
+	}
+	return nil
+}
+
+// ClusterCreate creates a new cluster for every cluster config file.
+func (c *Cluster) ClusterCreate(*kingpin.ParseContext) error {
+	for _, deployment := range c.clusterResources {
+		if err := c.provider.Create(c.DeploymentVars["CLUSTER_NAME"], deployment.Content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClusterRunning checks whether the cluster is running and creates it when it doesn't exist.
+func (c *Cluster) ClusterRunning(*kingpin.ParseContext) error {
+	name := c.DeploymentVars["CLUSTER_NAME"]
+	status, err := c.provider.Status(name)
+	if err != nil {
+		return err
+	}
+	if status == provider.ClusterRunning {
+		log.Printf("cluster %v is running", name)
+		return nil
+	}
+	return c.ClusterCreate(nil)
+}
+
+// ClusterDelete deletes a k8s cluster.
+// When a snapshot dir is set the cluster state is saved first, a failed snapshot doesn't prevent the deletion.
+func (c *Cluster) ClusterDelete(*kingpin.ParseContext) error {
+	name := c.DeploymentVars["CLUSTER_NAME"]
+	if c.SnapshotDir != "" {
+		tarball, err := c.snapshot(name)
+		if err != nil {
+			log.Printf("snapshot of cluster %v failed: %v", name, err)
+		} else {
+			log.Printf("snapshot of cluster %v saved to %v", name, tarball)
+		}
+	}
+	return c.provider.Delete(name)
+}
+
+// snapshot dumps the cluster state and the node logs into a tarball and returns its path.
+func (c *Cluster) snapshot(name string) (string, error) {
+	dir, err := ioutil.TempDir("", "snapshot-"+name)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := c.NewK8sProvider(nil); err != nil {
+		return "", err
+	}
+	if err := c.k8sProvider.Snapshot(filepath.Join(dir, "cluster")); err != nil {
+		return "", err
+	}
+	if collector, ok := c.provider.(provider.LogCollector); ok {
+		if err := collector.CollectLogs(name, filepath.Join(dir, "node-logs")); err != nil {
+			log.Printf("collecting node logs of cluster %v failed: %v", name, err)
+		}
+	}
+
+	if err := os.MkdirAll(c.SnapshotDir, 0755); err != nil {
+		return "", err
+	}
+	tarball := filepath.Join(c.SnapshotDir, fmt.Sprintf("%s-%s.tar.gz", name, time.Now().UTC().Format("20060102T150405Z")))
+	return tarball, provider.ArchiveDir(dir, tarball)
+}
+
+// LoadImage loads local images on all cluster nodes.
+func (c *Cluster) LoadImage(*kingpin.ParseContext) error {
+	return c.provider.LoadImage(c.DeploymentVars["CLUSTER_NAME"], c.Images)
+}
+
+// NewK8sProvider sets the k8s provider used for deploying k8s manifests.
+// The kubeconfig comes from the cluster provider or from the kubeconfig flags when there is none.
+func (c *Cluster) NewK8sProvider(*kingpin.ParseContext) error {
+	var apiConfig *clientcmdapi.Config
+	var err error
+	if c.provider != nil {
+		apiConfig, err = c.provider.Kubeconfig(c.DeploymentVars["CLUSTER_NAME"])
+	} else {
+		apiConfig, err = k8sProvider.LoadKubeconfig(c.KubeconfigPath, c.KubeconfigContext)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.k8sProvider, err = k8sProvider.New(c.ctx, apiConfig)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResourceApply calls k8s.ResourceApply to apply the k8s objects in the manifest files.
+func (c *Cluster) ResourceApply(*kingpin.ParseContext) error {
+	if err := c.k8sProvider.ResourceApply(c.k8sResources); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ResourceDelete calls k8s.ResourceDelete to delete the k8s objects in the manifest files.
+func (c *Cluster) ResourceDelete(*kingpin.ParseContext) error {
+	if err := c.k8sProvider.ResourceDelete(c.k8sResources); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetDeploymentVars shows deployment variables.
+func (c *Cluster) GetDeploymentVars(*kingpin.ParseContext) error {
+	fmt.Print("-------------------\n   DeploymentVars   \n------------------- \n")
+	for key, value := range c.DeploymentVars {
+		fmt.Println(key, ": ", value)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// ClusterStatus is the state of a cluster as reported by its provider.
+type ClusterStatus string
+
+const (
+	ClusterRunning  ClusterStatus = "running"
+	ClusterNotFound ClusterStatus = "not-found"
+)
+
+// ClusterProvider manages the lifecycle of k8s clusters for a single backend (kind, k3d, a cloud provider...).
+// K8s resource operations are not part of the provider, they go through the kubeconfig it returns.
+type ClusterProvider interface {
+	// Create creates a new cluster from the parsed cluster config file.
+	Create(name string, config []byte) error
+	// Delete deletes the cluster and all its nodes.
+	Delete(name string) error
+	// Status reports whether the cluster exists.
+	Status(name string) (ClusterStatus, error)
+	// Kubeconfig returns the client config used to reach the cluster api server.
+	Kubeconfig(name string) (*clientcmdapi.Config, error)
+	// LoadImage makes locally available images usable by all nodes of the cluster.
+	LoadImage(name string, images []string) error
+}
+
+// LogCollector is implemented by providers that can export the logs of the node machines or containers.
+type LogCollector interface {
+	// CollectLogs writes the node logs of the cluster into dir.
+	CollectLogs(name, dir string) error
+}
+
+// Factory builds a new ClusterProvider.
+type Factory func() ClusterProvider
+
+// Registration describes a provider and how the cli should present it.
+type Registration struct {
+	// Name is used as the cli command name.
+	Name string
+	// Help is the description shown in the cli usage.
+	Help string
+	// New builds the provider, it is only called when the provider command is selected.
+	New Factory
+}
+
+var providers = map[string]Registration{}
+
+// Register makes a cluster provider available to the cli.
+// It is meant to be called from the init function of the provider package.
+func Register(name, help string, fn Factory) {
+	if _, ok := providers[name]; ok {
+		panic(fmt.Sprintf("cluster provider %q registered twice", name))
+	}
+	providers[name] = Registration{Name: name, Help: help, New: fn}
+}
+
+// Providers returns all registered providers sorted by name.
+func Providers() []Registration {
+	res := make([]Registration, 0, len(providers))
+	for _, r := range providers {
+		res = append(res, r)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
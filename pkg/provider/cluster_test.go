@@ -0,0 +1,35 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegister(t *testing.T) {
+	defer func(saved map[string]Registration) { providers = saved }(providers)
+	providers = map[string]Registration{}
+
+	Register("kind", "kind provider", nil)
+	Register("k3d", "k3d provider", nil)
+	Register("eks", "eks provider", nil)
+
+	var names []string
+	for _, r := range Providers() {
+		names = append(names, r.Name)
+	}
+	assert.Equal(t, []string{"eks", "k3d", "kind"}, names)
+	assert.Equal(t, "k3d provider", providers["k3d"].Help)
+
+	assert.PanicsWithValue(t, `cluster provider "kind" registered twice`, func() {
+		Register("kind", "another kind provider", nil)
+	})
+}
+
+func TestMergeDeploymentVars(t *testing.T) {
+	res := MergeDeploymentVars(
+		map[string]string{"CLUSTER_NAME": "default", "PR_NUMBER": "1"},
+		map[string]string{"CLUSTER_NAME": "bench"},
+	)
+	assert.Equal(t, map[string]string{"CLUSTER_NAME": "bench", "PR_NUMBER": "1"}, res)
+}
@@ -0,0 +1,114 @@
+package k3d
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"datafuselabs/test-infra/pkg/provider"
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func init() {
+	provider.Register("k3d", `k3s in docker (k3d) provider - https://k3d.io`, func() provider.ClusterProvider {
+		return New()
+	})
+}
+
+// K3D manages k3s clusters running as containers through the k3d cli.
+type K3D struct {
+	// The k3d binary, K3D_BIN overrides the one found in the PATH.
+	bin string
+}
+
+// New is the K3D constructor.
+func New() *K3D {
+	bin := os.Getenv("K3D_BIN")
+	if bin == "" {
+		bin = "k3d"
+	}
+	return &K3D{bin: bin}
+}
+
+// cluster is the subset of `k3d cluster list -o json` we care about.
+type cluster struct {
+	Name           string `json:"name"`
+	ServersRunning int    `json:"serversRunning"`
+}
+
+// run executes a k3d command and returns its stdout.
+func (c *K3D) run(args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(c.bin, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "%s %s: %s", c.bin, strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// Create creates a new cluster from a k3d config file.
+// The cluster name always comes from the cli so the config file name is overridden.
+func (c *K3D) Create(name string, config []byte) error {
+	f, err := ioutil.TempFile("", "k3d-config-*.yaml")
+	if err != nil {
+		return errors.Wrap(err, "creating temp config file")
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(config); err != nil {
+		f.Close()
+		return errors.Wrap(err, "writing temp config file")
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	_, err = c.run("cluster", "create", name, "--config", f.Name(), "--wait")
+	return err
+}
+
+// Delete deletes a k3d cluster and removes it from the default kubeconfig.
+func (c *K3D) Delete(name string) error {
+	_, err := c.run("cluster", "delete", name)
+	return err
+}
+
+// Status checks whether the cluster exists and has a running server.
+func (c *K3D) Status(name string) (provider.ClusterStatus, error) {
+	out, err := c.run("cluster", "list", "-o", "json")
+	if err != nil {
+		return "", err
+	}
+	var clusters []cluster
+	if err := json.Unmarshal(out, &clusters); err != nil {
+		return "", errors.Wrap(err, "decoding k3d cluster list")
+	}
+	for _, cl := range clusters {
+		if cl.Name == name && cl.ServersRunning > 0 {
+			return provider.ClusterRunning, nil
+		}
+	}
+	return provider.ClusterNotFound, nil
+}
+
+// Kubeconfig returns the kubeconfig k3d generated for the cluster.
+func (c *K3D) Kubeconfig(name string) (*clientcmdapi.Config, error) {
+	out, err := c.run("kubeconfig", "get", name)
+	if err != nil {
+		return nil, err
+	}
+	return clientcmd.Load(out)
+}
+
+// LoadImage imports images from the local container runtime on every node.
+func (c *K3D) LoadImage(name string, images []string) error {
+	args := append([]string{"image", "import", "--cluster", name}, images...)
+	_, err := c.run(args...)
+	return err
+}
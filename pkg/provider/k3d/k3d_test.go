@@ -0,0 +1,89 @@
+package k3d
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"datafuselabs/test-infra/pkg/provider"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeK3D writes a k3d binary that logs its arguments, prints the cluster list
+// and fails the commands on the cluster broken.
+func fakeK3D(t *testing.T) (*K3D, string) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+echo "$@" >> ` + calls + `
+case "$*" in
+*broken*) echo "cluster broken is broken" >&2; exit 1 ;;
+"cluster list -o json") echo '[{"name":"bench","serversRunning":1},{"name":"stopped","serversRunning":0}]' ;;
+"kubeconfig get bench") printf 'apiVersion: v1\nkind: Config\ncurrent-context: k3d-bench\ncontexts:\n- name: k3d-bench\n  context:\n    cluster: k3d-bench\nclusters:\n- name: k3d-bench\n  cluster:\n    server: https://0.0.0.0:6443\n' ;;
+"cluster create "*) cat "$5" >> ` + calls + ` ;;
+esac
+`
+	bin := filepath.Join(dir, "k3d")
+	assert.NoError(t, ioutil.WriteFile(bin, []byte(script), 0755))
+	return &K3D{bin: bin}, calls
+}
+
+func readCalls(t *testing.T, calls string) []string {
+	content, err := ioutil.ReadFile(calls)
+	assert.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(content)), "\n")
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		cluster string
+		expect  provider.ClusterStatus
+	}{
+		{name: "running", cluster: "bench", expect: provider.ClusterRunning},
+		{name: "no server running", cluster: "stopped", expect: provider.ClusterNotFound},
+		{name: "missing", cluster: "missing", expect: provider.ClusterNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, calls := fakeK3D(t)
+			status, err := k.Status(tt.cluster)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expect, status)
+			assert.Equal(t, []string{"cluster list -o json"}, readCalls(t, calls))
+		})
+	}
+}
+
+func TestCreate(t *testing.T) {
+	k, calls := fakeK3D(t)
+	assert.NoError(t, k.Create("bench", []byte("servers: 1")))
+	lines := readCalls(t, calls)
+	assert.Len(t, lines, 2)
+	assert.Regexp(t, `^cluster create bench --config .*/k3d-config-.*\.yaml --wait$`, lines[0])
+	assert.Equal(t, "servers: 1", lines[1], "the config is passed to k3d")
+	config := strings.Fields(lines[0])[4]
+	assert.NoFileExists(t, config, "the temp config is removed")
+}
+
+func TestLoadImage(t *testing.T) {
+	k, calls := fakeK3D(t)
+	assert.NoError(t, k.LoadImage("bench", []string{"databend:a", "databend:b"}))
+	assert.Equal(t, []string{"image import --cluster bench databend:a databend:b"}, readCalls(t, calls))
+}
+
+func TestKubeconfig(t *testing.T) {
+	k, _ := fakeK3D(t)
+	config, err := k.Kubeconfig("bench")
+	assert.NoError(t, err)
+	assert.Equal(t, "k3d-bench", config.CurrentContext)
+	assert.Equal(t, "https://0.0.0.0:6443", config.Clusters["k3d-bench"].Server)
+}
+
+func TestRun_error(t *testing.T) {
+	k, _ := fakeK3D(t)
+	err := k.Delete("broken")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "cluster delete broken: cluster broken is broken")
+}
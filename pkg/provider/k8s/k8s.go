@@ -15,6 +15,7 @@ import (
 	apiErrors "k8s.io/apimachinery/pkg/api/errors"
 	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -44,7 +45,8 @@ type Resource struct {
 
 // K8s holds the fields used to generate API request from within a cluster.
 type K8s struct {
-	clt          *kubernetes.Clientset
+	clt          kubernetes.Interface
+	dynamicClt   dynamic.Interface
 	ApiExtClient *apiServerExtensionsClient.Clientset
 	// DeploymentFiles files provided from the cli.
 	DeploymentFiles []string
@@ -80,14 +82,42 @@ func New(ctx context.Context, config *clientcmdapi.Config) (*K8s, error) {
 		return nil, errors.Wrapf(err, "k8s api extensions client error")
 	}
 
+	dynamicClientset, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "k8s dynamic client error")
+	}
+
 	return &K8s{
 		ctx:            ctx,
 		clt:            clientset,
+		dynamicClt:     dynamicClientset,
 		ApiExtClient:   apiExtClientset,
 		DeploymentVars: make(map[string]string),
 	}, nil
 }
 
+// LoadKubeconfig loads a kubeconfig file and selects the given context.
+// An empty path follows the default loading rules ($KUBECONFIG or ~/.kube/config)
+// and an empty context keeps the current context of the file.
+func LoadKubeconfig(path, context string) (*clientcmdapi.Config, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = path
+	config, err := rules.Load()
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading kubeconfig")
+	}
+	if context != "" {
+		if _, ok := config.Contexts[context]; !ok {
+			return nil, fmt.Errorf("context %v not found in kubeconfig", context)
+		}
+		config.CurrentContext = context
+	}
+	if config.CurrentContext == "" {
+		return nil, fmt.Errorf("no context selected in kubeconfig")
+	}
+	return config, nil
+}
+
 // GetResources is a getter function for Resources field in K8s.
 func (c *K8s) GetResources() []Resource {
 	return c.resources
@@ -101,6 +131,18 @@ func (c *K8s) DeploymentsParse(*kingpin.ParseContext) error {
 		log.Fatalf("Couldn't parse deployment files: %v", err)
 	}
 
+	resources, err := Decode(deploymentResource)
+	if err != nil {
+		return err
+	}
+	c.resources = append(c.resources, resources...)
+	return nil
+}
+
+// Decode decodes the parsed deployment files into k8s objects grouped by the filename.
+// Files without any k8s object are skipped.
+func Decode(deploymentResource []provider.Resource) ([]Resource, error) {
+	var resources []Resource
 	for _, deployment := range deploymentResource {
 
 		decode := scheme.Codecs.UniversalDeserializer().Decode
@@ -114,7 +156,7 @@ func (c *K8s) DeploymentsParse(*kingpin.ParseContext) error {
 
 			resource, _, err := decode([]byte(text), nil, nil)
 			if err != nil {
-				return errors.Wrapf(err, "decoding the resource file:%v, section:%v...", deployment.FileName, text[:100])
+				return nil, errors.Wrapf(err, "decoding the resource file:%v, section:%v...", deployment.FileName, text[:100])
 			}
 			if resource == nil {
 				continue
@@ -122,10 +164,10 @@ func (c *K8s) DeploymentsParse(*kingpin.ParseContext) error {
 			k8sObjects = append(k8sObjects, resource)
 		}
 		if len(k8sObjects) > 0 {
-			c.resources = append(c.resources, Resource{FileName: deployment.FileName, Objects: k8sObjects})
+			resources = append(resources, Resource{FileName: deployment.FileName, Objects: k8sObjects})
 		}
 	}
-	return nil
+	return resources, nil
 }
 
 // ResourceApply applies k8s objects.
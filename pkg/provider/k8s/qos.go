@@ -0,0 +1,82 @@
+package k8s
+
+import (
+	"fmt"
+
+	appsV1 "k8s.io/api/apps/v1"
+	batchV1 "k8s.io/api/batch/v1"
+	apiCoreV1 "k8s.io/api/core/v1"
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+const (
+	// QoSAnnotation marks workloads whose pods must be scheduled with a given QoS.
+	QoSAnnotation = "test-infra.databend.io/qos"
+	// QoSGuaranteed requires the Guaranteed QoS class.
+	QoSGuaranteed = "guaranteed"
+	// QoSPinned requires the Guaranteed QoS class with integer cpus so the static cpu manager pins the containers.
+	QoSPinned = "pinned"
+)
+
+// ValidateQoS checks the pod templates of the workloads annotated with QoSAnnotation.
+// Benchmark results are only comparable when both sides run on reserved, identical resources.
+func ValidateQoS(deployments []Resource) error {
+	for _, deployment := range deployments {
+		for _, resource := range deployment.Objects {
+			meta, spec := podTemplate(resource)
+			if meta == nil {
+				continue
+			}
+			qos, ok := meta.Annotations[QoSAnnotation]
+			if !ok {
+				continue
+			}
+			kind := resource.GetObjectKind().GroupVersionKind().Kind
+			if err := validatePodQoS(spec, qos); err != nil {
+				return fmt.Errorf("error validating '%v' kind: %v, name: %v err:%v", deployment.FileName, kind, meta.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// podTemplate returns the metadata and the pod spec of the workload kinds we deploy.
+func podTemplate(resource runtime.Object) (*apiMetaV1.ObjectMeta, *apiCoreV1.PodSpec) {
+	switch req := resource.(type) {
+	case *apiCoreV1.Pod:
+		return &req.ObjectMeta, &req.Spec
+	case *appsV1.Deployment:
+		return &req.ObjectMeta, &req.Spec.Template.Spec
+	case *appsV1.StatefulSet:
+		return &req.ObjectMeta, &req.Spec.Template.Spec
+	case *appsV1.DaemonSet:
+		return &req.ObjectMeta, &req.Spec.Template.Spec
+	case *batchV1.Job:
+		return &req.ObjectMeta, &req.Spec.Template.Spec
+	}
+	return nil, nil
+}
+
+func validatePodQoS(spec *apiCoreV1.PodSpec, qos string) error {
+	if qos != QoSGuaranteed && qos != QoSPinned {
+		return fmt.Errorf("unknown %v value %v", QoSAnnotation, qos)
+	}
+	containers := append(append([]apiCoreV1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, c := range containers {
+		for _, name := range []apiCoreV1.ResourceName{apiCoreV1.ResourceCPU, apiCoreV1.ResourceMemory} {
+			limit, ok := c.Resources.Limits[name]
+			if !ok || limit.IsZero() {
+				return fmt.Errorf("container %v has no %v limit", c.Name, name)
+			}
+			// Requests default to the limits when they are not set.
+			if request, ok := c.Resources.Requests[name]; ok && request.Cmp(limit) != 0 {
+				return fmt.Errorf("container %v %v request %v differs from the limit %v", c.Name, name, request.String(), limit.String())
+			}
+		}
+		if cpu := c.Resources.Limits[apiCoreV1.ResourceCPU]; qos == QoSPinned && cpu.MilliValue()%1000 != 0 {
+			return fmt.Errorf("container %v cpu %v must be an integer to get pinned cores", c.Name, cpu.String())
+		}
+	}
+	return nil
+}
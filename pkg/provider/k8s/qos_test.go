@@ -0,0 +1,79 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsV1 "k8s.io/api/apps/v1"
+	apiCoreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func newFakeDeployment(qos string, requests, limits apiCoreV1.ResourceList) runtime.Object {
+	d := &appsV1.Deployment{
+		ObjectMeta: apiMetaV1.ObjectMeta{Name: "perf.current", Annotations: map[string]string{}},
+	}
+	if qos != "" {
+		d.Annotations[QoSAnnotation] = qos
+	}
+	d.Spec.Template.Spec.Containers = []apiCoreV1.Container{{
+		Name:      "perf-current",
+		Resources: apiCoreV1.ResourceRequirements{Requests: requests, Limits: limits},
+	}}
+	return d
+}
+
+func resources(cpu, memory string) apiCoreV1.ResourceList {
+	return apiCoreV1.ResourceList{
+		apiCoreV1.ResourceCPU:    resource.MustParse(cpu),
+		apiCoreV1.ResourceMemory: resource.MustParse(memory),
+	}
+}
+
+func TestValidateQoS(t *testing.T) {
+	tests := []struct {
+		name        string
+		object      runtime.Object
+		expectError bool
+	}{
+		{
+			name:   "not annotated",
+			object: newFakeDeployment("", resources("300m", "1Gi"), nil),
+		},
+		{
+			name:   "pinned",
+			object: newFakeDeployment(QoSPinned, resources("3", "3Gi"), resources("3", "3Gi")),
+		},
+		{
+			name:   "requests default to limits",
+			object: newFakeDeployment(QoSPinned, nil, resources("3", "3Gi")),
+		},
+		{
+			name:   "guaranteed fractional cpu",
+			object: newFakeDeployment(QoSGuaranteed, resources("3300m", "3Gi"), resources("3300m", "3Gi")),
+		},
+		{
+			name:        "pinned fractional cpu",
+			object:      newFakeDeployment(QoSPinned, resources("3300m", "3Gi"), resources("3300m", "3Gi")),
+			expectError: true,
+		},
+		{
+			name:        "burstable",
+			object:      newFakeDeployment(QoSGuaranteed, resources("2", "3Gi"), resources("3", "3Gi")),
+			expectError: true,
+		},
+		{
+			name:        "no limits",
+			object:      newFakeDeployment(QoSGuaranteed, resources("3", "3Gi"), nil),
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateQoS([]Resource{{FileName: "current.yaml", Objects: []runtime.Object{tt.object}}})
+			assert.Equal(t, tt.expectError, err != nil, "err: %v", err)
+		})
+	}
+}
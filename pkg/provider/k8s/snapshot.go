@@ -0,0 +1,219 @@
+package k8s
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	apiCoreV1 "k8s.io/api/core/v1"
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/yaml"
+)
+
+// snapshotSkippedResources are never dumped, secrets would leak credentials into the artifacts
+// and events are written separately in a readable format.
+var snapshotSkippedResources = map[string]bool{
+	"secrets": true,
+	"events":  true,
+}
+
+// Snapshot dumps the cluster state into dir so it can be inspected after the cluster is gone.
+// The layout is:
+//
+//	nodes/<node>.yaml
+//	namespaces/<namespace>/<resource>/<name>.yaml
+//	namespaces/<namespace>/events.txt
+//	namespaces/<namespace>/logs/<pod>/<container>[.previous].log
+//
+// Errors on a single object are logged and skipped so a broken object doesn't hide the rest of the state.
+func (c *K8s) Snapshot(dir string) error {
+	if err := c.snapshotNodes(filepath.Join(dir, "nodes")); err != nil {
+		return err
+	}
+
+	namespaces, err := c.clt.CoreV1().Namespaces().List(c.ctx, apiMetaV1.ListOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "listing namespaces")
+	}
+	resources, err := c.namespacedResources()
+	if err != nil {
+		return err
+	}
+	for _, ns := range namespaces.Items {
+		nsDir := filepath.Join(dir, "namespaces", ns.Name)
+		for _, gvr := range resources {
+			c.snapshotResource(nsDir, ns.Name, gvr)
+		}
+		if err := c.snapshotEvents(nsDir, ns.Name); err != nil {
+			log.Printf("snapshot events of namespace %v failed: %v", ns.Name, err)
+		}
+		if err := c.snapshotLogs(filepath.Join(nsDir, "logs"), ns.Name); err != nil {
+			log.Printf("snapshot logs of namespace %v failed: %v", ns.Name, err)
+		}
+	}
+	return nil
+}
+
+// namespacedResources returns every namespaced resource type the api server can list.
+func (c *K8s) namespacedResources() ([]schema.GroupVersionResource, error) {
+	lists, err := discovery.ServerPreferredNamespacedResources(c.clt.Discovery())
+	// Discovery returns partial results when a single api group is unavailable.
+	if err != nil && len(lists) == 0 {
+		return nil, errors.Wrapf(err, "discovering api resources")
+	}
+
+	var res []schema.GroupVersionResource
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if snapshotSkippedResources[r.Name] || strings.Contains(r.Name, "/") || !hasVerb(r.Verbs, "list") {
+				continue
+			}
+			res = append(res, gv.WithResource(r.Name))
+		}
+	}
+	return res, nil
+}
+
+func hasVerb(verbs apiMetaV1.Verbs, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *K8s) snapshotResource(dir, namespace string, gvr schema.GroupVersionResource) {
+	list, err := c.dynamicClt.Resource(gvr).Namespace(namespace).List(c.ctx, apiMetaV1.ListOptions{})
+	if err != nil {
+		log.Printf("snapshot of %v in namespace %v failed: %v", gvr.String(), namespace, err)
+		return
+	}
+	for _, item := range list.Items {
+		content, err := yaml.Marshal(item.Object)
+		if err != nil {
+			log.Printf("encoding %v %v/%v failed: %v", gvr.Resource, namespace, item.GetName(), err)
+			continue
+		}
+		name := filepath.Join(dir, gvr.Resource, item.GetName()+".yaml")
+		if err := writeSnapshotFile(name, content); err != nil {
+			log.Printf("writing %v failed: %v", name, err)
+		}
+	}
+}
+
+func (c *K8s) snapshotNodes(dir string) error {
+	nodes, err := c.clt.CoreV1().Nodes().List(c.ctx, apiMetaV1.ListOptions{})
+	if err != nil {
+		return errors.Wrapf(err, "listing nodes")
+	}
+	for _, node := range nodes.Items {
+		content, err := yaml.Marshal(node)
+		if err != nil {
+			return errors.Wrapf(err, "encoding node %v", node.Name)
+		}
+		if err := writeSnapshotFile(filepath.Join(dir, node.Name+".yaml"), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotEvents writes the namespace events sorted by time, one event per line.
+func (c *K8s) snapshotEvents(dir, namespace string) error {
+	events, err := c.clt.CoreV1().Events(namespace).List(c.ctx, apiMetaV1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	if len(events.Items) == 0 {
+		return nil
+	}
+	items := events.Items
+	sort.Slice(items, func(i, j int) bool {
+		return eventTime(items[i]).Before(eventTime(items[j]))
+	})
+
+	var b strings.Builder
+	for _, e := range items {
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s/%s\t%s\n",
+			eventTime(e).UTC().Format("2006-01-02T15:04:05Z"), e.Type, e.Reason,
+			strings.ToLower(e.InvolvedObject.Kind), e.InvolvedObject.Name, e.Message)
+	}
+	return writeSnapshotFile(filepath.Join(dir, "events.txt"), []byte(b.String()))
+}
+
+func eventTime(e apiCoreV1.Event) time.Time {
+	if !e.LastTimestamp.IsZero() {
+		return e.LastTimestamp.Time
+	}
+	if !e.EventTime.IsZero() {
+		return e.EventTime.Time
+	}
+	return e.CreationTimestamp.Time
+}
+
+// snapshotLogs writes the logs of every container, including the previous instance of restarted containers.
+func (c *K8s) snapshotLogs(dir, namespace string) error {
+	pods, err := c.clt.CoreV1().Pods(namespace).List(c.ctx, apiMetaV1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+			name := filepath.Join(dir, pod.Name, status.Name+".log")
+			if err := c.snapshotContainerLog(name, namespace, pod.Name, status.Name, false); err != nil {
+				log.Printf("logs of %v/%v/%v failed: %v", namespace, pod.Name, status.Name, err)
+			}
+			if status.RestartCount == 0 {
+				continue
+			}
+			name = filepath.Join(dir, pod.Name, status.Name+".previous.log")
+			if err := c.snapshotContainerLog(name, namespace, pod.Name, status.Name, true); err != nil {
+				log.Printf("previous logs of %v/%v/%v failed: %v", namespace, pod.Name, status.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *K8s) snapshotContainerLog(name, namespace, pod, container string, previous bool) error {
+	stream, err := c.clt.CoreV1().Pods(namespace).GetLogs(pod, &apiCoreV1.PodLogOptions{
+		Container: container,
+		Previous:  previous,
+	}).Stream(c.ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, stream)
+	return err
+}
+
+func writeSnapshotFile(name string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, content, 0644)
+}
@@ -0,0 +1,162 @@
+package k8s
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	apiCoreV1 "k8s.io/api/core/v1"
+	apiMetaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var (
+	configMapsResource  = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	secretsResource     = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	deploymentsResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
+func unstructuredObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   map[string]interface{}{"namespace": namespace, "name": name},
+	}}
+}
+
+func event(name, reason string, at time.Time) *apiCoreV1.Event {
+	return &apiCoreV1.Event{
+		ObjectMeta:     apiMetaV1.ObjectMeta{Namespace: "bench", Name: name},
+		InvolvedObject: apiCoreV1.ObjectReference{Kind: "Pod", Name: "query-0"},
+		Type:           "Normal",
+		Reason:         reason,
+		Message:        reason + " query-0",
+		LastTimestamp:  apiMetaV1.NewTime(at),
+	}
+}
+
+// snapshotFiles returns the files written under dir, relative to dir.
+func snapshotFiles(t *testing.T, dir string) []string {
+	var files []string
+	assert.NoError(t, filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	}))
+	sort.Strings(files)
+	return files
+}
+
+func TestSnapshot(t *testing.T) {
+	at := time.Date(2021, 7, 1, 10, 0, 0, 0, time.UTC)
+	objects := []runtime.Object{
+		&apiCoreV1.Node{ObjectMeta: apiMetaV1.ObjectMeta{Name: "control-plane"}},
+		&apiCoreV1.Namespace{ObjectMeta: apiMetaV1.ObjectMeta{Name: "bench"}},
+		&apiCoreV1.Namespace{ObjectMeta: apiMetaV1.ObjectMeta{Name: "empty"}},
+		event("started", "Started", at.Add(time.Minute)),
+		event("pulled", "Pulled", at),
+		&apiCoreV1.Pod{
+			ObjectMeta: apiMetaV1.ObjectMeta{Namespace: "bench", Name: "query-0"},
+			Status: apiCoreV1.PodStatus{
+				InitContainerStatuses: []apiCoreV1.ContainerStatus{{Name: "init"}},
+				ContainerStatuses:     []apiCoreV1.ContainerStatus{{Name: "query", RestartCount: 2}},
+			},
+		},
+	}
+	dynamicObjects := []runtime.Object{
+		unstructuredObject("v1", "ConfigMap", "bench", "query-config"),
+		unstructuredObject("v1", "Secret", "bench", "token"),
+		unstructuredObject("apps/v1", "Deployment", "bench", "query"),
+	}
+	coreResources := &apiMetaV1.APIResourceList{
+		GroupVersion: "v1",
+		APIResources: []apiMetaV1.APIResource{
+			{Name: "configmaps", Namespaced: true, Kind: "ConfigMap", Verbs: []string{"get", "list"}},
+			{Name: "secrets", Namespaced: true, Kind: "Secret", Verbs: []string{"get", "list"}},
+			{Name: "events", Namespaced: true, Kind: "Event", Verbs: []string{"get", "list"}},
+			{Name: "pods/log", Namespaced: true, Kind: "Pod", Verbs: []string{"get"}},
+		},
+	}
+	appsResources := &apiMetaV1.APIResourceList{
+		GroupVersion: "apps/v1",
+		APIResources: []apiMetaV1.APIResource{
+			{Name: "deployments", Namespaced: true, Kind: "Deployment", Verbs: []string{"get", "list"}},
+		},
+	}
+	unlistableApps := &apiMetaV1.APIResourceList{
+		GroupVersion: "apps/v1",
+		APIResources: []apiMetaV1.APIResource{
+			{Name: "deployments", Namespaced: true, Kind: "Deployment", Verbs: []string{"get"}},
+		},
+	}
+	logs := []string{
+		"namespaces/bench/events.txt",
+		"namespaces/bench/logs/query-0/init.log",
+		"namespaces/bench/logs/query-0/query.log",
+		"namespaces/bench/logs/query-0/query.previous.log",
+	}
+
+	tests := []struct {
+		name        string
+		resources   []*apiMetaV1.APIResourceList
+		expectFiles []string
+	}{
+		{
+			name:      "every listable resource",
+			resources: []*apiMetaV1.APIResourceList{coreResources, appsResources},
+			expectFiles: append([]string{
+				"namespaces/bench/configmaps/query-config.yaml",
+				"namespaces/bench/deployments/query.yaml",
+			}, append(logs, "nodes/control-plane.yaml")...),
+		},
+		{
+			name:        "resources without the list verb are skipped",
+			resources:   []*apiMetaV1.APIResourceList{coreResources, unlistableApps},
+			expectFiles: append([]string{"namespaces/bench/configmaps/query-config.yaml"}, append(logs, "nodes/control-plane.yaml")...),
+		},
+		{
+			name:        "no resource",
+			resources:   []*apiMetaV1.APIResourceList{{GroupVersion: "v1"}},
+			expectFiles: append(logs, "nodes/control-plane.yaml"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clt := fake.NewSimpleClientset(objects...)
+			clt.Fake.Resources = tt.resources
+			c := &K8s{
+				ctx: context.Background(),
+				clt: clt,
+				dynamicClt: dynamicFake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+					configMapsResource:  "ConfigMapList",
+					secretsResource:     "SecretList",
+					deploymentsResource: "DeploymentList",
+				}, dynamicObjects...),
+			}
+			dir := t.TempDir()
+
+			assert.NoError(t, c.Snapshot(dir))
+			assert.Equal(t, tt.expectFiles, snapshotFiles(t, dir))
+
+			events, err := ioutil.ReadFile(filepath.Join(dir, "namespaces/bench/events.txt"))
+			assert.NoError(t, err)
+			assert.Equal(t, "2021-07-01T10:00:00Z\tNormal\tPulled\tpod/query-0\tPulled query-0\n"+
+				"2021-07-01T10:01:00Z\tNormal\tStarted\tpod/query-0\tStarted query-0\n", string(events), "sorted by time")
+			node, err := ioutil.ReadFile(filepath.Join(dir, "nodes/control-plane.yaml"))
+			assert.NoError(t, err)
+			assert.Contains(t, string(node), "name: control-plane")
+		})
+	}
+}
@@ -1,214 +1,118 @@
 package kind
 
 import (
-	"context"
-	"fmt"
-	"strings"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
 
 	"datafuselabs/test-infra/pkg/provider"
-	k8sProvider "datafuselabs/test-infra/pkg/provider/k8s"
 	"github.com/pkg/errors"
-	"gopkg.in/alecthomas/kingpin.v2"
-	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/util/homedir"
 	"sigs.k8s.io/kind/pkg/cluster"
+	"sigs.k8s.io/kind/pkg/cluster/nodeutils"
 	"sigs.k8s.io/kind/pkg/cmd"
 )
 
-type Resource = provider.Resource
+func init() {
+	provider.Register("kind", `Kubernetes In Docker (KIND) provider - https://kind.sigs.k8s.io/docs/user/quick-start/`, func() provider.ClusterProvider {
+		return New()
+	})
+}
 
-// KIND holds the fields used to generate an API request.
+// KIND manages clusters running as docker containers.
 type KIND struct {
-
-	// The k8s provider used when we work with the manifest files.
-	k8sProvider *k8sProvider.K8s
 	// The kind provider used to instantiate a new provider.
 	kindProvider *cluster.Provider
-	// Final DeploymentFiles files.
-	DeploymentFiles []string
-	// Final DeploymentVars.
-	DeploymentVars map[string]string
-	// DeployResource to construct DeploymentVars and DeploymentFiles
-	DeploymentResource *provider.DeploymentResource
-	// Content bytes after parsing the template variables, grouped by filename.
-	kindResources []Resource
-	// K8s resource.runtime objects after parsing the template variables, grouped by filename.
-	k8sResources []k8sProvider.Resource
-
-	ctx context.Context
 	// KIND kuberconfig file
 	kubeconfig string
 }
 
 // New is the KIND constructor.
-func New(dr *provider.DeploymentResource) *KIND {
+func New() *KIND {
 	return &KIND{
-		DeploymentResource: dr,
 		kindProvider: cluster.NewProvider(
 			cluster.ProviderWithLogger(cmd.NewLogger()),
 		),
-		ctx:        context.Background(),
 		kubeconfig: homedir.HomeDir() + "/.kube/config",
 	}
 }
 
-// SetupDeploymentResources Sets up DeploymentVars and DeploymentFiles
-func (c *KIND) SetupDeploymentResources(*kingpin.ParseContext) error {
-	customDeploymentVars := map[string]string{
-		//"NGINX_SERVICE_TYPE":        "NodePort",
-		//"LOADGEN_SCALE_UP_REPLICAS": "2",
-	}
-
-	c.DeploymentFiles = c.DeploymentResource.DeploymentFiles
-	c.DeploymentVars = provider.MergeDeploymentVars(
-		c.DeploymentResource.DefaultDeploymentVars,
-		customDeploymentVars,
-		c.DeploymentResource.FlagDeploymentVars,
-	)
-	return nil
-}
-
-// KINDDeploymentsParse parses the environment/kind deployment files and saves the result as bytes grouped by the filename.
-// Any DeploymentVar will be replaced in the resources files following the golang text template format.
-func (c *KIND) KINDDeploymentsParse(*kingpin.ParseContext) error {
-	if err := c.checkDeploymentVarsAndFiles(); err != nil {
-		return err
-	}
-
-	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
+// Create creates a new cluster from a kind cluster config.
+// The NodeProfile documents of the config are applied to the nodes selecting them.
+func (c *KIND) Create(name string, config []byte) error {
+	config, err := applyNodeProfiles(config)
 	if err != nil {
 		return err
 	}
-	c.kindResources = deploymentResource
-	return nil
+	return c.kindProvider.Create(name, cluster.CreateWithRawConfig(config))
 }
 
-func (c *KIND) K8SDeploymentsParse(*kingpin.ParseContext) error {
-	if err := c.checkDeploymentVarsAndFiles(); err != nil {
-		return err
-	}
-
-	deploymentResource, err := provider.DeploymentsParse(c.DeploymentFiles, c.DeploymentVars)
-	if err != nil {
-		return err
-	}
-	for _, deployment := range deploymentResource {
-
-		decode := scheme.Codecs.UniversalDeserializer().Decode
-		k8sObjects := make([]runtime.Object, 0)
-
-		for _, text := range strings.Split(string(deployment.Content), provider.Separator) {
-			text = strings.TrimSpace(text)
-			if len(text) == 0 {
-				continue
-			}
-
-			resource, _, err := decode([]byte(text), nil, nil)
-			if err != nil {
-				return errors.Wrapf(err, "decoding the resource file:%v, section:%v...", deployment.FileName, text[:100])
-			}
-			if resource == nil {
-				continue
-			}
-			k8sObjects = append(k8sObjects, resource)
-		}
-		if len(k8sObjects) > 0 {
-			c.k8sResources = append(c.k8sResources, k8sProvider.Resource{FileName: deployment.FileName, Objects: k8sObjects})
-		}
-	}
-	return nil
-}
-
-// checkDeploymentVarsAndFiles checks whether the required deployment vars are passed.
-func (c *KIND) checkDeploymentVarsAndFiles() error {
-	reqDepVars := []string{"CLUSTER_NAME"}
-	for _, k := range reqDepVars {
-		if v, ok := c.DeploymentVars[k]; !ok || v == "" {
-			return fmt.Errorf("missing required %v variable", k)
-		}
-	}
-	if len(c.DeploymentFiles) == 0 {
-		return fmt.Errorf("missing deployment file(s)")
-	}
-	return nil
+// Delete deletes a kind cluster and removes it from the kubeconfig file.
+func (c *KIND) Delete(name string) error {
+	return c.kindProvider.Delete(name, c.kubeconfig)
 }
 
-// ClusterCreate create a new cluster or applies changes to an existing cluster.
-func (c *KIND) ClusterCreate(*kingpin.ParseContext) error {
-	for _, deployment := range c.kindResources {
-		CreateWithConfigFile := cluster.CreateWithRawConfig(deployment.Content)
-
-		err := c.kindProvider.Create(c.DeploymentVars["CLUSTER_NAME"], CreateWithConfigFile)
-		if err != nil {
-			return err
-		}
+// Status checks whether the cluster has any node container.
+func (c *KIND) Status(name string) (provider.ClusterStatus, error) {
+	nodes, err := c.kindProvider.ListNodes(name)
+	if err != nil {
+		return "", errors.Wrapf(err, "listing nodes of cluster %v", name)
 	}
-	return nil
-}
-
-// ClusterRunning will check on whether cluster is still running
-func (c *KIND) ClusterRunning(*kingpin.ParseContext) error {
-	for _, deployment := range c.kindResources {
-		CreateWithConfigFile := cluster.CreateWithRawConfig(deployment.Content)
-
-		err := c.kindProvider.Create(c.DeploymentVars["CLUSTER_NAME"], CreateWithConfigFile)
-		if err != nil && strings.Contains(err.Error(), "node(s) already exist"){
-			return nil
-		} else {
-			return err
-		}
+	if len(nodes) == 0 {
+		return provider.ClusterNotFound, nil
 	}
-	// created a new cluster
-	return nil
+	return provider.ClusterRunning, nil
 }
 
-// ClusterDelete deletes a k8s cluster.
-func (c *KIND) ClusterDelete(*kingpin.ParseContext) error {
-	err := c.kindProvider.Delete(c.DeploymentVars["CLUSTER_NAME"], c.kubeconfig)
+// Kubeconfig returns the kubeconfig kind generated for the cluster.
+func (c *KIND) Kubeconfig(name string) (*clientcmdapi.Config, error) {
+	raw, err := c.kindProvider.KubeConfig(name, false)
 	if err != nil {
-		return err
+		return nil, errors.Wrapf(err, "getting kubeconfig of cluster %v", name)
 	}
-	return nil
+	return clientcmd.Load([]byte(raw))
 }
 
-// NewK8sProvider sets the k8s provider used for deploying k8s manifests.
-func (c *KIND) NewK8sProvider(*kingpin.ParseContext) error {
-	var err error
-	apiConfig, err := clientcmd.LoadFromFile(c.kubeconfig)
+// LoadImage saves the images from the local docker daemon and imports them on every node.
+func (c *KIND) LoadImage(name string, images []string) error {
+	nodes, err := c.kindProvider.ListInternalNodes(name)
 	if err != nil {
-		return err
+		return errors.Wrapf(err, "listing nodes of cluster %v", name)
+	}
+	if len(nodes) == 0 {
+		return errors.Errorf("no nodes found for cluster %v", name)
 	}
 
-	c.k8sProvider, err = k8sProvider.New(c.ctx, apiConfig)
+	dir, err := ioutil.TempDir("", "image-tar")
 	if err != nil {
-		return err
+		return errors.Wrap(err, "creating temp dir")
 	}
-	return nil
-}
+	defer os.RemoveAll(dir)
 
-// ResourceApply calls k8s.ResourceApply to apply the k8s objects in the manifest files.
-func (c *KIND) ResourceApply(*kingpin.ParseContext) error {
-	if err := c.k8sProvider.ResourceApply(c.k8sResources); err != nil {
-		return err
+	archive := filepath.Join(dir, "images.tar")
+	args := append([]string{"save", "-o", archive}, images...)
+	if out, err := exec.Command("docker", args...).CombinedOutput(); err != nil {
+		return errors.Wrapf(err, "saving images: %s", out)
 	}
-	return nil
-}
 
-// ResourceDelete calls k8s.ResourceDelete to apply the k8s objects in the manifest files.
-func (c *KIND) ResourceDelete(*kingpin.ParseContext) error {
-	if err := c.k8sProvider.ResourceDelete(c.k8sResources); err != nil {
-		return err
+	for _, node := range nodes {
+		f, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		err = nodeutils.LoadImageArchive(node, f)
+		f.Close()
+		if err != nil {
+			return errors.Wrapf(err, "loading images on node %v", node.String())
+		}
 	}
 	return nil
 }
 
-// GetDeploymentVars shows deployment variables.
-func (c *KIND) GetDeploymentVars(parseContext *kingpin.ParseContext) error {
-	fmt.Print("-------------------\n   DeploymentVars   \n------------------- \n")
-	for key, value := range c.DeploymentVars {
-		fmt.Println(key, ": ", value)
-	}
-	return nil
-}
\ No newline at end of file
+// CollectLogs exports the logs of the kind node containers, same as `kind export logs`.
+func (c *KIND) CollectLogs(name, dir string) error {
+	return c.kindProvider.CollectLogs(name, dir)
+}
@@ -0,0 +1,189 @@
+package kind
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"datafuselabs/test-infra/pkg/provider"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/kind/pkg/apis/config/v1alpha4"
+)
+
+const (
+	// NodeProfileKind is the kind of the extra documents in a cluster config describing node profiles.
+	NodeProfileKind       = "NodeProfile"
+	NodeProfileAPIVersion = "test-infra.databend.io/v1alpha1"
+	// NodeProfileLabel selects the profile applied to a node of the cluster config.
+	NodeProfileLabel = "test-infra.databend.io/node-profile"
+)
+
+// NodeProfile tunes the kubelet and the node container of benchmark nodes so pods get pinned,
+// comparable cores. It is declared next to the kind Cluster in the cluster config file:
+//
+//	apiVersion: test-infra.databend.io/v1alpha1
+//	kind: NodeProfile
+//	name: benchmark
+//	cpuManagerPolicy: static
+//	reservedSystemCPUs: "0"
+//	topologyManagerPolicy: single-numa-node
+//	mounts:
+//	  - hostPath: /data/bench
+//	    containerPath: /var/lib/bench
+//
+// and selected by the nodes with the test-infra.databend.io/node-profile label.
+type NodeProfile struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Name       string `yaml:"name"`
+	// CPUManagerPolicy is the kubelet cpu manager policy, static gives exclusive cores to
+	// Guaranteed pods with integer cpu requests.
+	CPUManagerPolicy string `yaml:"cpuManagerPolicy,omitempty"`
+	// ReservedSystemCPUs is the cpu list kept for the system and kube daemons, required by the static policy.
+	ReservedSystemCPUs string `yaml:"reservedSystemCPUs,omitempty"`
+	// TopologyManagerPolicy aligns cpu and device allocations, e.g. single-numa-node.
+	TopologyManagerPolicy string `yaml:"topologyManagerPolicy,omitempty"`
+	// Mounts are added to the node container, e.g. a dedicated disk for benchmark data.
+	Mounts []v1alpha4.Mount `yaml:"mounts,omitempty"`
+}
+
+func (p NodeProfile) validate() error {
+	if p.APIVersion != NodeProfileAPIVersion {
+		return fmt.Errorf("node profile %v: unknown apiVersion %v", p.Name, p.APIVersion)
+	}
+	if p.Name == "" {
+		return fmt.Errorf("node profile without a name")
+	}
+	switch p.CPUManagerPolicy {
+	case "", "none":
+	case "static":
+		if p.ReservedSystemCPUs == "" {
+			return fmt.Errorf("node profile %v: the static cpu manager policy requires reservedSystemCPUs", p.Name)
+		}
+	default:
+		return fmt.Errorf("node profile %v: unknown cpu manager policy %v", p.Name, p.CPUManagerPolicy)
+	}
+	switch p.TopologyManagerPolicy {
+	case "", "none", "best-effort", "restricted", "single-numa-node":
+	default:
+		return fmt.Errorf("node profile %v: unknown topology manager policy %v", p.Name, p.TopologyManagerPolicy)
+	}
+	for _, m := range p.Mounts {
+		if m.HostPath == "" || m.ContainerPath == "" {
+			return fmt.Errorf("node profile %v: mounts require a hostPath and a containerPath", p.Name)
+		}
+	}
+	return nil
+}
+
+// kubeletArgs returns the kubelet flags of the profile.
+// Flags are used instead of a KubeletConfiguration patch because kubeadm join ignores
+// the KubeletConfiguration of worker nodes and uses the cluster wide one.
+func (p NodeProfile) kubeletArgs() map[string]string {
+	args := map[string]string{}
+	if p.CPUManagerPolicy != "" {
+		args["cpu-manager-policy"] = p.CPUManagerPolicy
+	}
+	if p.ReservedSystemCPUs != "" {
+		args["reserved-cpus"] = p.ReservedSystemCPUs
+	}
+	if p.TopologyManagerPolicy != "" {
+		args["topology-manager-policy"] = p.TopologyManagerPolicy
+	}
+	return args
+}
+
+// kubeadmPatches returns the kubeadm config patches setting the profile kubelet flags.
+// Both init and join patches are returned, kind only applies the one matching the node.
+func (p NodeProfile) kubeadmPatches() ([]string, error) {
+	args := p.kubeletArgs()
+	if len(args) == 0 {
+		return nil, nil
+	}
+	var patches []string
+	for _, kind := range []string{"InitConfiguration", "JoinConfiguration"} {
+		patch, err := yaml.Marshal(map[string]interface{}{
+			"kind": kind,
+			"nodeRegistration": map[string]interface{}{
+				"kubeletExtraArgs": args,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		patches = append(patches, string(patch))
+	}
+	return patches, nil
+}
+
+// applyNodeProfiles extracts the NodeProfile documents of a cluster config and applies them
+// to the nodes selecting them. The returned config only contains the kind Cluster.
+// Configs without profiles are returned unchanged.
+func applyNodeProfiles(config []byte) ([]byte, error) {
+	profiles := map[string]NodeProfile{}
+	var clusterDocs []string
+	for _, doc := range strings.Split(string(config), provider.Separator) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		var meta struct {
+			Kind string `yaml:"kind"`
+		}
+		if err := yaml.Unmarshal([]byte(doc), &meta); err != nil {
+			return nil, errors.Wrap(err, "decoding cluster config")
+		}
+		if meta.Kind != NodeProfileKind {
+			clusterDocs = append(clusterDocs, doc)
+			continue
+		}
+
+		var p NodeProfile
+		d := yaml.NewDecoder(strings.NewReader(doc))
+		d.KnownFields(true)
+		if err := d.Decode(&p); err != nil {
+			return nil, errors.Wrap(err, "decoding node profile")
+		}
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+		profiles[p.Name] = p
+	}
+	if len(profiles) == 0 {
+		return config, nil
+	}
+	if len(clusterDocs) != 1 {
+		return nil, fmt.Errorf("expected a single kind Cluster in the cluster config, got %d documents", len(clusterDocs))
+	}
+
+	var cluster v1alpha4.Cluster
+	d := yaml.NewDecoder(strings.NewReader(clusterDocs[0]))
+	d.KnownFields(true)
+	if err := d.Decode(&cluster); err != nil {
+		return nil, errors.Wrap(err, "decoding kind cluster")
+	}
+	for i, node := range cluster.Nodes {
+		name, ok := node.Labels[NodeProfileLabel]
+		if !ok {
+			continue
+		}
+		p, ok := profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("node %d selects unknown node profile %v", i, name)
+		}
+		patches, err := p.kubeadmPatches()
+		if err != nil {
+			return nil, err
+		}
+		cluster.Nodes[i].KubeadmConfigPatches = append(cluster.Nodes[i].KubeadmConfigPatches, patches...)
+		cluster.Nodes[i].ExtraMounts = append(cluster.Nodes[i].ExtraMounts, p.Mounts...)
+	}
+
+	var out bytes.Buffer
+	e := yaml.NewEncoder(&out)
+	e.SetIndent(2)
+	if err := e.Encode(&cluster); err != nil {
+		return nil, errors.Wrap(err, "encoding kind cluster")
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,110 @@
+package kind
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"sigs.k8s.io/kind/pkg/apis/config/v1alpha4"
+)
+
+const testCluster = `kind: Cluster
+apiVersion: kind.x-k8s.io/v1alpha4
+name: bendbench
+nodes:
+  - role: control-plane
+  - role: worker
+    labels:
+      test-infra.databend.io/node-profile: benchmark
+    kubeadmConfigPatches:
+      - |
+        kind: JoinConfiguration
+        nodeRegistration:
+          kubeletExtraArgs:
+            node-labels: "test-branch=main"
+`
+
+func Test_applyNodeProfiles(t *testing.T) {
+	tests := []struct {
+		name          string
+		config        string
+		expectError   string
+		expectPatches int
+		expectMounts  int
+	}{
+		{
+			name:          "no profile",
+			config:        testCluster,
+			expectPatches: 1,
+		},
+		{
+			name: "benchmark",
+			config: testCluster + `---
+apiVersion: test-infra.databend.io/v1alpha1
+kind: NodeProfile
+name: benchmark
+cpuManagerPolicy: static
+reservedSystemCPUs: "0"
+topologyManagerPolicy: single-numa-node
+mounts:
+  - hostPath: /data/bench
+    containerPath: /var/lib/bench
+`,
+			expectPatches: 3,
+			expectMounts:  1,
+		},
+		{
+			name: "static without reserved cpus",
+			config: testCluster + `---
+apiVersion: test-infra.databend.io/v1alpha1
+kind: NodeProfile
+name: benchmark
+cpuManagerPolicy: static
+`,
+			expectError: "requires reservedSystemCPUs",
+		},
+		{
+			name: "unknown profile",
+			config: testCluster + `---
+apiVersion: test-infra.databend.io/v1alpha1
+kind: NodeProfile
+name: other
+`,
+			expectError: "unknown node profile benchmark",
+		},
+		{
+			name: "unknown field",
+			config: testCluster + `---
+apiVersion: test-infra.databend.io/v1alpha1
+kind: NodeProfile
+name: benchmark
+cpuPolicy: static
+`,
+			expectError: "decoding node profile",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := applyNodeProfiles([]byte(tt.config))
+			if tt.expectError != "" {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectError)
+				return
+			}
+			assert.NoError(t, err)
+
+			var cluster v1alpha4.Cluster
+			assert.NoError(t, yaml.Unmarshal(out, &cluster))
+			assert.Equal(t, "bendbench", cluster.Name)
+			assert.Len(t, cluster.Nodes, 2)
+			worker := cluster.Nodes[1]
+			assert.Len(t, worker.KubeadmConfigPatches, tt.expectPatches)
+			assert.Len(t, worker.ExtraMounts, tt.expectMounts)
+			if tt.expectPatches > 1 {
+				assert.True(t, strings.Contains(worker.KubeadmConfigPatches[2], "cpu-manager-policy: static"))
+				assert.True(t, strings.Contains(worker.KubeadmConfigPatches[2], "kind: JoinConfiguration"))
+			}
+		})
+	}
+}